@@ -4,27 +4,38 @@
 package main
 
 import (
+	"Popcorn/internal/admin"
 	"Popcorn/internal/auth"
+	"Popcorn/internal/blob"
 	"Popcorn/internal/entity"
 	"Popcorn/internal/errors"
 	"Popcorn/internal/gang"
+	"Popcorn/internal/library"
 	"Popcorn/internal/metrics"
 	"Popcorn/internal/sse"
 	"Popcorn/internal/storage"
 	"Popcorn/internal/user"
 	"Popcorn/pkg/cleanup"
 	"Popcorn/pkg/db"
+	"Popcorn/pkg/filter"
 	"Popcorn/pkg/log"
 	"Popcorn/pkg/middlewares"
+	"Popcorn/pkg/monitor"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/signedurl"
+	"Popcorn/pkg/tracing"
+	"Popcorn/pkg/urlprobe"
 	"Popcorn/pkg/validations"
 	"context"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 var (
@@ -60,6 +71,26 @@ func main() {
 	if converr == nil {
 		LIVEKIT_CONFIG.MaxScreenShareHours = max_ss_hours_lim
 	}
+	ss_heartbeat_secs, converr := strconv.Atoi(os.Getenv("SCREENSHARE_HEARTBEAT_SECONDS"))
+	if converr == nil {
+		LIVEKIT_CONFIG.ScreenShareHeartbeatSeconds = ss_heartbeat_secs
+	}
+	max_stream_retries, converr := strconv.Atoi(os.Getenv("MAX_STREAM_RETRIES"))
+	if converr == nil {
+		LIVEKIT_CONFIG.MaxStreamRetries = max_stream_retries
+	}
+	// Quality profiles gang admins can pick from, every profile if unset
+	for _, profile := range strings.Split(os.Getenv("ALLOWED_QUALITY_PROFILES"), ",") {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			LIVEKIT_CONFIG.QualityProfiles = append(LIVEKIT_CONFIG.QualityProfiles, profile)
+		}
+	}
+
+	// Initializing the tracer provider, spans are exported as configured via OTEL_TRACES_EXPORTER
+	shutdownTracing, trcerr := tracing.Init(ctx, VERSION)
+	if trcerr != nil {
+		logger.Fatal().Err(trcerr).Msg("Couldn't initialize tracing")
+	}
 
 	logger.Info().Msg("Welcome to Popcorn!")
 	logger.Info().Msgf("Popcorn Environment: %s", ENVIRONMENT)
@@ -95,19 +126,45 @@ func main() {
 		}
 	}()
 
+	// Prometheus metrics get a listener of their own if METRICS_ADDR is set, scrapes carry METRICS_TOKEN if it's set too
+	metricsSrv := &http.Server{
+		Addr:    os.Getenv("METRICS_ADDR"),
+		Handler: monitor.Handler(os.Getenv("METRICS_TOKEN")),
+	}
+	if metricsSrv.Addr != "" {
+		go func() {
+			logger.Info().Msgf("Popcorn metrics served at: %s/metrics", metricsSrv.Addr)
+			if err := metricsSrv.ListenAndServe(); err != http.ErrServerClosed {
+				logger.Fatal().Err(err).Msg("Error in ListenAndServe() of metrics listener")
+			}
+		}()
+	}
+
 	// Graceful shutdown of Popcorn server triggered due to system interruptions
 	wait := cleanup.GracefulShutdown(ctx, logger, 5*time.Minute, []cleanup.Operation{
 		func(ctx context.Context) error {
-			// Stop long running ResetMetrics() method
+			// Stop long running ResetMetrics() and upload Janitor methods
 			metrics.Cleanup(ctx)
+			storage.Cleanup(ctx)
 			// Disconnect SSE connections & coressponding channels, then shutdown gin server
 			sse.Cleanup(ctx)
 			return srv.Shutdown(ctx)
 		},
+		func(ctx context.Context) error {
+			// Shutdown metrics listener, if any
+			if metricsSrv.Addr == "" {
+				return nil
+			}
+			return metricsSrv.Shutdown(ctx)
+		},
 		func(ctx context.Context) error {
 			// Close Redis DB Connection
 			return dbConnWrp.CloseDbConnection(ctx)
 		},
+		func(ctx context.Context) error {
+			// Flush spans yet to be exported
+			return shutdownTracing(ctx)
+		},
 	})
 	<-wait
 }
@@ -120,12 +177,37 @@ func setupRouter(ctx context.Context, dbConnWrp *db.RedisDB, logger log.Logger)
 
 	addr := os.Getenv("ACCESS_CTL_ALLOW_ORGIN")
 
+	// Words to be masked in gang messages, can be set inline or through a newline separated word list file
+	filterWords := strings.Split(os.Getenv("MSG_FILTER_WORDS"), ",")
+	if wordList := os.Getenv("MSG_FILTER_WORDLIST"); wordList != "" {
+		words, oserr := filter.LoadWordList(wordList)
+		if oserr != nil {
+			logger.Fatal().Err(oserr).Msg("Couldn't load MSG_FILTER_WORDLIST")
+		}
+		filterWords = append(filterWords, words...)
+	}
+	msgFilter := filter.NewWordFilter(filterWords)
+
+	// Signs URLs through which livekit ingress pulls uploaded gang contents
+	contentSigner, sigerr := signedurl.New()
+	if sigerr != nil {
+		logger.Fatal().Err(sigerr).Msg("Couldn't initialize content URL signer")
+	}
+	// Storage backend for uploaded gang contents, selected via STORAGE_BACKEND
+	contentStore, strerr := objectstore.New(contentSigner)
+	if strerr != nil {
+		logger.Fatal().Err(strerr).Msg("Couldn't initialize content storage backend")
+	}
+
 	// Initializing the gin server
 	ginMode := os.Getenv("GIN_MODE")
 	gin.SetMode(ginMode)
 	router := gin.New()
+	// Handlers pass gin context down to services, context values such as the request span are looked up through it
+	router.ContextWithFallback = true
 
 	// Declare global middlewares here
+	router.Use(otelgin.Middleware(tracing.ServiceName))   // Span per request, continuing the trace of incoming traceparent header
 	router.Use(log.LoggerGinExtension(logger))            // Forcing gin to use custom Logger instead of the default one
 	router.Use(gin.Recovery())                            // Recovery middleware recovers from any panics and writes a 500 if there was one
 	router.Use(middlewares.CorrelationMiddleware(logger)) // Fill up every request with unique CorrelationID
@@ -136,13 +218,22 @@ func setupRouter(ctx context.Context, dbConnWrp *db.RedisDB, logger log.Logger)
 	userRepo := user.NewRepository(dbConnWrp)
 	gangRepo := gang.NewRepository(dbConnWrp)
 	metricsRepo := metrics.NewRepository(dbConnWrp)
+	adminRepo := admin.NewRepository(dbConnWrp)
+	libraryRepo := library.NewRepository(dbConnWrp)
+	blobRepo := blob.NewRepository(dbConnWrp)
 
 	// Initialize internal Service instance
 	authService := auth.NewService(accSecret, refSecret, userRepo, authRepo, logger)
-	userService := user.NewService(userRepo, logger)
 	sseService := sse.NewService(logger)
 	metricsService := metrics.NewService(LIVEKIT_CONFIG, metricsRepo, logger)
-	gangService := gang.NewService(LIVEKIT_CONFIG, gangRepo, userRepo, sseService, metricsService, logger)
+	userService := user.NewService(userRepo, metricsService, logger)
+	gangService := gang.NewService(LIVEKIT_CONFIG, gangRepo, userRepo, blobRepo, sseService, metricsService, contentStore, msgFilter, urlprobe.New(), logger)
+	adminService := admin.NewService(adminRepo, authRepo, userRepo, gangRepo, libraryRepo, gangService, metricsService, sseService, contentStore, logger)
+	storageService := storage.NewService(LIVEKIT_CONFIG, contentStore, gangRepo, libraryRepo, blobRepo, metricsService, sseService, logger)
+	libraryService := library.NewService(LIVEKIT_CONFIG, libraryRepo, gangRepo, blobRepo, metricsService, sseService, contentStore, logger)
+
+	// Grant operator role to users listed in OPERATORS
+	adminService.BootstrapOperators(ctx, strings.Split(os.Getenv("OPERATORS"), ","))
 
 	// Launch ResetMetrics() in a separate goroutine
 	go metricsService.ResetMetrics(ctx)
@@ -151,11 +242,15 @@ func setupRouter(ctx context.Context, dbConnWrp *db.RedisDB, logger log.Logger)
 	sseService.GetOrSetEvent(ctx)
 	go sseService.Listen(ctx)
 
+	// Watch gang streams published before the restart again in a separate goroutine
+	go gangService.RecoverStreams(ctx)
+
 	// Declare internal middlewares here
 	accAuthMiddleware := auth.AuthMiddleware(logger, authRepo, userRepo, "access_token", accSecret)
 	refAuthMiddleware := auth.AuthMiddleware(logger, authRepo, userRepo, "refresh_token", refSecret)
 	sseConnMiddleware := sse.SSEConnManagerMiddleware(sseService, logger)
-	tusAuthMiddleware := storage.ContentStorageMiddleware(logger, LIVEKIT_CONFIG, metricsService, gangRepo)
+	tusAuthMiddleware := storage.ContentStorageMiddleware(logger, LIVEKIT_CONFIG, metricsService, gangRepo, contentStore)
+	operatorMiddleware := admin.OperatorMiddleware(logger, userRepo)
 
 	// Register handlers of different internal packages in Popcorn
 	// Register internal package auth handler
@@ -164,11 +259,38 @@ func setupRouter(ctx context.Context, dbConnWrp *db.RedisDB, logger log.Logger)
 	user.APIHandlers(router, userService, accAuthMiddleware, logger)
 	// Register internal package gang handler
 	gang.APIHandlers(router, gangService, accAuthMiddleware, logger)
+	// Register internal package library handler
+	library.APIHandlers(router, libraryService, accAuthMiddleware, logger)
+	// Register internal package admin handler
+	admin.APIHandlers(router, adminService, accAuthMiddleware, operatorMiddleware, logger)
 	// Register internal package sse handler
 	sse.APIHandlers(router, sseService, accAuthMiddleware, sseConnMiddleware, logger)
 	// Register tusd file storage handler
-	storage_handler := storage.GetTusdStorageHandler(gangRepo, metricsService, sseService, LIVEKIT_CONFIG, logger)
-	storage.APIHandlers(router, storage_handler, accAuthMiddleware, tusAuthMiddleware, logger)
+	storage_handler := storage.GetTusdStorageHandler(contentStore, gangRepo, libraryRepo, blobRepo, metricsService, sseService, LIVEKIT_CONFIG, logger)
+	storage.APIHandlers(router, storage_handler, storageService, contentStore, contentSigner, accAuthMiddleware, tusAuthMiddleware, logger)
+	// Launch upload Janitor in a separate goroutine, it reconciles leftovers of the previous run first
+	go storage.NewJanitor(LIVEKIT_CONFIG, contentStore, gangRepo, libraryRepo, blobRepo, metricsService, sseService, logger).Run(ctx)
+
+	// Service wide gauges taken on every scrape of Prometheus metrics
+	monitor.RegisterGauge("gangs", "Gangs in Popcorn.", func() float64 {
+		count, _ := gangService.CountGangs(ctx)
+		return float64(count)
+	})
+	monitor.RegisterGauge("streams", "Gang streams being started or published, including screen shares.", func() float64 {
+		return float64(gangService.ActiveStreams())
+	})
+	monitor.RegisterGauge("ingress_leases", "Livekit ingress leased to gangs and uploads.", func() float64 {
+		popcornMetrics, _ := metricsService.GetMetrics(ctx)
+		return float64(popcornMetrics.ActiveIngress)
+	})
+	// Prometheus metrics are served by the main listener behind METRICS_TOKEN, unless they've got a listener of their own
+	if os.Getenv("METRICS_ADDR") == "" {
+		if metricsToken := os.Getenv("METRICS_TOKEN"); metricsToken != "" {
+			router.GET("/metrics", gin.WrapH(monitor.Handler(metricsToken)))
+		} else {
+			logger.Warn().Msg("Prometheus metrics aren't exposed, set METRICS_ADDR or METRICS_TOKEN to expose them")
+		}
+	}
 
 	// Default route, Will help in healthchecks
 	router.GET("/", func(gctx *gin.Context) {
@@ -0,0 +1,277 @@
+// Exposes all of the REST APIs related to platform-wide moderation in Popcorn.
+
+package admin
+
+import (
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/pkg/log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Registers all of the REST API handlers related to internal package admin onto the gin server.
+func APIHandlers(router *gin.Engine, adminService Service, authWithAcc gin.HandlerFunc, operatorOnly gin.HandlerFunc, logger log.Logger) {
+	adminGroup := router.Group("/api/admin", authWithAcc, operatorOnly)
+	{
+		adminGroup.GET("/gangs", listGangs(adminService, logger))
+		adminGroup.POST("/gang/delete", deleteGang(adminService, logger))
+		adminGroup.POST("/gang/stop", stopStream(adminService, logger))
+		adminGroup.POST("/user/status", setUserStatus(adminService, logger))
+		adminGroup.GET("/metrics", getMetrics(adminService, logger))
+		adminGroup.POST("/metrics/reset", resetMetrics(adminService, logger))
+		adminGroup.GET("/uploads/dangling", listDanglingUploads(adminService, logger))
+		adminGroup.GET("/audit", getAuditLog(adminService, logger))
+	}
+}
+
+// listGangs returns a handler which takes care of listing live gangs, optionally only the ones streaming.
+func listGangs(adminService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the operator
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in listGangs")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		cursor, converr := strconv.ParseUint(gctx.DefaultQuery("cursor", "0"), 10, 64)
+		if converr != nil {
+			// Invalid cursor input
+			gctx.Status(http.StatusBadRequest)
+			return
+		}
+		streaming := gctx.DefaultQuery("streaming", "false") == "true"
+		response, newCursor, err := adminService.listgangs(gctx, user.Username, cursor, streaming)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{
+			"result": response,
+			"page":   newCursor,
+		})
+	}
+}
+
+// deleteGang returns a handler which takes care of force deleting a gang.
+func deleteGang(adminService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the operator
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in deleteGang")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var action entity.AdminGangAction
+		if binderr := gctx.ShouldBindJSON(&action); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := adminService.deletegang(gctx, user.Username, action)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// stopStream returns a handler which takes care of force stopping ongoing livestream of a gang.
+func stopStream(adminService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the operator
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in stopStream")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var action entity.AdminGangAction
+		if binderr := gctx.ShouldBindJSON(&action); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := adminService.stopstream(gctx, user.Username, action)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// setUserStatus returns a handler which takes care of activating, suspending, disabling or banning an user.
+func setUserStatus(adminService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the operator
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in setUserStatus")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var status entity.AdminUserStatus
+		if binderr := gctx.ShouldBindJSON(&status); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := adminService.setuserstatus(gctx, user.Username, status)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// getMetrics returns a handler which takes care of fetching Popcorn metrics.
+func getMetrics(adminService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the operator
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in getMetrics")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		metrics, err := adminService.getmetrics(gctx, user.Username)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		// entity.Metrics hides active ingress from clients, operators need it though
+		gctx.JSON(http.StatusOK, gin.H{
+			"active_ingress":         metrics.ActiveIngress,
+			"ingress_quota_exceeded": metrics.IngressQuotaExceeded,
+		})
+	}
+}
+
+// resetMetrics returns a handler which takes care of resetting Popcorn metrics.
+func resetMetrics(adminService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the operator
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in resetMetrics")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		err := adminService.resetmetrics(gctx, user.Username)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// listDanglingUploads returns a handler which takes care of listing uploads not referenced by any gang.
+func listDanglingUploads(adminService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the operator
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in listDanglingUploads")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		uploads, err := adminService.listdanglinguploads(gctx, user.Username)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{"result": uploads})
+	}
+}
+
+// getAuditLog returns a handler which takes care of fetching the audit log of operator actions.
+func getAuditLog(adminService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		cursor, converr := strconv.ParseInt(gctx.DefaultQuery("cursor", "0"), 10, 64)
+		if converr != nil || cursor < 0 {
+			// Invalid cursor input
+			gctx.Status(http.StatusBadRequest)
+			return
+		}
+		response, newCursor, err := adminService.getauditlog(gctx, cursor)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{
+			"result": response,
+			"page":   newCursor,
+		})
+	}
+}
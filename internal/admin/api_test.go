@@ -0,0 +1,391 @@
+// Admin API tests in Popcorn.
+
+package admin
+
+import (
+	"Popcorn/internal/auth"
+	"Popcorn/internal/blob"
+	"Popcorn/internal/entity"
+	"Popcorn/internal/gang"
+	"Popcorn/internal/library"
+	"Popcorn/internal/metrics"
+	"Popcorn/internal/sse"
+	"Popcorn/internal/test"
+	"Popcorn/internal/user"
+	"Popcorn/pkg/db"
+	"Popcorn/pkg/filter"
+	"Popcorn/pkg/log"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/signedurl"
+	"Popcorn/pkg/urlprobe"
+	"Popcorn/pkg/validations"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+// Global instance of log.Logger to be used during admin API testing.
+var logger log.Logger
+
+// Global instance of gin MockRouter to be used during admin API testing.
+var mockRouter *gin.Engine
+
+// Global instance of Db instance to be used during admin API testing.
+var client *db.RedisDB
+
+// Global instance of auth Repository to be used during admin API testing.
+var authRepo auth.Repository
+
+// Global instance of user Repository to be used during admin API testing.
+var userRepo user.Repository
+
+// Global instance of gang Repository to be used during admin API testing.
+var gangRepo gang.Repository
+
+// Global instance of metrics Service to be used during admin API testing.
+var metricsService metrics.Service
+
+// Global context
+var ctx context.Context = context.Background()
+
+// Operator account to be used during admin API tests
+var operatorCookie http.Cookie
+
+// Directory backing the content store used during admin API testing.
+var uploadDir string
+
+// Helper to build up a mock router instance for testing Popcorn.
+func setupMockRouter(dbConnWrp *db.RedisDB, logger log.Logger) {
+	// Initializing mock router
+	mockRouter = test.MockRouter()
+
+	// Initializing livekit mock config
+	livekitMockConfig := entity.LivekitConfig{
+		Host:      "ws://localhost:8000",
+		ApiKey:    "LivekitAPI",
+		ApiSecret: "LivekitAPISecret",
+	}
+
+	// Repositories needed by admin APIs and services to work
+	authRepo = auth.NewRepository(dbConnWrp)
+	userRepo = user.NewRepository(dbConnWrp)
+	gangRepo = gang.NewRepository(dbConnWrp)
+	adminRepo := NewRepository(dbConnWrp)
+
+	// Register internal package admin handler
+	sseService := sse.NewService(logger)
+	metricsService = metrics.NewService(livekitMockConfig, metrics.NewRepository(dbConnWrp), logger)
+	var oserr error
+	uploadDir, oserr = os.MkdirTemp("", "popcorn-admin-test")
+	if oserr != nil {
+		// Issues in MkdirTemp()
+		logger.Fatal().Err(oserr).Msg("Couldn't create upload directory, Aborting test run.")
+	}
+	contentStore, strerr := objectstore.NewLocalStore(uploadDir, "", signedurl.NewSigner([]byte("popcorn"), time.Hour))
+	if strerr != nil {
+		// Issues in NewLocalStore()
+		logger.Fatal().Err(strerr).Msg("Couldn't create content store, Aborting test run.")
+	}
+	gangService := gang.NewService(livekitMockConfig, gangRepo, userRepo, blob.NewRepository(dbConnWrp), sseService, metricsService, contentStore, filter.NewWordFilter([]string{}), urlprobe.NewProber(urlprobe.Config{}), logger)
+	adminService := NewService(adminRepo, authRepo, userRepo, gangRepo, library.NewRepository(dbConnWrp), gangService, metricsService, sseService, contentStore, logger)
+	adminService.BootstrapOperators(ctx, []string{"Operator_User123"})
+	APIHandlers(mockRouter, adminService, test.MockAuthMiddleware(logger), OperatorMiddleware(logger, userRepo), logger)
+}
+
+// Helper to register test user required in the tests below
+func registerTestUser(username, fullname string) (entity.User, http.Cookie) {
+	// Use user.SetOrUpdate repository method to set user data
+	testUser := entity.User{
+		Username: username,
+		FullName: fullname,
+		Password: "popcorn123",
+	}
+	testUser.SelectProfilePic()
+	_, dberr := userRepo.SetOrUpdateUser(ctx, logger, testUser, true)
+	if dberr != nil {
+		// Issues in SetOrUpdateUser()
+		logger.Fatal().Err(dberr).Msg("Couldn't create testUser, Aborting test run.")
+	}
+	// User Cookie to be passed during tests
+	testUserCookie := http.Cookie{
+		Name:     "user",
+		Value:    username,
+		HttpOnly: true,
+	}
+
+	return testUser, testUserCookie
+}
+
+// Helper to execute an admin API request as the given user
+func executeAdminAPI(t *testing.T, method, path string, payload interface{}, cookie http.Cookie, want int) test.APIResponse {
+	body := []byte{}
+	if payload != nil {
+		var mrserr error
+		body, mrserr = json.Marshal(payload)
+		if mrserr != nil {
+			logger.Error().Err(mrserr).Msg("Couldn't marshall payload into json in executeAdminAPI()")
+			t.Fatal()
+		}
+	}
+	request := test.RequestAPITest{
+		Method:       method,
+		Path:         path,
+		Body:         bytes.NewReader(body),
+		WantResponse: []int{want},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &cookie},
+	}
+	return test.ExecuteAPITest(logger, t, mockRouter, &request)
+}
+
+// Sets up resources before testing Admin APIs in Popcorn.
+func setup() {
+	// Initializing Resources before test run
+
+	// Load test.env
+	enverr := godotenv.Load("../../config/test.env")
+	if enverr != nil {
+		// Error during loading test.env, abort test run immediately
+		os.Exit(4)
+	}
+	version := os.Getenv("VERSION")
+
+	// Logger
+	logger = log.New(version)
+
+	// Db client instance
+	var dberr error
+	client, dberr = db.NewDbConnection(ctx, logger)
+	// Sending a PING request to DB for connection status check
+	if dberr != nil || client.CheckDbConnection(ctx, logger) != nil {
+		// connection failure
+		os.Exit(6)
+	}
+	// Initializing validator
+	govalidator.SetFieldsRequiredByDefault(true)
+	// Adding custom validation tags into ext-package govalidator
+	validations.RegisterCustomValidationTags(ctx, logger)
+	user.RegisterCustomValidationTags(ctx, logger)
+	gang.RegisterCustomValidationTags(ctx, logger)
+
+	// Setup an operator account before the router grants the role
+	userRepo = user.NewRepository(client)
+	_, operatorCookie = registerTestUser("Operator_User123", "Operator User")
+
+	// Initializing router
+	setupMockRouter(client, logger)
+
+	logger.Info().Msg("Test resources setup successful.")
+}
+
+// Cleans up the resources built during execution of setup()
+func teardown() {
+	logger.Info().Msg("Cleaning up resources ...")
+	if client.CheckDbConnection(ctx, logger) == nil {
+		// client still open
+		client.CleanTestDbData(ctx, logger)
+		client.CloseDbConnection(ctx)
+	}
+	os.RemoveAll(uploadDir)
+	logger.Info().Msg("Cleanup complete :)")
+}
+
+func TestMain(m *testing.M) {
+	// Setting up Resources
+	setup()
+	// Running the tests
+	testExitCode := m.Run()
+	// Cleanup Resources
+	teardown()
+	// Exit
+	os.Exit(testExitCode)
+}
+
+func TestOperatorOnly(t *testing.T) {
+	_, userCookie := registerTestUser("Regular_User123", "Regular User")
+	executeAdminAPI(t, http.MethodGet, "/api/admin/metrics", nil, userCookie, http.StatusForbidden)
+	executeAdminAPI(t, http.MethodGet, "/api/admin/metrics", nil, operatorCookie, http.StatusOK)
+}
+
+func TestUserStatus(t *testing.T) {
+	registerTestUser("Banned_User123", "Banned User")
+	now := time.Now()
+	dberr := authRepo.SetToken(ctx, logger, &auth.JWTdata{
+		Username:        "Banned_User123",
+		AccessTokenUUID: "banned-access-uuid",
+		AccTokenExp:     now.Add(time.Hour).Unix(),
+		RefTokenUUID:    "banned-refresh-uuid",
+		RefTokenExp:     now.Add(time.Hour).Unix(),
+	})
+	if dberr != nil {
+		// Issues in SetToken()
+		t.Fatal()
+	}
+
+	// Invalid status
+	executeAdminAPI(t, http.MethodPost, "/api/admin/user/status", entity.AdminUserStatus{Username: "Banned_User123", Status: "exiled"}, operatorCookie, http.StatusBadRequest)
+	// Operators cannot be locked out
+	executeAdminAPI(t, http.MethodPost, "/api/admin/user/status", entity.AdminUserStatus{Username: "Operator_User123", Status: entity.UserStatusBanned}, operatorCookie, http.StatusForbidden)
+	// Ban revokes every session of the user
+	executeAdminAPI(t, http.MethodPost, "/api/admin/user/status", entity.AdminUserStatus{Username: "Banned_User123", Status: entity.UserStatusBanned}, operatorCookie, http.StatusOK)
+	for _, tokenUUID := range []string{"banned-access-uuid", "banned-refresh-uuid"} {
+		valid, dberr := authRepo.HasToken(ctx, logger, tokenUUID, "Banned_User123")
+		assert.NoError(t, dberr)
+		assert.False(t, valid)
+	}
+	banned, dberr := userRepo.GetUser(ctx, logger, "Banned_User123")
+	assert.NoError(t, dberr)
+	assert.Equal(t, entity.UserStatusBanned, banned.Status)
+}
+
+func TestMetricsReset(t *testing.T) {
+	dberr := metricsService.HoldIngress(ctx, "Reset_Holder123")
+	if dberr == nil {
+		dberr = metricsService.SetIngressQuotaExceeded(ctx, true)
+	}
+	if dberr != nil {
+		// Issues in HoldIngress() or SetIngressQuotaExceeded()
+		t.Fatal()
+	}
+	executeAdminAPI(t, http.MethodPost, "/api/admin/metrics/reset", nil, operatorCookie, http.StatusOK)
+	response := executeAdminAPI(t, http.MethodGet, "/api/admin/metrics", nil, operatorCookie, http.StatusOK)
+	metrics := struct {
+		ActiveIngress        int  `json:"active_ingress"`
+		IngressQuotaExceeded bool `json:"ingress_quota_exceeded"`
+	}{}
+	if jsonerr := json.Unmarshal(response.Body, &metrics); jsonerr != nil {
+		t.Fatal()
+	}
+	// Leases of live streams are kept
+	assert.Equal(t, 1, metrics.ActiveIngress)
+	assert.False(t, metrics.IngressQuotaExceeded)
+	metricsService.ReleaseIngress(ctx, "Reset_Holder123")
+}
+
+func TestDanglingUploads(t *testing.T) {
+	// Gang referencing one of the uploads
+	testGang := entity.Gang{
+		Admin:          "Operator_User123",
+		Name:           "Operator Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Operator_User123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "movie.mp4", "referenced", "", false, false)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	// Upload data along with tusd .info files
+	for _, id := range []string{"referenced", "orphan"} {
+		if oserr := os.WriteFile(filepath.Join(uploadDir, id), []byte("popcorn"), 0644); oserr != nil {
+			t.Fatal()
+		}
+		info := []byte(`{"ID":"` + id + `","Size":7}`)
+		if oserr := os.WriteFile(filepath.Join(uploadDir, id+".info"), info, 0644); oserr != nil {
+			t.Fatal()
+		}
+	}
+
+	response := executeAdminAPI(t, http.MethodGet, "/api/admin/uploads/dangling", nil, operatorCookie, http.StatusOK)
+	dangling := struct {
+		Result []entity.DanglingUpload `json:"result"`
+	}{}
+	if jsonerr := json.Unmarshal(response.Body, &dangling); jsonerr != nil {
+		t.Fatal()
+	}
+	if assert.Len(t, dangling.Result, 1) {
+		assert.Equal(t, "orphan", dangling.Result[0].ID)
+		assert.Equal(t, int64(7), dangling.Result[0].Size)
+	}
+
+	// Listing gangs shows the gang, but not as streaming
+	// Other packages share the test DB, so look for this gang only
+	response = executeAdminAPI(t, http.MethodGet, "/api/admin/gangs", nil, operatorCookie, http.StatusOK)
+	gangs := struct {
+		Result []entity.GangResponse `json:"result"`
+	}{}
+	if jsonerr := json.Unmarshal(response.Body, &gangs); jsonerr != nil {
+		t.Fatal()
+	}
+	listed := false
+	for _, gang := range gangs.Result {
+		if gang.Admin == testGang.Admin {
+			listed = true
+			assert.False(t, gang.Streaming)
+		}
+	}
+	assert.True(t, listed)
+}
+
+func TestAuditLog(t *testing.T) {
+	executeAdminAPI(t, http.MethodGet, "/api/admin/metrics", nil, operatorCookie, http.StatusOK)
+	response := executeAdminAPI(t, http.MethodGet, "/api/admin/audit", nil, operatorCookie, http.StatusOK)
+	audit := struct {
+		Result []entity.AuditLog `json:"result"`
+	}{}
+	if jsonerr := json.Unmarshal(response.Body, &audit); jsonerr != nil {
+		t.Fatal()
+	}
+	if assert.NotEmpty(t, audit.Result) {
+		// Latest action comes first
+		assert.Equal(t, "Operator_User123", audit.Result[0].Operator)
+		assert.Equal(t, AuditViewMetrics, audit.Result[0].Action)
+	}
+}
+
+func TestUserSuspension(t *testing.T) {
+	registerTestUser("Suspend_Admin123", "Suspend Admin")
+	registerTestUser("Suspend_Member123", "Suspend Member")
+	testGang := entity.Gang{
+		Admin:          "Suspend_Admin123",
+		Name:           "Suspend Gang",
+		PassKey:        "12345",
+		Limit:          3,
+		MembersListKey: "gang-members:Suspend_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	dberr = gangRepo.JoinGang(ctx, logger, entity.GangJoin{Admin: testGang.Admin, Name: testGang.Name, Key: "gang:" + testGang.Admin}, "Suspend_Member123")
+	if dberr != nil {
+		// Issues in JoinGang()
+		t.Fatal()
+	}
+
+	// Suspension needs a duration
+	executeAdminAPI(t, http.MethodPost, "/api/admin/user/status", entity.AdminUserStatus{Username: "Suspend_Member123", Status: entity.UserStatusSuspended}, operatorCookie, http.StatusBadRequest)
+	// Suspended member is removed from the joined gang
+	executeAdminAPI(t, http.MethodPost, "/api/admin/user/status", entity.AdminUserStatus{Username: "Suspend_Member123", Status: entity.UserStatusSuspended, SuspendHours: 2}, operatorCookie, http.StatusOK)
+	members, dberr := gangRepo.GetGangMembers(ctx, logger, testGang.Admin)
+	assert.NoError(t, dberr)
+	assert.NotContains(t, members, "Suspend_Member123")
+	joined, dberr := gangRepo.GetJoinedGang(ctx, logger, "Suspend_Member123")
+	assert.NoError(t, dberr)
+	assert.Empty(t, joined.Admin)
+	suspended, dberr := userRepo.GetUser(ctx, logger, "Suspend_Member123")
+	assert.NoError(t, dberr)
+	assert.Equal(t, entity.UserStatusSuspended, suspended.Status)
+	assert.Greater(t, suspended.SuspendedUntil, time.Now().Add(time.Hour).Unix())
+}
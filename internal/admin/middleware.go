@@ -0,0 +1,43 @@
+// Operator middleware is used to restrict platform-wide moderation endpoints to Popcorn operators.
+
+package admin
+
+import (
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/internal/user"
+	"Popcorn/pkg/log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// This middleware should be chained after AuthMiddleware, as it needs the authenticated User in context.
+// Operator role is read fresh from the DB, so revoking the role takes effect immediately.
+func OperatorMiddleware(logger log.Logger, userRepo user.Repository) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		ctxUser, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in OperatorMiddleware")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		operator, dberr := userRepo.GetUser(gctx, logger, ctxUser.Username)
+		if dberr != nil {
+			// Error occured in GetUser()
+			err, ok := dberr.(errors.ErrorResponse)
+			if !ok || err.Status != http.StatusNotFound {
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(http.StatusForbidden, errors.Forbidden(""))
+			return
+		} else if !operator.Operator {
+			// Not an operator
+			gctx.AbortWithStatusJSON(http.StatusForbidden, errors.Forbidden(""))
+			return
+		}
+		gctx.Next()
+	}
+}
@@ -0,0 +1,85 @@
+// Admin repository encapsulates the data access logic (interactions with the DB) related to Operator actions in Popcorn.
+
+package admin
+
+import (
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/pkg/db"
+	"Popcorn/pkg/log"
+	"context"
+	"encoding/json"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var auditDbKey string = "popcorn:audit"
+
+// Max number of operator actions kept in the audit log.
+const auditMaxEntries = 10000
+
+// Number of audit logs returned per page in get_audit.
+const auditPageSize = 20
+
+type Repository interface {
+	// AddAuditLog appends an operator action into the audit log.
+	AddAuditLog(ctx context.Context, logger log.Logger, audit entity.AuditLog) error
+	// GetAuditLog returns a page of the audit log, latest first.
+	GetAuditLog(ctx context.Context, logger log.Logger, cursor int64) ([]entity.AuditLog, int64, error)
+}
+
+// repository struct of admin Repository.
+// Object of this will be passed around from main to internal.
+// Helps to access the repository layer interface and call methods.
+type repository struct {
+	db *db.RedisDB
+}
+
+// Returns a new instance of admin repository for other packages to access its interface.
+func NewRepository(dbwrp *db.RedisDB) Repository {
+	return repository{db: dbwrp}
+}
+
+// Returns nil if operator action got successfully appended into the audit log.
+func (r repository) AddAuditLog(ctx context.Context, logger log.Logger, audit entity.AuditLog) error {
+	auditData, jsonerr := json.Marshal(audit)
+	if jsonerr != nil {
+		logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during marshalling audit in admin.AddAuditLog")
+		return errors.InternalServerError("")
+	}
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, auditDbKey, auditData)
+		pipe.LTrim(ctx, auditDbKey, 0, auditMaxEntries-1)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.LPush() in admin.AddAuditLog")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Returns auditPageSize audit logs starting from cursor, along with the next cursor (0 if no more left).
+func (r repository) GetAuditLog(ctx context.Context, logger log.Logger, cursor int64) ([]entity.AuditLog, int64, error) {
+	audits := []entity.AuditLog{}
+	auditData, dberr := r.db.Client().LRange(ctx, auditDbKey, cursor, cursor+auditPageSize-1).Result()
+	if dberr != nil && dberr != redis.Nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.LRange() in admin.GetAuditLog")
+		return audits, 0, errors.InternalServerError("")
+	}
+	for _, data := range auditData {
+		var audit entity.AuditLog
+		if jsonerr := json.Unmarshal([]byte(data), &audit); jsonerr != nil {
+			logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during unmarshalling audit in admin.GetAuditLog")
+			return audits, 0, errors.InternalServerError("")
+		}
+		audits = append(audits, audit)
+	}
+	if len(audits) < auditPageSize {
+		// Reached the end of the log
+		return audits, 0, nil
+	}
+	return audits, cursor + auditPageSize, nil
+}
@@ -0,0 +1,302 @@
+// Service layer of the internal package admin.
+
+package admin
+
+import (
+	"Popcorn/internal/auth"
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/internal/gang"
+	"Popcorn/internal/library"
+	"Popcorn/internal/metrics"
+	"Popcorn/internal/sse"
+	"Popcorn/internal/user"
+	"Popcorn/pkg/log"
+	"Popcorn/pkg/objectstore"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditListGangs     = "list_gangs"
+	AuditDeleteGang    = "delete_gang"
+	AuditStopStream    = "stop_stream"
+	AuditUserStatus    = "user_status"
+	AuditViewMetrics   = "view_metrics"
+	AuditResetMetrics  = "reset_metrics"
+	AuditListDangling  = "list_dangling_uploads"
+	AuditGrantOperator = "grant_operator"
+)
+
+// Actor used for actions Popcorn performs on its own.
+const auditSystemOperator = "popcorn"
+
+// Service layer of internal package admin which encapsulates platform-wide moderation logic of Popcorn.
+type Service interface {
+	// List live gangs in Popcorn, optionally only the ones streaming
+	listgangs(ctx context.Context, operator string, cursor uint64, streaming bool) ([]entity.GangResponse, uint64, error)
+	// Force delete a gang
+	deletegang(ctx context.Context, operator string, action entity.AdminGangAction) error
+	// Force stop ongoing livestream of a gang
+	stopstream(ctx context.Context, operator string, action entity.AdminGangAction) error
+	// Activate, suspend, disable or ban an user
+	setuserstatus(ctx context.Context, operator string, status entity.AdminUserStatus) error
+	// Get Popcorn metrics
+	getmetrics(ctx context.Context, operator string) (entity.Metrics, error)
+	// Reset Popcorn metrics
+	resetmetrics(ctx context.Context, operator string) error
+	// List uploaded content files which aren't referenced by any gang or library
+	listdanglinguploads(ctx context.Context, operator string) ([]entity.DanglingUpload, error)
+	// Get audit log of operator actions
+	getauditlog(ctx context.Context, cursor int64) ([]entity.AuditLog, int64, error)
+	// Grant operator role to the given users, used during startup
+	BootstrapOperators(ctx context.Context, usernames []string)
+}
+
+// Object of this will be passed around from main to routers to API.
+// Helps to access the service layer interface and call methods.
+// Also helps to pass objects to be used from outer layer.
+type service struct {
+	adminRepo      Repository
+	authRepo       auth.Repository
+	userRepo       user.Repository
+	gangRepo       gang.Repository
+	libraryRepo    library.Repository
+	gangService    gang.Service
+	metricsService metrics.Service
+	sseService     sse.Service
+	contentStore   objectstore.Store
+	logger         log.Logger
+}
+
+// Helps to access the service layer interface and call methods. Service object is passed from main.
+func NewService(
+	adminRepo Repository,
+	authRepo auth.Repository,
+	userRepo user.Repository,
+	gangRepo gang.Repository,
+	libraryRepo library.Repository,
+	gangService gang.Service,
+	metricsService metrics.Service,
+	sseService sse.Service,
+	contentStore objectstore.Store,
+	logger log.Logger) Service {
+	return service{adminRepo, authRepo, userRepo, gangRepo, libraryRepo, gangService, metricsService, sseService, contentStore, logger}
+}
+
+func (s service) listgangs(ctx context.Context, operator string, cursor uint64, streaming bool) ([]entity.GangResponse, uint64, error) {
+	gangList, newCursor, dberr := s.gangRepo.ListGangs(ctx, s.logger, cursor)
+	if dberr != nil {
+		// Error in ListGangs()
+		return []entity.GangResponse{}, 0, dberr
+	}
+	if streaming {
+		// Only keep gangs with an ongoing stream
+		streams := []entity.GangResponse{}
+		for _, gang := range gangList {
+			if gang.Streaming {
+				streams = append(streams, gang)
+			}
+		}
+		gangList = streams
+	}
+	s.audit(ctx, operator, AuditListGangs, "", "")
+	return gangList, newCursor, nil
+}
+
+func (s service) deletegang(ctx context.Context, operator string, action entity.AdminGangAction) error {
+	valerr := s.validateAdminData(ctx, action)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	err := s.gangService.DeleteGang(ctx, action.Admin)
+	if err != nil {
+		// Error in DeleteGang()
+		return err
+	}
+	s.audit(ctx, operator, AuditDeleteGang, action.Admin, "")
+	return nil
+}
+
+func (s service) stopstream(ctx context.Context, operator string, action entity.AdminGangAction) error {
+	valerr := s.validateAdminData(ctx, action)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	err := s.gangService.StopContent(ctx, action.Admin)
+	if err != nil {
+		// Error in StopContent()
+		return err
+	}
+	s.audit(ctx, operator, AuditStopStream, action.Admin, "")
+	return nil
+}
+
+func (s service) setuserstatus(ctx context.Context, operator string, status entity.AdminUserStatus) error {
+	valerr := s.validateAdminData(ctx, status)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	suspendedUntil := int64(0)
+	if status.Status == entity.UserStatusSuspended {
+		if status.SuspendHours == 0 {
+			// Suspension needs a duration
+			return errors.GenerateValidationErrorResponse([]error{errors.New("suspend_hours:required while suspending an user")})
+		}
+		suspendedUntil = time.Now().Add(time.Duration(status.SuspendHours) * time.Hour).Unix()
+	}
+	target, dberr := s.userRepo.GetUser(ctx, s.logger, status.Username)
+	if dberr != nil {
+		// Error in GetUser()
+		return dberr
+	} else if target.Operator {
+		// Operators cannot lock out each other
+		return errors.Forbidden("Cannot change account status of an operator")
+	}
+	dberr = s.userRepo.SetUserStatus(ctx, s.logger, status.Username, status.Status, suspendedUntil)
+	if dberr != nil {
+		// Error in SetUserStatus()
+		return dberr
+	}
+	if status.Status != entity.UserStatusActive {
+		// Revoke every session and streaming token of the user
+		dberr = s.authRepo.DelUserTokens(ctx, s.logger, status.Username)
+		if dberr != nil {
+			// Error in DelUserTokens()
+			return dberr
+		}
+		s.userRepo.DelStreamingToken(ctx, s.logger, status.Username)
+		// Drop live SSE connection and remove the user from gangs
+		go s.sseService.DisconnectClient(ctx, status.Username)
+		dberr = s.gangService.EvictUser(ctx, status.Username)
+		if dberr != nil {
+			// Error in EvictUser()
+			return dberr
+		}
+	}
+	detail := status.Status
+	if suspendedUntil != 0 {
+		detail = fmt.Sprintf("%s until %d", status.Status, suspendedUntil)
+	}
+	s.audit(ctx, operator, AuditUserStatus, status.Username, detail)
+	return nil
+}
+
+func (s service) getmetrics(ctx context.Context, operator string) (entity.Metrics, error) {
+	metrics, dberr := s.metricsService.GetMetrics(ctx)
+	if dberr != nil {
+		// Error in GetMetrics()
+		return entity.Metrics{}, dberr
+	}
+	s.audit(ctx, operator, AuditViewMetrics, "", "")
+	return metrics, nil
+}
+
+func (s service) resetmetrics(ctx context.Context, operator string) error {
+	dberr := s.metricsService.SetIngressQuotaExceeded(ctx, false)
+	if dberr != nil {
+		// Error in SetIngressQuotaExceeded()
+		return dberr
+	}
+	// Ingress leases are left alone, they are held by live streams and reconciled by the upload Janitor
+	s.audit(ctx, operator, AuditResetMetrics, "", "")
+	return nil
+}
+
+func (s service) listdanglinguploads(ctx context.Context, operator string) ([]entity.DanglingUpload, error) {
+	dangling := []entity.DanglingUpload{}
+	// Collect content IDs referenced by live gangs and library items
+	referenced := map[string]struct{}{}
+	cursor := uint64(0)
+	for {
+		gangList, newCursor, dberr := s.gangRepo.ListGangs(ctx, s.logger, cursor)
+		if dberr != nil {
+			// Error in ListGangs()
+			return dangling, dberr
+		}
+		for _, gang := range gangList {
+			if gang.ContentID != "" {
+				referenced[gang.ContentID] = struct{}{}
+			}
+		}
+		if newCursor == 0 {
+			break
+		}
+		cursor = newCursor
+	}
+	items, dberr := s.libraryRepo.ListLibraryItems(ctx, s.logger)
+	if dberr != nil {
+		// Error in ListLibraryItems()
+		return dangling, dberr
+	}
+	for contentID := range items {
+		referenced[contentID] = struct{}{}
+	}
+	uploads, strerr := s.contentStore.List(ctx)
+	if strerr != nil {
+		s.logger.WithCtx(ctx).Error().Err(strerr).Msg("Error occured during listing content store in admin.listdanglinguploads")
+		return dangling, errors.InternalServerError("")
+	}
+	for _, upload := range uploads {
+		if _, ok := referenced[upload.ID]; ok {
+			continue
+		}
+		dangling = append(dangling, entity.DanglingUpload{
+			ID:       upload.ID,
+			Size:     upload.Size,
+			Modified: upload.Modified,
+		})
+	}
+	s.audit(ctx, operator, AuditListDangling, "", "")
+	return dangling, nil
+}
+
+func (s service) getauditlog(ctx context.Context, cursor int64) ([]entity.AuditLog, int64, error) {
+	return s.adminRepo.GetAuditLog(ctx, s.logger, cursor)
+}
+
+func (s service) BootstrapOperators(ctx context.Context, usernames []string) {
+	for _, username := range usernames {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+		dberr := s.userRepo.SetUserOperator(ctx, s.logger, username, true)
+		if dberr != nil {
+			// User might not have registered yet
+			s.logger.WithCtx(ctx).Warn().Msgf("Couldn't grant operator role to %s", username)
+			continue
+		}
+		s.audit(ctx, auditSystemOperator, AuditGrantOperator, username, "")
+	}
+}
+
+// Helper to record an operator action in the audit log.
+// Failure to record is logged by the repository and never fails the ongoing request.
+func (s service) audit(ctx context.Context, operator, action, target, detail string) {
+	s.adminRepo.AddAuditLog(ctx, s.logger, entity.AuditLog{
+		Operator: operator,
+		Action:   action,
+		Target:   target,
+		Detail:   detail,
+		Created:  time.Now().Unix(),
+	})
+}
+
+// Helper to validate the operator request data against validation-tags mentioned in its entity.
+func (s service) validateAdminData(ctx context.Context, data interface{}) error {
+	_, valerr := govalidator.ValidateStruct(data)
+	if valerr != nil {
+		valerr := valerr.(govalidator.Errors).Errors()
+		return errors.GenerateValidationErrorResponse(valerr)
+	}
+	return nil
+}
@@ -563,3 +563,82 @@ func TestValidateTokenSuccess(t *testing.T) {
 	}
 	test.ExecuteAPITest(logger, t, mockRouter, &request)
 }
+
+func TestAccountStatus(t *testing.T) {
+	// Register an user to get access_token & refresh_token
+	data := struct {
+		Username interface{} `json:"username,omitempty"`
+		FullName interface{} `json:"full_name,omitempty"`
+		Password interface{} `json:"password,omitempty"`
+	}{
+		Username: "suspended_User123",
+		FullName: "Suspended User",
+		Password: "popcorn123",
+	}
+	body, mrserr := json.Marshal(data)
+	if mrserr != nil {
+		logger.Error().Err(mrserr).Msg("Couldn't marshall authtest struct into json in TestAccountStatus()")
+		t.Fatal()
+	}
+	request := test.RequestAPITest{
+		Method:       http.MethodPost,
+		Path:         "/api/auth/register",
+		Body:         bytes.NewReader(body),
+		WantResponse: []int{http.StatusOK},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{},
+	}
+	initialResponse := test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	// Helper to run every auth flow against the current account status
+	execute := func(want ...int) {
+		for _, path := range []string{"/api/auth/login", "/api/auth/validate_token", "/api/auth/refresh_token"} {
+			method, cookie, payload := http.MethodGet, initialResponse.Cookie, []byte{}
+			if path == "/api/auth/login" {
+				method, cookie, payload = http.MethodPost, []*http.Cookie{}, body
+			} else if path == "/api/auth/refresh_token" {
+				method = http.MethodPost
+			}
+			request := test.RequestAPITest{
+				Method:       method,
+				Path:         path,
+				Body:         bytes.NewReader(payload),
+				WantResponse: want,
+				Header:       test.MockHeader(),
+				Parameters:   url.Values{},
+				Cookie:       cookie,
+			}
+			test.ExecuteAPITest(logger, t, mockRouter, &request)
+		}
+	}
+
+	// Ongoing suspension blocks every flow
+	suspendedUntil := time.Now().Add(time.Hour).Unix()
+	if dberr := userRepo.SetUserStatus(ctx, logger, "suspended_User123", "suspended", suspendedUntil); dberr != nil {
+		t.Fatal(dberr)
+	}
+	execute(http.StatusForbidden)
+
+	// Banned accounts are blocked as well, refresh_token was already consumed by the previous attempt
+	if dberr := userRepo.SetUserStatus(ctx, logger, "suspended_User123", "banned", 0); dberr != nil {
+		t.Fatal(dberr)
+	}
+	execute(http.StatusForbidden, http.StatusUnauthorized)
+
+	// Expired suspension is treated as active
+	suspendedUntil = time.Now().Add(-time.Hour).Unix()
+	if dberr := userRepo.SetUserStatus(ctx, logger, "suspended_User123", "suspended", suspendedUntil); dberr != nil {
+		t.Fatal(dberr)
+	}
+	request = test.RequestAPITest{
+		Method:       http.MethodPost,
+		Path:         "/api/auth/login",
+		Body:         bytes.NewReader(body),
+		WantResponse: []int{http.StatusOK},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{},
+	}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+}
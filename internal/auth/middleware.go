@@ -7,6 +7,7 @@ import (
 	"Popcorn/internal/errors"
 	"Popcorn/internal/user"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/monitor"
 	"fmt"
 	"net/http"
 
@@ -25,12 +26,12 @@ func AuthMiddleware(logger log.Logger, authRepo Repository, userRepo user.Reposi
 		vrftoken, valerr := parseIntoJWT(gctx, logger, secret, token)
 		if valerr != nil {
 			// Abort the call chain for the request here as the user is unauthenticated
-			gctx.AbortWithStatus(http.StatusUnauthorized)
+			rejectAuth(gctx, tokenType, "invalid_token")
 			return
 		}
 		// Check the parsed token for validity
 		if !vrftoken.Valid {
-			gctx.AbortWithStatus(http.StatusUnauthorized)
+			rejectAuth(gctx, tokenType, "invalid_token")
 			return
 		}
 		// Extract TokenUUID and UserID from token claims
@@ -38,14 +39,14 @@ func AuthMiddleware(logger log.Logger, authRepo Repository, userRepo user.Reposi
 		if !ok {
 			// Type assertion error
 			logger.WithCtx(gctx).Error().Msg("Type assertion error during asserting jwt.Claims to jwt.MapClaims in AuthMiddleware")
-			gctx.AbortWithStatus(http.StatusUnauthorized)
+			rejectAuth(gctx, tokenType, "invalid_claims")
 			return
 		}
 		tokenUUID, ok := tokenclaims[tokenType+"_uuid"].(string)
 		if !ok {
 			// Type assertion error
 			logger.WithCtx(gctx).Error().Msg("Type assertion error during asserting jwt.MapClaims to string in AuthMiddleware")
-			gctx.AbortWithStatus(http.StatusUnauthorized)
+			rejectAuth(gctx, tokenType, "invalid_claims")
 			return
 		}
 		// Successfully saved UserID is stored in float64 format even though type uint64 is passed during signing
@@ -54,7 +55,7 @@ func AuthMiddleware(logger log.Logger, authRepo Repository, userRepo user.Reposi
 		if !ok {
 			// Type assertion error
 			logger.WithCtx(gctx).Error().Msg("Type assertion error during asserting jwt.MapClaims to string in AuthMiddleware")
-			gctx.AbortWithStatus(http.StatusUnauthorized)
+			rejectAuth(gctx, tokenType, "invalid_claims")
 			return
 		}
 		// Verify if TokenUUID:UserID is available in DB
@@ -65,7 +66,7 @@ func AuthMiddleware(logger log.Logger, authRepo Repository, userRepo user.Reposi
 			return
 		} else if !valid {
 			// token missing in DB or mismatch with UserID
-			gctx.AbortWithStatus(http.StatusUnauthorized)
+			rejectAuth(gctx, tokenType, "revoked_token")
 			return
 		}
 		// In case of tokenType = "refresh_token", delete the previous refresh_token first
@@ -79,7 +80,7 @@ func AuthMiddleware(logger log.Logger, authRepo Repository, userRepo user.Reposi
 					gctx.AbortWithStatus(http.StatusInternalServerError)
 				}
 				// Maybe the key wasn't present in the DB at all
-				gctx.AbortWithStatus(http.StatusUnauthorized)
+				rejectAuth(gctx, tokenType, "revoked_token")
 				return
 			}
 		}
@@ -89,6 +90,14 @@ func AuthMiddleware(logger log.Logger, authRepo Repository, userRepo user.Reposi
 		if dberr != nil {
 			// Error during DB interaction
 			gctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		// Block suspended, disabled or banned accounts
+		if staterr := accountStatusError(user); staterr != nil {
+			monitor.AuthFailures.WithLabelValues(tokenType, "account_status").Inc()
+			err := staterr.(errors.ErrorResponse)
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
 		}
 		gctx.Set("User", user)
 		// Set User's accessToken which might be useful during logout
@@ -99,6 +108,12 @@ func AuthMiddleware(logger log.Logger, authRepo Repository, userRepo user.Reposi
 	}
 }
 
+// Helper to reject an unauthenticated request, counted as an auth failure of tokenType for reason.
+func rejectAuth(gctx *gin.Context, tokenType, reason string) {
+	monitor.AuthFailures.WithLabelValues(tokenType, reason).Inc()
+	gctx.AbortWithStatus(http.StatusUnauthorized)
+}
+
 // Helper to fetch token string from Header.
 func fetchTokenFromCookie(gctx *gin.Context, logger log.Logger, tokenType string) string {
 	var token *http.Cookie
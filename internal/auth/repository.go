@@ -19,6 +19,8 @@ type Repository interface {
 	HasToken(ctx context.Context, logger log.Logger, tokenUUID string, username string) (bool, error)
 	// DelToken deletes TokenUUID from DB (if exists).
 	DelToken(ctx context.Context, logger log.Logger, tokenUUID string) error
+	// DelUserTokens deletes every token issued to the user, logging them out of every session.
+	DelUserTokens(ctx context.Context, logger log.Logger, username string) error
 }
 
 // repository struct of auth Repository.
@@ -50,6 +52,17 @@ func (r repository) SetToken(ctx context.Context, logger log.Logger, jwtData *JW
 		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.Set in auth.SetToken")
 		return errors.InternalServerError("")
 	}
+	// Index issued tokens under user-tokens:<username> to be able to revoke every session of the user
+	tokensKey := "user-tokens:" + jwtData.Username
+	_, dberr = r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, tokensKey, jwtData.AccessTokenUUID, jwtData.RefTokenUUID)
+		pipe.Expire(ctx, tokensKey, refTokenExp.Sub(now))
+		return nil
+	})
+	if dberr != nil {
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.SAdd in auth.SetToken")
+		return errors.InternalServerError("")
+	}
 	return nil
 }
 
@@ -79,3 +92,19 @@ func (r repository) DelToken(ctx context.Context, logger log.Logger, tokenUUID s
 	}
 	return nil
 }
+
+// Returns nil if every token indexed under user-tokens:<username> got deleted from DB.
+func (r repository) DelUserTokens(ctx context.Context, logger log.Logger, username string) error {
+	tokensKey := "user-tokens:" + username
+	tokens, dberr := r.db.Client().SMembers(ctx, tokensKey).Result()
+	if dberr != nil && dberr != redis.Nil {
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.SMembers in auth.DelUserTokens")
+		return errors.InternalServerError("")
+	}
+	_, dberr = r.db.Client().Del(ctx, append(tokens, tokensKey)...).Result()
+	if dberr != nil {
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.Del in auth.DelUserTokens")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"Popcorn/internal/entity"
 	"Popcorn/internal/errors"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/monitor"
 	"context"
 	"strings"
 	"time"
@@ -132,6 +133,7 @@ func (s service) login(ctx context.Context, request entity.UserLogin) (map[strin
 		return token, dberr
 	} else if !available {
 		// User by the received username is not available in the platform
+		monitor.AuthFailures.WithLabelValues("login", "invalid_credentials").Inc()
 		return token, errors.Unauthorized("Username or Password is incorrect")
 	}
 
@@ -142,12 +144,12 @@ func (s service) login(ctx context.Context, request entity.UserLogin) (map[strin
 		return token, dberr
 	} else if !s.verifyPwDHash(ctx, request.Password, user.Password) {
 		// Invalid password
+		monitor.AuthFailures.WithLabelValues("login", "invalid_credentials").Inc()
 		return token, errors.Unauthorized("Username or Password is incorrect")
-	}
-
-	if dberr != nil {
-		// Error occured in Set()
-		return token, dberr
+	} else if staterr := accountStatusError(user); staterr != nil {
+		// Account is not allowed to login
+		monitor.AuthFailures.WithLabelValues("login", "account_status").Inc()
+		return token, staterr
 	}
 
 	// Generate JWT for the newly created user
@@ -189,6 +191,14 @@ func (s service) logout(ctx context.Context) error {
 
 func (s service) refreshtoken(ctx context.Context, username string) (map[string]any, error) {
 	token := make(map[string]any)
+	user, dberr := s.userRepo.GetUser(ctx, s.logger, username)
+	if dberr != nil {
+		// Error occured in GetUser()
+		return token, dberr
+	} else if staterr := accountStatusError(user); staterr != nil {
+		// Account is not allowed to refresh its session
+		return token, staterr
+	}
 	// Create fresh JWT for user
 	userJWTData, jwterr := s.createToken(ctx, username)
 	if jwterr != nil {
@@ -196,7 +206,7 @@ func (s service) refreshtoken(ctx context.Context, username string) (map[string]
 		return token, errors.InternalServerError("")
 	}
 	// Save generated tokens with expiration into the DB
-	dberr := s.authRepo.SetToken(ctx, s.logger, userJWTData)
+	dberr = s.authRepo.SetToken(ctx, s.logger, userJWTData)
 	if dberr != nil {
 		// Error during saving user's JWT
 		return token, dberr
@@ -210,6 +220,23 @@ func (s service) refreshtoken(ctx context.Context, username string) (map[string]
 	return token, nil
 }
 
+// Helper to check whether the account status of user allows access to Popcorn.
+// Returns a forbidden error for banned, disabled or currently suspended accounts, expired suspensions are let through.
+func accountStatusError(user entity.User) error {
+	switch user.Status {
+	case entity.UserStatusBanned:
+		return errors.Forbidden("Your account has been banned")
+	case entity.UserStatusDisabled:
+		return errors.Forbidden("Your account has been disabled")
+	case entity.UserStatusSuspended:
+		if time.Now().Unix() < user.SuspendedUntil {
+			until := time.Unix(user.SuspendedUntil, 0).UTC().Format(time.RFC3339)
+			return errors.Forbidden("Your account is suspended until " + until)
+		}
+	}
+	return nil
+}
+
 // Helper to validate the user data against validation-tags mentioned in its entity.
 func (s service) validateUserData(ctx context.Context, ue interface{}) error {
 	_, valerr := govalidator.ValidateStruct(ue)
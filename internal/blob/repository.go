@@ -0,0 +1,184 @@
+// Blob repository encapsulates the data access logic (interactions with the DB) related to deduplicated contents in Popcorn.
+// Uploads carrying the same content hash share a single blob, which is deleted once nothing refers to it anymore.
+
+package blob
+
+import (
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/pkg/db"
+	"Popcorn/pkg/log"
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type Repository interface {
+	// SetOrAcquireBlob saves blob holding a single reference, unless a blob with the same hash exists.
+	// In that case a reference to the existing blob is taken and the existing blob is returned.
+	SetOrAcquireBlob(ctx context.Context, logger log.Logger, blob entity.Blob) (entity.Blob, error)
+	// AcquireBlob takes a reference to the blob with the given hash.
+	AcquireBlob(ctx context.Context, logger log.Logger, hash string) (entity.Blob, error)
+	// ReleaseBlob drops a reference to content, returns true if nothing refers to it anymore.
+	ReleaseBlob(ctx context.Context, logger log.Logger, contentID string) (bool, error)
+	// DelBlob deletes blob of content irrespective of its references.
+	DelBlob(ctx context.Context, logger log.Logger, contentID string) error
+}
+
+// repository struct of blob Repository.
+// Object of this will be passed around from main to internal.
+// Helps to access the repository layer interface and call methods.
+type repository struct {
+	db *db.RedisDB
+}
+
+// Returns a new instance of blob repository for other packages to access its interface.
+func NewRepository(dbwrp *db.RedisDB) Repository {
+	return repository{db: dbwrp}
+}
+
+// Helper to run txf in a transaction, retried as long as the watched keys get changed in between.
+func (r repository) watch(ctx context.Context, txf func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < r.db.GetMaxRetries(); i++ {
+		dberr := r.db.Client().Watch(ctx, txf, keys...)
+		if dberr != redis.TxFailedErr {
+			return dberr
+		}
+		// Optimistic lock lost. Retry.
+	}
+	return errors.New("increment reached maximum number of retries")
+}
+
+// Helper to take a reference to the blob with the given hash within tx, returns false if there is no such blob.
+func acquire(ctx context.Context, tx *redis.Tx, hash string) (entity.Blob, bool, error) {
+	var blob entity.Blob
+	contentID, dberr := tx.Get(ctx, "blob-hash:"+hash).Result()
+	if dberr == redis.Nil {
+		return blob, false, nil
+	} else if dberr != nil {
+		return blob, false, dberr
+	}
+	blobKey := "blob:" + contentID
+	if dberr = tx.Watch(ctx, blobKey).Err(); dberr != nil {
+		return blob, false, dberr
+	}
+	if dberr = tx.HGetAll(ctx, blobKey).Scan(&blob); dberr != nil {
+		return blob, false, dberr
+	} else if blob.ID == "" || blob.Refs <= 0 {
+		// Released in the meantime, content is on its way out
+		return entity.Blob{}, false, nil
+	}
+	_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, blobKey, "refs", 1)
+		return nil
+	})
+	blob.Refs += 1
+	return blob, true, dberr
+}
+
+func (r repository) SetOrAcquireBlob(ctx context.Context, logger log.Logger, blob entity.Blob) (entity.Blob, error) {
+	hashKey := "blob-hash:" + blob.Hash
+	var result entity.Blob
+	txf := func(tx *redis.Tx) error {
+		stored, found, dberr := acquire(ctx, tx, blob.Hash)
+		if dberr != nil || found {
+			result = stored
+			return dberr
+		}
+		result = blob
+		result.Refs = 1
+		_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, hashKey, result.ID, 0)
+			pipe.HSet(ctx, "blob:"+result.ID, "id", result.ID, "hash", result.Hash, "refs", result.Refs, "size", result.Size, "meta", string(result.Meta))
+			return nil
+		})
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, hashKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in SetOrAcquireBlob transaction")
+		return entity.Blob{}, errors.InternalServerError("")
+	}
+	return result, nil
+}
+
+func (r repository) AcquireBlob(ctx context.Context, logger log.Logger, hash string) (entity.Blob, error) {
+	var result entity.Blob
+	var found bool
+	txf := func(tx *redis.Tx) error {
+		var dberr error
+		result, found, dberr = acquire(ctx, tx, hash)
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, "blob-hash:"+hash); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in AcquireBlob transaction")
+		return entity.Blob{}, errors.InternalServerError("")
+	} else if !found {
+		return entity.Blob{}, errors.NotFound("Content not stored")
+	}
+	return result, nil
+}
+
+func (r repository) ReleaseBlob(ctx context.Context, logger log.Logger, contentID string) (bool, error) {
+	blobKey := "blob:" + contentID
+	var last bool
+	txf := func(tx *redis.Tx) error {
+		var blob entity.Blob
+		if dberr := tx.HGetAll(ctx, blobKey).Scan(&blob); dberr != nil {
+			return dberr
+		} else if blob.ID == "" {
+			// Content was never deduplicated, nothing else can refer to it
+			last = true
+			return nil
+		}
+		last = blob.Refs <= 1
+		if !last {
+			_, dberr := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HIncrBy(ctx, blobKey, "refs", -1)
+				return nil
+			})
+			return dberr
+		}
+		return r.del(ctx, tx, blob)
+	}
+	if txferr := r.watch(ctx, txf, blobKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in ReleaseBlob transaction")
+		return false, errors.InternalServerError("")
+	}
+	return last, nil
+}
+
+func (r repository) DelBlob(ctx context.Context, logger log.Logger, contentID string) error {
+	blobKey := "blob:" + contentID
+	txf := func(tx *redis.Tx) error {
+		var blob entity.Blob
+		if dberr := tx.HGetAll(ctx, blobKey).Scan(&blob); dberr != nil || blob.ID == "" {
+			return dberr
+		}
+		return r.del(ctx, tx, blob)
+	}
+	if txferr := r.watch(ctx, txf, blobKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in DelBlob transaction")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Helper to delete blob within tx, hash is left alone if it points to another blob by now.
+func (r repository) del(ctx context.Context, tx *redis.Tx, blob entity.Blob) error {
+	hashKey := "blob-hash:" + blob.Hash
+	if dberr := tx.Watch(ctx, hashKey).Err(); dberr != nil {
+		return dberr
+	}
+	contentID, dberr := tx.Get(ctx, hashKey).Result()
+	if dberr != nil && dberr != redis.Nil {
+		return dberr
+	}
+	_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, "blob:"+blob.ID)
+		if contentID == blob.ID {
+			pipe.Del(ctx, hashKey)
+		}
+		return nil
+	})
+	return dberr
+}
@@ -0,0 +1,37 @@
+// Structure of Operator Models in Popcorn.
+
+package entity
+
+// Information structure of operator actions in Popcorn, appended to popcorn:audit DB list.
+type AuditLog struct {
+	// Username of the operator who performed the action.
+	Operator string `json:"operator"`
+	// Action performed, e.g., delete_gang, stop_stream, user_status.
+	Action string `json:"action"`
+	// Optional username or resource affected by the action.
+	Target string `json:"target,omitempty"`
+	// Optional details of the action.
+	Detail string `json:"detail,omitempty"`
+	// Audit Timestamp.
+	Created int64 `json:"created"`
+}
+
+// Used to bind and validate operator requests targeting a gang.
+type AdminGangAction struct {
+	Admin string `json:"gang_admin" valid:"required,type(string),printableascii,stringlength(5|30),username_custom~gang_admin:Invalid Username"`
+}
+
+// Used to bind and validate operator requests changing account status of an user.
+type AdminUserStatus struct {
+	Username string `json:"username" valid:"required,type(string),printableascii,stringlength(5|30),username_custom~username:Invalid Username"`
+	Status   string `json:"status" valid:"required,type(string),in(active|suspended|disabled|banned)"`
+	// Suspension duration in hours, required only while suspending an user.
+	SuspendHours uint `json:"suspend_hours,omitempty" valid:"optional,range(1|8760)"`
+}
+
+// Information structure of uploaded content files which aren't referenced by any gang.
+type DanglingUpload struct {
+	ID       string `json:"id"`
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"`
+}
@@ -23,8 +23,14 @@ type Gang struct {
 	ContentID string `json:"-" redis:"gang_content_ID" valid:"-"`
 	// Gang Content URL.
 	ContentURL string `json:"gang_content_url" redis:"gang_content_url" valid:"url,optional"`
+	// Rendition of manifest content URL picked by the admin, the one of highest bandwidth if empty.
+	ContentRendition string `json:"gang_content_rendition" redis:"gang_content_rendition" valid:"printableascii,stringlength(1|64),optional"`
+	// Manifest of content URL, set by server.
+	ContentManifest ContentMeta `json:"-" redis:"gang_content_manifest" valid:"-"`
 	// Gang Screen Share.
 	ContentScreenShare bool `json:"gang_screen_share" redis:"gang_screen_share" valid:"-"`
+	// Quality profile content gets streamed with, the default one allowed by server if empty.
+	Quality string `json:"gang_quality" redis:"gang_quality" valid:"in(data-saver|standard|hd|stereo),optional"`
 	// Gang Stream status.
 	Streaming bool `json:"-" redis:"gang_streaming" valid:"-"`
 	// Gang invite hash which decyphers to gang:<Gang.Admin>:<Gang.Name>.
@@ -34,18 +40,50 @@ type Gang struct {
 // Response structure of Gangs in Popcorn, typically used in get methods.
 // Used to send gang data to client.
 type GangResponse struct {
-	Admin              string `json:"gang_admin,omitempty" redis:"gang_admin"`
-	Name               string `json:"gang_name" redis:"gang_name"`
-	Limit              uint   `json:"gang_member_limit" redis:"gang_member_limit"`
-	IsAdmin            bool   `json:"is_admin"`
-	Count              int    `json:"gang_members_count"`
-	Created            int64  `json:"gang_created,omitempty" redis:"gang_created"`
-	ContentName        string `json:"gang_content_name" redis:"gang_content_name"`
-	ContentID          string `json:"gang_content_ID" redis:"gang_content_ID"`
-	ContentURL         string `json:"gang_content_url" redis:"gang_content_url"`
-	ContentScreenShare bool   `json:"gang_screen_share" redis:"gang_screen_share"`
-	Streaming          bool   `json:"gang_streaming" redis:"gang_streaming"`
-	InviteHashCode     string `json:"gang_invite_hashcode" redis:"gang_invite_hashcode"`
+	Admin              string      `json:"gang_admin,omitempty" redis:"gang_admin"`
+	Name               string      `json:"gang_name" redis:"gang_name"`
+	Limit              uint        `json:"gang_member_limit" redis:"gang_member_limit"`
+	IsAdmin            bool        `json:"is_admin"`
+	Count              int         `json:"gang_members_count"`
+	Created            int64       `json:"gang_created,omitempty" redis:"gang_created"`
+	ContentName        string      `json:"gang_content_name" redis:"gang_content_name"`
+	ContentID          string      `json:"gang_content_ID" redis:"gang_content_ID"`
+	ContentURL         string      `json:"gang_content_url" redis:"gang_content_url"`
+	ContentScreenShare bool        `json:"gang_screen_share" redis:"gang_screen_share"`
+	ContentRendition   string      `json:"gang_content_rendition,omitempty" redis:"gang_content_rendition"`
+	ContentManifest    ContentMeta `json:"gang_content_manifest,omitempty" redis:"gang_content_manifest"`
+	ContentMeta        ContentMeta `json:"gang_content_meta,omitempty" redis:"gang_content_meta"`
+	ContentLibrary     bool        `json:"gang_content_library" redis:"gang_content_library"`
+	ContentUpdated     int64       `json:"-" redis:"gang_content_updated"`
+	Quality            string      `json:"gang_quality" redis:"gang_quality"`
+	Streaming          bool        `json:"gang_streaming" redis:"gang_streaming"`
+	StreamStarted      int64       `json:"gang_stream_started" redis:"gang_stream_started"`
+	// Time the stream got accounted in the usage till
+	StreamMetered int64 `json:"-" redis:"gang_stream_metered"`
+	// Seconds the gang has streamed for through ingress and screen shares
+	IngressUsage     int64  `json:"gang_ingress_seconds" redis:"gang_ingress_usage"`
+	ScreenShareUsage int64  `json:"gang_screen_share_seconds" redis:"gang_screen_share_usage"`
+	InviteHashCode   string `json:"gang_invite_hashcode" redis:"gang_invite_hashcode"`
+}
+
+// Media metadata of uploaded gang content found during probing, or manifest of content URL.
+// Saved as JSON and sent to clients as is.
+type ContentMeta string
+
+func (m ContentMeta) MarshalJSON() ([]byte, error) {
+	if m == "" {
+		return []byte("null"), nil
+	}
+	return []byte(m), nil
+}
+
+func (m *ContentMeta) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = ""
+		return nil
+	}
+	*m = ContentMeta(data)
+	return nil
 }
 
 // Saved in DB as gang-members:<members>.
@@ -90,7 +128,100 @@ type GangExit struct {
 
 // Used to bind and validate incoming gang conversations in Popcorn.
 type GangMessage struct {
-	Message string `json:"message" valid:"required,type(string)"`
+	Message string `json:"message" valid:"required,type(string),stringlength(1|500)"`
+}
+
+// Information structure of gang conversations in Popcorn.
+// Saved in DB as gang-messages:<Gang.Admin> hash, keyed by GangMessageData.ID.
+type GangMessageData struct {
+	// Unique message ID generated during send_msg.
+	ID string `json:"message_id"`
+	// Username of the message sender.
+	Author string `json:"author"`
+	// Message content, after passing through the word filter.
+	Text string `json:"text"`
+	// Message Timestamp.
+	Created int64 `json:"created"`
+	// Timestamp of the last edit, 0 if never edited.
+	Edited int64 `json:"edited"`
+}
+
+// Used to bind and validate edit_msg request.
+type GangMessageEdit struct {
+	ID      string `json:"message_id" valid:"required,type(string),alphanum,stringlength(20|20)"`
+	Message string `json:"message" valid:"required,type(string),stringlength(1|500)"`
+}
+
+// Used to bind and validate delete_msg request.
+type GangMessageDelete struct {
+	ID string `json:"message_id" valid:"required,type(string),alphanum,stringlength(20|20)"`
+}
+
+// Used to bind and validate mute_member request.
+type GangMute struct {
+	Member string `json:"member_name" valid:"required,type(string),printableascii,stringlength(5|30),username_custom~username:Invalid Username"`
+	// Mute duration in minutes, maximum a day.
+	Minutes uint `json:"mute_minutes" valid:"required,range(1|1440)"`
+}
+
+// Used to bind and validate incoming gang reactions in Popcorn.
+type GangReaction struct {
+	Emoji string `json:"emoji" valid:"required,type(string),reaction_custom~emoji:Unsupported reaction"`
+}
+
+// Reactions aggregated server-side within a short window, broadcasted as a single gangReaction event.
+type GangReactionBurst struct {
+	// Count of every emoji received during the window.
+	Reactions map[string]int `json:"reactions"`
+	// Playback position (in seconds) of the gang stream when the burst started, -1 if nothing is streaming.
+	Position int64 `json:"position"`
+	// Burst Timestamp.
+	Created int64 `json:"created"`
+}
+
+// Information structure of gang activity in Popcorn, appended to gang-activity:<Gang.Admin> DB list.
+type GangActivity struct {
+	// Username of the user who performed the action, "popcorn" for automated actions.
+	Actor string `json:"actor"`
+	// Action performed, e.g., join, leave, boot, passkey_change, upload, stream_start.
+	Action string `json:"action"`
+	// Optional username or resource affected by the action.
+	Target string `json:"target,omitempty"`
+	// Activity Timestamp.
+	Created int64 `json:"created"`
+}
+
+// Information structure of gang content subtitles in Popcorn.
+// Saved in DB as gang-subtitles:<Gang.Admin> hash keyed by GangSubtitle.ID, WebVTT tracks are kept in gang-subtitle-tracks:<Gang.Admin>.
+type GangSubtitle struct {
+	// Unique subtitle ID generated during upload or extraction.
+	ID string `json:"subtitle_id"`
+	// Label shown to gang members while choosing subtitles.
+	Label string `json:"label"`
+	// Optional language tag, e.g., en or en-US.
+	Language string `json:"language,omitempty"`
+	// upload if sent by the gang admin, content if extracted from the uploaded content.
+	Source string `json:"source"`
+	// Subtitle Timestamp.
+	Created int64 `json:"created"`
+}
+
+// Used to bind and validate add_subtitle request, the subtitle file is sent along as multipart form data.
+type GangSubtitleUpload struct {
+	Label    string `form:"label" valid:"required,type(string),stringlength(1|40)"`
+	Language string `form:"language" valid:"type(string),stringlength(2|35),matches(^[a-zA-Z0-9-]+$)~language:Invalid language tag,optional"`
+}
+
+// Used to bind and validate delete_subtitle request.
+type GangSubtitleDelete struct {
+	ID string `json:"subtitle_id" valid:"required,type(string),alphanum,stringlength(20|20)"`
+}
+
+// Sent to gang members as gangSubtitles event whenever subtitles of the gang content change.
+type GangSubtitles struct {
+	Subtitles []GangSubtitle `json:"subtitles"`
+	// Cue timings are relative to the stream start, 0 if nothing is streaming.
+	StreamStarted int64 `json:"gang_stream_started"`
 }
 
 type LivekitConfig struct {
@@ -104,10 +235,50 @@ type LivekitConfig struct {
 	Identity string
 	// optional content file ID for uploading track
 	Content string
+	// optional height of the manifest rendition being streamed, ingress doesn't encode past it
+	ContentHeight int
+	// optional quality profile of the content being streamed
+	Quality string
 	// optional livekit room name
 	RoomName string
 	// Livekit concurrent ingress limit
 	MaxConcurrentIngressLimit int
 	// Livekit max screenshare hours
 	MaxScreenShareHours int
+	// Seconds screen shares are kept without their publisher being confirmed by livekit or a client heartbeat
+	ScreenShareHeartbeatSeconds int
+	// Times failed ingresses get restarted before their stream ends
+	MaxStreamRetries int
+	// Quality profiles gang admins can pick from, every profile if empty
+	QualityProfiles []string
+}
+
+// Sent to gang members and the admin over SSE as gangStreamError once the ingress of the gang stream fails,
+// and as gangStreamRecovered once the stream got restarted.
+type GangStreamHealth struct {
+	// Error reported by livekit for the failed ingress
+	Error string `json:"error,omitempty"`
+	// Restart attempt the stream recovered at, 0 till the first one
+	Attempt     int `json:"attempt"`
+	MaxAttempts int `json:"max_attempts"`
+	// Seconds of content played before the failure, restarted streams play from the beginning
+	Position int64 `json:"position"`
+}
+
+// Record of a gang stream being published through livekit ingress or screen shared by the admin, so that streams outlive server restarts.
+// Saved in DB as stream:<StreamRecord.Admin>.
+type StreamRecord struct {
+	// Ingress ID, or share ID for screen shares
+	IngressID string `redis:"ingress_id"`
+	RoomName  string `redis:"room_name"`
+	Admin     string `redis:"admin"`
+	// Content file ID or URL being pulled by the ingress
+	Content string `redis:"content"`
+	Started int64  `redis:"started"`
+	// Screen shares are published by the admin instead of an ingress
+	ScreenShare bool `redis:"screen_share"`
+	// True while livekit reports the screen share track of the admin as published
+	Publishing bool `redis:"publishing"`
+	// Last time the screen share got confirmed by livekit or by a client heartbeat
+	Heartbeat int64 `redis:"heartbeat"`
 }
@@ -0,0 +1,35 @@
+// Structure of Content Library Model in Popcorn.
+
+package entity
+
+// Information structure of uploads kept in the user's content library in Popcorn.
+// Saved in DB as library:items hash keyed by <Owner>/<ID>, IDs owned by an user are kept in library:<Owner> set.
+type LibraryItem struct {
+	// Upload ID of the content.
+	ID string `json:"item_id"`
+	// Username of the uploader.
+	Owner string `json:"owner"`
+	// Content name, initially the uploaded filename.
+	Name string `json:"name"`
+	// Content size in bytes, accounted in the owner's storage usage.
+	Size int64 `json:"size"`
+	// Media metadata found during probing.
+	Meta ContentMeta `json:"meta"`
+	// Library item Timestamp.
+	Created int64 `json:"created"`
+	// Timestamp of the last time the item got uploaded or attached to a gang.
+	LastUsed int64 `json:"last_used"`
+	// Timestamp after which the item gets deleted unless used again, filled in responses.
+	Expires int64 `json:"expires"`
+}
+
+// Used to bind and validate rename request of library items.
+type LibraryRename struct {
+	ID   string `json:"item_id" valid:"required,type(string),stringlength(1|256)"`
+	Name string `json:"name" valid:"required,type(string),stringlength(1|100)"`
+}
+
+// Used to bind and validate delete and attach requests of library items.
+type LibraryItemRequest struct {
+	ID string `json:"item_id" valid:"required,type(string),stringlength(1|256)"`
+}
@@ -3,8 +3,76 @@
 package entity
 
 type Metrics struct {
-	// Current Ingress count
-	ActiveIngress int `json:"-" redis:"active_ingress"`
+	// Current Ingress count, i.e., the number of ingress leases held
+	ActiveIngress int `json:"-" redis:"-"`
 	// Ingress limit exceeded indicator
 	IngressQuotaExceeded bool `json:"ingress_quota_exceeded" redis:"ingress_quota_exceeded"`
+	// Storage used by the requesting user
+	UserStorage StorageUsage `json:"user_storage"`
+	// Storage used across Popcorn
+	TotalStorage StorageUsage `json:"total_storage"`
+}
+
+// Content storage usage in bytes, either of an user or across Popcorn.
+type StorageUsage struct {
+	// Reserved by uploads in progress, including abandoned ones
+	InFlight int64 `json:"in_flight" redis:"in_flight"`
+	// Held by completed uploads still kept in the content store
+	Stored int64 `json:"stored" redis:"stored"`
+	// Configured limit, 0 if unlimited
+	Quota int64 `json:"quota" redis:"-"`
+}
+
+// Upload tracked for storage accounting.
+type StorageUpload struct {
+	User string `redis:"user"`
+	Size int64  `redis:"size"`
+	// Upload finished and moved from in flight to stored
+	Stored bool `redis:"stored"`
+	// Unix milli time the upload got tracked at
+	Tracked int64 `redis:"tracked"`
+}
+
+// Content stored once and shared by every upload carrying the same content hash.
+// Saved in DB as blob:<Blob.ID>, blob-hash:<Blob.Hash> points to the ID.
+type Blob struct {
+	// Upload ID under which the content is kept in the store
+	ID string `redis:"id"`
+	// Hex encoded sha256 of the content
+	Hash string `redis:"hash"`
+	// Number of gangs and library items holding the content
+	Refs int64       `redis:"refs"`
+	Size int64       `redis:"size"`
+	Meta ContentMeta `redis:"meta"`
+}
+
+// Used to bind and validate pre-upload content hash check, upload is skipped if the content is stored already.
+type ContentHashCheck struct {
+	Hash     string `json:"content_hash" valid:"required,type(string),hexadecimal,stringlength(64|64)"`
+	Filename string `json:"filename" valid:"required,type(string),stringlength(1|256)"`
+	Library  bool   `json:"library" valid:"-"`
+}
+
+// Streaming usage of an user in seconds within a month, along with the budgets configured.
+// Saved in DB as stream-usage:<Month>:<username>.
+type StreamUsage struct {
+	// Month the usage is of as YYYY-MM, in UTC
+	Month string `json:"month" redis:"-"`
+	// Contents published through livekit ingress, i.e., uploaded and URL contents
+	Ingress int64 `json:"ingress_seconds" redis:"ingress"`
+	// Screen shares
+	ScreenShare int64 `json:"screen_share_seconds" redis:"screen_share"`
+	// Configured budgets, 0 if unlimited
+	IngressBudget     int64 `json:"ingress_budget_seconds" redis:"-"`
+	ScreenShareBudget int64 `json:"screen_share_budget_seconds" redis:"-"`
+}
+
+// Sent to gang admins over SSE as their streaming usage crosses a warning threshold of the monthly budget.
+type StreamUsageWarning struct {
+	// Either ingress or screen_share
+	Kind string `json:"kind"`
+	// Threshold crossed, 100 once the budget is used up
+	Percent int   `json:"percent"`
+	Used    int64 `json:"used_seconds"`
+	Budget  int64 `json:"budget_seconds"`
 }
@@ -26,6 +26,8 @@ type SSE struct {
 	NewClients chan SSEClient
 	// Closed client connections
 	ClosedClients chan SSEClient
+	// Client IDs to be force disconnected
+	DisconnectedClients chan string
 	// Total client connections
 	TotalClients map[string]chan SSEData
 }
@@ -13,8 +13,22 @@ type User struct {
 	FullName   string `json:"full_name" redis:"full_name" valid:"required,type(string),stringlength(5|30),ascii,fullname_custom~full_name:Invalid Fullname"`
 	Password   string `json:"password,omitempty" redis:"password" valid:"required,type(string),stringlength(5|730),nospace~password:Cannot contain whitespace,pwdstrength~password:At least 1 letter and 1 number is mandatory"`
 	ProfilePic string `json:"user_profile_pic,omitempty" redis:"user_profile_pic" valid:"-"`
+	// Operator role flag, allows access to platform-wide moderation APIs.
+	Operator bool `json:"-" redis:"user_operator" valid:"-"`
+	// Account status, one of UserStatusActive, UserStatusSuspended, UserStatusDisabled or UserStatusBanned.
+	Status string `json:"-" redis:"user_status" valid:"-"`
+	// Suspension end Timestamp, only used with UserStatusSuspended.
+	SuspendedUntil int64 `json:"-" redis:"user_suspended_until" valid:"-"`
 }
 
+// Account statuses of an User in Popcorn, blank status is considered active.
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusDisabled  = "disabled"
+	UserStatusBanned    = "banned"
+)
+
 // Used to bind and validate user_login request
 type UserLogin struct {
 	Username string `json:"username" valid:"required,type(string),printableascii,stringlength(5|30),username_custom~username:Invalid Username"`
@@ -95,6 +95,28 @@ func UnprocessableEntity(msg string) ErrorResponse {
 	}
 }
 
+// TooManyRequests creates a new error response representing a rate limited request (HTTP 429)
+func TooManyRequests(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "You are sending requests too quickly, please slow down."
+	}
+	return ErrorResponse{
+		Status:  http.StatusTooManyRequests,
+		Message: msg,
+	}
+}
+
+// InsufficientStorage creates a new error response representing lack of storage to fulfil the request (HTTP 507)
+func InsufficientStorage(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "Not enough storage is available to process your request."
+	}
+	return ErrorResponse{
+		Status:  http.StatusInsufficientStorage,
+		Message: msg,
+	}
+}
+
 // Standard for Validation-error responses to the client.
 type validationError struct {
 	Param   string `json:"param"`   // Parameter or Field
@@ -0,0 +1,69 @@
+// Gang activity log helps gang admins to find out who did what in their gang.
+// Activities are kept in an append-only capped DB list, retention is configurable through env.
+
+package gang
+
+import (
+	"Popcorn/internal/entity"
+	"context"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Actions recorded in the gang activity log.
+const (
+	ActivityCreate        = "create"
+	ActivityUpdate        = "update"
+	ActivityPassKeyChange = "passkey_change"
+	ActivityJoin          = "join"
+	ActivityLeave         = "leave"
+	ActivityBoot          = "boot"
+	ActivityMute          = "mute"
+	ActivityMessageDelete = "message_delete"
+	ActivityUpload        = "upload"
+	ActivityLibraryAttach = "library_attach"
+	ActivityStreamStart   = "stream_start"
+	ActivityStreamStop    = "stream_stop"
+	ActivityStreamEnd     = "stream_end"
+)
+
+// Actor used for actions Popcorn performs on its own.
+const ActivitySystemActor = "popcorn"
+
+// Number of activities returned per page in get_activity.
+const activityPageSize = 20
+
+var (
+	GANG_ACTIVITY_MAX_ENTRIES    string = os.Getenv("GANG_ACTIVITY_MAX_ENTRIES")
+	GANG_ACTIVITY_RETENTION_DAYS string = os.Getenv("GANG_ACTIVITY_RETENTION_DAYS")
+)
+
+// Returns the max number of activities kept per gang, defaults to 500.
+func activityMaxEntries() int64 {
+	entries, converr := strconv.ParseInt(GANG_ACTIVITY_MAX_ENTRIES, 10, 64)
+	if converr != nil || entries <= 0 {
+		return 500
+	}
+	return entries
+}
+
+// Returns the duration for which the activity log is kept since the last activity, defaults to 7 days.
+func activityRetention() time.Duration {
+	days, converr := strconv.Atoi(GANG_ACTIVITY_RETENTION_DAYS)
+	if converr != nil || days <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Helper to record an activity in the gang activity log.
+// Failure to record is logged by the repository and never fails the ongoing request.
+func (s service) logactivity(ctx context.Context, admin, actor, action, target string) {
+	s.gangRepo.AddGangActivity(ctx, s.logger, admin, entity.GangActivity{
+		Actor:   actor,
+		Action:  action,
+		Target:  target,
+		Created: time.Now().Unix(),
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"Popcorn/internal/entity"
 	"Popcorn/internal/errors"
 	"Popcorn/pkg/log"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -21,6 +22,7 @@ func APIHandlers(router *gin.Engine, gangService Service, authWithAcc gin.Handle
 		gangGroup.GET("/get", getGang(gangService, logger))
 		gangGroup.GET("/get/invites", getGangInvites(gangService, logger))
 		gangGroup.GET("/get/gang_members", getGangMembers(gangService, logger))
+		gangGroup.GET("/activity", getGangActivity(gangService, logger))
 		gangGroup.POST("/create", createGang(gangService, logger))
 		gangGroup.POST("/update", updateGang(gangService, logger))
 		gangGroup.POST("/join", joinGang(gangService, logger))
@@ -31,10 +33,22 @@ func APIHandlers(router *gin.Engine, gangService Service, authWithAcc gin.Handle
 		gangGroup.POST("/boot_member", bootMember(gangService, logger))
 		gangGroup.POST("/delete", delGang(gangService, logger))
 		gangGroup.POST("/send_msg", sendMessage(gangService, logger))
+		gangGroup.POST("/edit_msg", editMessage(gangService, logger))
+		gangGroup.POST("/delete_msg", deleteMessage(gangService, logger))
+		gangGroup.POST("/mute_member", muteMember(gangService, logger))
+		gangGroup.POST("/send_reaction", sendReaction(gangService, logger))
+		gangGroup.POST("/send_typing", sendTyping(gangService, logger))
 		gangGroup.POST("/get_token", fetchStreamToken(gangService, logger))
 		gangGroup.POST("/play", playContent(gangService, logger))
 		gangGroup.POST("/stop", stopContent(gangService, logger))
+		gangGroup.POST("/share_heartbeat", screenShareHeartbeat(gangService, logger))
+		gangGroup.GET("/get/subtitles", getSubtitles(gangService, logger))
+		gangGroup.GET("/get/subtitles/:id", getSubtitleTrack(gangService, logger))
+		gangGroup.POST("/add_subtitle", addSubtitle(gangService, logger))
+		gangGroup.POST("/delete_subtitle", deleteSubtitle(gangService, logger))
 	}
+	// Livekit webhooks are authenticated through their signature instead of user tokens
+	router.POST("/api/livekit/webhook", livekitWebhook(gangService, logger))
 }
 
 // createGang returns a handler which takes care of creating gangs in Popcorn.
@@ -507,7 +521,106 @@ func sendMessage(gangService Service, logger log.Logger) gin.HandlerFunc {
 			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
 			return
 		}
-		err := gangService.sendmessage(gctx, msg, user)
+		msgData, err := gangService.sendmessage(gctx, msg, user)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{"message": msgData})
+	}
+}
+
+// editMessage returns a handler which takes care of editing a message sent by the user.
+func editMessage(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used in editmessage service
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in editMessage")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var edit entity.GangMessageEdit
+		if binderr := gctx.ShouldBindJSON(&edit); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		msgData, err := gangService.editmessage(gctx, edit, user)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{"message": msgData})
+	}
+}
+
+// deleteMessage returns a handler which takes care of deleting a message from the gang.
+func deleteMessage(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used in deletemessage service
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in deleteMessage")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var del entity.GangMessageDelete
+		if binderr := gctx.ShouldBindJSON(&del); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := gangService.deletemessage(gctx, del, user)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// muteMember returns a handler which takes care of muting a gang member for few minutes.
+func muteMember(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the gang admin
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in muteMember")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var mute entity.GangMute
+		if binderr := gctx.ShouldBindJSON(&mute); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := gangService.mutemember(gctx, user.Username, mute)
 		if err != nil {
 			// Error occured, might be validation or server error
 			err, ok := err.(errors.ErrorResponse)
@@ -523,6 +636,102 @@ func sendMessage(gangService Service, logger log.Logger) gin.HandlerFunc {
 	}
 }
 
+// sendReaction returns a handler which takes care of broadcasting reactions to gang members.
+func sendReaction(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used in sendreaction service
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in sendReaction")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var reaction entity.GangReaction
+		if binderr := gctx.ShouldBindJSON(&reaction); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := gangService.sendreaction(gctx, reaction, user)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// sendTyping returns a handler which takes care of broadcasting typing indicator to gang members.
+func sendTyping(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used in sendtyping service
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in sendTyping")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		err := gangService.sendtyping(gctx, user)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// getGangActivity returns a handler which takes care of fetching the gang activity log for gang admin.
+func getGangActivity(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the gang admin
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in getGangActivity")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		cursor, converr := strconv.ParseInt(gctx.DefaultQuery("cursor", "0"), 10, 64)
+		if converr != nil || cursor < 0 {
+			// Invalid cursor input
+			gctx.Status(http.StatusBadRequest)
+			return
+		}
+		response, newCursor, err := gangService.getgangactivity(gctx, user.Username, cursor)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{
+			"result": response,
+			"page":   newCursor,
+		})
+	}
+}
+
 // fetchStreamToken returns a handler which takes care of getting livekit token for streaming.
 func fetchStreamToken(gangService Service, logger log.Logger) gin.HandlerFunc {
 	return func(gctx *gin.Context) {
@@ -603,3 +812,194 @@ func stopContent(gangService Service, logger log.Logger) gin.HandlerFunc {
 		gctx.Status(http.StatusOK)
 	}
 }
+
+// screenShareHeartbeat returns a handler which takes care of confirming the ongoing screen share is still being published.
+// Screen shares not confirmed by livekit or by these heartbeats get ended once the grace period is over.
+func screenShareHeartbeat(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the beatscreenshare service
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in screenShareHeartbeat")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		err := gangService.beatscreenshare(gctx, user.Username)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// addSubtitle returns a handler which takes care of adding SRT / WebVTT subtitles to the gang content.
+func addSubtitle(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the gang admin
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in addSubtitle")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var upload entity.GangSubtitleUpload
+		if binderr := gctx.ShouldBind(&upload); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		file, ferr := gctx.FormFile("subtitle")
+		if ferr != nil {
+			valerr := errors.New("subtitle:Subtitle file is required")
+			gctx.AbortWithStatusJSON(http.StatusBadRequest, errors.GenerateValidationErrorResponse([]error{valerr}))
+			return
+		} else if file.Size > maxSubtitleSize {
+			valerr := errors.New("subtitle:Subtitle file is too large")
+			gctx.AbortWithStatusJSON(http.StatusBadRequest, errors.GenerateValidationErrorResponse([]error{valerr}))
+			return
+		}
+		reader, ferr := file.Open()
+		if ferr != nil {
+			logger.WithCtx(gctx).Error().Err(ferr).Msg("Cannot open subtitle file in addSubtitle")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		defer reader.Close()
+		data, ferr := io.ReadAll(io.LimitReader(reader, maxSubtitleSize))
+		if ferr != nil {
+			logger.WithCtx(gctx).Error().Err(ferr).Msg("Cannot read subtitle file in addSubtitle")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		response, err := gangService.addsubtitle(gctx, user.Username, upload, data)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, response)
+	}
+}
+
+// getSubtitles returns a handler which takes care of listing subtitles of the gang content.
+func getSubtitles(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used in getsubtitles service
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in getSubtitles")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		response, err := gangService.getsubtitles(gctx, user.Username)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{"result": response})
+	}
+}
+
+// getSubtitleTrack returns a handler which serves a gang subtitle as WebVTT to gang members.
+func getSubtitleTrack(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used in getsubtitletrack service
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in getSubtitleTrack")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		track, err := gangService.getsubtitletrack(gctx, user.Username, gctx.Param("id"))
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Data(http.StatusOK, "text/vtt; charset=utf-8", track)
+	}
+}
+
+// deleteSubtitle returns a handler which takes care of deleting subtitles of the gang content.
+func deleteSubtitle(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the gang admin
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in deleteSubtitle")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var del entity.GangSubtitleDelete
+		if binderr := gctx.ShouldBindJSON(&del); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := gangService.delsubtitle(gctx, user.Username, del)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// livekitWebhook returns a handler which takes care of livekit webhook events related to gang streams.
+func livekitWebhook(gangService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Apply the service logic for livekit webhooks in Popcorn
+		err := gangService.handlewebhook(gctx, gctx.Request)
+		if err != nil {
+			// Error occured, might be signature or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
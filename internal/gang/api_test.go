@@ -3,27 +3,44 @@
 package gang
 
 import (
+	"Popcorn/internal/blob"
 	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
 	"Popcorn/internal/metrics"
 	"Popcorn/internal/sse"
 	"Popcorn/internal/test"
 	"Popcorn/internal/user"
 	"Popcorn/pkg/db"
+	"Popcorn/pkg/filter"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/manifest"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/signedurl"
+	"Popcorn/pkg/urlprobe"
 	"Popcorn/pkg/validations"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -45,6 +62,9 @@ var gangRepo Repository
 // Global instance of metrics Repository to be used during metrics API testing.
 var metricsRepo metrics.Repository
 
+// Global instance of streamManager used by the gang service during testing.
+var streams *streamManager
+
 // Global context
 var ctx context.Context = context.Background()
 
@@ -125,6 +145,10 @@ func setupMockRouter(dbConnWrp *db.RedisDB, logger log.Logger) {
 		Host:      "ws://localhost:8000",
 		ApiKey:    "LivekitAPI",
 		ApiSecret: "LivekitAPISecret",
+		// URL content is rejected once concurrent ingress limit is reached
+		MaxConcurrentIngressLimit: 5,
+		// Stereo audio is left out to test quality profiles the server doesn't allow
+		QualityProfiles: []string{QualityDataSaver, QualityStandard, QualityHD},
 	}
 
 	// Repositories needed by gang APIs and services to work
@@ -134,9 +158,17 @@ func setupMockRouter(dbConnWrp *db.RedisDB, logger log.Logger) {
 
 	// Register internal package gang handler
 	sseService := sse.NewService(logger)
+	// SSE instance is initialized up front as in main, rather than by the first event sent
+	sseService.GetOrSetEvent(ctx)
 	metricsService := metrics.NewService(livekitMockConfig, metricsRepo, logger)
-	gangService := NewService(livekitMockConfig, gangRepo, userRepo, sseService, metricsService, logger)
+	contentStore, strerr := objectstore.NewLocalStore(filepath.Join(os.TempDir(), "popcorn-gang-test"), "", signedurl.NewSigner([]byte("popcorn"), time.Hour))
+	if strerr != nil {
+		// Issues in NewLocalStore()
+		logger.Fatal().Err(strerr).Msg("Couldn't create content store, Aborting test run.")
+	}
+	gangService := NewService(livekitMockConfig, gangRepo, userRepo, blob.NewRepository(dbConnWrp), sseService, metricsService, contentStore, filter.NewWordFilter([]string{"spoiler"}), urlprobe.NewProber(urlprobe.Config{Timeout: time.Second, AllowPrivate: true}), logger)
 	APIHandlers(mockRouter, gangService, test.MockAuthMiddleware(logger), logger)
+	streams = gangService.(service).streams
 }
 
 // Helper to register list of gang to avoid repetition in tests below
@@ -722,3 +754,1420 @@ func TestGangInviteBoot(t *testing.T) {
 	}
 	test.ExecuteAPITest(logger, t, mockRouter, &request)
 }
+
+func TestGangReaction(t *testing.T) {
+	// Create a temp user who's going to react without any gang
+	_, reactorCookie := registerTestUser("Reactor_User123", "Reactor User")
+	reaction := entity.GangReaction{Emoji: "🍿"}
+	body, mrserr := json.Marshal(reaction)
+	if mrserr != nil {
+		logger.Error().Err(mrserr).Msg("Couldn't marshall GangReaction struct into json in TestGangReaction()")
+		t.Fatal()
+	}
+	request := test.RequestAPITest{
+		Method:       http.MethodPost,
+		Path:         "/api/gang/send_reaction",
+		Body:         bytes.NewReader(body),
+		WantResponse: []int{http.StatusBadRequest},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &reactorCookie},
+	}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	// Create a gang for the reactor
+	testGang := entity.Gang{
+		Admin:   "Reactor_User123",
+		Name:    "Reactor Gang",
+		PassKey: "12345",
+		Limit:   2,
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+
+	// Unsupported reaction
+	invalidBody, mrserr := json.Marshal(entity.GangReaction{Emoji: "popcorn"})
+	if mrserr != nil {
+		logger.Error().Err(mrserr).Msg("Couldn't marshall GangReaction struct into json in TestGangReaction()")
+		t.Fatal()
+	}
+	request.Body = bytes.NewReader(invalidBody)
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	// Reactions within the rate limit
+	request.WantResponse = []int{http.StatusOK}
+	for i := 0; i < reactionRateLimit; i++ {
+		request.Body = bytes.NewReader(body)
+		test.ExecuteAPITest(logger, t, mockRouter, &request)
+	}
+	// Reaction spam gets rate limited
+	request.Body = bytes.NewReader(body)
+	request.WantResponse = []int{http.StatusTooManyRequests}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	// Typing indicator is rate limited as well
+	request = test.RequestAPITest{
+		Method:       http.MethodPost,
+		Path:         "/api/gang/send_typing",
+		Body:         bytes.NewReader([]byte{}),
+		WantResponse: []int{http.StatusOK},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &reactorCookie},
+	}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+	request.WantResponse = []int{http.StatusTooManyRequests}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	gangRepo.DelGang(ctx, logger, "Reactor_User123")
+}
+
+func TestGangMessageModeration(t *testing.T) {
+	// Gang admin and a member who's going to chat
+	_, adminCookie := registerTestUser("Moderator_User123", "Moderator User")
+	_, memberCookie := registerTestUser("Chatter_User123", "Chatter User")
+	testGang := entity.Gang{
+		Admin:          "Moderator_User123",
+		Name:           "Moderated Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Moderator_User123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	dberr = gangRepo.JoinGang(ctx, logger, entity.GangJoin{Admin: testGang.Admin, Name: testGang.Name, Key: "gang:" + testGang.Admin}, "Chatter_User123")
+	if dberr != nil {
+		// Issues in JoinGang()
+		t.Fatal()
+	}
+	// Helper to execute a moderation request as the given user
+	execute := func(path string, payload interface{}, cookie http.Cookie, want int) test.APIResponse {
+		body, mrserr := json.Marshal(payload)
+		if mrserr != nil {
+			logger.Error().Err(mrserr).Msg("Couldn't marshall payload into json in TestGangMessageModeration()")
+			t.Fatal()
+		}
+		request := test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         path,
+			Body:         bytes.NewReader(body),
+			WantResponse: []int{want},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &cookie},
+		}
+		return test.ExecuteAPITest(logger, t, mockRouter, &request)
+	}
+
+	// Message too long
+	execute("/api/gang/send_msg", entity.GangMessage{Message: strings.Repeat("a", 501)}, memberCookie, http.StatusBadRequest)
+
+	// Valid message gets an ID and passes through the word filter
+	response := execute("/api/gang/send_msg", entity.GangMessage{Message: "No Spoiler please"}, memberCookie, http.StatusOK)
+	sent := struct {
+		Message entity.GangMessageData `json:"message"`
+	}{}
+	if jsonerr := json.Unmarshal(response.Body, &sent); jsonerr != nil {
+		logger.Error().Err(jsonerr).Msg("Couldn't unmarshall send_msg response in TestGangMessageModeration()")
+		t.Fatal()
+	}
+	assert.Len(t, sent.Message.ID, 20)
+	assert.Equal(t, "No ******* please", sent.Message.Text)
+
+	// Only the author can edit a message
+	edit := entity.GangMessageEdit{ID: sent.Message.ID, Message: "edited"}
+	execute("/api/gang/edit_msg", edit, adminCookie, http.StatusForbidden)
+	execute("/api/gang/edit_msg", edit, memberCookie, http.StatusOK)
+
+	// Only gang admin can mute, muted member cannot send messages
+	mute := entity.GangMute{Member: "Chatter_User123", Minutes: 5}
+	execute("/api/gang/mute_member", mute, memberCookie, http.StatusBadRequest)
+	execute("/api/gang/mute_member", mute, adminCookie, http.StatusOK)
+	execute("/api/gang/send_msg", entity.GangMessage{Message: "hello?"}, memberCookie, http.StatusForbidden)
+
+	// Gang admin can delete any message, deleted message is gone
+	del := entity.GangMessageDelete{ID: sent.Message.ID}
+	execute("/api/gang/delete_msg", del, adminCookie, http.StatusOK)
+	execute("/api/gang/delete_msg", del, adminCookie, http.StatusNotFound)
+
+	gangRepo.DelGang(ctx, logger, "Moderator_User123")
+}
+
+func TestGangActivity(t *testing.T) {
+	// Gang admin and a member whose moderation gets recorded
+	_, adminCookie := registerTestUser("Activity_Admin123", "Activity Admin")
+	_, memberCookie := registerTestUser("Activity_Member123", "Activity Member")
+	testGang := entity.Gang{
+		Admin:          "Activity_Admin123",
+		Name:           "Activity Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Activity_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	dberr = gangRepo.JoinGang(ctx, logger, entity.GangJoin{Admin: testGang.Admin, Name: testGang.Name, Key: "gang:" + testGang.Admin}, "Activity_Member123")
+	if dberr != nil {
+		// Issues in JoinGang()
+		t.Fatal()
+	}
+	body, mrserr := json.Marshal(entity.GangMute{Member: "Activity_Member123", Minutes: 1})
+	if mrserr != nil {
+		logger.Error().Err(mrserr).Msg("Couldn't marshall GangMute struct into json in TestGangActivity()")
+		t.Fatal()
+	}
+	request := test.RequestAPITest{
+		Method:       http.MethodPost,
+		Path:         "/api/gang/mute_member",
+		Body:         bytes.NewReader(body),
+		WantResponse: []int{http.StatusOK},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &adminCookie},
+	}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	// Gang members cannot view the activity log
+	request = test.RequestAPITest{
+		Method:       http.MethodGet,
+		Path:         "/api/gang/activity",
+		Body:         bytes.NewReader([]byte{}),
+		WantResponse: []int{http.StatusForbidden},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &memberCookie},
+	}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	// Gang admin gets the latest activity first
+	request.Header = test.MockHeader()
+	request.Cookie = []*http.Cookie{test.MockAuthAllowCookie, &adminCookie}
+	request.WantResponse = []int{http.StatusOK}
+	response := test.ExecuteAPITest(logger, t, mockRouter, &request)
+	activity := struct {
+		Result []entity.GangActivity `json:"result"`
+		Page   int64                 `json:"page"`
+	}{}
+	if jsonerr := json.Unmarshal(response.Body, &activity); jsonerr != nil {
+		logger.Error().Err(jsonerr).Msg("Couldn't unmarshall activity response in TestGangActivity()")
+		t.Fatal()
+	}
+	if assert.Len(t, activity.Result, 1) {
+		assert.Equal(t, "Activity_Admin123", activity.Result[0].Actor)
+		assert.Equal(t, ActivityMute, activity.Result[0].Action)
+		assert.Equal(t, "Activity_Member123", activity.Result[0].Target)
+	}
+	assert.Equal(t, int64(0), activity.Page)
+
+	// Invalid cursor
+	request.Header = test.MockHeader()
+	request.Parameters = url.Values{"cursor": []string{"-1"}}
+	request.WantResponse = []int{http.StatusBadRequest}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	gangRepo.DelGang(ctx, logger, "Activity_Admin123")
+}
+
+func TestGangContentMeta(t *testing.T) {
+	_, adminCookie := registerTestUser("Meta_Admin123", "Meta Admin")
+	testGang := entity.Gang{
+		Admin:          "Meta_Admin123",
+		Name:           "Meta Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Meta_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "movie.mkv", "content-id", "", false, false)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	dberr = gangRepo.SetGangContentMeta(ctx, logger, testGang.Admin, []byte(`{"container":"matroska","duration":90.5}`))
+	if dberr != nil {
+		// Issues in SetGangContentMeta()
+		t.Fatal()
+	}
+
+	// Helper to fetch gang data of admin
+	getGang := func() map[string]interface{} {
+		request := test.RequestAPITest{
+			Method:       http.MethodGet,
+			Path:         "/api/gang/get",
+			Body:         bytes.NewReader([]byte{}),
+			WantResponse: []int{http.StatusOK},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &adminCookie},
+		}
+		response := test.ExecuteAPITest(logger, t, mockRouter, &request)
+		data := struct {
+			Gang map[string]interface{} `json:"gang"`
+		}{}
+		if jsonerr := json.Unmarshal(response.Body, &data); jsonerr != nil {
+			t.Fatal()
+		}
+		return data.Gang
+	}
+
+	// Metadata is sent as a JSON object
+	meta, ok := getGang()["gang_content_meta"].(map[string]interface{})
+	if assert.True(t, ok) {
+		assert.Equal(t, "matroska", meta["container"])
+		assert.Equal(t, 90.5, meta["duration"])
+	}
+
+	// Erasing the content erases its metadata
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", "", false, false)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	assert.NotContains(t, getGang(), "gang_content_meta")
+}
+
+func TestStorageQuota(t *testing.T) {
+	_, userCookie := registerTestUser("Quota_User123", "Quota User")
+	metricsService := metrics.NewService(entity.LivekitConfig{}, metricsRepo, logger)
+	defer func(quota string) { metrics.USER_STORAGE_QUOTA = quota }(metrics.USER_STORAGE_QUOTA)
+	metrics.USER_STORAGE_QUOTA = "1000"
+
+	// Uploads are reserved as in flight till the quota allows
+	assert.NoError(t, metricsService.ReserveStorage(ctx, "Quota_User123", 600))
+	err := metricsService.ReserveStorage(ctx, "Quota_User123", 600)
+	if assert.IsType(t, errors.ErrorResponse{}, err) {
+		assert.Equal(t, http.StatusInsufficientStorage, err.(errors.ErrorResponse).Status)
+	}
+	assert.NoError(t, metricsService.TrackUpload(ctx, "Quota_User123", "quota-upload", 600))
+
+	// Helper to fetch storage usage of user from gang metrics
+	getUsage := func() entity.StorageUsage {
+		request := test.RequestAPITest{
+			Method:       http.MethodGet,
+			Path:         "/api/gang/get",
+			Body:         bytes.NewReader([]byte{}),
+			WantResponse: []int{http.StatusOK},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &userCookie},
+		}
+		response := test.ExecuteAPITest(logger, t, mockRouter, &request)
+		data := struct {
+			Metrics entity.Metrics `json:"metrics"`
+		}{}
+		if jsonerr := json.Unmarshal(response.Body, &data); jsonerr != nil {
+			t.Fatal()
+		}
+		return data.Metrics.UserStorage
+	}
+	assert.Equal(t, entity.StorageUsage{InFlight: 600, Quota: 1000}, getUsage())
+
+	// Finished upload moves to stored, committing twice doesn't count it again
+	assert.NoError(t, metricsService.CommitStorage(ctx, "Quota_User123", "quota-upload", 600))
+	assert.NoError(t, metricsService.CommitStorage(ctx, "Quota_User123", "quota-upload", 600))
+	assert.Equal(t, entity.StorageUsage{Stored: 600, Quota: 1000}, getUsage())
+
+	// Deleted content frees up the quota
+	metricsService.ReleaseStorage(ctx, "quota-upload")
+	metricsService.ReleaseStorage(ctx, "quota-upload")
+	assert.Equal(t, entity.StorageUsage{Quota: 1000}, getUsage())
+	assert.NoError(t, metricsService.ReserveStorage(ctx, "Quota_User123", 1000))
+	assert.NoError(t, metricsService.TrackUpload(ctx, "Quota_User123", "quota-upload", 1000))
+	metricsService.ReleaseStorage(ctx, "quota-upload")
+}
+
+func TestIngressLeases(t *testing.T) {
+	metricsService := metrics.NewService(entity.LivekitConfig{MaxConcurrentIngressLimit: 5}, metricsRepo, logger)
+	assert.NoError(t, metricsService.ReconcileIngress(ctx, nil, time.Now()))
+	activeIngress := func() int {
+		metricsData, dberr := metricsService.GetMetrics(ctx)
+		assert.NoError(t, dberr)
+		return metricsData.ActiveIngress
+	}
+
+	// Concurrent streams can't lease past the limit, nor lose each other's leases
+	var acquired int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, dberr := metricsService.AcquireIngress(ctx, "Lease_Holder"+strconv.Itoa(i))
+			assert.NoError(t, dberr)
+			if ok {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), acquired)
+	assert.Equal(t, 5, activeIngress())
+
+	// Holders lease a single ingress however many times they acquire it, releasing it twice doesn't count twice
+	metricsData, _ := metricsService.GetMetrics(ctx)
+	leases, _ := client.Client().HKeys(ctx, "popcorn:ingress-leases").Result()
+	assert.Len(t, leases, metricsData.ActiveIngress)
+	ok, dberr := metricsService.AcquireIngress(ctx, leases[0])
+	assert.NoError(t, dberr)
+	assert.True(t, ok)
+	metricsService.ReleaseIngress(ctx, leases[0])
+	metricsService.ReleaseIngress(ctx, leases[0])
+	assert.Equal(t, 4, activeIngress())
+	// Accepted contents hold their ingress past the limit
+	assert.NoError(t, metricsService.HoldIngress(ctx, leases[0]))
+	assert.NoError(t, metricsService.HoldIngress(ctx, "Lease_Uploader"))
+	assert.Equal(t, 6, activeIngress())
+
+	// Reconciliation leases ingress to actual holders only, leases acquired meanwhile are kept
+	since := time.Now().Add(-time.Hour)
+	assert.NoError(t, metricsService.ReconcileIngress(ctx, []string{"Lease_Streamer"}, since))
+	assert.Equal(t, 7, activeIngress())
+	assert.NoError(t, metricsService.ReconcileIngress(ctx, []string{"Lease_Streamer", leases[1]}, time.Now()))
+	assert.Equal(t, 2, activeIngress())
+	assert.NoError(t, metricsService.ReconcileIngress(ctx, nil, time.Now()))
+	assert.Equal(t, 0, activeIngress())
+}
+
+func TestGangSubtitles(t *testing.T) {
+	_, adminCookie := registerTestUser("Subtitle_Admin123", "Subtitle Admin")
+	_, memberCookie := registerTestUser("Subtitle_Member123", "Subtitle Member")
+	testGang := entity.Gang{
+		Admin:          "Subtitle_Admin123",
+		Name:           "Subtitle Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Subtitle_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	dberr = gangRepo.JoinGang(ctx, logger, entity.GangJoin{Admin: testGang.Admin, Name: testGang.Name, Key: "gang:" + testGang.Admin}, "Subtitle_Member123")
+	if dberr != nil {
+		// Issues in JoinGang()
+		t.Fatal()
+	}
+
+	// Helper to upload a subtitle file as the given user
+	addSubtitle := func(cookie *http.Cookie, label, data string, want int) entity.GangSubtitle {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("label", label)
+		form.WriteField("language", "en")
+		file, _ := form.CreateFormFile("subtitle", "movie.srt")
+		file.Write([]byte(data))
+		form.Close()
+		header := http.Header{}
+		header.Set("Content-Type", form.FormDataContentType())
+		request := test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         "/api/gang/add_subtitle",
+			Body:         bytes.NewReader(body.Bytes()),
+			WantResponse: []int{want},
+			Header:       header,
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, cookie},
+		}
+		response := test.ExecuteAPITest(logger, t, mockRouter, &request)
+		var sub entity.GangSubtitle
+		json.Unmarshal(response.Body, &sub)
+		return sub
+	}
+	srt := "1\r\n00:00:01,000 --> 00:00:02,500\r\nHello there\r\n"
+
+	// Subtitles are tied to the gang content
+	addSubtitle(&adminCookie, "English", srt, http.StatusBadRequest)
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "movie.mkv", "content-id", "", false, false)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	addSubtitle(&memberCookie, "English", srt, http.StatusForbidden)
+	addSubtitle(&adminCookie, "English", "not a subtitle", http.StatusBadRequest)
+	sub := addSubtitle(&adminCookie, "English", srt, http.StatusOK)
+	assert.Equal(t, entity.GangSubtitle{ID: sub.ID, Label: "English", Language: "en", Source: SubtitleSourceUpload, Created: sub.Created}, sub)
+
+	// Gang members get the list and the WebVTT track
+	request := test.RequestAPITest{
+		Method:       http.MethodGet,
+		Path:         "/api/gang/get/subtitles",
+		Body:         bytes.NewReader([]byte{}),
+		WantResponse: []int{http.StatusOK},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &memberCookie},
+	}
+	response := test.ExecuteAPITest(logger, t, mockRouter, &request)
+	list := struct {
+		Result []entity.GangSubtitle `json:"result"`
+	}{}
+	if jsonerr := json.Unmarshal(response.Body, &list); jsonerr != nil {
+		t.Fatal()
+	}
+	assert.Equal(t, []entity.GangSubtitle{sub}, list.Result)
+
+	request.Header = test.MockHeader()
+	request.Path = "/api/gang/get/subtitles/" + sub.ID
+	response = test.ExecuteAPITest(logger, t, mockRouter, &request)
+	assert.Equal(t, "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello there\n", string(response.Body))
+
+	// Only gang admin can delete subtitles
+	body, _ := json.Marshal(entity.GangSubtitleDelete{ID: sub.ID})
+	request = test.RequestAPITest{
+		Method:       http.MethodPost,
+		Path:         "/api/gang/delete_subtitle",
+		Body:         bytes.NewReader(body),
+		WantResponse: []int{http.StatusForbidden},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &memberCookie},
+	}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+	request.Header = test.MockHeader()
+	request.Body = bytes.NewReader(body)
+	request.Cookie = []*http.Cookie{test.MockAuthAllowCookie, &adminCookie}
+	request.WantResponse = []int{http.StatusOK}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+	request.Header = test.MockHeader()
+	request.Body = bytes.NewReader(body)
+	request.WantResponse = []int{http.StatusNotFound}
+	test.ExecuteAPITest(logger, t, mockRouter, &request)
+
+	// Changing the gang content erases its subtitles
+	addSubtitle(&adminCookie, "English", srt, http.StatusOK)
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "movie.mkv", "content-id", "", false, true)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	subtitles, dberr := gangRepo.GetGangSubtitles(ctx, logger, testGang.Admin)
+	assert.NoError(t, dberr)
+	assert.Len(t, subtitles, 1)
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", "", false, false)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	subtitles, dberr = gangRepo.GetGangSubtitles(ctx, logger, testGang.Admin)
+	assert.NoError(t, dberr)
+	assert.Empty(t, subtitles)
+}
+
+func TestUpdateGangContentURL(t *testing.T) {
+	_, adminCookie := registerTestUser("Link_Admin123", "Link Admin")
+	testGang := entity.Gang{
+		Admin:          "Link_Admin123",
+		Name:           "Link Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Link_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+
+	// Stand-in for content servers
+	mux := http.NewServeMux()
+	mux.HandleFunc("/movie.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Length", "2048")
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><body>Not a movie</body></html>"))
+	})
+	mux.HandleFunc("/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\n360p.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720\n720p.m3u8\n"))
+	})
+	mux.HandleFunc("/360p.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Write([]byte("#EXTM3U\n#EXTINF:10.0,\n0.ts\n#EXTINF:10.0,\n1.ts\n#EXT-X-ENDLIST\n"))
+	})
+	mux.HandleFunc("/720p.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Write([]byte("#EXTM3U\n#EXTINF:10.0,\n0.ts\n#EXTINF:10.0,\n1.ts\n#EXT-X-ENDLIST\n"))
+	})
+	mux.HandleFunc("/live.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Write([]byte("#EXTM3U\n#EXTINF:10.0,\n0.ts\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Helper to update content URL of the gang
+	updateContentURL := func(contentURL string, wantResponse int, rendition ...string) []byte {
+		body, _ := json.Marshal(map[string]interface{}{
+			"gang_name":              testGang.Name,
+			"gang_pass_key":          "",
+			"gang_member_limit":      testGang.Limit,
+			"gang_content_url":       contentURL,
+			"gang_content_rendition": strings.Join(rendition, ""),
+		})
+		request := test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         "/api/gang/update",
+			Body:         bytes.NewReader(body),
+			WantResponse: []int{wantResponse},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &adminCookie},
+		}
+		return test.ExecuteAPITest(logger, t, mockRouter, &request).Body
+	}
+
+	// Pages and dead links are rejected before reaching ingress
+	response := updateContentURL(server.URL+"/page", http.StatusBadRequest)
+	assert.Contains(t, string(response), urlprobe.ErrUnsupportedType.Error())
+	response = updateContentURL(server.URL+"/missing", http.StatusBadRequest)
+	assert.Contains(t, string(response), urlprobe.StatusError{Code: http.StatusNotFound}.Error())
+	gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+	assert.Empty(t, gangData.ContentURL)
+
+	updateContentURL(server.URL+"/movie.mp4", http.StatusOK)
+	gangData, _ = gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+	assert.Equal(t, server.URL+"/movie.mp4", gangData.ContentURL)
+	response = updateContentURL(server.URL+"/movie.mp4", http.StatusBadRequest, "0")
+	assert.Contains(t, string(response), "Only manifest URLs have renditions")
+
+	// Renditions of manifests are picked by the admin, the one of highest bandwidth otherwise
+	response = updateContentURL(server.URL+"/live.m3u8", http.StatusBadRequest)
+	assert.Contains(t, string(response), manifest.ErrLive.Error())
+	response = updateContentURL(server.URL+"/master.m3u8", http.StatusBadRequest, "9")
+	assert.Contains(t, string(response), manifest.ErrUnknownRendition.Error())
+	updateContentURL(server.URL+"/master.m3u8", http.StatusOK)
+	gangData, _ = gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+	assert.Equal(t, "1", gangData.ContentRendition)
+	updateContentURL(server.URL+"/master.m3u8", http.StatusOK, "0")
+	gangData, _ = gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+	assert.Equal(t, "0", gangData.ContentRendition)
+	var contentManifest manifest.Manifest
+	if jsonerr := json.Unmarshal([]byte(gangData.ContentManifest), &contentManifest); assert.NoError(t, jsonerr) {
+		assert.Equal(t, manifest.FormatHLS, contentManifest.Format)
+		assert.Equal(t, 20.0, contentManifest.Duration)
+		assert.Len(t, contentManifest.Renditions, 2)
+	}
+	rendition, ok := contentRendition(gangData)
+	assert.True(t, ok)
+	assert.Equal(t, server.URL+"/360p.m3u8", rendition.URL)
+	assert.Equal(t, livekit.IngressVideoEncodingPreset_H264_540P_25FPS_2_LAYERS, ingressVideoPreset(rendition.Height))
+
+	// Manifest goes along with its URL
+	updateContentURL(server.URL+"/movie.mp4", http.StatusOK)
+	gangData, _ = gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+	assert.Empty(t, gangData.ContentManifest)
+	assert.Empty(t, gangData.ContentRendition)
+}
+
+func TestGangQuality(t *testing.T) {
+	_, adminCookie := registerTestUser("Tune_Admin123", "Tune Admin")
+	testGang := entity.Gang{
+		Admin:          "Tune_Admin123",
+		Name:           "Tune Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Tune_Admin123",
+		Quality:        QualityStandard,
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+
+	// Helper to update quality profile of the gang
+	updateQuality := func(quality string, wantResponse int) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"gang_name":         testGang.Name,
+			"gang_pass_key":     "",
+			"gang_member_limit": testGang.Limit,
+			"gang_quality":      quality,
+		})
+		request := test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         "/api/gang/update",
+			Body:         bytes.NewReader(body),
+			WantResponse: []int{wantResponse},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &adminCookie},
+		}
+		test.ExecuteAPITest(logger, t, mockRouter, &request)
+	}
+	quality := func() string {
+		gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+		return gangData.Quality
+	}
+
+	// Unknown profiles and the ones server doesn't allow are rejected
+	updateQuality("ultra", http.StatusBadRequest)
+	updateQuality(QualityStereo, http.StatusBadRequest)
+	assert.Equal(t, QualityStandard, quality())
+	updateQuality(QualityHD, http.StatusOK)
+	assert.Equal(t, QualityHD, quality())
+	// Profile is kept if left out
+	updateQuality("", http.StatusOK)
+	assert.Equal(t, QualityHD, quality())
+
+	// Profiles map to ingress encodings, video isn't encoded past the content
+	config := entity.LivekitConfig{Quality: QualityHD}
+	video, audio := ingressOptions(config)
+	assert.Equal(t, livekit.IngressVideoEncodingPreset_H264_1080P_30FPS_3_LAYERS, video.GetPreset())
+	assert.Equal(t, livekit.IngressAudioEncodingPreset_OPUS_STEREO_96KBPS, audio.GetPreset())
+	config.ContentHeight = 360
+	video, _ = ingressOptions(config)
+	assert.Equal(t, livekit.IngressVideoEncodingPreset_H264_540P_25FPS_2_LAYERS, video.GetPreset())
+	config = entity.LivekitConfig{Quality: QualityDataSaver}
+	video, audio = ingressOptions(config)
+	assert.Equal(t, livekit.IngressVideoEncodingPreset_H264_540P_25FPS_2_LAYERS, video.GetPreset())
+	assert.Equal(t, livekit.IngressAudioEncodingPreset_OPUS_MONO_64KBS, audio.GetPreset())
+	// Profiles no longer allowed fall back to the default one
+	config = entity.LivekitConfig{Quality: QualityStereo, QualityProfiles: []string{QualityDataSaver, QualityStandard}}
+	video, audio = ingressOptions(config)
+	assert.Equal(t, livekit.IngressVideoEncodingPreset_H264_720P_30FPS_3_LAYERS, video.GetPreset())
+	assert.Equal(t, livekit.IngressAudioEncodingPreset_OPUS_MONO_64KBS, audio.GetPreset())
+	assert.Equal(t, QualityDataSaver, defaultQuality(entity.LivekitConfig{QualityProfiles: []string{"ultra", QualityDataSaver}}))
+}
+
+func TestLivekitWebhook(t *testing.T) {
+	registerTestUser("Hook_Admin123", "Hook Admin")
+	testGang := entity.Gang{
+		Admin:          "Hook_Admin123",
+		Name:           "Hook Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Hook_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", "https://popcorn.test/movie.mp4", false, true)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+
+	// Record the stream as if its ingress got created
+	config := entity.LivekitConfig{
+		Host:      "ws://localhost:8000",
+		ApiKey:    "LivekitAPI",
+		ApiSecret: "LivekitAPISecret",
+		Identity:  testGang.Admin,
+		Content:   "https://popcorn.test/movie.mp4",
+		RoomName:  "room:" + testGang.Admin,
+	}
+	watchStream(ctx, logger, sse.NewService(logger), metrics.NewService(config, metricsRepo, logger), gangRepo,
+		blob.NewRepository(client), nil, createIngressClient(ctx, config), streams, "IN_hook", config)
+
+	// Helper to send webhook payload signed with secret
+	sendWebhook := func(payload, secret string, wantResponse int) {
+		sum := sha256.Sum256([]byte(payload))
+		token, _ := auth.NewAccessToken(config.ApiKey, secret).
+			SetValidFor(time.Minute).
+			SetSha256(base64.StdEncoding.EncodeToString(sum[:])).
+			ToJWT()
+		header := test.MockHeader()
+		header.Set("Authorization", token)
+		request := test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         "/api/livekit/webhook",
+			Body:         bytes.NewReader([]byte(payload)),
+			WantResponse: []int{wantResponse},
+			Header:       header,
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{},
+		}
+		test.ExecuteAPITest(logger, t, mockRouter, &request)
+	}
+	streaming := func() bool {
+		gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+		return gangData.Streaming
+	}
+	ingressEnded := func(ingressID string) string {
+		return `{"event":"ingress_ended","id":"EV_` + ingressID + `","ingressInfo":{"ingressId":"` + ingressID +
+			`","roomName":"room:Hook_Admin123","state":{"status":"ENDPOINT_COMPLETE"}}}`
+	}
+
+	// Unsigned and forged payloads are rejected
+	sendWebhook(ingressEnded("IN_hook"), "NotLivekitAPISecret", http.StatusUnauthorized)
+	test.ExecuteAPITest(logger, t, mockRouter, &test.RequestAPITest{
+		Method:       http.MethodPost,
+		Path:         "/api/livekit/webhook",
+		Body:         bytes.NewReader([]byte(ingressEnded("IN_hook"))),
+		WantResponse: []int{http.StatusUnauthorized},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{},
+	})
+	assert.True(t, streaming())
+
+	// Events of other ingresses and participants leave the stream alone
+	sendWebhook(ingressEnded("IN_previous"), config.ApiSecret, http.StatusOK)
+	sendWebhook(`{"event":"participant_joined","room":{"name":"room:Hook_Admin123"},"participant":{"identity":"Hook_Member123"}}`, config.ApiSecret, http.StatusOK)
+	sendWebhook(`{"event":"room_started","room":{"name":"some-other-room"}}`, config.ApiSecret, http.StatusOK)
+	assert.True(t, streaming())
+
+	// Ended ingress ends the stream and erases gang content
+	sendWebhook(ingressEnded("IN_hook"), config.ApiSecret, http.StatusOK)
+	assert.Eventually(t, func() bool { return !streaming() }, 5*time.Second, 50*time.Millisecond)
+	gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+	assert.Empty(t, gangData.ContentURL)
+	assert.Eventually(t, func() bool { _, ok := streams.status(testGang.Admin); return !ok }, 5*time.Second, 50*time.Millisecond)
+
+	// Retried deliveries find nothing left to end
+	sendWebhook(ingressEnded("IN_hook"), config.ApiSecret, http.StatusOK)
+}
+
+func TestReconcileStreams(t *testing.T) {
+	// Helper to create a gang streaming contentURL
+	createStreamingGang := func(admin string, streaming bool) {
+		registerTestUser(admin, "Stream Admin")
+		testGang := entity.Gang{
+			Admin:          admin,
+			Name:           "Stream Gang",
+			PassKey:        "12345",
+			Limit:          2,
+			MembersListKey: "gang-members:" + admin,
+		}
+		_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+		if dberr != nil {
+			// Issues in SetOrUpdateGang()
+			t.Fatal()
+		}
+		dberr = gangRepo.UpdateGangContentData(ctx, logger, admin, "", "", "https://popcorn.test/"+admin+".mp4", false, streaming)
+		if dberr != nil {
+			// Issues in UpdateGangContentData()
+			t.Fatal()
+		}
+	}
+	for admin, streaming := range map[string]bool{"Live_Admin123": true, "Dead_Admin123": true, "Fresh_Admin123": true, "Idle_Admin123": false} {
+		createStreamingGang(admin, streaming)
+		defer gangRepo.DelGang(ctx, logger, admin)
+	}
+	for admin, ingressID := range map[string]string{"Live_Admin123": "IN_live", "Dead_Admin123": "IN_dead", "Idle_Admin123": "IN_idle", "Gone_Admin123": "IN_gone"} {
+		dberr := gangRepo.SetStreamRecord(ctx, logger, entity.StreamRecord{
+			IngressID: ingressID,
+			RoomName:  "room:" + admin,
+			Admin:     admin,
+			Content:   "https://popcorn.test/" + admin + ".mp4",
+			Started:   time.Now().Unix(),
+		})
+		if dberr != nil {
+			// Issues in SetStreamRecord()
+			t.Fatal()
+		}
+	}
+	ingresses := []*livekit.IngressInfo{
+		{IngressId: "IN_live", RoomName: "room:Live_Admin123", State: &livekit.IngressState{Status: livekit.IngressState_ENDPOINT_PUBLISHING}},
+		{IngressId: "IN_dead", RoomName: "room:Dead_Admin123", State: &livekit.IngressState{Status: livekit.IngressState_ENDPOINT_COMPLETE}},
+		{IngressId: "IN_fresh", RoomName: "room:Fresh_Admin123", State: &livekit.IngressState{Status: livekit.IngressState_ENDPOINT_BUFFERING}},
+	}
+	config := entity.LivekitConfig{Host: "ws://localhost:8000", ApiKey: "LivekitAPI", ApiSecret: "LivekitAPISecret"}
+	metricsService := metrics.NewService(config, metricsRepo, logger)
+	err := reconcileStreams(ctx, logger, sse.NewService(logger), metricsService, gangRepo, blob.NewRepository(client),
+		nil, createIngressClient(ctx, config), streams, config, ingresses)
+	assert.NoError(t, err)
+
+	streaming := func(admin string) bool {
+		gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false)
+		return gangData.Streaming
+	}
+	records, _ := gangRepo.GetStreamRecords(ctx, logger)
+
+	// Publishing streams are watched again, recorded or not
+	assert.True(t, streaming("Live_Admin123"))
+	ingressID, _ := streams.status("Live_Admin123")
+	assert.Equal(t, "IN_live", ingressID)
+	assert.Equal(t, "IN_live", records["Live_Admin123"].IngressID)
+	assert.True(t, streaming("Fresh_Admin123"))
+	ingressID, _ = streams.status("Fresh_Admin123")
+	assert.Equal(t, "IN_fresh", ingressID)
+	assert.Equal(t, "https://popcorn.test/Fresh_Admin123.mp4", records["Fresh_Admin123"].Content)
+
+	// Streams which ended in between are wrapped up
+	assert.False(t, streaming("Dead_Admin123"))
+	gangData, _ := gangRepo.GetGang(ctx, logger, "gang:Dead_Admin123", "Dead_Admin123", false)
+	assert.Empty(t, gangData.ContentURL)
+	for _, admin := range []string{"Dead_Admin123", "Idle_Admin123", "Gone_Admin123"} {
+		assert.NotContains(t, records, admin)
+	}
+
+	// Recovered streams end just like the others
+	for _, admin := range []string{"Live_Admin123", "Fresh_Admin123"} {
+		admin := admin
+		assert.True(t, streams.stop(admin, ""))
+		assert.Eventually(t, func() bool { return !streaming(admin) }, 5*time.Second, 50*time.Millisecond)
+	}
+	assert.Eventually(t, func() bool {
+		records, _ := gangRepo.GetStreamRecords(ctx, logger)
+		return len(records) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestStreamManager(t *testing.T) {
+	manager := newStreamManager()
+
+	// Only one of the concurrent claims wins
+	var claimed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if manager.claim("Race_Admin123") {
+				atomic.AddInt32(&claimed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), claimed)
+
+	// Stops requested while starting end the stream once it's attached
+	ended := make(chan struct{}, 2)
+	assert.True(t, manager.stop("Race_Admin123", ""))
+	manager.attach("Race_Admin123", "IN_race", func(string) { ended <- struct{}{} })
+	<-ended
+	assert.Eventually(t, func() bool { return manager.claim("Race_Admin123") }, 5*time.Second, 10*time.Millisecond)
+
+	// Streams failing to start free their gang
+	manager.release("Race_Admin123")
+	assert.True(t, manager.claim("Race_Admin123"))
+
+	// Live streams end once however many stops race each other
+	var ends int32
+	manager.attach("Race_Admin123", "IN_live", func(string) { atomic.AddInt32(&ends, 1) })
+	manager.release("Race_Admin123")
+	ingressID, ok := manager.status("Race_Admin123")
+	assert.True(t, ok)
+	assert.Equal(t, "IN_live", ingressID)
+	assert.False(t, manager.stop("Race_Admin123", "IN_previous"))
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manager.stop("Race_Admin123", "IN_live")
+		}()
+	}
+	wg.Wait()
+	_, ok = manager.status("Race_Admin123")
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool { return manager.claim("Race_Admin123") }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ends))
+
+	// Failed streams stay active while restarting and end under the ID of their latest ingress
+	endedID := make(chan string, 1)
+	manager.attach("Race_Admin123", "IN_failed", func(id string) { endedID <- id })
+	assert.True(t, manager.fail("Race_Admin123", "IN_failed"))
+	assert.False(t, manager.fail("Race_Admin123", "IN_failed"))
+	manager.release("Race_Admin123")
+	ingressID, ok = manager.status("Race_Admin123")
+	assert.True(t, ok)
+	assert.Equal(t, "IN_failed", ingressID)
+	assert.True(t, manager.recover("Race_Admin123", "IN_failed", "IN_restarted"))
+	assert.False(t, manager.recover("Race_Admin123", "IN_failed", "IN_again"))
+	assert.False(t, manager.stop("Race_Admin123", "IN_failed"))
+	assert.True(t, manager.stop("Race_Admin123", "IN_restarted"))
+	assert.Equal(t, "IN_restarted", <-endedID)
+
+	// Streams stopped while restarting aren't recovered
+	assert.Eventually(t, func() bool { return manager.claim("Race_Admin123") }, 5*time.Second, 10*time.Millisecond)
+	manager.attach("Race_Admin123", "IN_failed", func(id string) { endedID <- id })
+	assert.True(t, manager.fail("Race_Admin123", "IN_failed"))
+	assert.True(t, manager.stop("Race_Admin123", ""))
+	assert.False(t, manager.recover("Race_Admin123", "IN_failed", "IN_restarted"))
+	assert.Equal(t, "IN_failed", <-endedID)
+}
+
+func TestPlayStopStorm(t *testing.T) {
+	_, adminCookie := registerTestUser("Storm_Admin123", "Storm Admin")
+	testGang := entity.Gang{
+		Admin:          "Storm_Admin123",
+		Name:           "Storm Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Storm_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	// Screen shares are played without livekit
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", "", true, false)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+
+	// Helper to play or stop gang content
+	send := func(path string) {
+		request := test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         path,
+			Body:         bytes.NewReader([]byte{}),
+			WantResponse: []int{http.StatusOK, http.StatusBadRequest},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &adminCookie},
+		}
+		test.ExecuteAPITest(logger, t, mockRouter, &request)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				send("/api/gang/play")
+			} else {
+				send("/api/gang/stop")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Gang data settles in line with the single stream left, if any
+	streaming := func() bool {
+		gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+		return gangData.Streaming
+	}
+	assert.Eventually(t, func() bool {
+		_, ok := streams.status(testGang.Admin)
+		return ok == streaming()
+	}, 5*time.Second, 50*time.Millisecond)
+	send("/api/gang/stop")
+	assert.Eventually(t, func() bool {
+		_, ok := streams.status(testGang.Admin)
+		return !ok && !streaming()
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestStreamUsage(t *testing.T) {
+	defer func(budget string) { metrics.USER_MONTHLY_SCREENSHARE_MINUTES = budget }(metrics.USER_MONTHLY_SCREENSHARE_MINUTES)
+	metrics.USER_MONTHLY_SCREENSHARE_MINUTES = "2"
+	_, adminCookie := registerTestUser("Usage_Admin123", "Usage Admin")
+	testGang := entity.Gang{
+		Admin:          "Usage_Admin123",
+		Name:           "Usage Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Usage_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	// Screen shares are played without livekit
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", "", true, false)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	metricsService := metrics.NewService(entity.LivekitConfig{}, metricsRepo, logger)
+	usageKey := "stream-usage:" + time.Now().UTC().Format("2006-01") + ":" + testGang.Admin
+	client.Client().Del(ctx, usageKey)
+	defer client.Client().Del(ctx, usageKey)
+
+	// Helper to play or stop gang content
+	send := func(path string, want int) {
+		request := test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         path,
+			Body:         bytes.NewReader([]byte{}),
+			WantResponse: []int{want},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &adminCookie},
+		}
+		test.ExecuteAPITest(logger, t, mockRouter, &request)
+	}
+
+	// Monthly screen share budget is used up
+	metricsService.AddStreamUsage(ctx, testGang.Admin, true, 120)
+	send("/api/gang/play", http.StatusTooManyRequests)
+
+	// Half of the budget is left
+	client.Client().Del(ctx, usageKey)
+	metricsService.AddStreamUsage(ctx, testGang.Admin, true, 60)
+	send("/api/gang/play", http.StatusOK)
+	assert.Equal(t, 0, checkStreamUsage(ctx, logger, sse.NewService(logger), metricsService, gangRepo, testGang.Admin, 0))
+
+	// Admin gets warned once the ongoing stream crosses the warning threshold
+	client.Client().HSet(ctx, "gang:"+testGang.Admin, "gang_stream_started", time.Now().Unix()-40)
+	sseService := sse.NewService(logger)
+	warned := make(chan int)
+	go func() {
+		warned <- checkStreamUsage(ctx, logger, sseService, metricsService, gangRepo, testGang.Admin, 0)
+	}()
+	timeout := time.After(5 * time.Second)
+	for warning := (entity.StreamUsageWarning{}); warning.Kind == ""; {
+		select {
+		case msg := <-sseService.GetOrSetEvent(ctx).Message:
+			// Events of other tests are left behind in the channel
+			if msg.Type == "gangUsageWarning" && msg.To == testGang.Admin {
+				warning = msg.Data.(entity.StreamUsageWarning)
+			}
+		case <-timeout:
+			t.Fatal()
+		}
+		if warning.Kind != "" {
+			assert.Equal(t, "screen_share", warning.Kind)
+			assert.Equal(t, 80, warning.Percent)
+			assert.GreaterOrEqual(t, warning.Used, int64(100))
+			assert.Equal(t, int64(120), warning.Budget)
+		}
+	}
+	assert.Equal(t, 80, <-warned)
+	// Thresholds are warned at once
+	assert.Equal(t, 80, checkStreamUsage(ctx, logger, sseService, metricsService, gangRepo, testGang.Admin, 80))
+
+	// Time streamed gets accounted while streaming, each second only once
+	gangData, dberr := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+	assert.NoError(t, dberr)
+	recordStreamUsage(ctx, logger, metricsService, gangRepo, gangData)
+	usage, dberr := metricsService.GetStreamUsage(ctx, testGang.Admin)
+	assert.NoError(t, dberr)
+	assert.GreaterOrEqual(t, usage.ScreenShare, int64(100))
+	recordStreamUsage(ctx, logger, metricsService, gangRepo, gangData)
+	metered, dberr := metricsService.GetStreamUsage(ctx, testGang.Admin)
+	assert.NoError(t, dberr)
+	assert.InDelta(t, usage.ScreenShare, metered.ScreenShare, 1)
+
+	// Time streamed gets accounted once the stream ends
+	send("/api/gang/stop", http.StatusOK)
+	assert.Eventually(t, func() bool {
+		usage, _ := metricsService.GetStreamUsage(ctx, testGang.Admin)
+		gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+		return usage.ScreenShare >= 100 && gangData.ScreenShareUsage >= 40 && gangData.IngressUsage == 0
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 100, metrics.UsageThreshold(entity.StreamUsage{ScreenShare: 100, ScreenShareBudget: 120}, true, 20))
+	assert.Equal(t, 0, metrics.UsageThreshold(entity.StreamUsage{ScreenShare: 100}, true, 20))
+}
+
+func TestScreenShareSession(t *testing.T) {
+	defer func(interval time.Duration) { screenShareCheckInterval = interval }(screenShareCheckInterval)
+	screenShareCheckInterval = 50 * time.Millisecond
+	_, adminCookie := registerTestUser("Share_Admin123", "Share Admin")
+	testGang := entity.Gang{
+		Admin:          "Share_Admin123",
+		Name:           "Share Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Share_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	defer gangRepo.DelStreamRecord(ctx, logger, testGang.Admin, "")
+
+	// Helper to send requests as gang admin
+	send := func(path string, want int) {
+		request := test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         path,
+			Body:         bytes.NewReader([]byte{}),
+			WantResponse: []int{want},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &adminCookie},
+		}
+		test.ExecuteAPITest(logger, t, mockRouter, &request)
+	}
+	// Helper to send webhook payload signed by livekit
+	sendWebhook := func(payload string) {
+		sum := sha256.Sum256([]byte(payload))
+		token, _ := auth.NewAccessToken("LivekitAPI", "LivekitAPISecret").
+			SetValidFor(time.Minute).
+			SetSha256(base64.StdEncoding.EncodeToString(sum[:])).
+			ToJWT()
+		header := test.MockHeader()
+		header.Set("Authorization", token)
+		test.ExecuteAPITest(logger, t, mockRouter, &test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         "/api/livekit/webhook",
+			Body:         bytes.NewReader([]byte(payload)),
+			WantResponse: []int{http.StatusOK},
+			Header:       header,
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{},
+		})
+	}
+	shareScreen := func() string {
+		dberr := gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", "", true, false)
+		if dberr != nil {
+			// Issues in UpdateGangContentData()
+			t.Fatal()
+		}
+		send("/api/gang/play", http.StatusOK)
+		shareID, ok := streams.status(testGang.Admin)
+		assert.True(t, ok)
+		assert.True(t, isScreenShare(shareID))
+		return shareID
+	}
+	ended := func() bool {
+		gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+		record, _ := gangRepo.GetStreamRecord(ctx, logger, testGang.Admin)
+		_, ok := streams.status(testGang.Admin)
+		return !ok && !gangData.Streaming && !gangData.ContentScreenShare && record.Admin == ""
+	}
+	endReason := func() string {
+		activities, _, _ := gangRepo.GetGangActivity(ctx, logger, testGang.Admin, 0)
+		for _, activity := range activities {
+			if activity.Action == ActivityStreamEnd {
+				return activity.Target
+			}
+		}
+		return ""
+	}
+
+	// Heartbeats are only taken while screen sharing
+	send("/api/gang/share_heartbeat", http.StatusBadRequest)
+
+	// Screen share session is persisted and confirmed by heartbeats and livekit
+	shareID := shareScreen()
+	record, _ := gangRepo.GetStreamRecord(ctx, logger, testGang.Admin)
+	assert.Equal(t, shareID, record.IngressID)
+	assert.True(t, record.ScreenShare)
+	assert.False(t, record.Publishing)
+	client.Client().HSet(ctx, "stream:"+testGang.Admin, "heartbeat", 0)
+	send("/api/gang/share_heartbeat", http.StatusOK)
+	record, _ = gangRepo.GetStreamRecord(ctx, logger, testGang.Admin)
+	assert.NotZero(t, record.Heartbeat)
+	trackEvent := func(event, identity string) string {
+		return `{"event":"` + event + `","room":{"name":"room:Share_Admin123"},"participant":{"identity":"` + identity +
+			`"},"track":{"sid":"TR_share","source":"SCREEN_SHARE"}}`
+	}
+	sendWebhook(trackEvent("track_published", "Share_Member123"))
+	record, _ = gangRepo.GetStreamRecord(ctx, logger, testGang.Admin)
+	assert.False(t, record.Publishing)
+	sendWebhook(trackEvent("track_published", testGang.Admin))
+	record, _ = gangRepo.GetStreamRecord(ctx, logger, testGang.Admin)
+	assert.True(t, record.Publishing)
+
+	// Published screen shares are kept without heartbeats
+	client.Client().HSet(ctx, "stream:"+testGang.Admin, "heartbeat", time.Now().Unix()-3600)
+	time.Sleep(4 * screenShareCheckInterval)
+	_, ok := streams.status(testGang.Admin)
+	assert.True(t, ok)
+
+	// Screen share ends along with its publisher
+	sendWebhook(`{"event":"participant_left","room":{"name":"room:Share_Admin123"},"participant":{"identity":"Share_Admin123"}}`)
+	assert.Eventually(t, ended, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, screenSharePublisherLeft, endReason())
+
+	// Unconfirmed screen shares end once the grace period is over
+	shareScreen()
+	sendWebhook(trackEvent("track_unpublished", testGang.Admin))
+	client.Client().HSet(ctx, "stream:"+testGang.Admin, "heartbeat", time.Now().Unix()-3600)
+	assert.Eventually(t, ended, 5*time.Second, 50*time.Millisecond)
+
+	// Screen shares are watched again after a restart
+	shareID = shareScreen()
+	streams.stop(testGang.Admin, "")
+	assert.Eventually(t, ended, 5*time.Second, 50*time.Millisecond)
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", "", true, true)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	started := time.Now().Unix() - 60
+	gangRepo.SetStreamRecord(ctx, logger, entity.StreamRecord{
+		IngressID:   shareID,
+		RoomName:    "room:" + testGang.Admin,
+		Admin:       testGang.Admin,
+		Started:     started,
+		ScreenShare: true,
+		Publishing:  true,
+	})
+	config := entity.LivekitConfig{Host: "ws://localhost:8000", ApiKey: "LivekitAPI", ApiSecret: "LivekitAPISecret"}
+	err := reconcileStreams(ctx, logger, sse.NewService(logger), metrics.NewService(config, metricsRepo, logger), gangRepo,
+		blob.NewRepository(client), nil, createIngressClient(ctx, config), streams, config, nil)
+	assert.NoError(t, err)
+	recovered, ok := streams.status(testGang.Admin)
+	assert.True(t, ok)
+	assert.Equal(t, shareID, recovered)
+	record, _ = gangRepo.GetStreamRecord(ctx, logger, testGang.Admin)
+	assert.Equal(t, started, record.Started)
+	assert.False(t, record.Publishing)
+	assert.True(t, streams.stop(testGang.Admin, shareID))
+	assert.Eventually(t, ended, 5*time.Second, 50*time.Millisecond)
+
+	// Screen shares end after the time limit, whether published or not
+	now := time.Now()
+	limited := entity.LivekitConfig{MaxScreenShareHours: 2, ScreenShareHeartbeatSeconds: 30}
+	record = entity.StreamRecord{Started: now.Add(-time.Hour).Unix(), Heartbeat: now.Add(-10 * time.Second).Unix()}
+	assert.Equal(t, "", screenShareEndReason(record, limited, now))
+	record.Heartbeat = now.Add(-time.Minute).Unix()
+	assert.Equal(t, screenSharePublisherLeft, screenShareEndReason(record, limited, now))
+	record.Publishing = true
+	assert.Equal(t, "", screenShareEndReason(record, limited, now))
+	record.Started = now.Add(-2 * time.Hour).Unix()
+	assert.Equal(t, screenShareTimeLimit, screenShareEndReason(record, limited, now))
+}
+
+func TestStreamRestart(t *testing.T) {
+	defer func(backoff time.Duration) { streamRetryBackoff = backoff }(streamRetryBackoff)
+	streamRetryBackoff = 100 * time.Millisecond
+	registerTestUser("Fail_Admin123", "Fail Admin")
+	testGang := entity.Gang{
+		Admin:          "Fail_Admin123",
+		Name:           "Fail Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Fail_Admin123",
+	}
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &testGang, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, testGang.Admin)
+	config := entity.LivekitConfig{
+		Host:      "ws://localhost:8000",
+		ApiKey:    "LivekitAPI",
+		ApiSecret: "LivekitAPISecret",
+		Identity:  testGang.Admin,
+		Content:   "https://popcorn.test/failing.mp4",
+		RoomName:  "room:" + testGang.Admin,
+	}
+	// Helper to start streaming content as if its ingress got created
+	startStream := func(ingressID string) {
+		dberr := gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", config.Content, false, true)
+		if dberr != nil {
+			// Issues in UpdateGangContentData()
+			t.Fatal()
+		}
+		assert.True(t, streams.claim(testGang.Admin))
+		watchStream(ctx, logger, sse.NewService(logger), metrics.NewService(config, metricsRepo, logger), gangRepo,
+			blob.NewRepository(client), nil, createIngressClient(ctx, config), streams, ingressID, config)
+	}
+	ended := func() bool {
+		gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+		_, ok := streams.status(testGang.Admin)
+		return !ok && !gangData.Streaming && gangData.ContentURL == ""
+	}
+	sendWebhook := func(payload string) {
+		sum := sha256.Sum256([]byte(payload))
+		token, _ := auth.NewAccessToken(config.ApiKey, config.ApiSecret).
+			SetValidFor(time.Minute).
+			SetSha256(base64.StdEncoding.EncodeToString(sum[:])).
+			ToJWT()
+		header := test.MockHeader()
+		header.Set("Authorization", token)
+		test.ExecuteAPITest(logger, t, mockRouter, &test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         "/api/livekit/webhook",
+			Body:         bytes.NewReader([]byte(payload)),
+			WantResponse: []int{http.StatusOK},
+			Header:       header,
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{},
+		})
+	}
+	ingressEnded := func(ingressID, status string) string {
+		return `{"event":"ingress_ended","id":"EV_` + ingressID + status + `","ingressInfo":{"ingressId":"` + ingressID +
+			`","roomName":"room:Fail_Admin123","state":{"status":"` + status + `","error":"pull failed"}}}`
+	}
+
+	// Errored ingress keeps the stream and its content while being restarted
+	startStream("IN_error")
+	sseService := sse.NewService(logger)
+	sendWebhook(ingressEnded("IN_error", "ENDPOINT_ERROR"))
+	ingressID, ok := streams.status(testGang.Admin)
+	assert.True(t, ok)
+	assert.Equal(t, "IN_error", ingressID)
+	gangData, _ := gangRepo.GetGang(ctx, logger, "gang:"+testGang.Admin, testGang.Admin, false)
+	assert.True(t, gangData.Streaming)
+	assert.Equal(t, config.Content, gangData.ContentURL)
+	timeout := time.After(5 * time.Second)
+	for health := (entity.GangStreamHealth{}); health.MaxAttempts == 0; {
+		select {
+		case msg := <-sseService.GetOrSetEvent(ctx).Message:
+			// Events of other tests are left behind in the channel
+			if msg.Type == "gangStreamError" && msg.To == testGang.Admin {
+				health = msg.Data.(entity.GangStreamHealth)
+			}
+		case <-timeout:
+			t.Fatal()
+		}
+		if health.MaxAttempts != 0 {
+			assert.Equal(t, "pull failed", health.Error)
+			assert.Equal(t, 3, health.MaxAttempts)
+			assert.Equal(t, 0, health.Attempt)
+		}
+	}
+	// Redelivered failures don't restart the stream twice
+	sendWebhook(ingressEnded("IN_error", "ENDPOINT_ERROR"))
+
+	// Stream ends once the retries are exhausted, livekit isn't reachable in tests
+	assert.Eventually(t, ended, 10*time.Second, 50*time.Millisecond)
+
+	// Streams stopped while restarting end right away
+	startStream("IN_stopped")
+	sendWebhook(ingressEnded("IN_stopped", "ENDPOINT_ERROR"))
+	assert.True(t, streams.stop(testGang.Admin, ""))
+	assert.Eventually(t, ended, 5*time.Second, 50*time.Millisecond)
+
+	// Completed ingress ends the stream without restarts
+	startStream("IN_complete")
+	sendWebhook(ingressEnded("IN_complete", "ENDPOINT_COMPLETE"))
+	assert.Eventually(t, ended, 5*time.Second, 50*time.Millisecond)
+
+	// Ingresses which failed while Popcorn was down are restarted on recovery
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, testGang.Admin, "", "", config.Content, false, true)
+	if dberr != nil {
+		// Issues in UpdateGangContentData()
+		t.Fatal()
+	}
+	gangRepo.SetStreamRecord(ctx, logger, entity.StreamRecord{
+		IngressID: "IN_down",
+		RoomName:  config.RoomName,
+		Admin:     testGang.Admin,
+		Content:   config.Content,
+		Started:   time.Now().Unix(),
+	})
+	ingresses := []*livekit.IngressInfo{
+		{IngressId: "IN_down", RoomName: config.RoomName, State: &livekit.IngressState{Status: livekit.IngressState_ENDPOINT_ERROR, Error: "pull failed"}},
+	}
+	err := reconcileStreams(ctx, logger, sse.NewService(logger), metrics.NewService(config, metricsRepo, logger), gangRepo,
+		blob.NewRepository(client), nil, createIngressClient(ctx, config), streams, config, ingresses)
+	assert.NoError(t, err)
+	ingressID, ok = streams.status(testGang.Admin)
+	assert.True(t, ok)
+	assert.Equal(t, "IN_down", ingressID)
+	assert.Eventually(t, ended, 10*time.Second, 50*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		record, _ := gangRepo.GetStreamRecord(ctx, logger, testGang.Admin)
+		return record.Admin == ""
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,150 @@
+// Stream manager owning the lifecycle of gang streams, shared by requests, webhooks and stream recovery.
+
+package gang
+
+import (
+	"sync"
+)
+
+// Lifecycle states of gang streams.
+const (
+	// Claimed while its ingress is being created
+	streamStarting = iota
+	// Being published
+	streamLive
+	// Ingress failed, a new one is being created
+	streamFailed
+	// Signalled to end, gang data is being updated
+	streamEnding
+)
+
+// Gang stream tracked by streamManager.
+type managedStream struct {
+	// ID of the stream, ingress ID for contents being published through livekit ingress.
+	// Changes once a failed ingress gets replaced.
+	id    string
+	state int
+	// Closed once the stream is signalled to end
+	stop chan struct{}
+}
+
+// streamManager guarantees a single active stream per gang, streams are keyed by their gang admin.
+type streamManager struct {
+	mu      sync.Mutex
+	streams map[string]*managedStream
+}
+
+// Returns a new instance of streamManager without any streams.
+func newStreamManager() *streamManager {
+	return &streamManager{streams: map[string]*managedStream{}}
+}
+
+// Reserves stream of admin while the stream is being started, returns false if the gang has an active stream already.
+func (m *streamManager) claim(admin string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.streams[admin]; ok {
+		return false
+	}
+	m.streams[admin] = &managedStream{state: streamStarting, stop: make(chan struct{})}
+	return true
+}
+
+// Frees stream of admin claimed earlier, used when the stream couldn't be started.
+func (m *streamManager) release(admin string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stream, ok := m.streams[admin]; ok && stream.state != streamLive && stream.state != streamFailed {
+		delete(m.streams, admin)
+	}
+}
+
+// Marks stream of admin live as id, end gets called with the latest ID of the stream once it's signalled to end.
+// Streams recovered after a restart are attached without being claimed, stops requested while starting take effect right away.
+func (m *streamManager) attach(admin, id string, end func(id string)) {
+	m.mu.Lock()
+	stream, ok := m.streams[admin]
+	if !ok {
+		stream = &managedStream{stop: make(chan struct{})}
+		m.streams[admin] = stream
+	}
+	stream.id = id
+	if stream.state == streamStarting {
+		stream.state = streamLive
+	}
+	m.mu.Unlock()
+
+	go func() {
+		<-stream.stop
+		m.mu.Lock()
+		id := stream.id
+		m.mu.Unlock()
+		end(id)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.streams[admin] == stream {
+			delete(m.streams, admin)
+		}
+	}()
+}
+
+// Signals stream of admin to end, id of the stream must match unless it's empty.
+// Returns false if no such stream is active.
+func (m *streamManager) stop(admin, id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[admin]
+	if !ok || (id != "" && stream.id != id) {
+		return false
+	}
+	if stream.state != streamEnding {
+		stream.state = streamEnding
+		close(stream.stop)
+	}
+	return true
+}
+
+// Marks live stream of admin failed, the stream stays active while it's being restarted.
+// Returns false if no such stream is live as id, failures reported again are left to the ongoing restart.
+func (m *streamManager) fail(admin, id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[admin]
+	if !ok || stream.id != id || stream.state != streamLive {
+		return false
+	}
+	stream.state = streamFailed
+	return true
+}
+
+// Marks failed stream of admin live again as id, once its restart succeeded.
+// Returns false if the stream was signalled to end in the meantime.
+func (m *streamManager) recover(admin, failedID, id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[admin]
+	if !ok || stream.id != failedID || stream.state != streamFailed {
+		return false
+	}
+	stream.id = id
+	stream.state = streamLive
+	return true
+}
+
+// Returns ID of the stream of admin, false if the gang has no active stream.
+func (m *streamManager) status(admin string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[admin]
+	if !ok || stream.state == streamEnding {
+		return "", false
+	}
+	return stream.id, true
+}
+
+// Returns the number of gangs with an active stream, including the ones still starting.
+func (m *streamManager) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.streams)
+}
@@ -0,0 +1,81 @@
+// Gang reactions are aggregated here before being broadcasted to gang members.
+// This makes sure a gang spamming reactions doesn't flood sse.Listen with one event per reaction.
+
+package gang
+
+import (
+	"Popcorn/internal/entity"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// Window in which incoming reactions of a gang are merged into a single gangReaction event.
+	reactionAggregateWindow = 1 * time.Second
+	// Max reactions an user can send within reactionRateWindow.
+	reactionRateLimit  = 10
+	reactionRateWindow = 5 * time.Second
+	// Max typing indicators an user can send within typingRateWindow.
+	typingRateLimit  = 1
+	typingRateWindow = 2 * time.Second
+)
+
+// Reactions received by every gang during the ongoing aggregate window, keyed by gang admin.
+var reactionBuckets = map[string]*entity.GangReactionBurst{}
+
+// Mutex guarding reactionBuckets as reactions arrive from concurrent requests.
+var reactionMutex sync.Mutex
+
+// Helper to add a reaction into the gang's current bucket.
+// The first reaction of a window schedules the flush of the bucket.
+func (s service) aggregateReaction(gang entity.GangResponse, emoji string) {
+	reactionMutex.Lock()
+	defer reactionMutex.Unlock()
+	bucket, ok := reactionBuckets[gang.Admin]
+	if !ok {
+		now := time.Now().Unix()
+		bucket = &entity.GangReactionBurst{
+			Reactions: map[string]int{},
+			Position:  -1,
+			Created:   now,
+		}
+		if gang.Streaming && gang.StreamStarted > 0 {
+			// Timestamp the burst against the playback position of the stream
+			bucket.Position = now - gang.StreamStarted
+		}
+		reactionBuckets[gang.Admin] = bucket
+		time.AfterFunc(reactionAggregateWindow, func() {
+			s.flushReactions(gang.Admin)
+		})
+	}
+	bucket.Reactions[emoji] += 1
+}
+
+// Helper to broadcast aggregated reactions of a gang to its members.
+func (s service) flushReactions(admin string) {
+	reactionMutex.Lock()
+	bucket, ok := reactionBuckets[admin]
+	delete(reactionBuckets, admin)
+	reactionMutex.Unlock()
+	if !ok {
+		return
+	}
+	// Request context is long gone by now
+	ctx := context.Background()
+	members, dberr := s.gangRepo.GetGangMembers(ctx, s.logger, admin)
+	if dberr != nil {
+		// Error occured in GetGangMembers()
+		return
+	}
+	for _, member := range members {
+		go func(member string) {
+			data := entity.SSEData{
+				Data: *bucket,
+				Type: "gangReaction",
+				To:   member,
+			}
+			s.sseService.GetOrSetEvent(ctx).Message <- data
+		}(member)
+	}
+}
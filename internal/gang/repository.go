@@ -7,14 +7,21 @@ import (
 	"Popcorn/internal/errors"
 	"Popcorn/pkg/db"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/tracing"
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// Duration for which gang message history is kept since the last message.
+const gangMessageTTL = 24 * time.Hour
+
 type Repository interface {
 	// HasGang returns a boolean depending on gang's availability.
 	HasGang(ctx context.Context, logger log.Logger, gangKey string, gangName string) (bool, error)
@@ -46,6 +53,58 @@ type Repository interface {
 	AcceptGangInvite(ctx context.Context, logger log.Logger, invite entity.GangInvite) error
 	// UpdateGangContentData updates content filename and ID from gang data.
 	UpdateGangContentData(ctx context.Context, logger log.Logger, admin, cname, cID, cURL string, screen_share, streaming bool) error
+	// HitRateLimit registers an action under key and returns true if limit got exceeded within the window.
+	HitRateLimit(ctx context.Context, logger log.Logger, key string, limit int64, window time.Duration) (bool, error)
+	// SetGangMessage adds or updates a message in the gang's message history.
+	SetGangMessage(ctx context.Context, logger log.Logger, admin string, msg entity.GangMessageData) error
+	// GetGangMessage returns a message from the gang's message history.
+	GetGangMessage(ctx context.Context, logger log.Logger, admin string, id string) (entity.GangMessageData, error)
+	// DelGangMessage deletes a message from the gang's message history.
+	DelGangMessage(ctx context.Context, logger log.Logger, admin string, id string) error
+	// MuteGangMember mutes a gang member for the given duration.
+	MuteGangMember(ctx context.Context, logger log.Logger, admin string, member string, duration time.Duration) error
+	// GetGangMemberMute returns the remaining mute duration of a gang member, 0 if not muted.
+	GetGangMemberMute(ctx context.Context, logger log.Logger, admin string, member string) (time.Duration, error)
+	// SetGangContentMeta saves media metadata of the uploaded gang content.
+	SetGangContentMeta(ctx context.Context, logger log.Logger, admin string, meta []byte) error
+	// MeterGangStream adds seconds streamed by the gang since it was last metered to its usage, either through ingress or screen share.
+	// Returns the seconds added and whether the gang is screen sharing.
+	MeterGangStream(ctx context.Context, logger log.Logger, admin string) (int64, bool, error)
+	// RestartGangStream resets the time the gang stream started at to now, used once the stream got restarted.
+	RestartGangStream(ctx context.Context, logger log.Logger, admin string) error
+	// SetGangContentLibrary marks the gang content as kept in the content library, so that it outlives the gang stream.
+	SetGangContentLibrary(ctx context.Context, logger log.Logger, admin string, library bool) error
+	// AddGangActivity appends an activity into the gang activity log.
+	AddGangActivity(ctx context.Context, logger log.Logger, admin string, activity entity.GangActivity) error
+	// GetGangActivity returns a page of the gang activity log, latest first.
+	GetGangActivity(ctx context.Context, logger log.Logger, admin string, cursor int64) ([]entity.GangActivity, int64, error)
+	// ListGangs returns paginated data of every live gang in Popcorn.
+	ListGangs(ctx context.Context, logger log.Logger, cursor uint64) ([]entity.GangResponse, uint64, error)
+	// CountGangs returns the number of gangs in gang:index.
+	CountGangs(ctx context.Context, logger log.Logger) (int64, error)
+	// SetGangSubtitle saves subtitle details along with its WebVTT track.
+	SetGangSubtitle(ctx context.Context, logger log.Logger, admin string, sub entity.GangSubtitle, track []byte) error
+	// GetGangSubtitles returns subtitles of the gang content, oldest first.
+	GetGangSubtitles(ctx context.Context, logger log.Logger, admin string) ([]entity.GangSubtitle, error)
+	// GetGangSubtitleTrack returns the WebVTT track of a gang subtitle.
+	GetGangSubtitleTrack(ctx context.Context, logger log.Logger, admin string, id string) ([]byte, error)
+	// DelGangSubtitle deletes a gang subtitle along with its track.
+	DelGangSubtitle(ctx context.Context, logger log.Logger, admin string, id string) error
+	// SetStreamRecord saves record of a gang stream being published through livekit ingress or screen shared.
+	SetStreamRecord(ctx context.Context, logger log.Logger, record entity.StreamRecord) error
+	// GetStreamRecord returns stream record of admin, empty if the gang has no recorded stream.
+	GetStreamRecord(ctx context.Context, logger log.Logger, admin string) (entity.StreamRecord, error)
+	// GetStreamRecords returns records of every gang stream being published, keyed by gang admin.
+	GetStreamRecords(ctx context.Context, logger log.Logger) (map[string]entity.StreamRecord, error)
+	// SetStreamHeartbeat confirms the screen share recorded as streamID at heartbeat.
+	// Returns false if admin has no such stream recorded.
+	SetStreamHeartbeat(ctx context.Context, logger log.Logger, admin, streamID string, heartbeat int64) (bool, error)
+	// SetStreamPublishing saves whether livekit reports the screen share recorded as streamID as published, confirming it at heartbeat.
+	// Returns false if admin has no such stream recorded.
+	SetStreamPublishing(ctx context.Context, logger log.Logger, admin, streamID string, publishing bool, heartbeat int64) (bool, error)
+	// DelStreamRecord deletes stream record of admin, unless it's of an ingress other than ingressID.
+	// Ingress isn't checked if ingressID is empty.
+	DelStreamRecord(ctx context.Context, logger log.Logger, admin, ingressID string) error
 }
 
 // repository struct of gang Repository.
@@ -62,6 +121,8 @@ func NewRepository(dbwrp *db.RedisDB) Repository {
 
 // Returns true if gang:<gang_admin> exists in Popcorn.
 func (r repository) HasGang(ctx context.Context, logger log.Logger, gangKey string, gangName string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "gang.HasGang")
+	defer span.End()
 	available, dberr := r.db.Client().Exists(ctx, gangKey).Result()
 	if dberr != nil && dberr != redis.Nil {
 		// Error during interacting with DB
@@ -87,6 +148,8 @@ func (r repository) HasGang(ctx context.Context, logger log.Logger, gangKey stri
 
 // Returns true if gang got successfully added into the DB.
 func (r repository) SetOrUpdateGang(ctx context.Context, logger log.Logger, gang *entity.Gang, update bool) (bool, error) {
+	ctx, span := tracing.Start(ctx, "gang.SetOrUpdateGang")
+	defer span.End()
 	// Checking if an gang with admin gang.Admin exists in the DB
 	available, dberr := r.HasGang(ctx, logger, "gang:"+gang.Admin, "")
 	if dberr != nil {
@@ -98,8 +161,12 @@ func (r repository) SetOrUpdateGang(ctx context.Context, logger log.Logger, gang
 	gangKey := "gang:" + gang.Admin
 	txferr := func(key string) error {
 		txf := func(tx *redis.Tx) error {
+			contentURL, dberr := tx.HGet(ctx, gangKey, "gang_content_url").Result()
+			if dberr != nil && dberr != redis.Nil {
+				return dberr
+			}
 			// Operation is commited only if the watched keys remain unchanged
-			_, dberr := r.db.Client().TxPipelined(ctx, func(client redis.Pipeliner) error {
+			_, dberr = r.db.Client().TxPipelined(ctx, func(client redis.Pipeliner) error {
 				client.HSet(ctx, gangKey, "gang_name", gang.Name)
 				if !update || (update && gang.PassKey != "PREVIOUSPASSKEY") {
 					// Only used during createGang or when passKey is being updated
@@ -108,6 +175,7 @@ func (r repository) SetOrUpdateGang(ctx context.Context, logger log.Logger, gang
 				client.HSet(ctx, gangKey, "gang_member_limit", gang.Limit)
 				client.HSet(ctx, gangKey, "gang_content_url", gang.ContentURL)
 				client.HSet(ctx, gangKey, "gang_screen_share", gang.ContentScreenShare)
+				client.HSet(ctx, gangKey, "gang_quality", gang.Quality)
 				client.HSet(ctx, gangKey, "gang_invite_hashcode", gang.InviteHashCode)
 				if !update {
 					// Only set during creating gang, some of these can be changed by server
@@ -115,6 +183,7 @@ func (r repository) SetOrUpdateGang(ctx context.Context, logger log.Logger, gang
 					client.HSet(ctx, gangKey, "gang_members_key", gang.MembersListKey)
 					client.HSet(ctx, gangKey, "gang_created", gang.Created)
 					client.HSet(ctx, gangKey, "gang_streaming", false)
+					client.HSet(ctx, gangKey, "gang_stream_started", 0)
 					client.HSet(ctx, gangKey, "gang_content_name", "")
 					client.HSet(ctx, gangKey, "gang_content_ID", "")
 					client.HSet(ctx, gangKey, "gang_content_url", "")
@@ -124,6 +193,19 @@ func (r repository) SetOrUpdateGang(ctx context.Context, logger log.Logger, gang
 					client.HSet(ctx, gangKey, "gang_content_name", gang.ContentName)
 					client.HSet(ctx, gangKey, "gang_content_ID", gang.ContentID)
 				}
+				if update && contentURL != gang.ContentURL {
+					// Subtitles belong to the previous content
+					client.Del(ctx, gangSubtitleKeys(gang.Admin)...)
+				}
+				if update {
+					client.HSet(ctx, gangKey, "gang_content_rendition", gang.ContentRendition)
+					if gang.ContentManifest != "" {
+						// Only set by server once the manifest got loaded
+						client.HSet(ctx, gangKey, "gang_content_manifest", string(gang.ContentManifest))
+					} else if contentURL != gang.ContentURL {
+						client.HDel(ctx, gangKey, "gang_content_manifest")
+					}
+				}
 				return nil
 			})
 			return dberr
@@ -166,6 +248,8 @@ func (r repository) SetOrUpdateGang(ctx context.Context, logger log.Logger, gang
 
 // Returns nil if gang got successfully deleted from the DB.
 func (r repository) DelGang(ctx context.Context, logger log.Logger, admin string) error {
+	ctx, span := tracing.Start(ctx, "gang.DelGang")
+	defer span.End()
 	// Required gang metadata needed to delete related gang data from the DB
 	gangData := struct {
 		Key            string
@@ -205,6 +289,12 @@ func (r repository) DelGang(ctx context.Context, logger log.Logger, admin string
 		// Issues in Del()
 		return dberr
 	}
+	// Delete gang message history, activity log and subtitles from DB
+	dberr = r.db.Client().Del(ctx, append(gangSubtitleKeys(admin), "gang-messages:"+admin, "gang-activity:"+admin)...).Err()
+	if dberr != nil && dberr != redis.Nil {
+		// Issues in Del()
+		return dberr
+	}
 	// Delete gang index from DB
 	r.delGangIndex(ctx, logger, fmt.Sprintf("gang:%s:%s", admin, strings.ToLower(gangData.Name)))
 	return nil
@@ -212,6 +302,8 @@ func (r repository) DelGang(ctx context.Context, logger log.Logger, admin string
 
 // Returns nil if gang member got successfully added into the DB.
 func (r repository) SetGangMembers(ctx context.Context, logger log.Logger, gangMemberKey string, member string) error {
+	ctx, span := tracing.Start(ctx, "gang.SetGangMembers")
+	defer span.End()
 	_, dberr := r.db.Client().SAdd(ctx, gangMemberKey, member).Result()
 	if dberr != nil {
 		// Issues in SAdd()
@@ -223,6 +315,8 @@ func (r repository) SetGangMembers(ctx context.Context, logger log.Logger, gangM
 
 // Returns nil if gang member got successfully removed from the gang.
 func (r repository) DelGangMember(ctx context.Context, logger log.Logger, gangMemberKey string, member string) error {
+	ctx, span := tracing.Start(ctx, "gang.DelGangMember")
+	defer span.End()
 	_, dberr := r.db.Client().SRem(ctx, gangMemberKey, member).Result()
 	if dberr != nil {
 		// Issues in SAdd()
@@ -234,6 +328,8 @@ func (r repository) DelGangMember(ctx context.Context, logger log.Logger, gangMe
 
 // Returns gang data if user has created a gang.
 func (r repository) GetGang(ctx context.Context, logger log.Logger, gangKey string, username string, existCheck bool) (entity.GangResponse, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGang")
+	defer span.End()
 	if !existCheck {
 		// Checking if gangKey exists in the DB
 		available, dberr := r.HasGang(ctx, logger, gangKey, "")
@@ -269,6 +365,8 @@ func (r repository) GetGang(ctx context.Context, logger log.Logger, gangKey stri
 
 // Returns gang passkey, used to validate incoming passkey before JoinGang is called
 func (r repository) GetGangPassKey(ctx context.Context, logger log.Logger, gangKey entity.GangJoin) (string, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGangPassKey")
+	defer span.End()
 	// Checking if an gang with gangKey with the same gang name exists in the DB
 	available, dberr := r.HasGang(ctx, logger, gangKey.Key, gangKey.Name)
 	if dberr != nil {
@@ -292,6 +390,8 @@ func (r repository) GetGangPassKey(ctx context.Context, logger log.Logger, gangK
 
 // Returns a list of GangInvite objects consisting invite metadata.
 func (r repository) GetGangInvites(ctx context.Context, logger log.Logger, username string) ([]entity.GangInvite, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGangInvites")
+	defer span.End()
 	inviteKeys, dberr := r.db.Client().ZRevRange(ctx, "gang-invites:"+username, 0, -1).Result()
 	if dberr != nil && dberr != redis.Nil {
 		// Error during interacting with DB
@@ -313,6 +413,8 @@ func (r repository) GetGangInvites(ctx context.Context, logger log.Logger, usern
 
 // Returns gang data if user has joined a gang.
 func (r repository) GetJoinedGang(ctx context.Context, logger log.Logger, username string) (entity.GangResponse, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetJoinedGang")
+	defer span.End()
 	gangKey, dberr := r.db.Client().Get(ctx, "gang-joined:"+username).Result()
 	if dberr != nil && dberr != redis.Nil {
 		// Error during interacting with DB
@@ -328,6 +430,8 @@ func (r repository) GetJoinedGang(ctx context.Context, logger log.Logger, userna
 
 // Returns a list of joined gang members.
 func (r repository) GetGangMembers(ctx context.Context, logger log.Logger, admin string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGangMembers")
+	defer span.End()
 	membersList, dberr := r.db.Client().SMembers(ctx, "gang-members:"+admin).Result()
 	if dberr != nil && dberr != redis.Nil {
 		// Error during interacting with DB
@@ -342,6 +446,8 @@ func (r repository) GetGangMembers(ctx context.Context, logger log.Logger, admin
 
 // Leaves the current joined gang.
 func (r repository) LeaveGang(ctx context.Context, logger log.Logger, boot entity.GangExit) error {
+	ctx, span := tracing.Start(ctx, "gang.LeaveGang")
+	defer span.End()
 	// Checking if a gang with gangKey and same gangName exists in the DB
 	available, dberr := r.HasGang(ctx, logger, boot.Key, boot.Name)
 	if dberr != nil {
@@ -408,6 +514,8 @@ func (r repository) LeaveGang(ctx context.Context, logger log.Logger, boot entit
 
 // Returns nil if user got successfully added to the gang.
 func (r repository) JoinGang(ctx context.Context, logger log.Logger, join entity.GangJoin, username string) error {
+	ctx, span := tracing.Start(ctx, "gang.JoinGang")
+	defer span.End()
 	// Check if gang can take a member in by checking if current gang members count + 1 < members_limit
 	gangLimitStr, dberr := r.db.Client().HGet(ctx, join.Key, "gang_member_limit").Result()
 	idx := join.Key + ":" + strings.ToLower(join.Name)
@@ -485,6 +593,8 @@ func (r repository) JoinGang(ctx context.Context, logger log.Logger, join entity
 
 // Returns paginated gang details of all the gangs matched by query (gang_name) in DB.
 func (r repository) SearchGang(ctx context.Context, logger log.Logger, gs entity.GangSearch, username string) ([]entity.GangResponse, uint64, error) {
+	ctx, span := tracing.Start(ctx, "gang.SearchGang")
+	defer span.End()
 	// try searching gang index gang:*:query:index, assuming query as gang name
 	searchBy := fmt.Sprintf("gang:*:%s*", strings.ToLower(gs.Name))
 	initialResult, newCursor, dberr := r.db.Client().SScan(ctx, "gang:index", uint64(gs.Cursor), searchBy, 10).Result()
@@ -539,6 +649,8 @@ func (r repository) SearchGang(ctx context.Context, logger log.Logger, gs entity
 
 // Deletes gang invites, usually triggered by gang invite decline.
 func (r repository) DelGangInvite(ctx context.Context, logger log.Logger, invite entity.GangInvite) error {
+	ctx, span := tracing.Start(ctx, "gang.DelGangInvite")
+	defer span.End()
 	query := invite.Admin + ":" + invite.Name + ":*"
 	inviteKey := "gang-invites:" + invite.For
 	existingInvites, _, dberr := r.db.Client().ZScan(ctx, inviteKey, 0, query, 100).Result()
@@ -564,6 +676,8 @@ func (r repository) DelGangInvite(ctx context.Context, logger log.Logger, invite
 
 // Adds incoming invite request to receiver's gang-invites: set in DB.
 func (r repository) SendGangInvite(ctx context.Context, logger log.Logger, invite entity.GangInvite) error {
+	ctx, span := tracing.Start(ctx, "gang.SendGangInvite")
+	defer span.End()
 	// check if gang exists
 	available, dberr := r.HasGang(ctx, logger, "gang:"+invite.Admin, invite.Name)
 	if dberr != nil {
@@ -605,6 +719,8 @@ func (r repository) SendGangInvite(ctx context.Context, logger log.Logger, invit
 
 // Accepts a gang invite request and joins the gang.
 func (r repository) AcceptGangInvite(ctx context.Context, logger log.Logger, invite entity.GangInvite) error {
+	ctx, span := tracing.Start(ctx, "gang.AcceptGangInvite")
+	defer span.End()
 	if invite.InviteHashCode == "NOTREQUIRED" {
 		// Delete the invite from user's gang-invites: set
 		dberr := r.DelGangInvite(ctx, logger, invite)
@@ -638,6 +754,8 @@ func (r repository) AcceptGangInvite(ctx context.Context, logger log.Logger, inv
 
 // Updates gang content ID and filename from gang data.
 func (r repository) UpdateGangContentData(ctx context.Context, logger log.Logger, admin, cname, cID, cURL string, screen_share, streaming bool) error {
+	ctx, span := tracing.Start(ctx, "gang.UpdateGangContentData")
+	defer span.End()
 	// Checking if an gang with admin exists in the DB
 	available, dberr := r.HasGang(ctx, logger, "gang:"+admin, "")
 	if dberr != nil {
@@ -649,13 +767,37 @@ func (r repository) UpdateGangContentData(ctx context.Context, logger log.Logger
 	gangKey := "gang:" + admin
 	txferr := func(key string) error {
 		txf := func(tx *redis.Tx) error {
+			content, dberr := tx.HMGet(ctx, gangKey, "gang_content_ID", "gang_content_url").Result()
+			if dberr != nil {
+				return dberr
+			}
+			contentID, _ := content[0].(string)
+			contentURL, _ := content[1].(string)
 			// Operation is commited only if the watched keys remain unchanged
-			_, dberr := r.db.Client().TxPipelined(ctx, func(client redis.Pipeliner) error {
+			_, dberr = r.db.Client().TxPipelined(ctx, func(client redis.Pipeliner) error {
 				client.HSet(ctx, gangKey, "gang_content_name", cname)
 				client.HSet(ctx, gangKey, "gang_content_ID", cID)
 				client.HSet(ctx, gangKey, "gang_content_url", cURL)
 				client.HSet(ctx, gangKey, "gang_screen_share", screen_share)
 				client.HSet(ctx, gangKey, "gang_streaming", streaming)
+				if streaming {
+					// Playback position of the stream is calculated from here
+					client.HSet(ctx, gangKey, "gang_stream_started", time.Now().Unix())
+				} else {
+					client.HSet(ctx, gangKey, "gang_stream_started", 0)
+				}
+				client.HDel(ctx, gangKey, "gang_stream_metered")
+				if cID == "" {
+					// Metadata belongs to the uploaded content only
+					client.HDel(ctx, gangKey, "gang_content_meta")
+				}
+				if contentID != cID || contentURL != cURL {
+					// Subtitles, library mark and manifest belong to the previous content
+					client.Del(ctx, gangSubtitleKeys(admin)...)
+					client.HDel(ctx, gangKey, "gang_content_library", "gang_content_manifest", "gang_content_rendition")
+					// Deduplicated contents are older than the gang holding them, idle time starts from here
+					client.HSet(ctx, gangKey, "gang_content_updated", time.Now().Unix())
+				}
 				return nil
 			})
 			return dberr
@@ -680,6 +822,411 @@ func (r repository) UpdateGangContentData(ctx context.Context, logger log.Logger
 	return nil
 }
 
+// Saves media metadata of the uploaded gang content, erased along with the content ID.
+func (r repository) SetGangContentMeta(ctx context.Context, logger log.Logger, admin string, meta []byte) error {
+	ctx, span := tracing.Start(ctx, "gang.SetGangContentMeta")
+	defer span.End()
+	dberr := r.db.Client().HSet(ctx, "gang:"+admin, "gang_content_meta", meta).Err()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HSet() in gang.SetGangContentMeta")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Marks the uploaded gang content as a library item, the mark is erased along with the content ID.
+func (r repository) SetGangContentLibrary(ctx context.Context, logger log.Logger, admin string, library bool) error {
+	ctx, span := tracing.Start(ctx, "gang.SetGangContentLibrary")
+	defer span.End()
+	dberr := r.db.Client().HSet(ctx, "gang:"+admin, "gang_content_library", library).Err()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HSet() in gang.SetGangContentLibrary")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Adds seconds streamed by the gang of admin, nothing is added if the gang got deleted in between.
+func (r repository) MeterGangStream(ctx context.Context, logger log.Logger, admin string) (int64, bool, error) {
+	ctx, span := tracing.Start(ctx, "gang.MeterGangStream")
+	defer span.End()
+	gangKey := "gang:" + admin
+	var (
+		seconds     int64
+		screenShare bool
+	)
+	txf := func(tx *redis.Tx) error {
+		seconds, screenShare = 0, false
+		var gang entity.GangResponse
+		if dberr := tx.HGetAll(ctx, gangKey).Scan(&gang); dberr != nil {
+			return dberr
+		} else if !gang.Streaming || gang.StreamStarted == 0 {
+			// Gang is gone or not streaming
+			return nil
+		}
+		now := time.Now().Unix()
+		since := gang.StreamStarted
+		if gang.StreamMetered > since {
+			// Time streamed before got accounted already
+			since = gang.StreamMetered
+		}
+		if now <= since {
+			return nil
+		}
+		field := "gang_ingress_usage"
+		if gang.ContentScreenShare {
+			field = "gang_screen_share_usage"
+		}
+		// Operation is commited only if the watched keys remain unchanged
+		_, dberr := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(ctx, gangKey, field, now-since)
+			pipe.HSet(ctx, gangKey, "gang_stream_metered", now)
+			return nil
+		})
+		if dberr == nil {
+			seconds, screenShare = now-since, gang.ContentScreenShare
+		}
+		return dberr
+	}
+	for i := 0; i < r.db.GetMaxRetries(); i++ {
+		dberr := r.db.Client().Watch(ctx, txf, gangKey)
+		if dberr == nil {
+			return seconds, screenShare, nil
+		} else if dberr != redis.TxFailedErr {
+			logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured in MeterGangStream transaction")
+			return 0, false, errors.InternalServerError("")
+		}
+		// Optimistic lock lost. Retry.
+	}
+	logger.WithCtx(ctx).Error().Msg("MeterGangStream transaction reached maximum number of retries")
+	return 0, false, errors.InternalServerError("")
+}
+
+func (r repository) RestartGangStream(ctx context.Context, logger log.Logger, admin string) error {
+	ctx, span := tracing.Start(ctx, "gang.RestartGangStream")
+	defer span.End()
+	gangKey := "gang:" + admin
+	txf := func(tx *redis.Tx) error {
+		streaming, dberr := tx.HGet(ctx, gangKey, "gang_streaming").Bool()
+		if dberr == redis.Nil || (dberr == nil && !streaming) {
+			// Gang is gone or the stream ended in the meantime
+			return nil
+		} else if dberr != nil {
+			return dberr
+		}
+		// Operation is commited only if the watched keys remain unchanged
+		_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, gangKey, "gang_stream_started", time.Now().Unix())
+			return nil
+		})
+		return dberr
+	}
+	for i := 0; i < r.db.GetMaxRetries(); i++ {
+		dberr := r.db.Client().Watch(ctx, txf, gangKey)
+		if dberr == nil {
+			return nil
+		} else if dberr != redis.TxFailedErr {
+			logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured in RestartGangStream transaction")
+			return errors.InternalServerError("")
+		}
+		// Optimistic lock lost. Retry.
+	}
+	logger.WithCtx(ctx).Error().Msg("RestartGangStream transaction reached maximum number of retries")
+	return errors.InternalServerError("")
+}
+
+// Increments the action counter saved in key, the counter expires after window.
+// Returns true if the counter went past limit during the current window.
+func (r repository) HitRateLimit(ctx context.Context, logger log.Logger, key string, limit int64, window time.Duration) (bool, error) {
+	ctx, span := tracing.Start(ctx, "gang.HitRateLimit")
+	defer span.End()
+	// Counter and its countdown are created at once, so that it can never be left without expiry
+	var hits *redis.IntCmd
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetNX(ctx, key, 0, window)
+		hits = pipe.Incr(ctx, key)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.Incr() in gang.HitRateLimit")
+		return false, errors.InternalServerError("")
+	}
+	return hits.Val() > limit, nil
+}
+
+// Returns the number of gangs in gang:index, expired gangs count till they're pruned during listing.
+func (r repository) CountGangs(ctx context.Context, logger log.Logger) (int64, error) {
+	ctx, span := tracing.Start(ctx, "gang.CountGangs")
+	defer span.End()
+	count, dberr := r.db.Client().SCard(ctx, "gang:index").Result()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.SCard() in gang.CountGangs")
+		return 0, errors.InternalServerError("")
+	}
+	return count, nil
+}
+
+// Returns gang data of a page of gang:index, along with the next cursor (0 if no more left).
+// Expired gangs found during listing are removed from the index.
+func (r repository) ListGangs(ctx context.Context, logger log.Logger, cursor uint64) ([]entity.GangResponse, uint64, error) {
+	ctx, span := tracing.Start(ctx, "gang.ListGangs")
+	defer span.End()
+	gangList := []entity.GangResponse{}
+	indexes, newCursor, dberr := r.db.Client().SScan(ctx, "gang:index", cursor, "gang:*", 20).Result()
+	if dberr != nil && dberr != redis.Nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.SScan() in gang.ListGangs")
+		return gangList, uint64(0), errors.InternalServerError("")
+	}
+	for _, gangIndex := range indexes {
+		gangKey, gangName, exterr := extDataFromGangIndex(ctx, logger, gangIndex)
+		if exterr != nil {
+			// Issues in extDataFromGangIndex()
+			return gangList, uint64(0), exterr
+		}
+		gang, dberr := r.GetGang(ctx, logger, gangKey, "", false)
+		if dberr != nil {
+			// Issues in GetGang()
+			return gangList, uint64(0), dberr
+		} else if gang.Admin == "" {
+			// Empty gang, must be expired
+			r.delGangIndex(ctx, logger, gangKey+":"+strings.ToLower(gangName))
+			continue
+		}
+		gangList = append(gangList, gang)
+	}
+	return gangList, newCursor, nil
+}
+
+// Returns nil if message got successfully saved in gang-messages:<admin>.
+func (r repository) SetGangMessage(ctx context.Context, logger log.Logger, admin string, msg entity.GangMessageData) error {
+	ctx, span := tracing.Start(ctx, "gang.SetGangMessage")
+	defer span.End()
+	msgKey := "gang-messages:" + admin
+	msgData, jsonerr := json.Marshal(msg)
+	if jsonerr != nil {
+		logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during marshalling message in gang.SetGangMessage")
+		return errors.InternalServerError("")
+	}
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, msgKey, msg.ID, msgData)
+		// Message history shouldn't outlive an abandoned gang
+		pipe.Expire(ctx, msgKey, gangMessageTTL)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HSet() in gang.SetGangMessage")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Returns message data if present in gang-messages:<admin>.
+func (r repository) GetGangMessage(ctx context.Context, logger log.Logger, admin string, id string) (entity.GangMessageData, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGangMessage")
+	defer span.End()
+	var msg entity.GangMessageData
+	msgData, dberr := r.db.Client().HGet(ctx, "gang-messages:"+admin, id).Result()
+	if dberr == redis.Nil {
+		// Message deleted or never existed
+		return msg, errors.NotFound("Message not found")
+	} else if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGet() in gang.GetGangMessage")
+		return msg, errors.InternalServerError("")
+	}
+	if jsonerr := json.Unmarshal([]byte(msgData), &msg); jsonerr != nil {
+		logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during unmarshalling message in gang.GetGangMessage")
+		return msg, errors.InternalServerError("")
+	}
+	return msg, nil
+}
+
+// Returns nil if message got successfully deleted from gang-messages:<admin>.
+func (r repository) DelGangMessage(ctx context.Context, logger log.Logger, admin string, id string) error {
+	ctx, span := tracing.Start(ctx, "gang.DelGangMessage")
+	defer span.End()
+	dberr := r.db.Client().HDel(ctx, "gang-messages:"+admin, id).Err()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HDel() in gang.DelGangMessage")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Returns nil if gang member got successfully muted, mute expires on its own after duration.
+func (r repository) MuteGangMember(ctx context.Context, logger log.Logger, admin string, member string, duration time.Duration) error {
+	ctx, span := tracing.Start(ctx, "gang.MuteGangMember")
+	defer span.End()
+	dberr := r.db.Client().Set(ctx, fmt.Sprintf("gang-mute:%s:%s", admin, member), time.Now().Add(duration).Unix(), duration).Err()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.Set() in gang.MuteGangMember")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Returns remaining mute duration of the gang member, 0 if not muted.
+func (r repository) GetGangMemberMute(ctx context.Context, logger log.Logger, admin string, member string) (time.Duration, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGangMemberMute")
+	defer span.End()
+	ttl, dberr := r.db.Client().TTL(ctx, fmt.Sprintf("gang-mute:%s:%s", admin, member)).Result()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.TTL() in gang.GetGangMemberMute")
+		return 0, errors.InternalServerError("")
+	} else if ttl < 0 {
+		// Key doesn't exist (-2) or has no expiry (-1)
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Returns nil if activity got successfully appended into gang-activity:<admin>.
+// Older activities are trimmed once the log grows beyond activityMaxEntries().
+func (r repository) AddGangActivity(ctx context.Context, logger log.Logger, admin string, activity entity.GangActivity) error {
+	ctx, span := tracing.Start(ctx, "gang.AddGangActivity")
+	defer span.End()
+	activityKey := "gang-activity:" + admin
+	activityData, jsonerr := json.Marshal(activity)
+	if jsonerr != nil {
+		logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during marshalling activity in gang.AddGangActivity")
+		return errors.InternalServerError("")
+	}
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, activityKey, activityData)
+		pipe.LTrim(ctx, activityKey, 0, activityMaxEntries()-1)
+		pipe.Expire(ctx, activityKey, activityRetention())
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.LPush() in gang.AddGangActivity")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Returns activityPageSize activities starting from cursor, along with the next cursor (0 if no more left).
+func (r repository) GetGangActivity(ctx context.Context, logger log.Logger, admin string, cursor int64) ([]entity.GangActivity, int64, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGangActivity")
+	defer span.End()
+	activities := []entity.GangActivity{}
+	activityData, dberr := r.db.Client().LRange(ctx, "gang-activity:"+admin, cursor, cursor+activityPageSize-1).Result()
+	if dberr != nil && dberr != redis.Nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.LRange() in gang.GetGangActivity")
+		return activities, 0, errors.InternalServerError("")
+	}
+	for _, data := range activityData {
+		var activity entity.GangActivity
+		if jsonerr := json.Unmarshal([]byte(data), &activity); jsonerr != nil {
+			logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during unmarshalling activity in gang.GetGangActivity")
+			return activities, 0, errors.InternalServerError("")
+		}
+		activities = append(activities, activity)
+	}
+	if len(activities) < activityPageSize {
+		// Reached the end of the log
+		return activities, 0, nil
+	}
+	return activities, cursor + activityPageSize, nil
+}
+
+// Returns nil if subtitle details and its track got successfully saved in gang-subtitles:<admin> and gang-subtitle-tracks:<admin>.
+func (r repository) SetGangSubtitle(ctx context.Context, logger log.Logger, admin string, sub entity.GangSubtitle, track []byte) error {
+	ctx, span := tracing.Start(ctx, "gang.SetGangSubtitle")
+	defer span.End()
+	subData, jsonerr := json.Marshal(sub)
+	if jsonerr != nil {
+		logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during marshalling subtitle in gang.SetGangSubtitle")
+		return errors.InternalServerError("")
+	}
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, "gang-subtitles:"+admin, sub.ID, subData)
+		pipe.HSet(ctx, "gang-subtitle-tracks:"+admin, sub.ID, track)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HSet() in gang.SetGangSubtitle")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Returns subtitles saved in gang-subtitles:<admin>, oldest first.
+func (r repository) GetGangSubtitles(ctx context.Context, logger log.Logger, admin string) ([]entity.GangSubtitle, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGangSubtitles")
+	defer span.End()
+	subtitles := []entity.GangSubtitle{}
+	subData, dberr := r.db.Client().HGetAll(ctx, "gang-subtitles:"+admin).Result()
+	if dberr != nil && dberr != redis.Nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGetAll() in gang.GetGangSubtitles")
+		return subtitles, errors.InternalServerError("")
+	}
+	for _, data := range subData {
+		var sub entity.GangSubtitle
+		if jsonerr := json.Unmarshal([]byte(data), &sub); jsonerr != nil {
+			logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during unmarshalling subtitle in gang.GetGangSubtitles")
+			return subtitles, errors.InternalServerError("")
+		}
+		subtitles = append(subtitles, sub)
+	}
+	// IDs are sortable by their creation time
+	sort.Slice(subtitles, func(i, j int) bool { return subtitles[i].ID < subtitles[j].ID })
+	return subtitles, nil
+}
+
+// Returns WebVTT track if present in gang-subtitle-tracks:<admin>.
+func (r repository) GetGangSubtitleTrack(ctx context.Context, logger log.Logger, admin string, id string) ([]byte, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetGangSubtitleTrack")
+	defer span.End()
+	track, dberr := r.db.Client().HGet(ctx, "gang-subtitle-tracks:"+admin, id).Bytes()
+	if dberr == redis.Nil {
+		// Subtitle deleted or never existed
+		return nil, errors.NotFound("Subtitle not found")
+	} else if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGet() in gang.GetGangSubtitleTrack")
+		return nil, errors.InternalServerError("")
+	}
+	return track, nil
+}
+
+// Returns nil if subtitle got successfully deleted from gang-subtitles:<admin> and gang-subtitle-tracks:<admin>.
+func (r repository) DelGangSubtitle(ctx context.Context, logger log.Logger, admin string, id string) error {
+	ctx, span := tracing.Start(ctx, "gang.DelGangSubtitle")
+	defer span.End()
+	var deleted *redis.IntCmd
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.HDel(ctx, "gang-subtitles:"+admin, id)
+		pipe.HDel(ctx, "gang-subtitle-tracks:"+admin, id)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HDel() in gang.DelGangSubtitle")
+		return errors.InternalServerError("")
+	} else if deleted.Val() == 0 {
+		return errors.NotFound("Subtitle not found")
+	}
+	return nil
+}
+
+// Returns DB keys holding subtitles of the gang content.
+func gangSubtitleKeys(admin string) []string {
+	return []string{"gang-subtitles:" + admin, "gang-subtitle-tracks:" + admin}
+}
+
 // Helper to delete expired gang index from DB.
 func (r repository) delGangIndex(ctx context.Context, logger log.Logger, index string) error {
 	_, dberr := r.db.Client().SRem(ctx, "gang:index", index).Result()
@@ -725,3 +1272,142 @@ func extDataFromInviteIndex(ctx context.Context, logger log.Logger, inviteKey st
 
 	return invite, nil
 }
+
+// Returns nil if stream record got saved in stream:<admin> and indexed in stream:index.
+func (r repository) SetStreamRecord(ctx context.Context, logger log.Logger, record entity.StreamRecord) error {
+	ctx, span := tracing.Start(ctx, "gang.SetStreamRecord")
+	defer span.End()
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, "stream:"+record.Admin, "ingress_id", record.IngressID, "room_name", record.RoomName,
+			"admin", record.Admin, "content", record.Content, "started", record.Started,
+			"screen_share", record.ScreenShare, "publishing", record.Publishing, "heartbeat", record.Heartbeat)
+		pipe.SAdd(ctx, "stream:index", record.Admin)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.TxPipelined() in gang.SetStreamRecord")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+func (r repository) GetStreamRecord(ctx context.Context, logger log.Logger, admin string) (entity.StreamRecord, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetStreamRecord")
+	defer span.End()
+	var record entity.StreamRecord
+	dberr := r.db.Client().HGetAll(ctx, "stream:"+admin).Scan(&record)
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGetAll() in gang.GetStreamRecord")
+		return entity.StreamRecord{}, errors.InternalServerError("")
+	}
+	return record, nil
+}
+
+// Returns every stream record listed in stream:index, stale index entries are removed along the way.
+func (r repository) GetStreamRecords(ctx context.Context, logger log.Logger) (map[string]entity.StreamRecord, error) {
+	ctx, span := tracing.Start(ctx, "gang.GetStreamRecords")
+	defer span.End()
+	records := map[string]entity.StreamRecord{}
+	admins, dberr := r.db.Client().SMembers(ctx, "stream:index").Result()
+	if dberr != nil && dberr != redis.Nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.SMembers() in gang.GetStreamRecords")
+		return records, errors.InternalServerError("")
+	}
+	for _, admin := range admins {
+		var record entity.StreamRecord
+		dberr = r.db.Client().HGetAll(ctx, "stream:"+admin).Scan(&record)
+		if dberr != nil {
+			// Error during interacting with DB
+			logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGetAll() in gang.GetStreamRecords")
+			return records, errors.InternalServerError("")
+		} else if record.Admin == "" {
+			r.db.Client().SRem(ctx, "stream:index", admin)
+			continue
+		}
+		records[admin] = record
+	}
+	return records, nil
+}
+
+func (r repository) DelStreamRecord(ctx context.Context, logger log.Logger, admin, ingressID string) error {
+	ctx, span := tracing.Start(ctx, "gang.DelStreamRecord")
+	defer span.End()
+	recordKey := "stream:" + admin
+	txf := func(tx *redis.Tx) error {
+		recorded, dberr := tx.HGet(ctx, recordKey, "ingress_id").Result()
+		if dberr == redis.Nil || (dberr == nil && ingressID != "" && recorded != ingressID) {
+			// Nothing recorded, or recorded for a stream started since
+			return nil
+		} else if dberr != nil {
+			return dberr
+		}
+		_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, recordKey)
+			pipe.SRem(ctx, "stream:index", admin)
+			return nil
+		})
+		return dberr
+	}
+	for i := 0; i < r.db.GetMaxRetries(); i++ {
+		dberr := r.db.Client().Watch(ctx, txf, recordKey)
+		if dberr == nil {
+			return nil
+		} else if dberr != redis.TxFailedErr {
+			logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured in DelStreamRecord transaction")
+			return errors.InternalServerError("")
+		}
+		// Optimistic lock lost. Retry.
+	}
+	logger.WithCtx(ctx).Error().Msg("DelStreamRecord transaction reached maximum number of retries")
+	return errors.InternalServerError("")
+}
+
+func (r repository) SetStreamHeartbeat(ctx context.Context, logger log.Logger, admin, streamID string, heartbeat int64) (bool, error) {
+	ctx, span := tracing.Start(ctx, "gang.SetStreamHeartbeat")
+	defer span.End()
+	return r.updateStreamRecord(ctx, logger, "SetStreamHeartbeat", admin, streamID, "heartbeat", heartbeat)
+}
+
+func (r repository) SetStreamPublishing(ctx context.Context, logger log.Logger, admin, streamID string, publishing bool, heartbeat int64) (bool, error) {
+	ctx, span := tracing.Start(ctx, "gang.SetStreamPublishing")
+	defer span.End()
+	return r.updateStreamRecord(ctx, logger, "SetStreamPublishing", admin, streamID, "publishing", publishing, "heartbeat", heartbeat)
+}
+
+// Helper to set fields of the stream record of admin, only if it's recorded as streamID.
+// Returns false if admin has no such stream recorded.
+func (r repository) updateStreamRecord(ctx context.Context, logger log.Logger, method, admin, streamID string, values ...interface{}) (bool, error) {
+	recordKey := "stream:" + admin
+	updated := false
+	txf := func(tx *redis.Tx) error {
+		updated = false
+		recorded, dberr := tx.HGet(ctx, recordKey, "ingress_id").Result()
+		if dberr == redis.Nil || (dberr == nil && recorded != streamID) {
+			// Nothing recorded, or recorded for another stream
+			return nil
+		} else if dberr != nil {
+			return dberr
+		}
+		_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, recordKey, values...)
+			return nil
+		})
+		updated = dberr == nil
+		return dberr
+	}
+	for i := 0; i < r.db.GetMaxRetries(); i++ {
+		dberr := r.db.Client().Watch(ctx, txf, recordKey)
+		if dberr == nil {
+			return updated, nil
+		} else if dberr != redis.TxFailedErr {
+			logger.WithCtx(ctx).Error().Err(dberr).Msgf("Error occured in %s transaction", method)
+			return false, errors.InternalServerError("")
+		}
+		// Optimistic lock lost. Retry.
+	}
+	logger.WithCtx(ctx).Error().Msgf("%s transaction reached maximum number of retries", method)
+	return false, errors.InternalServerError("")
+}
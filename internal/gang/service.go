@@ -3,20 +3,30 @@
 package gang
 
 import (
+	"Popcorn/internal/blob"
 	"Popcorn/internal/entity"
 	"Popcorn/internal/errors"
 	"Popcorn/internal/metrics"
 	"Popcorn/internal/sse"
 	"Popcorn/internal/user"
 	"Popcorn/pkg/cleanup"
+	"Popcorn/pkg/filter"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/tracing"
+	"Popcorn/pkg/urlprobe"
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/livekit/protocol/auth"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/webhook"
+	"github.com/rs/xid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -49,13 +59,49 @@ type Service interface {
 	// delete a gang before expiry
 	delgang(ctx context.Context, admin string) error
 	// send incoming message to gang members
-	sendmessage(ctx context.Context, msg entity.GangMessage, user entity.User) error
+	sendmessage(ctx context.Context, msg entity.GangMessage, user entity.User) (entity.GangMessageData, error)
+	// edit a previously sent message, only allowed for the author
+	editmessage(ctx context.Context, edit entity.GangMessageEdit, user entity.User) (entity.GangMessageData, error)
+	// delete a previously sent message, allowed for the author and gang admin
+	deletemessage(ctx context.Context, del entity.GangMessageDelete, user entity.User) error
+	// mute a gang member for few minutes
+	mutemember(ctx context.Context, admin string, mute entity.GangMute) error
+	// send incoming reaction to gang members, aggregated before broadcast
+	sendreaction(ctx context.Context, reaction entity.GangReaction, user entity.User) error
+	// notify gang members that user is typing a message
+	sendtyping(ctx context.Context, user entity.User) error
+	// get gang activity log, only allowed for gang admin
+	getgangactivity(ctx context.Context, admin string, cursor int64) ([]entity.GangActivity, int64, error)
 	// get livekit stream token needed for streaming content
 	fetchstreamtoken(ctx context.Context, username string) (string, error)
 	// livestream gang content to all of the gang members
 	playcontent(ctx context.Context, admin string) error
 	// stop ongoing gang livestream
 	stopcontent(ctx context.Context, admin string) error
+	// confirm ongoing screen share of the gang is still being published by admin
+	beatscreenshare(ctx context.Context, admin string) error
+	// handle livekit webhook event sent along req, after verifying its signature
+	handlewebhook(ctx context.Context, req *http.Request) error
+	// add subtitles of gang content, only allowed for gang admin
+	addsubtitle(ctx context.Context, admin string, upload entity.GangSubtitleUpload, data []byte) (entity.GangSubtitle, error)
+	// get subtitles of gang content
+	getsubtitles(ctx context.Context, username string) ([]entity.GangSubtitle, error)
+	// get WebVTT track of a gang subtitle
+	getsubtitletrack(ctx context.Context, username, id string) ([]byte, error)
+	// delete subtitles of gang content, only allowed for gang admin
+	delsubtitle(ctx context.Context, admin string, del entity.GangSubtitleDelete) error
+	// force delete a gang, used by Popcorn operators
+	DeleteGang(ctx context.Context, admin string) error
+	// force stop ongoing gang livestream, used by Popcorn operators
+	StopContent(ctx context.Context, admin string) error
+	// remove user from Popcorn gangs by deleting the owned gang or leaving the joined one, used on suspension
+	EvictUser(ctx context.Context, username string) error
+	// watch gang streams published before a restart again, streams which ended in between are wrapped up
+	RecoverStreams(ctx context.Context) error
+	// count gangs in Popcorn, used in service metrics
+	CountGangs(ctx context.Context) (int64, error)
+	// count gang streams being started or published, used in service metrics
+	ActiveStreams() int
 }
 
 // Object of this will be passed around from main to routers to API.
@@ -65,26 +111,29 @@ type service struct {
 	livekit_config entity.LivekitConfig
 	gangRepo       Repository
 	userRepo       user.Repository
+	blobRepo       blob.Repository
 	sseService     sse.Service
 	metricsService metrics.Service
+	contentStore   objectstore.Store
+	msgFilter      filter.Filter
+	urlProber      urlprobe.Prober
+	streams        *streamManager
 	logger         log.Logger
 }
 
-// Instance of stream records used as an helper to close stream.
-type close_stream_signal chan bool
-
-var streamRecords map[string]close_stream_signal
-
 // Helps to access the service layer interface and call methods. Service object is passed from main.
 func NewService(
 	livekit_conf entity.LivekitConfig,
 	gangRepo Repository,
 	userRepo user.Repository,
+	blobRepo blob.Repository,
 	sseService sse.Service,
 	metricsService metrics.Service,
+	contentStore objectstore.Store,
+	msgFilter filter.Filter,
+	urlProber urlprobe.Prober,
 	logger log.Logger) Service {
-	streamRecords = map[string]close_stream_signal{}
-	return service{livekit_conf, gangRepo, userRepo, sseService, metricsService, logger}
+	return service{livekit_conf, gangRepo, userRepo, blobRepo, sseService, metricsService, contentStore, msgFilter, urlProber, newStreamManager(), logger}
 }
 
 func (s service) creategang(ctx context.Context, gang *entity.Gang) error {
@@ -93,6 +142,13 @@ func (s service) creategang(ctx context.Context, gang *entity.Gang) error {
 		// Error occured during validation
 		return valerr
 	}
+	if gang.Quality == "" {
+		gang.Quality = defaultQuality(s.livekit_config)
+	}
+	valerr = validateGangQuality(s.livekit_config, gang.Quality)
+	if valerr != nil {
+		return valerr
+	}
 	// Check if user already has an unexpired gang created in Popcorn
 	available, dberr := s.gangRepo.HasGang(ctx, s.logger, "gang:"+gang.Admin, "")
 	if dberr != nil {
@@ -137,14 +193,13 @@ func (s service) creategang(ctx context.Context, gang *entity.Gang) error {
 		}
 		return dberr
 	}
+	s.logactivity(ctx, gang.Admin, gang.Admin, ActivityCreate, "")
 	// Check if testing is going on
 	if os.Getenv("ENV") == "TEST" {
 		return nil
 	}
 	// Create livekit room
-	s.livekit_config.Identity = gang.Admin
-	s.livekit_config.RoomName = "room:" + gang.Admin
-	_, rerr := createStreamRoomIfNotExists(ctx, s.logger, s.gangRepo, s.userRepo, s.livekit_config)
+	_, rerr := createStreamRoomIfNotExists(ctx, s.logger, s.gangRepo, s.userRepo, s.roomConfig(gang.Admin, gang.Admin))
 	if rerr != nil {
 		// Error occured in createStreamRoom()
 		return rerr
@@ -187,6 +242,7 @@ func (s service) updategang(ctx context.Context, gang *entity.Gang) error {
 		}
 	}
 
+	passKeyChanged := gang.PassKey != ""
 	if gang.PassKey == "" {
 		// Just to pass validation
 		gang.PassKey = "PREVIOUSPASSKEY"
@@ -211,11 +267,31 @@ func (s service) updategang(ctx context.Context, gang *entity.Gang) error {
 		// Error occured during validation
 		return valerr
 	}
+	if gang.Quality == "" {
+		// Quality profile is kept unless it's being changed
+		gang.Quality = existingGangData.Quality
+	} else if gang.Quality != existingGangData.Quality {
+		valerr = validateGangQuality(s.livekit_config, gang.Quality)
+		if valerr != nil {
+			return valerr
+		}
+	}
+	if gang.ContentURL != existingGangData.ContentURL || gang.ContentRendition != existingGangData.ContentRendition {
+		// Ingress fails long after accepting URLs it can't pull, so they are checked upfront
+		valerr = validateGangContentURL(ctx, s.urlProber, gang)
+		if valerr != nil {
+			return valerr
+		}
+	}
 	_, dberr = s.gangRepo.SetOrUpdateGang(ctx, s.logger, gang, true)
 	if dberr != nil {
 		// Error in SetOrUpdateGang()
 		return dberr
 	}
+	s.logactivity(ctx, gang.Admin, gang.Admin, ActivityUpdate, "")
+	if passKeyChanged {
+		s.logactivity(ctx, gang.Admin, gang.Admin, ActivityPassKeyChange, "")
+	}
 	// Send notifications to gang Members about the updates
 	members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, gang.Admin)
 	for _, member := range members {
@@ -241,6 +317,17 @@ func (s service) getgang(ctx context.Context, username string) (interface{}, int
 		// Error occured in GetMetrics()
 		return entity.GangResponse{}, metrics, canCreate, canJoin, dberr
 	}
+	// Get storage usage of the user and across Popcorn
+	metrics.UserStorage, dberr = s.metricsService.GetStorageUsage(ctx, username)
+	if dberr != nil {
+		// Error occured in GetStorageUsage()
+		return entity.GangResponse{}, metrics, canCreate, canJoin, dberr
+	}
+	metrics.TotalStorage, dberr = s.metricsService.GetStorageUsage(ctx, "")
+	if dberr != nil {
+		// Error occured in GetStorageUsage()
+		return entity.GangResponse{}, metrics, canCreate, canJoin, dberr
+	}
 	// Get gang data from DB
 	gangKey := "gang:" + username
 	gangData, dberr := s.gangRepo.GetGang(ctx, s.logger, gangKey, username, false)
@@ -259,24 +346,21 @@ func (s service) getgang(ctx context.Context, username string) (interface{}, int
 		return entity.GangResponse{}, metrics, canCreate, canJoin, dberr
 	}
 	if (gangData.Admin != "" || gangJoinedData.Admin != "") && os.Getenv("ENV") != "TEST" {
-		if gangData.Admin != "" {
-			s.livekit_config.Identity = gangData.Admin
-			s.livekit_config.RoomName = "room:" + gangData.Admin
-		} else {
-			s.livekit_config.Identity = gangJoinedData.Admin
-			s.livekit_config.RoomName = "room:" + gangJoinedData.Admin
+		admin := gangData.Admin
+		if admin == "" {
+			admin = gangJoinedData.Admin
 		}
-		created, rerr := createStreamRoomIfNotExists(ctx, s.logger, s.gangRepo, s.userRepo, s.livekit_config)
+		created, rerr := createStreamRoomIfNotExists(ctx, s.logger, s.gangRepo, s.userRepo, s.roomConfig(admin, admin))
 		if rerr != nil {
 			// Error occured in createStreamRoom()
 			return entity.GangResponse{}, metrics, canCreate, canJoin, rerr
 		}
 		if created {
 			// Tell the clients currently using the old token to refresh
-			members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, s.livekit_config.Identity)
+			members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, admin)
 			go func() {
 				for _, member := range members {
-					if member != s.livekit_config.Identity {
+					if member != admin {
 						data := entity.SSEData{
 							Data: nil,
 							Type: "tokenRefresh",
@@ -367,6 +451,7 @@ func (s service) joingang(ctx context.Context, user entity.User, joinGangData en
 		// Error occured in JoinGang()
 		return dberr
 	}
+	s.logactivity(ctx, joinGangData.Admin, user.Username, ActivityJoin, "")
 	// Send notification to the gang page
 	members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, joinGangData.Admin)
 	user.Password = ""
@@ -462,6 +547,7 @@ func (s service) acceptganginvite(ctx context.Context, user entity.User, invite
 		// Error in AcceptGangInvite()
 		return dberr
 	}
+	s.logactivity(ctx, invite.Admin, user.Username, ActivityJoin, "")
 	// Send notification to the gang page
 	members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, invite.Admin)
 	user.Password = ""
@@ -491,10 +577,10 @@ func (s service) leavegang(ctx context.Context, boot entity.GangExit) error {
 		// Error in bootmember()
 		return dberr
 	}
+	s.logactivity(ctx, joinedGang.Admin, boot.Member, ActivityLeave, "")
 	// Remove member from ongoing stream
 	if joinedGang.Streaming {
-		s.livekit_config.RoomName = "room:" + joinedGang.Admin
-		RemoveGangMemberFromStream(ctx, s.logger, s.livekit_config, boot.Member)
+		RemoveGangMemberFromStream(ctx, s.logger, s.roomConfig("", joinedGang.Admin), boot.Member)
 	}
 	// Erase stream token of user if exists
 	s.userRepo.DelStreamingToken(ctx, s.logger, boot.Member)
@@ -535,8 +621,7 @@ func (s service) bootmember(ctx context.Context, admin string, boot entity.GangE
 		return valerr
 	}
 	// Remove member from ongoing stream
-	s.livekit_config.RoomName = "room:" + admin
-	go RemoveGangMemberFromStream(ctx, s.logger, s.livekit_config, boot.Member)
+	go RemoveGangMemberFromStream(ctx, s.logger, s.roomConfig("", admin), boot.Member)
 	// Send notification to gang members
 	members, dberr := s.gangRepo.GetGangMembers(ctx, s.logger, admin)
 	if dberr != nil {
@@ -548,6 +633,7 @@ func (s service) bootmember(ctx context.Context, admin string, boot entity.GangE
 		// Error in LeaveGang()
 		return dberr
 	}
+	s.logactivity(ctx, admin, admin, ActivityBoot, boot.Member)
 	// Send notification to the kicked member
 	go func() {
 		data := entity.SSEData{
@@ -583,15 +669,20 @@ func (s service) delgang(ctx context.Context, admin string) error {
 	}
 
 	// Delete livekit room
-	s.livekit_config.RoomName = "room:" + admin
-	rerr := deleteStreamRoom(ctx, s.logger, s.livekit_config)
+	rerr := deleteStreamRoom(ctx, s.logger, s.roomConfig("", admin))
 	if rerr != nil {
 		// Error occured in deleteStreamRoom()
 		return rerr
 	}
 
-	// Delete uploaded gang contents
-	go cleanup.DeleteContentFiles(oldGangData.ContentID, s.logger)
+	if !oldGangData.ContentLibrary {
+		// Delete uploaded gang contents, library items outlive the gang
+		go func(ctx context.Context, contentID string) {
+			if cleanup.DeleteContentFiles(s.contentStore, s.blobRepo, contentID, s.logger) {
+				s.metricsService.ReleaseStorage(ctx, contentID)
+			}
+		}(tracing.Detach(ctx), oldGangData.ContentID)
+	}
 
 	members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, admin)
 	dberr = s.gangRepo.DelGang(ctx, s.logger, admin)
@@ -599,10 +690,13 @@ func (s service) delgang(ctx context.Context, admin string) error {
 		// Error in DelGang()
 		return dberr
 	}
-	// Send notification to gang members
+	// Ingress held by gang content is free again
+	s.metricsService.ReleaseIngress(ctx, admin)
+	// Send notification to gang members, tokens get deleted after the request is done
+	detached := tracing.Detach(ctx)
 	go func() {
 		for _, member := range members {
-			go s.userRepo.DelStreamingToken(ctx, s.logger, member)
+			go s.userRepo.DelStreamingToken(detached, s.logger, member)
 			data := entity.SSEData{
 				Data: nil,
 				Type: "gangDelete",
@@ -614,31 +708,39 @@ func (s service) delgang(ctx context.Context, admin string) error {
 	return nil
 }
 
-func (s service) sendmessage(ctx context.Context, msg entity.GangMessage, user entity.User) error {
+func (s service) sendmessage(ctx context.Context, msg entity.GangMessage, user entity.User) (entity.GangMessageData, error) {
 	valerr := validateGangData(ctx, msg)
 	if valerr != nil {
 		// Error occured during validation
-		return valerr
+		return entity.GangMessageData{}, valerr
 	}
-	// get gang key to fetch the list of gang members using GetGang or GetJoinedGang
-	gang, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+user.Username, user.Username, true)
+	// get gang to fetch the list of gang members
+	gang, err := s.getusergang(ctx, user.Username)
+	if err != nil {
+		// Error in getusergang()
+		return entity.GangMessageData{}, err
+	}
+	err = s.checkmute(ctx, gang.Admin, user.Username)
+	if err != nil {
+		// Muted or error in checkmute()
+		return entity.GangMessageData{}, err
+	}
+	text, _ := s.msgFilter.Clean(msg.Message)
+	msgData := entity.GangMessageData{
+		ID:      xid.New().String(),
+		Author:  user.Username,
+		Text:    text,
+		Created: time.Now().Unix(),
+	}
+	dberr := s.gangRepo.SetGangMessage(ctx, s.logger, gang.Admin, msgData)
 	if dberr != nil {
-		// Error in GetGang()
-		return dberr
-	} else if (gang == entity.GangResponse{}) {
-		// check using getJoinedGang
-		gang, dberr = s.gangRepo.GetJoinedGang(ctx, s.logger, user.Username)
-		if dberr != nil {
-			// Error in GetJoinedGang()
-			return dberr
-		} else if (gang == entity.GangResponse{}) {
-			return errors.BadRequest("user needs to create or join a gang")
-		}
+		// Error in SetGangMessage()
+		return entity.GangMessageData{}, dberr
 	}
 	members, dberr := s.gangRepo.GetGangMembers(ctx, s.logger, gang.Admin)
 	if dberr != nil {
 		// Error in GetGangMembers()
-		return dberr
+		return entity.GangMessageData{}, dberr
 	}
 	// Send received message to members
 	for _, member := range members {
@@ -647,12 +749,14 @@ func (s service) sendmessage(ctx context.Context, msg entity.GangMessage, user e
 				// Don't send this message to the sender
 				data := entity.SSEData{
 					Data: struct {
-						Text string `json:"text"`
-						User struct {
+						ID      string `json:"message_id"`
+						Text    string `json:"text"`
+						Created int64  `json:"created"`
+						User    struct {
 							Username   string `json:"username"`
 							ProfilePic string `json:"user_profile_pic"`
 						} `json:"user"`
-					}{msg.Message, struct {
+					}{msgData.ID, msgData.Text, msgData.Created, struct {
 						Username   string `json:"username"`
 						ProfilePic string `json:"user_profile_pic"`
 					}{user.Username, user.ProfilePic}},
@@ -663,12 +767,196 @@ func (s service) sendmessage(ctx context.Context, msg entity.GangMessage, user e
 			}(member)
 		}
 	}
+	return msgData, nil
+}
+
+func (s service) editmessage(ctx context.Context, edit entity.GangMessageEdit, user entity.User) (entity.GangMessageData, error) {
+	valerr := validateGangData(ctx, edit)
+	if valerr != nil {
+		// Error occured during validation
+		return entity.GangMessageData{}, valerr
+	}
+	gang, err := s.getusergang(ctx, user.Username)
+	if err != nil {
+		// Error in getusergang()
+		return entity.GangMessageData{}, err
+	}
+	err = s.checkmute(ctx, gang.Admin, user.Username)
+	if err != nil {
+		// Muted or error in checkmute()
+		return entity.GangMessageData{}, err
+	}
+	msgData, dberr := s.gangRepo.GetGangMessage(ctx, s.logger, gang.Admin, edit.ID)
+	if dberr != nil {
+		// Error in GetGangMessage()
+		return entity.GangMessageData{}, dberr
+	} else if msgData.Author != user.Username {
+		// Only the author can edit a message
+		return entity.GangMessageData{}, errors.Forbidden("Only the author can edit this message")
+	}
+	msgData.Text, _ = s.msgFilter.Clean(edit.Message)
+	msgData.Edited = time.Now().Unix()
+	dberr = s.gangRepo.SetGangMessage(ctx, s.logger, gang.Admin, msgData)
+	if dberr != nil {
+		// Error in SetGangMessage()
+		return entity.GangMessageData{}, dberr
+	}
+	s.broadcastmoderation(ctx, gang.Admin, user.Username, "gangMessageEdit", msgData)
+	return msgData, nil
+}
+
+func (s service) deletemessage(ctx context.Context, del entity.GangMessageDelete, user entity.User) error {
+	valerr := validateGangData(ctx, del)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	gang, err := s.getusergang(ctx, user.Username)
+	if err != nil {
+		// Error in getusergang()
+		return err
+	}
+	msgData, dberr := s.gangRepo.GetGangMessage(ctx, s.logger, gang.Admin, del.ID)
+	if dberr != nil {
+		// Error in GetGangMessage()
+		return dberr
+	} else if msgData.Author != user.Username && gang.Admin != user.Username {
+		// Only the author or gang admin can delete a message
+		return errors.Forbidden("Only the author or gang admin can delete this message")
+	}
+	dberr = s.gangRepo.DelGangMessage(ctx, s.logger, gang.Admin, del.ID)
+	if dberr != nil {
+		// Error in DelGangMessage()
+		return dberr
+	}
+	if msgData.Author != user.Username {
+		// Moderated by gang admin
+		s.logactivity(ctx, gang.Admin, user.Username, ActivityMessageDelete, msgData.Author)
+	}
+	s.broadcastmoderation(ctx, gang.Admin, user.Username, "gangMessageDelete", struct {
+		ID        string `json:"message_id"`
+		DeletedBy string `json:"deleted_by"`
+	}{del.ID, user.Username})
+	return nil
+}
+
+func (s service) mutemember(ctx context.Context, admin string, mute entity.GangMute) error {
+	valerr := validateGangData(ctx, mute)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	gang, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+admin, admin, false)
+	if dberr != nil {
+		// Error occured in GetGang()
+		return dberr
+	} else if gang.Admin == "" {
+		// Not an admin
+		return errors.BadRequest("user needs to create a gang")
+	} else if mute.Member == admin {
+		valerr := errors.New("member_name:Gang admin cannot mute themselves")
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	members, dberr := s.gangRepo.GetGangMembers(ctx, s.logger, admin)
+	if dberr != nil {
+		// Error in GetGangMembers()
+		return dberr
+	}
+	isMember := false
+	for _, member := range members {
+		if member == mute.Member {
+			isMember = true
+			break
+		}
+	}
+	if !isMember {
+		return errors.BadRequest("user is not a member of this gang")
+	}
+	duration := time.Duration(mute.Minutes) * time.Minute
+	dberr = s.gangRepo.MuteGangMember(ctx, s.logger, admin, mute.Member, duration)
+	if dberr != nil {
+		// Error in MuteGangMember()
+		return dberr
+	}
+	s.logactivity(ctx, admin, admin, ActivityMute, mute.Member)
+	s.broadcastmoderation(ctx, admin, admin, "gangMemberMute", struct {
+		Member string `json:"member_name"`
+		Until  int64  `json:"muted_until"`
+	}{mute.Member, time.Now().Add(duration).Unix()})
+	return nil
+}
+
+func (s service) sendreaction(ctx context.Context, reaction entity.GangReaction, user entity.User) error {
+	valerr := validateGangData(ctx, reaction)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	gang, err := s.getusergang(ctx, user.Username)
+	if err != nil {
+		// Error in getusergang()
+		return err
+	}
+	limited, dberr := s.gangRepo.HitRateLimit(ctx, s.logger, "rate-limit:reaction:"+user.Username, reactionRateLimit, reactionRateWindow)
+	if dberr != nil {
+		// Error in HitRateLimit()
+		return dberr
+	} else if limited {
+		return errors.TooManyRequests("")
+	}
+	// Reactions are broadcasted in bursts by flushReactions()
+	s.aggregateReaction(gang, reaction.Emoji)
+	return nil
+}
+
+func (s service) sendtyping(ctx context.Context, user entity.User) error {
+	gang, err := s.getusergang(ctx, user.Username)
+	if err != nil {
+		// Error in getusergang()
+		return err
+	}
+	limited, dberr := s.gangRepo.HitRateLimit(ctx, s.logger, "rate-limit:typing:"+user.Username, typingRateLimit, typingRateWindow)
+	if dberr != nil {
+		// Error in HitRateLimit()
+		return dberr
+	} else if limited {
+		return errors.TooManyRequests("")
+	}
+	members, dberr := s.gangRepo.GetGangMembers(ctx, s.logger, gang.Admin)
+	if dberr != nil {
+		// Error in GetGangMembers()
+		return dberr
+	}
+	for _, member := range members {
+		if user.Username != member {
+			go func(member string) {
+				// Don't send typing indicator to the sender
+				data := entity.SSEData{
+					Data: user.Username,
+					Type: "gangTyping",
+					To:   member,
+				}
+				s.sseService.GetOrSetEvent(ctx).Message <- data
+			}(member)
+		}
+	}
 	return nil
 }
 
+func (s service) getgangactivity(ctx context.Context, admin string, cursor int64) ([]entity.GangActivity, int64, error) {
+	gang, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+admin, admin, false)
+	if dberr != nil {
+		// Error occured in GetGang()
+		return []entity.GangActivity{}, 0, dberr
+	} else if gang.Admin == "" {
+		// Members of the gang cannot view the activity log
+		return []entity.GangActivity{}, 0, errors.Forbidden("Only gang admin can view gang activity")
+	}
+	return s.gangRepo.GetGangActivity(ctx, s.logger, admin, cursor)
+}
+
 func (s service) fetchstreamtoken(ctx context.Context, username string) (string, error) {
-	s.livekit_config.Identity = username
-	return getStreamToken(ctx, s.logger, s.gangRepo, s.userRepo, s.livekit_config)
+	return getStreamToken(ctx, s.logger, s.gangRepo, s.userRepo, s.roomConfig(username, ""))
 }
 
 func (s service) playcontent(ctx context.Context, admin string) error {
@@ -683,20 +971,34 @@ func (s service) playcontent(ctx context.Context, admin string) error {
 	} else if gang.Streaming {
 		// Already streaming
 		return errors.BadRequest("content is already streaming")
+	} else if gang.ContentID == "" && gang.ContentURL == "" && !gang.ContentScreenShare {
+		// Nothing to stream
+		return errors.BadRequest("gang has no content to stream")
+	}
+	// Monthly streaming budget of admin must be left
+	if buderr := s.metricsService.CheckStreamBudget(ctx, admin, gang.ContentScreenShare); buderr != nil {
+		return buderr
+	}
+	// Only a single stream per gang, concurrent requests lose here
+	if !s.streams.claim(admin) {
+		return errors.BadRequest("content is already streaming")
 	}
 	// getting the members list early
 	// coz if failure occurs here, no point of publishing content
 	members, dberr := s.gangRepo.GetGangMembers(ctx, s.logger, admin)
 	if dberr != nil {
 		// Error occured in GetGangMembers()
+		s.streams.release(admin)
 		return dberr
 	}
 	// set gang.Streaming flag to true
 	dberr = s.gangRepo.UpdateGangContentData(ctx, s.logger, admin, gang.ContentName, gang.ContentID, gang.ContentURL, gang.ContentScreenShare, true)
 	if dberr != nil {
 		// Error occured in UpdateGangContentData()
+		s.streams.release(admin)
 		return dberr
 	}
+	s.logactivity(ctx, admin, admin, ActivityStreamStart, "")
 	// Send notification to gang members
 	for _, member := range members {
 		go func(member string) {
@@ -711,28 +1013,28 @@ func (s service) playcontent(ctx context.Context, admin string) error {
 
 	if !gang.ContentScreenShare {
 		// Publish encoded content files into livekit cloud
-		if gang.ContentURL != "" {
-			s.livekit_config.Content = gang.ContentURL
-		} else {
-			s.livekit_config.Content = gang.ContentID
-		}
-		s.livekit_config.RoomName = "room:" + admin
-		s.livekit_config.Identity = admin
-		perr := launchStreamContent(ctx, s.logger, s.sseService, s.metricsService, s.gangRepo, s.livekit_config)
+		config := gangStreamConfig(s.livekit_config, gang)
+		perr := launchStreamContent(ctx, s.logger, s.sseService, s.metricsService, s.gangRepo, s.blobRepo, s.contentStore, s.streams, config)
 		if perr != nil {
-			// Error occured in publishStreamContent()
+			// Error occured in publishStreamContent(), gang isn't streaming after all
+			s.gangRepo.UpdateGangContentData(ctx, s.logger, admin, gang.ContentName, gang.ContentID, gang.ContentURL, gang.ContentScreenShare, false)
+			s.streams.release(admin)
 			return perr
 		}
 	} else {
-		go func() {
-			// stop screen sharing after 2 hours
-			time.AfterFunc(time.Duration(s.livekit_config.MaxScreenShareHours)*time.Hour, func() {
-				gang, dberr := s.gangRepo.GetGang(ctx, s.logger, gangKey, admin, false)
-				if dberr == nil && !gang.Streaming {
-					s.gangRepo.UpdateGangContentData(ctx, s.logger, admin, "", "", "", false, false)
-				}
-			})
-		}()
+		// Screen shares are published by the admin, Popcorn keeps track of the session only
+		now := time.Now().Unix()
+		record := entity.StreamRecord{
+			IngressID:   screenSharePrefix + xid.New().String(),
+			RoomName:    "room:" + admin,
+			Admin:       admin,
+			Started:     now,
+			ScreenShare: true,
+			Heartbeat:   now,
+		}
+		// Persisted so that the screen share can be watched again after a restart
+		s.gangRepo.SetStreamRecord(ctx, s.logger, record)
+		watchScreenShare(ctx, s.logger, s.sseService, s.metricsService, s.gangRepo, s.streams, s.livekit_config, record)
 	}
 	return nil
 }
@@ -746,46 +1048,249 @@ func (s service) stopcontent(ctx context.Context, admin string) error {
 	} else if gang.Admin == "" {
 		// Not an admin
 		return errors.BadRequest("user needs to create a gang")
+	}
+	// Streams still starting end as soon as they're up
+	if s.streams.stop(admin, "") {
+		s.logactivity(ctx, admin, admin, ActivityStreamStop, "")
+		return nil
 	} else if !gang.Streaming {
 		// Not streaming
 		return errors.BadRequest("content is not being streamed")
 	}
+	s.logactivity(ctx, admin, admin, ActivityStreamStop, "")
 
+	// Streams started before a restart and not recovered since are wrapped up right here
+	s.logger.WithCtx(ctx).Warn().Msgf("Couldn't find stream of room:%s", admin)
 	if !gang.ContentScreenShare {
-		s.livekit_config.RoomName = "room:" + admin
+		config := s.roomConfig(admin, admin)
 		if gang.ContentURL != "" {
-			s.livekit_config.Content = gang.ContentURL
-		} else {
-			s.livekit_config.Content = gang.ContentID
-		}
-		s.livekit_config.Identity = admin
-		if stream, ok := streamRecords[s.livekit_config.RoomName]; ok {
-			stream <- true
+			config.Content = gang.ContentURL
 		} else {
-			s.logger.WithCtx(ctx).Warn().Msgf("Couldn't find streamRecords for %s", s.livekit_config.RoomName)
-			ingressClient := createIngressClient(ctx, s.livekit_config)
-			updateAfterStreamEnds(ctx, s.logger, s.sseService, s.metricsService, s.gangRepo, ingressClient, s.livekit_config)
+			config.Content = gang.ContentID
 		}
+		ingressClient := createIngressClient(ctx, config)
+		updateAfterStreamEnds(ctx, s.logger, s.sseService, s.metricsService, s.gangRepo, s.blobRepo, s.contentStore, ingressClient, config)
+		s.gangRepo.DelStreamRecord(ctx, s.logger, admin, "")
 	} else {
-		// set gang.Streaming flag to false
-		dberr = s.gangRepo.UpdateGangContentData(ctx, s.logger, admin, "", "", "", false, false)
-		if dberr != nil {
-			// Error occured in UpdateGangContentData()
-			return dberr
-		}
+		return endScreenShare(ctx, s.logger, s.sseService, s.metricsService, s.gangRepo, admin, "")
+	}
+	return nil
+}
+
+func (s service) beatscreenshare(ctx context.Context, admin string) error {
+	shareID, ok := s.streams.status(admin)
+	if !ok || !isScreenShare(shareID) {
+		// Not screen sharing
+		return errors.BadRequest("gang is not screen sharing")
+	}
+	_, dberr := s.gangRepo.SetStreamHeartbeat(ctx, s.logger, admin, shareID, time.Now().Unix())
+	return dberr
+}
+
+func (s service) handlewebhook(ctx context.Context, req *http.Request) error {
+	// Livekit signs webhooks with the same API key and secret Popcorn uses
+	event, whkerr := webhook.ReceiveWebhookEvent(req, auth.NewSimpleKeyProvider(s.livekit_config.ApiKey, s.livekit_config.ApiSecret))
+	if whkerr != nil {
+		s.logger.WithCtx(ctx).Warn().Err(whkerr).Msg("Rejected livekit webhook")
+		return errors.Unauthorized("")
+	}
+	var roomName string
+	if event.GetIngressInfo() != nil {
+		roomName = event.GetIngressInfo().GetRoomName()
+	} else {
+		roomName = event.GetRoom().GetName()
+	}
+	admin, ok := strings.CutPrefix(roomName, "room:")
+	if !ok {
+		// Not a gang room
+		return nil
+	}
+	s.logger.WithCtx(ctx).Info().Msgf("Received livekit webhook %s | %s", event.GetEvent(), roomName)
+
+	// Helper to notify gang members about the stream
+	notify := func(sseType string, data interface{}) {
 		members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, admin)
 		for _, member := range members {
+			go func(member string) {
+				s.sseService.GetOrSetEvent(ctx).Message <- entity.SSEData{
+					Data: data,
+					Type: sseType,
+					To:   member,
+				}
+			}(member)
+		}
+	}
+	switch event.GetEvent() {
+	case webhook.EventIngressStarted:
+		// Content is being published from here on
+		if _, ok := s.streams.status(admin); ok {
+			notify("gangStreamStarted", nil)
+		}
+	case webhook.EventIngressEnded:
+		ingress := event.GetIngressInfo()
+		if ingress.GetState().GetStatus() == livekit.IngressState_ENDPOINT_ERROR {
+			// Failed ingresses are restarted, stream ends only once the retries are exhausted
+			if !s.streams.fail(admin, ingress.GetIngressId()) {
+				return nil
+			}
+			gang, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+admin, admin, false)
+			if dberr != nil {
+				// Error occured in GetGang(), livekit retries the webhook
+				s.streams.recover(admin, ingress.GetIngressId(), ingress.GetIngressId())
+				return dberr
+			}
+			// Restarts outlive the request they got triggered by
+			go restartStream(tracing.Detach(ctx), s.logger, s.sseService, s.metricsService, s.gangRepo, s.contentStore, createIngressClient(ctx, s.livekit_config),
+				s.streams, gangStreamConfig(s.livekit_config, gang), ingress.GetIngressId(), ingress.GetState().GetError())
+			return nil
+		}
+		// Ended streams of ingresses replaced since are left alone
+		s.streams.stop(admin, ingress.GetIngressId())
+	case webhook.EventRoomFinished:
+		// Ingress can't outlive its room
+		s.streams.stop(admin, "")
+	case webhook.EventTrackPublished, webhook.EventTrackUnpublished:
+		// Screen shares are confirmed by livekit while their track is published
+		if event.GetParticipant().GetIdentity() != admin || event.GetTrack().GetSource() != livekit.TrackSource_SCREEN_SHARE {
+			return nil
+		}
+		if shareID, ok := s.streams.status(admin); ok && isScreenShare(shareID) {
+			// Unpublished screen shares are kept for the grace period, admin might be switching screens
+			s.gangRepo.SetStreamPublishing(ctx, s.logger, admin, shareID, event.GetEvent() == webhook.EventTrackPublished, time.Now().Unix())
+		}
+	case webhook.EventParticipantJoined, webhook.EventParticipantLeft:
+		identity := event.GetParticipant().GetIdentity()
+		if identity == "" || identity == "gang_admin" {
+			// Ingress publishes content as gang_admin
+			return nil
+		}
+		if shareID, ok := s.streams.status(admin); ok && isScreenShare(shareID) && identity == admin && event.GetEvent() == webhook.EventParticipantLeft {
+			// Screen shares end along with their publisher
+			stopScreenShare(ctx, s.logger, s.gangRepo, s.streams, admin, shareID, screenSharePublisherLeft)
+		}
+		if event.GetEvent() == webhook.EventParticipantJoined {
+			notify("gangStreamJoin", identity)
+		} else {
+			notify("gangStreamLeave", identity)
+		}
+	}
+	return nil
+}
+
+func (s service) RecoverStreams(ctx context.Context) error {
+	ingressClient := createIngressClient(ctx, s.livekit_config)
+	lkctx, span := tracing.StartClient(ctx, "livekit.ListIngress")
+	ingressList, ingerr := ingressClient.ListIngress(lkctx, &livekit.ListIngressRequest{})
+	tracing.End(span, ingerr)
+	if ingerr != nil {
+		// Error occured in ListIngress()
+		s.logger.WithCtx(ctx).Error().Err(ingerr).Msg("Error occured during listing ingress via livekit.ListIngress() in RecoverStreams")
+		return errors.InternalServerError("")
+	}
+	return reconcileStreams(ctx, s.logger, s.sseService, s.metricsService, s.gangRepo, s.blobRepo, s.contentStore, ingressClient, s.streams, s.livekit_config, ingressList.GetItems())
+}
+
+func (s service) CountGangs(ctx context.Context) (int64, error) {
+	return s.gangRepo.CountGangs(ctx, s.logger)
+}
+
+func (s service) ActiveStreams() int {
+	return s.streams.count()
+}
+
+func (s service) DeleteGang(ctx context.Context, admin string) error {
+	return s.delgang(ctx, admin)
+}
+
+func (s service) StopContent(ctx context.Context, admin string) error {
+	return s.stopcontent(ctx, admin)
+}
+
+func (s service) EvictUser(ctx context.Context, username string) error {
+	owned, dberr := s.gangRepo.HasGang(ctx, s.logger, "gang:"+username, "")
+	if dberr != nil {
+		// Error in HasGang()
+		return dberr
+	} else if owned {
+		// Deleting the gang also deletes its livekit room
+		return s.delgang(ctx, username)
+	}
+	joinedGang, dberr := s.gangRepo.GetJoinedGang(ctx, s.logger, username)
+	if dberr != nil {
+		// Error in GetJoinedGang()
+		return dberr
+	} else if joinedGang.Admin == "" {
+		// User is not part of any gang
+		return nil
+	}
+	return s.leavegang(ctx, entity.GangExit{Member: username, Type: "leave"})
+}
+
+// Helper to check if user is muted in the gang, returns errors.Forbidden if so.
+func (s service) checkmute(ctx context.Context, admin, username string) error {
+	remaining, dberr := s.gangRepo.GetGangMemberMute(ctx, s.logger, admin, username)
+	if dberr != nil {
+		// Error in GetGangMemberMute()
+		return dberr
+	} else if remaining > 0 {
+		minutes := int(remaining.Round(time.Minute).Minutes())
+		if minutes < 1 {
+			minutes = 1
+		}
+		return errors.Forbidden(fmt.Sprintf("You are muted in this gang for %d more minute(s)", minutes))
+	}
+	return nil
+}
+
+// Helper to broadcast a moderation event to every gang member except the one who triggered it.
+func (s service) broadcastmoderation(ctx context.Context, admin, sender, eventType string, payload interface{}) {
+	members, dberr := s.gangRepo.GetGangMembers(ctx, s.logger, admin)
+	if dberr != nil {
+		// Error in GetGangMembers(), already logged
+		return
+	}
+	for _, member := range members {
+		if sender != member {
 			go func(member string) {
 				data := entity.SSEData{
-					Data: nil,
-					Type: "gangEndContent",
+					Data: payload,
+					Type: eventType,
 					To:   member,
 				}
 				s.sseService.GetOrSetEvent(ctx).Message <- data
 			}(member)
 		}
 	}
-	return nil
+}
+
+// Helper to fetch the gang user has created or joined.
+// Returns BadRequest if user isn't part of any gang.
+func (s service) getusergang(ctx context.Context, username string) (entity.GangResponse, error) {
+	gang, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+username, username, true)
+	if dberr != nil {
+		// Error in GetGang()
+		return gang, dberr
+	} else if (gang == entity.GangResponse{}) {
+		// check using getJoinedGang
+		gang, dberr = s.gangRepo.GetJoinedGang(ctx, s.logger, username)
+		if dberr != nil {
+			// Error in GetJoinedGang()
+			return gang, dberr
+		} else if (gang == entity.GangResponse{}) {
+			return gang, errors.BadRequest("user needs to create or join a gang")
+		}
+	}
+	return gang, nil
+}
+
+// Returns livekit config of the room of admin's gang as identity, the shared config is never mutated as it's used concurrently.
+func (s service) roomConfig(identity, admin string) entity.LivekitConfig {
+	config := s.livekit_config
+	config.Identity = identity
+	if admin != "" {
+		config.RoomName = "room:" + admin
+	}
+	return config
 }
 
 // Helper to generate password hash and return in string type.
@@ -3,6 +3,7 @@
 package gang
 
 import (
+	"Popcorn/internal/blob"
 	"Popcorn/internal/entity"
 	"Popcorn/internal/errors"
 	"Popcorn/internal/metrics"
@@ -10,23 +11,42 @@ import (
 	"Popcorn/internal/user"
 	"Popcorn/pkg/cleanup"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/manifest"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/tracing"
 	"context"
+	"encoding/json"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/livekit/protocol/auth"
 	"github.com/livekit/protocol/livekit"
 	lksdk "github.com/livekit/server-sdk-go"
+	"github.com/rs/xid"
 )
 
 var (
-	ENV         string = os.Getenv("ENV")
-	UPLOAD_PATH string = os.Getenv("UPLOAD_PATH")
-	APP_URL     string = os.Getenv("ACCESS_CTL_ALLOW_ORGIN")
+	ENV string = os.Getenv("ENV")
+)
+
+// Interval streaming usage of ongoing streams is checked against monthly budgets at.
+var usageMeterInterval = time.Minute
+
+// Delay before the first restart of a failed ingress, doubled for every attempt after.
+var streamRetryBackoff = 5 * time.Second
+
+// Interval screen shares are checked against their time limit and for their publisher at.
+var screenShareCheckInterval = 15 * time.Second
+
+// Prefix of IDs screen shares are tracked under in place of an ingress ID.
+const screenSharePrefix = "share:"
+
+// Reasons Popcorn ends screen shares for, recorded as target of the stream_end activity.
+const (
+	screenShareTimeLimit     = "time_limit"
+	screenSharePublisherLeft = "publisher_left"
 )
 
 // Helper to fetch livekit room access token to be used by clients.
@@ -97,7 +117,7 @@ func getStreamToken(ctx context.Context, logger log.Logger, gangRepo Repository,
 		return "", errors.InternalServerError("")
 	}
 	// Save the newly created streaming_token
-	go userRepo.AddStreamingToken(ctx, logger, config.Identity, streaming_token)
+	go userRepo.AddStreamingToken(tracing.Detach(ctx), logger, config.Identity, streaming_token)
 
 	return streaming_token, err
 }
@@ -105,7 +125,9 @@ func getStreamToken(ctx context.Context, logger log.Logger, gangRepo Repository,
 // Helper to create a livekit room to be used for content streaming in Popcorn gangs.
 func createStreamRoomIfNotExists(ctx context.Context, logger log.Logger, gangRepo Repository, userRepo user.Repository, config entity.LivekitConfig) (bool, error) {
 	roomClient := lksdk.NewRoomServiceClient(config.Host, config.ApiKey, config.ApiSecret)
-	roomList, rerr := roomClient.ListRooms(ctx, &livekit.ListRoomsRequest{Names: []string{config.RoomName}})
+	lkctx, span := tracing.StartClient(ctx, "livekit.ListRooms")
+	roomList, rerr := roomClient.ListRooms(lkctx, &livekit.ListRoomsRequest{Names: []string{config.RoomName}})
+	tracing.End(span, rerr)
 	if rerr != nil {
 		// Error occured in livekit ListRooms()
 		logger.WithCtx(ctx).Error().Err(rerr).Msg("Error occured while creating room in livekit.ListRooms()")
@@ -119,15 +141,17 @@ func createStreamRoomIfNotExists(ctx context.Context, logger log.Logger, gangRep
 			return false, dberr
 		}
 		for _, member := range members {
-			go userRepo.DelStreamingToken(ctx, logger, member)
+			go userRepo.DelStreamingToken(tracing.Detach(ctx), logger, member)
 		}
 		// Create new livekit room
-		_, rerr := roomClient.CreateRoom(ctx, &livekit.CreateRoomRequest{
+		lkctx, span := tracing.StartClient(ctx, "livekit.CreateRoom")
+		_, rerr := roomClient.CreateRoom(lkctx, &livekit.CreateRoomRequest{
 			Name:            config.RoomName,
 			MaxParticipants: 10,
 			EmptyTimeout:    10800,
 			MinPlayoutDelay: 0,
 		})
+		tracing.End(span, rerr)
 		if rerr != nil {
 			// Error occured in livekit CreateRoom()
 			logger.WithCtx(ctx).Error().Err(rerr).Msg("Error occured while creating room in livekit.createStreamRoom()")
@@ -143,14 +167,18 @@ func createStreamRoomIfNotExists(ctx context.Context, logger log.Logger, gangRep
 // Helper to delete room, triggered during delGang request from admin.
 func deleteStreamRoom(ctx context.Context, logger log.Logger, config entity.LivekitConfig) error {
 	roomClient := lksdk.NewRoomServiceClient(config.Host, config.ApiKey, config.ApiSecret)
-	roomList, rerr := roomClient.ListRooms(ctx, &livekit.ListRoomsRequest{Names: []string{config.RoomName}})
+	lkctx, span := tracing.StartClient(ctx, "livekit.ListRooms")
+	roomList, rerr := roomClient.ListRooms(lkctx, &livekit.ListRoomsRequest{Names: []string{config.RoomName}})
+	tracing.End(span, rerr)
 	if rerr != nil {
 		// Error occured in livekit ListRooms()
 		logger.WithCtx(ctx).Error().Err(rerr).Msg("Error occured while creating room in livekit.ListRooms()")
 		return errors.InternalServerError("")
 	}
 	if len(roomList.Rooms) != 0 {
-		_, rerr = roomClient.DeleteRoom(ctx, &livekit.DeleteRoomRequest{Room: config.RoomName})
+		lkctx, span := tracing.StartClient(ctx, "livekit.DeleteRoom")
+		_, rerr = roomClient.DeleteRoom(lkctx, &livekit.DeleteRoomRequest{Room: config.RoomName})
+		tracing.End(span, rerr)
 		if rerr != nil {
 			// Error occured in livekit.DeleteRoom()
 			logger.WithCtx(ctx).Error().Err(rerr).Msgf("Couldn't delete room - %s", config.RoomName)
@@ -164,16 +192,138 @@ func deleteStreamRoom(ctx context.Context, logger log.Logger, config entity.Live
 // Triggered during leave gang or booting a member.
 func RemoveGangMemberFromStream(ctx context.Context, logger log.Logger, config entity.LivekitConfig, member string) {
 	roomClient := lksdk.NewRoomServiceClient(config.Host, config.ApiKey, config.ApiSecret)
-	_, rerr := roomClient.RemoveParticipant(ctx, &livekit.RoomParticipantIdentity{
+	lkctx, span := tracing.StartClient(ctx, "livekit.RemoveParticipant")
+	_, rerr := roomClient.RemoveParticipant(lkctx, &livekit.RoomParticipantIdentity{
 		Room:     config.RoomName,
 		Identity: member,
 	})
+	tracing.End(span, rerr)
 	if rerr != nil {
 		// Error occured in RemoveParticipant()
 		logger.WithCtx(ctx).Error().Err(rerr).Msg("Error occured during removing member in livekit.RemoveParticipant()")
 	}
 }
 
+// Helper to pick ingress video preset, content isn't encoded past the height of the manifest rendition picked by the admin.
+func ingressVideoPreset(height int) livekit.IngressVideoEncodingPreset {
+	switch {
+	case height == 0 || height > 720:
+		return livekit.IngressVideoEncodingPreset_H264_1080P_30FPS_3_LAYERS
+	case height > 540:
+		return livekit.IngressVideoEncodingPreset_H264_720P_30FPS_3_LAYERS
+	default:
+		return livekit.IngressVideoEncodingPreset_H264_540P_25FPS_2_LAYERS
+	}
+}
+
+// Quality profiles gang admins can pick from.
+const (
+	QualityDataSaver = "data-saver"
+	QualityStandard  = "standard"
+	QualityHD        = "hd"
+	QualityStereo    = "stereo"
+)
+
+// Encoding of a quality profile, video is encoded up to height.
+type qualityProfile struct {
+	height int
+	audio  livekit.IngressAudioEncodingPreset
+}
+
+// Encodings of quality profiles, standard is the default one.
+var qualityProfiles = map[string]qualityProfile{
+	QualityDataSaver: {height: 540, audio: livekit.IngressAudioEncodingPreset_OPUS_MONO_64KBS},
+	QualityStandard:  {height: 720, audio: livekit.IngressAudioEncodingPreset_OPUS_MONO_64KBS},
+	QualityHD:        {height: 1080, audio: livekit.IngressAudioEncodingPreset_OPUS_STEREO_96KBPS},
+	QualityStereo:    {height: 720, audio: livekit.IngressAudioEncodingPreset_OPUS_STEREO_96KBPS},
+}
+
+// Returns true if quality profile is known and allowed by server.
+func allowedQuality(config entity.LivekitConfig, quality string) bool {
+	if _, ok := qualityProfiles[quality]; !ok {
+		return false
+	} else if len(config.QualityProfiles) == 0 {
+		return true
+	}
+	for _, profile := range config.QualityProfiles {
+		if profile == quality {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns quality profile gangs get by default, standard unless the server doesn't allow it.
+func defaultQuality(config entity.LivekitConfig) string {
+	if allowedQuality(config, QualityStandard) {
+		return QualityStandard
+	}
+	for _, profile := range config.QualityProfiles {
+		if allowedQuality(config, profile) {
+			return profile
+		}
+	}
+	return QualityStandard
+}
+
+// Helper to build ingress encoding options of config.Quality, profiles no longer allowed fall back to the default one.
+// Video isn't encoded past the height of the content either.
+func ingressOptions(config entity.LivekitConfig) (*livekit.IngressVideoOptions, *livekit.IngressAudioOptions) {
+	quality := config.Quality
+	if !allowedQuality(config, quality) {
+		quality = defaultQuality(config)
+	}
+	profile := qualityProfiles[quality]
+	height := profile.height
+	if config.ContentHeight != 0 && config.ContentHeight < height {
+		height = config.ContentHeight
+	}
+	video := &livekit.IngressVideoOptions{
+		EncodingOptions: &livekit.IngressVideoOptions_Preset{
+			Preset: ingressVideoPreset(height),
+		},
+	}
+	audio := &livekit.IngressAudioOptions{
+		EncodingOptions: &livekit.IngressAudioOptions_Preset{
+			Preset: profile.audio,
+		},
+	}
+	return video, audio
+}
+
+// Helper to find the manifest rendition picked by admin of gang, returns false unless content URL is a manifest.
+func contentRendition(gang entity.GangResponse) (manifest.Rendition, bool) {
+	if gang.ContentManifest == "" {
+		return manifest.Rendition{}, false
+	}
+	var contentManifest manifest.Manifest
+	if jsonerr := json.Unmarshal([]byte(gang.ContentManifest), &contentManifest); jsonerr != nil {
+		return manifest.Rendition{}, false
+	}
+	rendition, mnferr := contentManifest.Rendition(gang.ContentRendition)
+	return rendition, mnferr == nil
+}
+
+// Returns livekit config of the stream of gang content, based on the shared config.
+func gangStreamConfig(config entity.LivekitConfig, gang entity.GangResponse) entity.LivekitConfig {
+	config.Identity = gang.Admin
+	config.RoomName = "room:" + gang.Admin
+	config.Quality = gang.Quality
+	if gang.ContentURL != "" {
+		config.Content = gang.ContentURL
+		if rendition, ok := contentRendition(gang); ok {
+			// HLS renditions are pulled on their own, DASH ones only cap the encoding
+			if rendition.URL != "" {
+				config.Content = rendition.URL
+			}
+			config.ContentHeight = rendition.Height
+		}
+	} else {
+		config.Content = gang.ContentID
+	}
+	return config
+}
+
 // Helper to create and return an IngressClient.
 func createIngressClient(_ context.Context, config entity.LivekitConfig) *lksdk.IngressClient {
 	return lksdk.NewIngressClient(config.Host, config.ApiKey, config.ApiSecret)
@@ -186,6 +336,9 @@ func launchStreamContent(
 	sseService sse.Service,
 	metricsService metrics.Service,
 	gangRepo Repository,
+	blobRepo blob.Repository,
+	contentStore objectstore.Store,
+	streams *streamManager,
 	config entity.LivekitConfig) error {
 	ingressClient := createIngressClient(ctx, config)
 
@@ -196,12 +349,63 @@ func launchStreamContent(
 		return ingerr
 	}
 
+	// Uploaded content holds its ingress since the upload finished, URL content only while streaming
+	if govalidator.IsURL(config.Content) {
+		acquired, dberr := metricsService.AcquireIngress(ctx, config.Identity)
+		if dberr != nil {
+			return dberr
+		} else if !acquired {
+			// Livekit concurrent ingress limit exceeded
+			valerr := errors.New("gang:Max concurrent URL livestream limit exceeded")
+			return errors.GenerateValidationErrorResponse([]error{valerr})
+		}
+	} else {
+		dberr := metricsService.HoldIngress(ctx, config.Identity)
+		if dberr != nil {
+			return dberr
+		}
+	}
+	info, ingerr := createStreamIngress(ctx, logger, metricsService, ingressClient, contentStore, config)
+	if ingerr != nil {
+		// Error occured in createStreamIngress()
+		if govalidator.IsURL(config.Content) {
+			metricsService.ReleaseIngress(ctx, config.Identity)
+		}
+		return ingerr
+	}
+
+	// Persisted so that the stream can be watched again after a restart
+	gangRepo.SetStreamRecord(ctx, logger, entity.StreamRecord{
+		IngressID: info.IngressId,
+		RoomName:  config.RoomName,
+		Admin:     config.Identity,
+		Content:   config.Content,
+		Started:   time.Now().Unix(),
+	})
+	watchStream(ctx, logger, sseService, metricsService, gangRepo, blobRepo, contentStore, ingressClient, streams, info.IngressId, config)
+	return nil
+}
+
+// Helper to create livekit ingress pulling the content of config into the gang room.
+func createStreamIngress(
+	ctx context.Context,
+	logger log.Logger,
+	metricsService metrics.Service,
+	ingressClient *lksdk.IngressClient,
+	contentStore objectstore.Store,
+	config entity.LivekitConfig) (*livekit.IngressInfo, error) {
 	var media_pull_url string
 	if govalidator.IsURL(config.Content) {
 		// Check whether content is an URL or a filename
 		media_pull_url = config.Content
 	} else {
-		media_pull_url = APP_URL + "/api/upload_content/" + config.Content
+		// Uploaded content is pulled from wherever the content store keeps it
+		var strerr error
+		media_pull_url, strerr = contentStore.PullURL(ctx, config.Content)
+		if strerr != nil {
+			logger.WithCtx(ctx).Error().Err(strerr).Msg("Error occured while building pull URL of gang content")
+			return nil, errors.InternalServerError("")
+		}
 	}
 	// Create a new ingress request
 	ingressRequest := &livekit.CreateIngressRequest{
@@ -211,95 +415,534 @@ func launchStreamContent(
 		ParticipantIdentity: "gang_admin",
 		ParticipantName:     config.Identity,
 		Url:                 media_pull_url,
-		Video: &livekit.IngressVideoOptions{
-			EncodingOptions: &livekit.IngressVideoOptions_Preset{
-				Preset: livekit.IngressVideoEncodingPreset_H264_1080P_30FPS_3_LAYERS,
-			},
-		},
-		Audio: &livekit.IngressAudioOptions{
-			EncodingOptions: &livekit.IngressAudioOptions_Preset{
-				Preset: livekit.IngressAudioEncodingPreset_OPUS_MONO_64KBS,
-			},
-		},
 	}
+	ingressRequest.Video, ingressRequest.Audio = ingressOptions(config)
 	metrics, dberr := metricsService.GetMetrics(ctx)
 	if dberr != nil {
-		return dberr
+		return nil, dberr
 	}
-	info, ingerr := ingressClient.CreateIngress(ctx, ingressRequest)
+	lkctx, span := tracing.StartClient(ctx, "livekit.CreateIngress")
+	info, ingerr := ingressClient.CreateIngress(lkctx, ingressRequest)
+	tracing.End(span, ingerr)
 	if ingerr != nil {
 		// Error in CreateIngress()
 		logger.WithCtx(ctx).Error().Err(ingerr).Msg("Error occured during the execution of livekit.CreateIngress()")
 		if strings.Contains(ingerr.Error(), "exceeded") {
 			// Set IngressQuotaExceeded as True to block other streams trying to utilize Ingress
-			metrics.IngressQuotaExceeded = true
-			go metricsService.SetOrUpdateMetrics(ctx, &metrics)
+			metricsService.SetIngressQuotaExceeded(ctx, true)
 		}
-		return errors.InternalServerError("")
-	} else {
-		metrics.IngressQuotaExceeded = false
+		return nil, errors.InternalServerError("")
+	} else if metrics.IngressQuotaExceeded {
+		metricsService.SetIngressQuotaExceeded(ctx, false)
+	}
+	return info, nil
+}
+
+// Helper to watch the stream published through ingressID, gang data gets updated once the stream is signalled to end.
+// Streams end on livekit webhooks and on user triggered force-close, they keep being published across server restarts.
+func watchStream(
+	ctx context.Context,
+	logger log.Logger,
+	sseService sse.Service,
+	metricsService metrics.Service,
+	gangRepo Repository,
+	blobRepo blob.Repository,
+	contentStore objectstore.Store,
+	ingressClient *lksdk.IngressClient,
+	streams *streamManager,
+	ingressID string,
+	config entity.LivekitConfig) {
+	// Streams outlive the request they got started by
+	ctx = tracing.Detach(ctx)
+	streams.attach(config.Identity, ingressID, func(id string) {
+		updateAfterStreamEnds(ctx, logger, sseService, metricsService, gangRepo, blobRepo, contentStore, ingressClient, config)
+		gangRepo.DelStreamRecord(ctx, logger, config.Identity, id)
+	})
+	go meterStream(ctx, logger, sseService, metricsService, gangRepo, streams, config.Identity, ingressID)
+}
+
+// Returns the number of times failed ingresses get restarted, defaults to 3.
+func streamRetries(config entity.LivekitConfig) int {
+	if config.MaxStreamRetries <= 0 {
+		return 3
+	}
+	return config.MaxStreamRetries
+}
+
+// Helper to restart stream of config whose ingress failed as ingressID with cause, retried with backoff till the retries are exhausted.
+// Content is kept till then, so that transient failures don't end the stream for good.
+// URL ingress can't seek, restarted streams play from the beginning and the gang playback position is reset along with them.
+// Restarts outlive the request they got triggered by, ctx must be detached from it.
+func restartStream(
+	ctx context.Context,
+	logger log.Logger,
+	sseService sse.Service,
+	metricsService metrics.Service,
+	gangRepo Repository,
+	contentStore objectstore.Store,
+	ingressClient *lksdk.IngressClient,
+	streams *streamManager,
+	config entity.LivekitConfig,
+	ingressID, cause string) {
+	admin := config.Identity
+	health := entity.GangStreamHealth{Error: cause, MaxAttempts: streamRetries(config)}
+	if gang, dberr := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false); dberr == nil && gang.StreamStarted > 0 {
+		health.Position = time.Now().Unix() - gang.StreamStarted
 	}
+	logger.WithCtx(ctx).Warn().Msgf("Stream failed for content %s | %s : %s", config.Content, config.RoomName, cause)
+	notifyStreamHealth(ctx, logger, sseService, gangRepo, admin, "gangStreamError", health)
 
-	// Change ActiveIngress metrics
-	metrics.ActiveIngress += 1
-	dberr = metricsService.SetOrUpdateMetrics(ctx, &metrics)
+	backoff := streamRetryBackoff
+	for attempt := 1; attempt <= health.MaxAttempts; attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		if id, ok := streams.status(admin); !ok || id != ingressID {
+			// Stream got stopped in the meantime
+			return
+		}
+		// Failed ingress is replaced by a new one
+		deleteIngress(ctx, logger, ingressClient, config.RoomName)
+		info, ingerr := createStreamIngress(ctx, logger, metricsService, ingressClient, contentStore, config)
+		if ingerr != nil {
+			// Error occured in createStreamIngress()
+			continue
+		}
+		if !streams.recover(admin, ingressID, info.IngressId) {
+			// Stream got stopped while the ingress was being created
+			deleteIngress(ctx, logger, ingressClient, config.RoomName)
+			return
+		}
+		// Time streamed till now is accounted before playback starts over
+		if gang, dberr := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false); dberr == nil {
+			recordStreamUsage(ctx, logger, metricsService, gangRepo, gang)
+		}
+		gangRepo.RestartGangStream(ctx, logger, admin)
+		gangRepo.SetStreamRecord(ctx, logger, entity.StreamRecord{
+			IngressID: info.IngressId,
+			RoomName:  config.RoomName,
+			Admin:     admin,
+			Content:   config.Content,
+			Started:   time.Now().Unix(),
+		})
+		go meterStream(ctx, logger, sseService, metricsService, gangRepo, streams, admin, info.IngressId)
+		logger.WithCtx(ctx).Info().Msgf("Stream restarted for content %s | %s after %d attempts", config.Content, config.RoomName, attempt)
+		health.Attempt = attempt
+		notifyStreamHealth(ctx, logger, sseService, gangRepo, admin, "gangStreamRecovered", health)
+		return
+	}
+	// Retries exhausted, stream ends like the completed ones
+	logger.WithCtx(ctx).Error().Msgf("Stream couldn't be restarted for content %s | %s", config.Content, config.RoomName)
+	streams.stop(admin, ingressID)
+}
+
+// Helper to notify gang members, the admin included, about the health of the gang stream.
+func notifyStreamHealth(ctx context.Context, logger log.Logger, sseService sse.Service, gangRepo Repository, admin, sseType string, health entity.GangStreamHealth) {
+	members, _ := gangRepo.GetGangMembers(ctx, logger, admin)
+	for _, member := range members {
+		go func(member string) {
+			sseService.GetOrSetEvent(ctx).Message <- entity.SSEData{
+				Data: health,
+				Type: sseType,
+				To:   member,
+			}
+		}(member)
+	}
+}
+
+// Helper to meter stream of admin published as streamID against the monthly budgets of admin till the stream ends.
+func meterStream(
+	ctx context.Context,
+	logger log.Logger,
+	sseService sse.Service,
+	metricsService metrics.Service,
+	gangRepo Repository,
+	streams *streamManager,
+	admin, streamID string) {
+	ticker := time.NewTicker(usageMeterInterval)
+	defer ticker.Stop()
+	warned := 0
+	for range ticker.C {
+		if id, ok := streams.status(admin); !ok || id != streamID {
+			return
+		}
+		// Time streamed is accounted every tick, so that streams running across months are split between them
+		if gang, dberr := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false); dberr == nil {
+			recordStreamUsage(ctx, logger, metricsService, gangRepo, gang)
+		}
+		warned = checkStreamUsage(ctx, logger, sseService, metricsService, gangRepo, admin, warned)
+	}
+}
+
+// Helper to warn admin over SSE once the ongoing stream crosses a threshold of the monthly budget higher than warned.
+// Returns the highest threshold admin got warned at.
+func checkStreamUsage(
+	ctx context.Context,
+	logger log.Logger,
+	sseService sse.Service,
+	metricsService metrics.Service,
+	gangRepo Repository,
+	admin string,
+	warned int) int {
+	gang, dberr := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false)
+	if dberr != nil || !gang.Streaming || gang.StreamStarted == 0 {
+		return warned
+	}
+	usage, dberr := metricsService.GetStreamUsage(ctx, admin)
 	if dberr != nil {
-		return dberr
+		return warned
+	}
+	// Time streamed since the stream was last metered isn't accounted in usage yet
+	elapsed := time.Now().Unix() - gang.StreamStarted
+	if gang.StreamMetered > gang.StreamStarted {
+		elapsed = time.Now().Unix() - gang.StreamMetered
+	}
+	threshold := metrics.UsageThreshold(usage, gang.ContentScreenShare, elapsed)
+	if threshold <= warned {
+		return warned
+	}
+	warning := entity.StreamUsageWarning{
+		Kind:    "ingress",
+		Percent: threshold,
+		Used:    usage.Ingress + elapsed,
+		Budget:  usage.IngressBudget,
+	}
+	if gang.ContentScreenShare {
+		warning.Kind = "screen_share"
+		warning.Used = usage.ScreenShare + elapsed
+		warning.Budget = usage.ScreenShareBudget
+	}
+	go func(member string) {
+		sseService.GetOrSetEvent(ctx).Message <- entity.SSEData{
+			Data: warning,
+			Type: "gangUsageWarning",
+			To:   member,
+		}
+	}(admin)
+	return threshold
+}
+
+// Helper to account the time gang streamed for since it was last metered in the monthly usage of its admin and in the gang usage.
+func recordStreamUsage(ctx context.Context, logger log.Logger, metricsService metrics.Service, gangRepo Repository, gang entity.GangResponse) {
+	if !gang.Streaming || gang.StreamStarted == 0 {
+		return
+	}
+	elapsed, screenShare, dberr := gangRepo.MeterGangStream(ctx, logger, gang.Admin)
+	if dberr != nil || elapsed <= 0 {
+		return
+	}
+	metricsService.AddStreamUsage(ctx, gang.Admin, screenShare, elapsed)
+}
+
+// Returns true if streamID is of a screen share.
+func isScreenShare(streamID string) bool {
+	return strings.HasPrefix(streamID, screenSharePrefix)
+}
+
+// Returns the duration screen shares are kept without their publisher being confirmed, defaults to a minute.
+func screenShareGrace(config entity.LivekitConfig) time.Duration {
+	if config.ScreenShareHeartbeatSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(config.ScreenShareHeartbeatSeconds) * time.Second
+}
+
+// Returns the reason screen share recorded in record has to be ended for at now, empty if it can go on.
+func screenShareEndReason(record entity.StreamRecord, config entity.LivekitConfig, now time.Time) string {
+	if config.MaxScreenShareHours > 0 && now.Sub(time.Unix(record.Started, 0)) >= time.Duration(config.MaxScreenShareHours)*time.Hour {
+		return screenShareTimeLimit
+	}
+	if !record.Publishing && now.Sub(time.Unix(record.Heartbeat, 0)) > screenShareGrace(config) {
+		return screenSharePublisherLeft
+	}
+	return ""
+}
+
+// Helper to watch screen share recorded in record, the screen share is ended once it's signalled to end.
+// Screen shares are ended by Popcorn after the time limit, or once their publisher isn't confirmed for longer than the grace period.
+func watchScreenShare(
+	ctx context.Context,
+	logger log.Logger,
+	sseService sse.Service,
+	metricsService metrics.Service,
+	gangRepo Repository,
+	streams *streamManager,
+	config entity.LivekitConfig,
+	record entity.StreamRecord) {
+	// Screen shares outlive the request they got started by
+	ctx = tracing.Detach(ctx)
+	streams.attach(record.Admin, record.IngressID, func(id string) {
+		endScreenShare(ctx, logger, sseService, metricsService, gangRepo, record.Admin, id)
+	})
+	go meterStream(ctx, logger, sseService, metricsService, gangRepo, streams, record.Admin, record.IngressID)
+	go superviseScreenShare(ctx, logger, gangRepo, streams, config, screenShareCheckInterval, record.Admin, record.IngressID)
+}
+
+// Helper to check screen share of admin shared as shareID every interval, ending it once it has to be.
+func superviseScreenShare(
+	ctx context.Context,
+	logger log.Logger,
+	gangRepo Repository,
+	streams *streamManager,
+	config entity.LivekitConfig,
+	interval time.Duration,
+	admin, shareID string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if id, ok := streams.status(admin); !ok || id != shareID {
+			return
+		}
+		record, dberr := gangRepo.GetStreamRecord(ctx, logger, admin)
+		if dberr != nil {
+			continue
+		}
+		now := time.Now()
+		reason := screenShareEndReason(record, config, now)
+		if reason == screenSharePublisherLeft && record.IngressID == shareID && screenSharePublished(ctx, logger, config, admin) {
+			// Webhook got lost, the track is still published
+			gangRepo.SetStreamPublishing(ctx, logger, admin, shareID, true, now.Unix())
+			continue
+		} else if reason != "" {
+			stopScreenShare(ctx, logger, gangRepo, streams, admin, shareID, reason)
+			return
+		}
 	}
+}
 
-	ticker := time.NewTicker(2 * time.Second)
+// Helper to signal screen share of admin shared as shareID to end for reason, recorded in the gang activity log.
+// Returns false if no such screen share is active.
+func stopScreenShare(ctx context.Context, logger log.Logger, gangRepo Repository, streams *streamManager, admin, shareID, reason string) bool {
+	if !streams.stop(admin, shareID) {
+		return false
+	}
+	logger.WithCtx(ctx).Info().Msgf("Ending screen share of room:%s | %s", admin, reason)
+	gangRepo.AddGangActivity(ctx, logger, admin, entity.GangActivity{
+		Actor:   ActivitySystemActor,
+		Action:  ActivityStreamEnd,
+		Target:  reason,
+		Created: time.Now().Unix(),
+	})
+	return true
+}
+
+// Returns true if livekit has the screen share track of admin published in the gang room.
+func screenSharePublished(ctx context.Context, logger log.Logger, config entity.LivekitConfig, admin string) bool {
+	roomClient := lksdk.NewRoomServiceClient(config.Host, config.ApiKey, config.ApiSecret)
+	lkctx, span := tracing.StartClient(ctx, "livekit.GetParticipant")
+	participant, rerr := roomClient.GetParticipant(lkctx, &livekit.RoomParticipantIdentity{
+		Room:     "room:" + admin,
+		Identity: admin,
+	})
+	tracing.End(span, rerr)
+	if rerr != nil {
+		// Participant isn't in the room, or livekit couldn't be reached
+		logger.WithCtx(ctx).Warn().Err(rerr).Msgf("Couldn't confirm screen share of room:%s via livekit.GetParticipant()", admin)
+		return false
+	}
+	for _, track := range participant.GetTracks() {
+		if track.GetSource() == livekit.TrackSource_SCREEN_SHARE {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to erase screen share content of admin's gang and notify gang members about it.
+// Screen share record is deleted unless it's of a screen share other than shareID, not checked if shareID is empty.
+func endScreenShare(
+	ctx context.Context,
+	logger log.Logger,
+	sseService sse.Service,
+	metricsService metrics.Service,
+	gangRepo Repository,
+	admin, shareID string) error {
+	defer gangRepo.DelStreamRecord(ctx, logger, admin, shareID)
+	gang, dberr := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false)
+	if dberr != nil {
+		// Error occured in GetGang()
+		return dberr
+	}
+	recordStreamUsage(ctx, logger, metricsService, gangRepo, gang)
+	// set gang.Streaming flag to false
+	dberr = gangRepo.UpdateGangContentData(ctx, logger, admin, "", "", "", false, false)
+	if dberr != nil {
+		// Error occured in UpdateGangContentData()
+		return dberr
+	}
+	members, _ := gangRepo.GetGangMembers(ctx, logger, admin)
+	for _, member := range members {
+		go func(member string) {
+			data := entity.SSEData{
+				Data: nil,
+				Type: "gangEndContent",
+				To:   member,
+			}
+			sseService.GetOrSetEvent(ctx).Message <- data
+		}(member)
+	}
+	return nil
+}
 
-	// Start a goroutine to handle graceful update of gang data after stream ends via livekit (not client side stop action)
-	go func() {
-		for range ticker.C {
-			ingList, err := ingressClient.ListIngress(ctx, &livekit.ListIngressRequest{IngressId: info.IngressId})
-			if err != nil {
-				updateAfterStreamEnds(ctx, logger, sseService, metricsService, gangRepo, ingressClient, config)
-				ticker.Stop()
-				return
+// Helper to reconcile streaming gangs and their stream records against ingresses of the livekit project.
+// Streams whose ingress is still publishing are watched again, the rest are wrapped up as if they just ended.
+func reconcileStreams(
+	ctx context.Context,
+	logger log.Logger,
+	sseService sse.Service,
+	metricsService metrics.Service,
+	gangRepo Repository,
+	blobRepo blob.Repository,
+	contentStore objectstore.Store,
+	ingressClient *lksdk.IngressClient,
+	streams *streamManager,
+	config entity.LivekitConfig,
+	ingresses []*livekit.IngressInfo) error {
+	records, dberr := gangRepo.GetStreamRecords(ctx, logger)
+	if dberr != nil {
+		// Error occured in GetStreamRecords()
+		return dberr
+	}
+	// Ingresses still buffering or publishing, and failed ones, keyed by their room
+	active := map[string]*livekit.IngressInfo{}
+	failed := map[string]*livekit.IngressInfo{}
+	for _, ingress := range ingresses {
+		if activeIngress(ingress) {
+			active[ingress.RoomName] = ingress
+		} else if ingress.GetState().GetStatus() == livekit.IngressState_ENDPOINT_ERROR {
+			failed[ingress.RoomName] = ingress
+		}
+	}
+	cursor := uint64(0)
+	for {
+		gangList, newCursor, dberr := gangRepo.ListGangs(ctx, logger, cursor)
+		if dberr != nil {
+			// Error occured in ListGangs()
+			return dberr
+		}
+		for _, gang := range gangList {
+			record, recorded := records[gang.Admin]
+			delete(records, gang.Admin)
+			if !gang.Streaming {
+				if recorded {
+					gangRepo.DelStreamRecord(ctx, logger, gang.Admin, record.IngressID)
+				}
+				continue
 			}
-			for _, ing := range ingList.Items {
-				ing_status := livekit.IngressState_Status(ing.State.Status.Number())
-				// 1 is ENDPOINT_BUFFERING and 2 is ENDPOINT_PUBLISHING
-				if ing_status != 1 && ing_status != 2 {
-					// Stream finished
-					updateAfterStreamEnds(ctx, logger, sseService, metricsService, gangRepo, ingressClient, config)
-					ticker.Stop()
-					return
+			if gang.ContentScreenShare {
+				// Screen shares go without ingress
+				if !streams.claim(gang.Admin) {
+					// Started or being watched already
+					continue
+				}
+				if !recorded || !record.ScreenShare {
+					// Screen share started right before the restart, before it got recorded
+					record = entity.StreamRecord{
+						IngressID:   screenSharePrefix + xid.New().String(),
+						RoomName:    "room:" + gang.Admin,
+						Admin:       gang.Admin,
+						Started:     gang.StreamStarted,
+						ScreenShare: true,
+					}
 				}
+				// Webhooks sent while Popcorn was down are lost, publisher has to be confirmed afresh
+				record.Publishing = false
+				record.Heartbeat = time.Now().Unix()
+				gangRepo.SetStreamRecord(ctx, logger, record)
+				logger.WithCtx(ctx).Info().Msgf("Recovered screen share of %s", record.RoomName)
+				watchScreenShare(ctx, logger, sseService, metricsService, gangRepo, streams, config, record)
+				continue
 			}
+			if !streams.claim(gang.Admin) {
+				// Started or being watched already
+				continue
+			}
+			streamConfig := gangStreamConfig(config, gang)
+			if recorded {
+				streamConfig.Content = record.Content
+			}
+			ingress, ok := active[streamConfig.RoomName]
+			failure, hasFailed := failed[streamConfig.RoomName]
+			switch {
+			case ok && (!recorded || record.IngressID == ingress.IngressId):
+				if !recorded {
+					// Stream started right before the restart, before it got recorded
+					gangRepo.SetStreamRecord(ctx, logger, entity.StreamRecord{
+						IngressID: ingress.IngressId,
+						RoomName:  streamConfig.RoomName,
+						Admin:     gang.Admin,
+						Content:   streamConfig.Content,
+						Started:   gang.StreamStarted,
+					})
+				}
+				logger.WithCtx(ctx).Info().Msgf("Recovered stream of %s | %s", streamConfig.Content, streamConfig.RoomName)
+				watchStream(ctx, logger, sseService, metricsService, gangRepo, blobRepo, contentStore, ingressClient, streams, ingress.IngressId, streamConfig)
+			case !ok && hasFailed && (!recorded || record.IngressID == failure.IngressId):
+				// Failed while Popcorn was down, restarted just like the failures reported by webhooks
+				watchStream(ctx, logger, sseService, metricsService, gangRepo, blobRepo, contentStore, ingressClient, streams, failure.IngressId, streamConfig)
+				if streams.fail(gang.Admin, failure.IngressId) {
+					go restartStream(tracing.Detach(ctx), logger, sseService, metricsService, gangRepo, contentStore, ingressClient, streams, streamConfig, failure.IngressId, failure.GetState().GetError())
+				}
+			default:
+				// Ended while Popcorn was down
+				updateAfterStreamEnds(ctx, logger, sseService, metricsService, gangRepo, blobRepo, contentStore, ingressClient, streamConfig)
+				gangRepo.DelStreamRecord(ctx, logger, gang.Admin, "")
+				streams.release(gang.Admin)
+			}
+		}
+		if newCursor == 0 {
+			break
+		}
+		cursor = newCursor
+	}
+	// Records of gangs gone in the meantime
+	for admin, record := range records {
+		if !record.ScreenShare {
+			deleteIngress(ctx, logger, ingressClient, record.RoomName)
 		}
-	}()
-	// Start another goroutine to updates because of handle server shutdown
-	go func() {
-		s := make(chan os.Signal, 1)
-		signal.Notify(s, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
-		<-s
-		ticker.Stop()
-		updateAfterStreamEnds(ctx, logger, sseService, metricsService, gangRepo, ingressClient, config)
-	}()
-	// Another goroutine to handle user triggered force-close of this stream
-	go func() {
-		streamRecords[config.RoomName] = make(close_stream_signal, 1)
-		<-streamRecords[config.RoomName]
-		updateAfterStreamEnds(ctx, logger, sseService, metricsService, gangRepo, ingressClient, config)
-		ticker.Stop()
-		close(streamRecords[config.RoomName])
-		delete(streamRecords, config.RoomName)
-	}()
+		gangRepo.DelStreamRecord(ctx, logger, admin, record.IngressID)
+	}
 	return nil
 }
 
+// Returns true if ingress is still buffering or publishing.
+func activeIngress(ingress *livekit.IngressInfo) bool {
+	status := ingress.GetState().GetStatus()
+	return status == livekit.IngressState_ENDPOINT_BUFFERING || status == livekit.IngressState_ENDPOINT_PUBLISHING
+}
+
+// Returns admins of gangs whose room has an active ingress in the livekit project, used to reconcile ingress leases.
+func ListIngressHolders(ctx context.Context, logger log.Logger, config entity.LivekitConfig) ([]string, error) {
+	lkctx, span := tracing.StartClient(ctx, "livekit.ListIngress")
+	ingressList, ingerr := createIngressClient(ctx, config).ListIngress(lkctx, &livekit.ListIngressRequest{})
+	tracing.End(span, ingerr)
+	if ingerr != nil {
+		// Error occured in ListIngress()
+		logger.WithCtx(ctx).Error().Err(ingerr).Msg("Error occured during listing ingress via livekit.ListIngress() in ListIngressHolders")
+		return nil, errors.InternalServerError("")
+	}
+	holders := []string{}
+	for _, ingress := range ingressList.GetItems() {
+		if admin, ok := strings.CutPrefix(ingress.RoomName, "room:"); ok && activeIngress(ingress) {
+			holders = append(holders, admin)
+		}
+	}
+	return holders, nil
+}
+
 // Helper to delete already built livekit ingress.
 func deleteIngress(ctx context.Context, logger log.Logger, client *lksdk.IngressClient, roomName string) error {
-	ingressList, ingerr := client.ListIngress(ctx, &livekit.ListIngressRequest{RoomName: roomName})
+	lkctx, span := tracing.StartClient(ctx, "livekit.ListIngress")
+	ingressList, ingerr := client.ListIngress(lkctx, &livekit.ListIngressRequest{RoomName: roomName})
+	tracing.End(span, ingerr)
 	if ingerr != nil {
 		// Error occured in ListIngress()
 		logger.WithCtx(ctx).Error().Err(ingerr).Msg("Error occured during listing ingress via livekit.ListIngress()")
 		return errors.InternalServerError("")
 	}
 	for _, ing := range ingressList.GetItems() {
-		_, ingerr = client.DeleteIngress(ctx, &livekit.DeleteIngressRequest{IngressId: ing.IngressId})
+		lkctx, span := tracing.StartClient(ctx, "livekit.DeleteIngress")
+		_, ingerr = client.DeleteIngress(lkctx, &livekit.DeleteIngressRequest{IngressId: ing.IngressId})
+		tracing.End(span, ingerr)
 		if ingerr != nil {
 			logger.WithCtx(ctx).Error().Err(ingerr).Msgf("Error occured while deleting ingress - %s via livekit.DeleteIngress()", ing)
 		} else {
@@ -316,25 +959,34 @@ func updateAfterStreamEnds(
 	sseService sse.Service,
 	metricsService metrics.Service,
 	gangRepo Repository,
+	blobRepo blob.Repository,
+	contentStore objectstore.Store,
 	ingressClient *lksdk.IngressClient, config entity.LivekitConfig) {
 	logger.WithCtx(ctx).Info().Msgf("Stream ended for content %s | %s", config.Content, config.RoomName)
 	// Delete ingress
 	deleteIngress(ctx, logger, ingressClient, config.RoomName)
-	if !govalidator.IsURL(config.Content) {
-		// Delete gang content files
-		cleanup.DeleteContentFiles(config.Content, logger)
+	gang, dberr := gangRepo.GetGang(ctx, logger, "gang:"+config.Identity, config.Identity, false)
+	if dberr == nil {
+		recordStreamUsage(ctx, logger, metricsService, gangRepo, gang)
 	}
-	// Change ActiveIngress metrics
-	metrics, dberr := metricsService.GetMetrics(ctx)
-	if dberr != nil {
-		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured in updateAfterStreamEnds()")
-	} else if metrics.ActiveIngress >= 1 {
-		metrics.ActiveIngress -= 1
-		go metricsService.SetOrUpdateMetrics(ctx, &metrics)
+	if !govalidator.IsURL(config.Content) {
+		if dberr != nil || !gang.ContentLibrary || gang.ContentID != config.Content {
+			// Delete gang content files, unless kept in the content library or shared with other holders
+			if cleanup.DeleteContentFiles(contentStore, blobRepo, config.Content, logger) {
+				metricsService.ReleaseStorage(ctx, config.Content)
+			}
+		}
 	}
+	// Gang holds no ingress once its content is erased
+	metricsService.ReleaseIngress(ctx, config.Identity)
 
 	// Erase gang content data
 	gangRepo.UpdateGangContentData(ctx, logger, config.Identity, "", "", "", false, false)
+	gangRepo.AddGangActivity(ctx, logger, config.Identity, entity.GangActivity{
+		Actor:   ActivitySystemActor,
+		Action:  ActivityStreamEnd,
+		Created: time.Now().Unix(),
+	})
 	// Notify the members that stream has stopped
 	members, _ := gangRepo.GetGangMembers(ctx, logger, config.Identity)
 	for _, member := range members {
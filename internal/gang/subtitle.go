@@ -0,0 +1,218 @@
+// Subtitles of gang content, either uploaded by the gang admin as SRT / WebVTT or extracted from the uploaded content.
+// Every track is normalised to WebVTT and kept until the gang content changes.
+
+package gang
+
+import (
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/internal/sse"
+	"Popcorn/pkg/log"
+	"Popcorn/pkg/mediaprobe"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/subtitle"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// Sources of gang subtitles.
+const (
+	SubtitleSourceUpload  = "upload"
+	SubtitleSourceContent = "content"
+)
+
+const (
+	// Max size of an uploaded subtitle file and of a normalised track.
+	maxSubtitleSize = 2 << 20
+	// Max number of subtitles kept per gang content.
+	maxGangSubtitles = 10
+)
+
+func (s service) addsubtitle(ctx context.Context, admin string, upload entity.GangSubtitleUpload, data []byte) (entity.GangSubtitle, error) {
+	valerr := validateGangData(ctx, upload)
+	if valerr != nil {
+		// Error occured during validation
+		return entity.GangSubtitle{}, valerr
+	}
+	gang, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+admin, admin, false)
+	if dberr != nil {
+		// Error occured in GetGang()
+		return entity.GangSubtitle{}, dberr
+	} else if gang.Admin == "" {
+		// Members of the gang cannot add subtitles
+		return entity.GangSubtitle{}, errors.Forbidden("Only gang admin can add subtitles")
+	} else if gang.ContentID == "" && gang.ContentURL == "" {
+		// Subtitles are tied to the gang content
+		valerr := errors.New("subtitle:Gang needs a content file or URL first")
+		return entity.GangSubtitle{}, errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	subtitles, dberr := s.gangRepo.GetGangSubtitles(ctx, s.logger, admin)
+	if dberr != nil {
+		// Error occured in GetGangSubtitles()
+		return entity.GangSubtitle{}, dberr
+	} else if len(subtitles) >= maxGangSubtitles {
+		valerr := fmt.Errorf("subtitle:Gang content cannot have more than %d subtitles", maxGangSubtitles)
+		return entity.GangSubtitle{}, errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	cues, parseerr := subtitle.Parse(data)
+	if parseerr != nil {
+		// Neither SRT nor WebVTT
+		valerr := errors.New("subtitle:" + parseerr.Error())
+		return entity.GangSubtitle{}, errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	track := subtitle.WebVTT(cues)
+	if len(track) > maxSubtitleSize {
+		valerr := errors.New("subtitle:Subtitle file is too large")
+		return entity.GangSubtitle{}, errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	sub := entity.GangSubtitle{
+		ID:       xid.New().String(),
+		Label:    upload.Label,
+		Language: upload.Language,
+		Source:   SubtitleSourceUpload,
+		Created:  time.Now().Unix(),
+	}
+	dberr = s.gangRepo.SetGangSubtitle(ctx, s.logger, admin, sub, track)
+	if dberr != nil {
+		// Error in SetGangSubtitle()
+		return entity.GangSubtitle{}, dberr
+	}
+	broadcastSubtitles(ctx, s.logger, s.gangRepo, s.sseService, admin)
+	return sub, nil
+}
+
+func (s service) getsubtitles(ctx context.Context, username string) ([]entity.GangSubtitle, error) {
+	gang, err := s.getusergang(ctx, username)
+	if err != nil {
+		// Error in getusergang()
+		return []entity.GangSubtitle{}, err
+	}
+	return s.gangRepo.GetGangSubtitles(ctx, s.logger, gang.Admin)
+}
+
+func (s service) getsubtitletrack(ctx context.Context, username, id string) ([]byte, error) {
+	gang, err := s.getusergang(ctx, username)
+	if err != nil {
+		// Error in getusergang()
+		return nil, err
+	}
+	return s.gangRepo.GetGangSubtitleTrack(ctx, s.logger, gang.Admin, id)
+}
+
+func (s service) delsubtitle(ctx context.Context, admin string, del entity.GangSubtitleDelete) error {
+	valerr := validateGangData(ctx, del)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	gang, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+admin, admin, false)
+	if dberr != nil {
+		// Error occured in GetGang()
+		return dberr
+	} else if gang.Admin == "" {
+		// Members of the gang cannot delete subtitles
+		return errors.Forbidden("Only gang admin can delete subtitles")
+	}
+	dberr = s.gangRepo.DelGangSubtitle(ctx, s.logger, admin, del.ID)
+	if dberr != nil {
+		// Error in DelGangSubtitle()
+		return dberr
+	}
+	broadcastSubtitles(ctx, s.logger, s.gangRepo, s.sseService, admin)
+	return nil
+}
+
+// Extracts text subtitle tracks muxed into the uploaded content and saves them as gang subtitles.
+// Runs after the upload is accepted, tracks are dropped if the gang content changes in the meantime.
+func ExtractContentSubtitles(
+	ctx context.Context,
+	logger log.Logger,
+	gangRepo Repository,
+	sseService sse.Service,
+	contentStore objectstore.Store,
+	admin, contentID string,
+	size int64) {
+	file, strerr := contentStore.Open(ctx, contentID)
+	if strerr != nil {
+		logger.WithCtx(ctx).Error().Err(strerr).Msg("Cannot open content for subtitle extraction - " + contentID)
+		return
+	}
+	tracks, prberr := mediaprobe.ExtractSubtitles(file, size)
+	file.Close()
+	if prberr != nil {
+		logger.WithCtx(ctx).Warn().Err(prberr).Msg("Cannot extract subtitles from content - " + contentID)
+		return
+	} else if len(tracks) == 0 {
+		return
+	}
+	gang, dberr := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false)
+	if dberr != nil || gang.ContentID != contentID {
+		// Gang content changed during extraction
+		return
+	}
+	saved := 0
+	for i, track := range tracks {
+		vtt := subtitle.WebVTT(track.Cues)
+		if len(track.Cues) == 0 || len(vtt) > maxSubtitleSize {
+			logger.WithCtx(ctx).Info().Msgf("Skipped subtitle track %d of content %s", i, contentID)
+			continue
+		} else if saved == maxGangSubtitles {
+			break
+		}
+		label := track.Track.Name
+		if label == "" {
+			label = track.Track.Language
+		}
+		if label == "" {
+			label = fmt.Sprintf("Track %d", i+1)
+		}
+		sub := entity.GangSubtitle{
+			ID:       xid.New().String(),
+			Label:    label,
+			Language: track.Track.Language,
+			Source:   SubtitleSourceContent,
+			Created:  time.Now().Unix(),
+		}
+		if dberr := gangRepo.SetGangSubtitle(ctx, logger, admin, sub, vtt); dberr != nil {
+			// Error in SetGangSubtitle(), already logged
+			return
+		}
+		saved++
+	}
+	if saved != 0 {
+		broadcastSubtitles(ctx, logger, gangRepo, sseService, admin)
+	}
+}
+
+// Helper to send the current gang subtitles to every gang member as gangSubtitles event.
+func broadcastSubtitles(ctx context.Context, logger log.Logger, gangRepo Repository, sseService sse.Service, admin string) {
+	subtitles, dberr := gangRepo.GetGangSubtitles(ctx, logger, admin)
+	if dberr != nil {
+		// Error in GetGangSubtitles(), already logged
+		return
+	}
+	gang, dberr := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false)
+	if dberr != nil {
+		// Error in GetGang(), already logged
+		return
+	}
+	members, dberr := gangRepo.GetGangMembers(ctx, logger, admin)
+	if dberr != nil {
+		// Error in GetGangMembers(), already logged
+		return
+	}
+	payload := entity.GangSubtitles{Subtitles: subtitles, StreamStarted: gang.StreamStarted}
+	for _, member := range members {
+		go func(member string) {
+			data := entity.SSEData{
+				Data: payload,
+				Type: "gangSubtitles",
+				To:   member,
+			}
+			sseService.GetOrSetEvent(ctx).Message <- data
+		}(member)
+	}
+}
@@ -6,12 +6,20 @@ import (
 	"Popcorn/internal/entity"
 	"Popcorn/internal/errors"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/manifest"
+	"Popcorn/pkg/urlprobe"
 	"context"
+	"encoding/json"
 	"regexp"
 
 	"github.com/asaskevich/govalidator"
 )
 
+// Set of emojis which can be sent as a reaction during gang streams.
+var allowedReactions = map[string]struct{}{
+	"🍿": {}, "😂": {}, "😮": {}, "😢": {}, "😍": {}, "😱": {}, "👏": {}, "🔥": {}, "👍": {}, "👎": {},
+}
+
 func RegisterCustomValidationTags(ctx context.Context, logger log.Logger) {
 	// Gang name validation.
 	// Gang name can only contain letters, numbers, underscore, periods and spaces.
@@ -19,6 +27,12 @@ func RegisterCustomValidationTags(ctx context.Context, logger log.Logger) {
 		pattern := regexp.MustCompile("[^a-zA-Z0-9_. ]")
 		return !pattern.MatchString(str) && !govalidator.HasWhitespaceOnly(str)
 	})
+	// Gang reaction validation.
+	// Only the emojis listed in allowedReactions can be sent as a reaction.
+	govalidator.TagMap["reaction_custom"] = govalidator.Validator(func(str string) bool {
+		_, ok := allowedReactions[str]
+		return ok
+	})
 
 	logger.WithCtx(ctx).Info().Msg("Successfully registered gang related custom validations.")
 }
@@ -32,6 +46,55 @@ func canUpdateGangContentRelatedData(existingGangData entity.GangResponse, gang
 		(existingGangData.ContentScreenShare && gang.ContentURL != ""))
 }
 
+// Returns a validation error unless quality profile is allowed by server.
+func validateGangQuality(config entity.LivekitConfig, quality string) error {
+	if !allowedQuality(config, quality) {
+		valerr := errors.New("gang_quality:Quality profile isn't allowed")
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	return nil
+}
+
+// Returns a validation error if content URL of gang can't be pulled as media content.
+// Manifests get loaded to record the picked rendition along with the manifest on gang.
+func validateGangContentURL(ctx context.Context, prober urlprobe.Prober, gang *entity.Gang) error {
+	if gang.ContentURL == "" {
+		if gang.ContentRendition != "" {
+			valerr := errors.New("gang_content_rendition:Only manifest URLs have renditions")
+			return errors.GenerateValidationErrorResponse([]error{valerr})
+		}
+		return nil
+	}
+	result, prberr := prober.Probe(ctx, gang.ContentURL)
+	if prberr != nil {
+		valerr := errors.New("gang_content_url:" + prberr.Error())
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	format := manifest.Detect(result.URL, result.ContentType)
+	if format == "" {
+		if gang.ContentRendition != "" {
+			valerr := errors.New("gang_content_rendition:Only manifest URLs have renditions")
+			return errors.GenerateValidationErrorResponse([]error{valerr})
+		}
+		return nil
+	}
+	// Relative URIs in the manifest are resolved against the URL reached after redirects
+	contentManifest, mnferr := manifest.Load(ctx, prober, result.URL, format)
+	if mnferr != nil {
+		valerr := errors.New("gang_content_url:" + mnferr.Error())
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	rendition, mnferr := contentManifest.Rendition(gang.ContentRendition)
+	if mnferr != nil {
+		valerr := errors.New("gang_content_rendition:" + mnferr.Error())
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	data, _ := json.Marshal(contentManifest)
+	gang.ContentRendition = rendition.ID
+	gang.ContentManifest = entity.ContentMeta(data)
+	return nil
+}
+
 func validateGangData(_ context.Context, gang interface{}) error {
 	_, valerr := govalidator.ValidateStruct(gang)
 	if valerr != nil {
@@ -0,0 +1,154 @@
+// Exposes all of the REST APIs related to the content library in Popcorn.
+
+package library
+
+import (
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/pkg/log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Registers all of the REST API handlers related to internal package library onto the gin server.
+func APIHandlers(router *gin.Engine, libraryService Service, authWithAcc gin.HandlerFunc, logger log.Logger) {
+	libraryGroup := router.Group("/api/library", authWithAcc)
+	{
+		libraryGroup.GET("/get", getItems(libraryService, logger))
+		libraryGroup.POST("/rename", renameItem(libraryService, logger))
+		libraryGroup.POST("/delete", deleteItem(libraryService, logger))
+		libraryGroup.POST("/attach", attachItem(libraryService, logger))
+	}
+}
+
+// getItems returns a handler which takes care of listing the content library of user.
+func getItems(libraryService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the library owner
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in getItems")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		items, err := libraryService.getitems(gctx, user.Username)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{
+			"result":         items,
+			"max_items":      MaxItems(),
+			"retention_days": int(Retention() / (24 * time.Hour)),
+		})
+	}
+}
+
+// renameItem returns a handler which takes care of renaming a library item.
+func renameItem(libraryService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the library owner
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in renameItem")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var rename entity.LibraryRename
+		if binderr := gctx.ShouldBindJSON(&rename); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		item, err := libraryService.renameitem(gctx, user.Username, rename)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, item)
+	}
+}
+
+// deleteItem returns a handler which takes care of deleting a library item along with its content files.
+func deleteItem(libraryService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the library owner
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in deleteItem")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var req entity.LibraryItemRequest
+		if binderr := gctx.ShouldBindJSON(&req); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := libraryService.deleteitem(gctx, user.Username, req)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
+
+// attachItem returns a handler which takes care of attaching a library item to the gang as its content.
+func attachItem(libraryService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the library owner and gang admin
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in attachItem")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var req entity.LibraryItemRequest
+		if binderr := gctx.ShouldBindJSON(&req); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := libraryService.attachitem(gctx, user.Username, req)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,299 @@
+// Content library API tests in Popcorn.
+
+package library
+
+import (
+	"Popcorn/internal/blob"
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/internal/gang"
+	"Popcorn/internal/metrics"
+	"Popcorn/internal/sse"
+	"Popcorn/internal/test"
+	"Popcorn/internal/user"
+	"Popcorn/pkg/db"
+	"Popcorn/pkg/log"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/signedurl"
+	"Popcorn/pkg/validations"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+// Global instance of log.Logger to be used during library API testing.
+var logger log.Logger
+
+// Global instance of gin MockRouter to be used during library API testing.
+var mockRouter *gin.Engine
+
+// Global instance of Db instance to be used during library API testing.
+var client *db.RedisDB
+
+// Global instance of user Repository to be used during library API testing.
+var userRepo user.Repository
+
+// Global instance of gang Repository to be used during library API testing.
+var gangRepo gang.Repository
+
+// Global instance of library Repository to be used during library API testing.
+var libraryRepo Repository
+
+// Global instance of metrics Service to be used during library API testing.
+var metricsService metrics.Service
+
+// Global instance of content store to be used during library API testing.
+var contentStore objectstore.Store
+
+// Global context
+var ctx context.Context = context.Background()
+
+// Directory backing the content store used during library API testing.
+var uploadDir string
+
+// Helper to build up a mock router instance for testing Popcorn.
+func setupMockRouter(dbConnWrp *db.RedisDB, logger log.Logger) {
+	// Initializing mock router
+	mockRouter = test.MockRouter()
+
+	// Initializing livekit mock config
+	livekitMockConfig := entity.LivekitConfig{
+		Host:                      "ws://localhost:8000",
+		ApiKey:                    "LivekitAPI",
+		ApiSecret:                 "LivekitAPISecret",
+		MaxConcurrentIngressLimit: 10,
+	}
+
+	// Repositories needed by library APIs and services to work
+	userRepo = user.NewRepository(dbConnWrp)
+	gangRepo = gang.NewRepository(dbConnWrp)
+	libraryRepo = NewRepository(dbConnWrp)
+
+	// Register internal package library handler
+	metricsService = metrics.NewService(livekitMockConfig, metrics.NewRepository(dbConnWrp), logger)
+	var oserr error
+	uploadDir, oserr = os.MkdirTemp("", "popcorn-library-test")
+	if oserr != nil {
+		// Issues in MkdirTemp()
+		logger.Fatal().Err(oserr).Msg("Couldn't create upload directory, Aborting test run.")
+	}
+	var strerr error
+	contentStore, strerr = objectstore.NewLocalStore(uploadDir, "", signedurl.NewSigner([]byte("popcorn"), time.Hour))
+	if strerr != nil {
+		// Issues in NewLocalStore()
+		logger.Fatal().Err(strerr).Msg("Couldn't create content store, Aborting test run.")
+	}
+	libraryService := NewService(livekitMockConfig, libraryRepo, gangRepo, blob.NewRepository(dbConnWrp), metricsService, sse.NewService(logger), contentStore, logger)
+	APIHandlers(mockRouter, libraryService, test.MockAuthMiddleware(logger), logger)
+}
+
+// Helper to register test user required in the tests below
+func registerTestUser(username, fullname string) (entity.User, http.Cookie) {
+	// Use user.SetOrUpdate repository method to set user data
+	testUser := entity.User{
+		Username: username,
+		FullName: fullname,
+		Password: "popcorn123",
+	}
+	testUser.SelectProfilePic()
+	_, dberr := userRepo.SetOrUpdateUser(ctx, logger, testUser, true)
+	if dberr != nil {
+		// Issues in SetOrUpdateUser()
+		logger.Fatal().Err(dberr).Msg("Couldn't create testUser, Aborting test run.")
+	}
+	// User Cookie to be passed during tests
+	testUserCookie := http.Cookie{
+		Name:     "user",
+		Value:    username,
+		HttpOnly: true,
+	}
+
+	return testUser, testUserCookie
+}
+
+// Helper to execute a library API request as the given user
+func executeLibraryAPI(t *testing.T, method, path string, payload interface{}, cookie http.Cookie, want int) test.APIResponse {
+	body := []byte{}
+	if payload != nil {
+		var mrserr error
+		body, mrserr = json.Marshal(payload)
+		if mrserr != nil {
+			logger.Error().Err(mrserr).Msg("Couldn't marshall payload into json in executeLibraryAPI()")
+			t.Fatal()
+		}
+	}
+	request := test.RequestAPITest{
+		Method:       method,
+		Path:         path,
+		Body:         bytes.NewReader(body),
+		WantResponse: []int{want},
+		Header:       test.MockHeader(),
+		Parameters:   url.Values{},
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, &cookie},
+	}
+	return test.ExecuteAPITest(logger, t, mockRouter, &request)
+}
+
+// Sets up resources before testing Library APIs in Popcorn.
+func setup() {
+	// Initializing Resources before test run
+
+	// Load test.env
+	enverr := godotenv.Load("../../config/test.env")
+	if enverr != nil {
+		// Error during loading test.env, abort test run immediately
+		os.Exit(4)
+	}
+	version := os.Getenv("VERSION")
+
+	// Logger
+	logger = log.New(version)
+
+	// Db client instance
+	var dberr error
+	client, dberr = db.NewDbConnection(ctx, logger)
+	// Sending a PING request to DB for connection status check
+	if dberr != nil || client.CheckDbConnection(ctx, logger) != nil {
+		// connection failure
+		os.Exit(6)
+	}
+	// Initializing validator
+	govalidator.SetFieldsRequiredByDefault(true)
+	// Adding custom validation tags into ext-package govalidator
+	validations.RegisterCustomValidationTags(ctx, logger)
+	user.RegisterCustomValidationTags(ctx, logger)
+	gang.RegisterCustomValidationTags(ctx, logger)
+
+	// Initializing router
+	setupMockRouter(client, logger)
+
+	logger.Info().Msg("Test resources setup successful.")
+}
+
+// Cleans up the resources built during execution of setup()
+func teardown() {
+	logger.Info().Msg("Cleaning up resources ...")
+	if client.CheckDbConnection(ctx, logger) == nil {
+		// client still open
+		client.CleanTestDbData(ctx, logger)
+		client.CloseDbConnection(ctx)
+	}
+	os.RemoveAll(uploadDir)
+	logger.Info().Msg("Cleanup complete :)")
+}
+
+func TestMain(m *testing.M) {
+	// Setting up Resources
+	setup()
+	// Running the tests
+	testExitCode := m.Run()
+	// Cleanup Resources
+	teardown()
+	// Exit
+	os.Exit(testExitCode)
+}
+
+func TestLibrary(t *testing.T) {
+	_, ownerCookie := registerTestUser("Library_Owner123", "Library Owner")
+	_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &entity.Gang{
+		Admin:          "Library_Owner123",
+		Name:           "Library Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Library_Owner123",
+	}, false)
+	if dberr != nil {
+		// Issues in SetOrUpdateGang()
+		t.Fatal()
+	}
+	defer gangRepo.DelGang(ctx, logger, "Library_Owner123")
+
+	// Finished upload kept in the library, accounted as stored
+	contentID := "library-content"
+	if oserr := os.WriteFile(filepath.Join(uploadDir, contentID), []byte("popcorn"), 0644); oserr != nil {
+		t.Fatal(oserr)
+	}
+	if oserr := os.WriteFile(filepath.Join(uploadDir, contentID+".info"), []byte(`{"ID":"library-content","Size":7,"Offset":7}`), 0644); oserr != nil {
+		t.Fatal(oserr)
+	}
+	assert.NoError(t, metricsService.ReserveStorage(ctx, "Library_Owner123", 7))
+	assert.NoError(t, metricsService.TrackUpload(ctx, "Library_Owner123", contentID, 7))
+	assert.NoError(t, metricsService.CommitStorage(ctx, "Library_Owner123", contentID, 7))
+	created := time.Now().Add(-time.Hour).Unix()
+	assert.NoError(t, libraryRepo.SetLibraryItem(ctx, logger, entity.LibraryItem{
+		ID: contentID, Owner: "Library_Owner123", Name: "movie.mp4", Size: 7,
+		Meta: `{"container":"mp4"}`, Created: created, LastUsed: created,
+	}))
+	assert.NoError(t, libraryRepo.SetLibraryItem(ctx, logger, entity.LibraryItem{
+		ID: "another-content", Owner: "Library_Other123", Name: "another.mp4", Created: created, LastUsed: created,
+	}))
+
+	// Owner only sees their own items
+	response := executeLibraryAPI(t, http.MethodGet, "/api/library/get", nil, ownerCookie, http.StatusOK)
+	list := struct {
+		Result   []entity.LibraryItem `json:"result"`
+		MaxItems int                  `json:"max_items"`
+	}{}
+	if jsonerr := json.Unmarshal(response.Body, &list); jsonerr != nil {
+		t.Fatal()
+	}
+	if assert.Len(t, list.Result, 1) {
+		assert.Equal(t, "movie.mp4", list.Result[0].Name)
+		assert.Equal(t, entity.ContentMeta(`{"container":"mp4"}`), list.Result[0].Meta)
+		assert.Equal(t, time.Unix(created, 0).Add(Retention()).Unix(), list.Result[0].Expires)
+	}
+	assert.Equal(t, MaxItems(), list.MaxItems)
+
+	// Rename
+	executeLibraryAPI(t, http.MethodPost, "/api/library/rename", entity.LibraryRename{ID: contentID, Name: ""}, ownerCookie, http.StatusBadRequest)
+	executeLibraryAPI(t, http.MethodPost, "/api/library/rename", entity.LibraryRename{ID: "another-content", Name: "Mine"}, ownerCookie, http.StatusNotFound)
+	executeLibraryAPI(t, http.MethodPost, "/api/library/rename", entity.LibraryRename{ID: contentID, Name: "Movie Night"}, ownerCookie, http.StatusOK)
+
+	// Attaching makes the item the gang content without uploading it again
+	executeLibraryAPI(t, http.MethodPost, "/api/library/attach", entity.LibraryItemRequest{ID: contentID}, ownerCookie, http.StatusOK)
+	gangData, dberr := gangRepo.GetGang(ctx, logger, "gang:Library_Owner123", "Library_Owner123", false)
+	assert.NoError(t, dberr)
+	assert.Equal(t, contentID, gangData.ContentID)
+	assert.Equal(t, "Movie Night", gangData.ContentName)
+	assert.Equal(t, entity.ContentMeta(`{"container":"mp4"}`), gangData.ContentMeta)
+	assert.True(t, gangData.ContentLibrary)
+	item, dberr := libraryRepo.GetLibraryItem(ctx, logger, "Library_Owner123", contentID)
+	assert.NoError(t, dberr)
+	assert.Greater(t, item.LastUsed, created)
+	executeLibraryAPI(t, http.MethodPost, "/api/library/attach", entity.LibraryItemRequest{ID: contentID}, ownerCookie, http.StatusBadRequest)
+
+	// Attached items can't be deleted
+	executeLibraryAPI(t, http.MethodPost, "/api/library/delete", entity.LibraryItemRequest{ID: contentID}, ownerCookie, http.StatusBadRequest)
+
+	// Library is full
+	defer func(items string) { LIBRARY_MAX_ITEMS = items }(LIBRARY_MAX_ITEMS)
+	LIBRARY_MAX_ITEMS = "1"
+	err := CheckCapacity(ctx, logger, libraryRepo, "Library_Owner123")
+	if assert.IsType(t, errors.ErrorResponse{}, err) {
+		assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).Status)
+	}
+	assert.NoError(t, CheckCapacity(ctx, logger, libraryRepo, "Library_Empty123"))
+
+	// Content is kept once the gang is done with it, deleting the item frees up the storage
+	assert.NoError(t, gangRepo.UpdateGangContentData(ctx, logger, "Library_Owner123", "", "", "", false, false))
+	executeLibraryAPI(t, http.MethodPost, "/api/library/delete", entity.LibraryItemRequest{ID: contentID}, ownerCookie, http.StatusOK)
+	executeLibraryAPI(t, http.MethodPost, "/api/library/delete", entity.LibraryItemRequest{ID: contentID}, ownerCookie, http.StatusNotFound)
+	assert.Eventually(t, func() bool {
+		_, oserr := os.Stat(filepath.Join(uploadDir, contentID))
+		return os.IsNotExist(oserr)
+	}, time.Second, 10*time.Millisecond)
+	usage, dberr := metricsService.GetStorageUsage(ctx, "Library_Owner123")
+	assert.NoError(t, dberr)
+	assert.Zero(t, usage.Stored)
+}
@@ -0,0 +1,160 @@
+// Library repository encapsulates the data access logic (interactions with the DB) related to the content library in Popcorn.
+
+package library
+
+import (
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/pkg/db"
+	"Popcorn/pkg/log"
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DB hash holding every library item keyed by its owner and upload ID.
+const libraryItemsKey = "library:items"
+
+// Helper to get field of library:items holding item of owner, deduplicated uploads can be kept by several owners.
+func libraryItemField(owner, id string) string {
+	return owner + "/" + id
+}
+
+type Repository interface {
+	// SetLibraryItem saves a new library item or updates an existing one.
+	SetLibraryItem(ctx context.Context, logger log.Logger, item entity.LibraryItem) error
+	// GetLibraryItem returns a library item owned by username.
+	GetLibraryItem(ctx context.Context, logger log.Logger, username string, id string) (entity.LibraryItem, error)
+	// GetLibraryItems returns library items owned by username, latest first.
+	GetLibraryItems(ctx context.Context, logger log.Logger, username string) ([]entity.LibraryItem, error)
+	// ListLibraryItems returns every library item in Popcorn grouped by its upload ID.
+	ListLibraryItems(ctx context.Context, logger log.Logger) (map[string][]entity.LibraryItem, error)
+	// DelLibraryItem deletes a library item, content files are left to the caller.
+	DelLibraryItem(ctx context.Context, logger log.Logger, item entity.LibraryItem) error
+}
+
+// repository struct of library Repository.
+// Object of this will be passed around from main to internal.
+// Helps to access the repository layer interface and call methods.
+type repository struct {
+	db *db.RedisDB
+}
+
+// Returns a new instance of library repository for other packages to access its interface.
+func NewRepository(dbwrp *db.RedisDB) Repository {
+	return repository{db: dbwrp}
+}
+
+// Returns nil if library item got successfully saved in library:items and library:<owner>.
+func (r repository) SetLibraryItem(ctx context.Context, logger log.Logger, item entity.LibraryItem) error {
+	itemData, jsonerr := json.Marshal(item)
+	if jsonerr != nil {
+		logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during marshalling library item in library.SetLibraryItem")
+		return errors.InternalServerError("")
+	}
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, libraryItemsKey, libraryItemField(item.Owner, item.ID), itemData)
+		pipe.SAdd(ctx, "library:"+item.Owner, item.ID)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HSet() in library.SetLibraryItem")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Returns library item of username if present in library:items.
+func (r repository) GetLibraryItem(ctx context.Context, logger log.Logger, username string, id string) (entity.LibraryItem, error) {
+	var item entity.LibraryItem
+	itemData, dberr := r.db.Client().HGet(ctx, libraryItemsKey, libraryItemField(username, id)).Result()
+	if dberr == redis.Nil {
+		// Item deleted, never existed or owned by another user
+		return item, errors.NotFound("Library item not found")
+	} else if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGet() in library.GetLibraryItem")
+		return item, errors.InternalServerError("")
+	}
+	if jsonerr := json.Unmarshal([]byte(itemData), &item); jsonerr != nil {
+		logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during unmarshalling library item in library.GetLibraryItem")
+		return item, errors.InternalServerError("")
+	}
+	return item, nil
+}
+
+// Returns library items listed in library:<username>, latest first.
+func (r repository) GetLibraryItems(ctx context.Context, logger log.Logger, username string) ([]entity.LibraryItem, error) {
+	items := []entity.LibraryItem{}
+	ids, dberr := r.db.Client().SMembers(ctx, "library:"+username).Result()
+	if dberr != nil && dberr != redis.Nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.SMembers() in library.GetLibraryItems")
+		return items, errors.InternalServerError("")
+	} else if len(ids) == 0 {
+		return items, nil
+	}
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = libraryItemField(username, id)
+	}
+	itemData, dberr := r.db.Client().HMGet(ctx, libraryItemsKey, fields...).Result()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HMGet() in library.GetLibraryItems")
+		return items, errors.InternalServerError("")
+	}
+	for _, data := range itemData {
+		data, ok := data.(string)
+		if !ok {
+			// Item deleted in the meantime
+			continue
+		}
+		var item entity.LibraryItem
+		if jsonerr := json.Unmarshal([]byte(data), &item); jsonerr != nil {
+			logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during unmarshalling library item in library.GetLibraryItems")
+			return items, errors.InternalServerError("")
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Created > items[j].Created })
+	return items, nil
+}
+
+// Returns every library item saved in library:items.
+func (r repository) ListLibraryItems(ctx context.Context, logger log.Logger) (map[string][]entity.LibraryItem, error) {
+	items := map[string][]entity.LibraryItem{}
+	itemData, dberr := r.db.Client().HGetAll(ctx, libraryItemsKey).Result()
+	if dberr != nil && dberr != redis.Nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGetAll() in library.ListLibraryItems")
+		return items, errors.InternalServerError("")
+	}
+	for _, data := range itemData {
+		var item entity.LibraryItem
+		if jsonerr := json.Unmarshal([]byte(data), &item); jsonerr != nil {
+			logger.WithCtx(ctx).Error().Err(jsonerr).Msg("Error occured during unmarshalling library item in library.ListLibraryItems")
+			return items, errors.InternalServerError("")
+		}
+		items[item.ID] = append(items[item.ID], item)
+	}
+	return items, nil
+}
+
+// Returns nil if library item got successfully deleted from library:items and library:<owner>.
+func (r repository) DelLibraryItem(ctx context.Context, logger log.Logger, item entity.LibraryItem) error {
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, libraryItemsKey, libraryItemField(item.Owner, item.ID))
+		pipe.SRem(ctx, "library:"+item.Owner, item.ID)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HDel() in library.DelLibraryItem")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
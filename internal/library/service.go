@@ -0,0 +1,273 @@
+// Service layer of the internal package library.
+// Uploads kept in the library outlive gang streams, so they can be attached to a gang again without re-uploading.
+
+package library
+
+import (
+	"Popcorn/internal/blob"
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/internal/gang"
+	"Popcorn/internal/metrics"
+	"Popcorn/internal/sse"
+	"Popcorn/pkg/cleanup"
+	"Popcorn/pkg/log"
+	"Popcorn/pkg/mediaprobe"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/tracing"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+)
+
+var (
+	// Max number of items kept in the library of an user
+	LIBRARY_MAX_ITEMS string = os.Getenv("LIBRARY_MAX_ITEMS")
+	// Days for which library items are kept since they were last used
+	LIBRARY_RETENTION_DAYS string = os.Getenv("LIBRARY_RETENTION_DAYS")
+)
+
+// Returns the max number of items kept per user, defaults to 10.
+func MaxItems() int {
+	items, converr := strconv.Atoi(LIBRARY_MAX_ITEMS)
+	if converr != nil || items <= 0 {
+		return 10
+	}
+	return items
+}
+
+// Returns the duration for which library items are kept since they were last used, defaults to 30 days.
+func Retention() time.Duration {
+	days, converr := strconv.Atoi(LIBRARY_RETENTION_DAYS)
+	if converr != nil || days <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Service layer of internal package library which encapsulates content library logic of Popcorn.
+type Service interface {
+	// Get library items of user
+	getitems(ctx context.Context, username string) ([]entity.LibraryItem, error)
+	// Rename a library item
+	renameitem(ctx context.Context, username string, rename entity.LibraryRename) (entity.LibraryItem, error)
+	// Delete a library item along with its content files
+	deleteitem(ctx context.Context, username string, req entity.LibraryItemRequest) error
+	// Attach a library item to the gang created by user as its content
+	attachitem(ctx context.Context, username string, req entity.LibraryItemRequest) error
+}
+
+// Object of this will be passed around from main to routers to API.
+// Helps to access the service layer interface and call methods.
+// Also helps to pass objects to be used from outer layer.
+type service struct {
+	livekit_config entity.LivekitConfig
+	libraryRepo    Repository
+	gangRepo       gang.Repository
+	blobRepo       blob.Repository
+	metricsService metrics.Service
+	sseService     sse.Service
+	contentStore   objectstore.Store
+	logger         log.Logger
+}
+
+// Helps to access the service layer interface and call methods. Service object is passed from main.
+func NewService(
+	livekit_conf entity.LivekitConfig,
+	libraryRepo Repository,
+	gangRepo gang.Repository,
+	blobRepo blob.Repository,
+	metricsService metrics.Service,
+	sseService sse.Service,
+	contentStore objectstore.Store,
+	logger log.Logger) Service {
+	return service{livekit_conf, libraryRepo, gangRepo, blobRepo, metricsService, sseService, contentStore, logger}
+}
+
+func (s service) getitems(ctx context.Context, username string) ([]entity.LibraryItem, error) {
+	items, dberr := s.libraryRepo.GetLibraryItems(ctx, s.logger, username)
+	if dberr != nil {
+		// Error in GetLibraryItems()
+		return items, dberr
+	}
+	for i := range items {
+		items[i].Expires = time.Unix(items[i].LastUsed, 0).Add(Retention()).Unix()
+	}
+	return items, nil
+}
+
+func (s service) renameitem(ctx context.Context, username string, rename entity.LibraryRename) (entity.LibraryItem, error) {
+	valerr := s.validateLibraryData(ctx, rename)
+	if valerr != nil {
+		// Error occured during validation
+		return entity.LibraryItem{}, valerr
+	}
+	item, dberr := s.libraryRepo.GetLibraryItem(ctx, s.logger, username, rename.ID)
+	if dberr != nil {
+		// Error in GetLibraryItem()
+		return item, dberr
+	}
+	item.Name = rename.Name
+	dberr = s.libraryRepo.SetLibraryItem(ctx, s.logger, item)
+	if dberr != nil {
+		// Error in SetLibraryItem()
+		return entity.LibraryItem{}, dberr
+	}
+	item.Expires = time.Unix(item.LastUsed, 0).Add(Retention()).Unix()
+	return item, nil
+}
+
+func (s service) deleteitem(ctx context.Context, username string, req entity.LibraryItemRequest) error {
+	valerr := s.validateLibraryData(ctx, req)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	item, dberr := s.libraryRepo.GetLibraryItem(ctx, s.logger, username, req.ID)
+	if dberr != nil {
+		// Error in GetLibraryItem()
+		return dberr
+	}
+	gangData, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+username, username, false)
+	if dberr != nil {
+		// Error in GetGang()
+		return dberr
+	} else if gangData.ContentID == item.ID {
+		// Gang would be left with a content which doesn't exist
+		valerr := errors.New("item_id:Library item is attached to your gang")
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	dberr = s.libraryRepo.DelLibraryItem(ctx, s.logger, item)
+	if dberr != nil {
+		// Error in DelLibraryItem()
+		return dberr
+	}
+	// Deduplicated content stays around as long as other gangs or libraries hold it
+	if cleanup.DeleteContentFiles(s.contentStore, s.blobRepo, item.ID, s.logger) {
+		s.metricsService.ReleaseStorage(ctx, item.ID)
+	}
+	return nil
+}
+
+func (s service) attachitem(ctx context.Context, username string, req entity.LibraryItemRequest) error {
+	valerr := s.validateLibraryData(ctx, req)
+	if valerr != nil {
+		// Error occured during validation
+		return valerr
+	}
+	item, dberr := s.libraryRepo.GetLibraryItem(ctx, s.logger, username, req.ID)
+	if dberr != nil {
+		// Error in GetLibraryItem()
+		return dberr
+	}
+	gangData, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+username, username, false)
+	if dberr != nil {
+		// Error in GetGang()
+		return dberr
+	} else if gangData.Admin == "" {
+		// Not an admin
+		return errors.BadRequest("user needs to create a gang")
+	} else if gangData.ContentID != "" || gangData.ContentURL != "" || gangData.ContentScreenShare || gangData.Streaming {
+		// Either file or link or share
+		valerr := errors.New("gang:Can only have file or link or screenshare as a content")
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	// Attached content holds an ingress just like a fresh upload
+	metricsData, dberr := s.metricsService.GetMetrics(ctx)
+	if dberr != nil {
+		// Error in GetMetrics()
+		return dberr
+	} else if metricsData.IngressQuotaExceeded {
+		// Livekit ingress monthly quota exceeded
+		valerr := errors.New("gang:Monthly URL or File streaming quota has been exceeded")
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	acquired, dberr := s.metricsService.AcquireIngress(ctx, username)
+	if dberr != nil {
+		// Error in AcquireIngress()
+		return dberr
+	} else if !acquired {
+		// Livekit concurrent ingress limit exceeded
+		valerr := errors.New("gang:Max concurrent File livestream limit exceeded")
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+
+	dberr = s.gangRepo.UpdateGangContentData(ctx, s.logger, username, item.Name, item.ID, "", false, false)
+	if dberr != nil {
+		// Error in UpdateGangContentData()
+		s.metricsService.ReleaseIngress(ctx, username)
+		return dberr
+	}
+	dberr = s.gangRepo.SetGangContentLibrary(ctx, s.logger, username, true)
+	if dberr != nil {
+		// Error in SetGangContentLibrary()
+		return dberr
+	}
+	if item.Meta != "" {
+		dberr = s.gangRepo.SetGangContentMeta(ctx, s.logger, username, []byte(item.Meta))
+		if dberr != nil {
+			// Error in SetGangContentMeta()
+			return dberr
+		}
+	}
+	// Retention starts over as the item is in use again
+	item.LastUsed = time.Now().Unix()
+	dberr = s.libraryRepo.SetLibraryItem(ctx, s.logger, item)
+	if dberr != nil {
+		// Error in SetLibraryItem()
+		return dberr
+	}
+	s.gangRepo.AddGangActivity(ctx, s.logger, username, entity.GangActivity{
+		Actor:   username,
+		Action:  gang.ActivityLibraryAttach,
+		Target:  item.Name,
+		Created: time.Now().Unix(),
+	})
+	var mediaInfo mediaprobe.Info
+	if jsonerr := json.Unmarshal([]byte(item.Meta), &mediaInfo); jsonerr == nil &&
+		len(mediaInfo.Subtitles) != 0 && mediaInfo.Container != mediaprobe.ContainerMP4 {
+		// Subtitles got erased along with the previous content
+		go gang.ExtractContentSubtitles(tracing.Detach(ctx), s.logger, s.gangRepo, s.sseService, s.contentStore, username, item.ID, item.Size)
+	}
+	// Send notifications to gang Members about the updates
+	members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, username)
+	for _, member := range members {
+		go func(member string) {
+			data := entity.SSEData{
+				Data: nil,
+				Type: "gangUpdate",
+				To:   member,
+			}
+			s.sseService.GetOrSetEvent(ctx).Message <- data
+		}(member)
+	}
+	return nil
+}
+
+// Helper to validate the library request data against validation-tags mentioned in its entity.
+func (s service) validateLibraryData(_ context.Context, data interface{}) error {
+	_, valerr := govalidator.ValidateStruct(data)
+	if valerr != nil {
+		valerr := valerr.(govalidator.Errors).Errors()
+		return errors.GenerateValidationErrorResponse(valerr)
+	}
+	return nil
+}
+
+// Returns a validation error if user can't keep one more item in the library.
+func CheckCapacity(ctx context.Context, logger log.Logger, libraryRepo Repository, username string) error {
+	items, dberr := libraryRepo.GetLibraryItems(ctx, logger, username)
+	if dberr != nil {
+		// Error in GetLibraryItems()
+		return dberr
+	} else if len(items) >= MaxItems() {
+		valerr := fmt.Errorf("library:Library cannot have more than %d items", MaxItems())
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	return nil
+}
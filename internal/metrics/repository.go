@@ -8,17 +8,53 @@ import (
 	"Popcorn/pkg/db"
 	"Popcorn/pkg/log"
 	"context"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
 var metricsDbKey string = "popcorn:metrics"
 
+// Ingress leases across Popcorn, a field per holder with the unix milli time it was acquired at.
+var ingressLeaseDbKey string = "popcorn:ingress-leases"
+
+// Storage usage across Popcorn.
+var storageDbKey string = "popcorn:storage"
+
+// Time monthly streaming usage of users is kept for since it was last updated.
+var streamUsageRetention time.Duration = 62 * 24 * time.Hour
+
 type Repository interface {
 	// Get Popcorn Metrics data
 	GetMetrics(ctx context.Context, logger log.Logger) (entity.Metrics, error)
-	// Set or Update Popcorn Metrics data
-	SetOrUpdateMetrics(ctx context.Context, logger log.Logger, metrics *entity.Metrics) error
+	// Flag monthly ingress quota as exceeded or not
+	SetIngressQuotaExceeded(ctx context.Context, logger log.Logger, exceeded bool) error
+	// Lease an ingress to holder if there are less than limit leases (0 being unlimited), true if holder holds it already
+	AcquireIngress(ctx context.Context, logger log.Logger, holder string, limit int) (bool, error)
+	// Release ingress leased to holder, if any
+	ReleaseIngress(ctx context.Context, logger log.Logger, holder string) error
+	// Lease ingress to holders missing one and release those of others acquired till since (unix milli),
+	// returns counts of leases added and removed
+	ReconcileIngress(ctx context.Context, logger log.Logger, holders []string, since int64) (int, int, error)
+	// Get storage usage of an user, or across Popcorn if username is blank
+	GetStorageUsage(ctx context.Context, logger log.Logger, username string) (entity.StorageUsage, error)
+	// Reserve storage for an upload as in flight if it fits in the quotas (0 being unlimited)
+	ReserveStorage(ctx context.Context, logger log.Logger, username string, size, userQuota, totalQuota int64) error
+	// Attach upload ID to storage reserved by an user
+	TrackUpload(ctx context.Context, logger log.Logger, username, uploadID string, size int64) error
+	// Move storage held by a finished upload from in flight to stored
+	CommitStorage(ctx context.Context, logger log.Logger, username, uploadID string, size int64) error
+	// Release storage held by an upload
+	ReleaseStorage(ctx context.Context, logger log.Logger, uploadID string) error
+	// Drop uploads missing from uploadIDs tracked till since (unix milli) and recompute storage usage from the ones left,
+	// returns counts of uploads dropped and usages fixed
+	ReconcileStorage(ctx context.Context, logger log.Logger, uploadIDs []string, since int64) (int, int, error)
+	// Get streaming usage of an user within month (YYYY-MM)
+	GetStreamUsage(ctx context.Context, logger log.Logger, username, month string) (entity.StreamUsage, error)
+	// Add seconds streamed by an user within month (YYYY-MM), either through ingress or screen share
+	AddStreamUsage(ctx context.Context, logger log.Logger, username, month string, screenShare bool, seconds int64) error
 }
 
 // repository struct of gang Repository.
@@ -34,51 +70,380 @@ func NewRepository(dbwrp *db.RedisDB) Repository {
 }
 
 func (r repository) GetMetrics(ctx context.Context, logger log.Logger) (entity.Metrics, error) {
-	// check if metrics data is available in the db
-	available, dberr := r.db.Client().Exists(ctx, metricsDbKey).Result()
-	if dberr != nil && dberr != redis.Nil {
+	var metrics entity.Metrics
+	if dberr := r.db.Client().HGetAll(ctx, metricsDbKey).Scan(&metrics); dberr != nil {
 		// Error during interacting with DB
-		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.Exists() in metrics.GetMetrics")
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGetAll() in metrics.GetMetrics")
 		return entity.Metrics{}, errors.InternalServerError("")
-	} else if available == 0 {
-		// no metrics data is available
-		return entity.Metrics{}, nil
 	}
-	var metrics entity.Metrics
-	if dberr = r.db.Client().HGetAll(ctx, metricsDbKey).Scan(&metrics); dberr != nil {
+	// Every lease holds an ingress
+	leases, dberr := r.db.Client().HLen(ctx, ingressLeaseDbKey).Result()
+	if dberr != nil {
 		// Error during interacting with DB
-		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGetAll() in metrics.GetMetrics")
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HLen() in metrics.GetMetrics")
 		return entity.Metrics{}, errors.InternalServerError("")
 	}
+	metrics.ActiveIngress = int(leases)
 	return metrics, nil
 }
 
-func (r repository) SetOrUpdateMetrics(ctx context.Context, logger log.Logger, metrics *entity.Metrics) error {
-	txferr := func(key string) error {
-		txf := func(tx *redis.Tx) error {
-			// Operation is commited only if the watched keys remain unchanged
-			_, dberr := r.db.Client().TxPipelined(ctx, func(client redis.Pipeliner) error {
-				client.HSet(ctx, metricsDbKey, "active_ingress", metrics.ActiveIngress)
-				client.HSet(ctx, metricsDbKey, "ingress_quota_exceeded", metrics.IngressQuotaExceeded)
-				return nil
-			})
+func (r repository) SetIngressQuotaExceeded(ctx context.Context, logger log.Logger, exceeded bool) error {
+	if dberr := r.db.Client().HSet(ctx, metricsDbKey, "ingress_quota_exceeded", exceeded).Err(); dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HSet() in metrics.SetIngressQuotaExceeded")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+func (r repository) AcquireIngress(ctx context.Context, logger log.Logger, holder string, limit int) (bool, error) {
+	acquired := false
+	txf := func(tx *redis.Tx) error {
+		acquired = false
+		held, dberr := tx.HExists(ctx, ingressLeaseDbKey, holder).Result()
+		if dberr != nil {
 			return dberr
+		} else if held {
+			// Acquiring the same lease again is a no-op
+			acquired = true
+			return nil
 		}
-		for i := 0; i < r.db.GetMaxRetries(); i++ {
-			dberr := r.db.Client().Watch(ctx, txf, key)
-			if dberr == nil {
-				return nil
-			} else if dberr == redis.TxFailedErr {
-				// Optimistic lock lost. Retry.
-				continue
+		leases, dberr := tx.HLen(ctx, ingressLeaseDbKey).Result()
+		if dberr != nil {
+			return dberr
+		} else if limit > 0 && int(leases) >= limit {
+			return nil
+		}
+		// Operation is commited only if the watched keys remain unchanged
+		_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, ingressLeaseDbKey, holder, time.Now().UnixMilli())
+			return nil
+		})
+		acquired = dberr == nil
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, ingressLeaseDbKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in AcquireIngress transaction")
+		return false, errors.InternalServerError("")
+	}
+	return acquired, nil
+}
+
+func (r repository) ReleaseIngress(ctx context.Context, logger log.Logger, holder string) error {
+	if dberr := r.db.Client().HDel(ctx, ingressLeaseDbKey, holder).Err(); dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HDel() in metrics.ReleaseIngress")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+func (r repository) ReconcileIngress(ctx context.Context, logger log.Logger, holders []string, since int64) (int, int, error) {
+	var added, removed int
+	txf := func(tx *redis.Tx) error {
+		added, removed = 0, 0
+		leases, dberr := tx.HGetAll(ctx, ingressLeaseDbKey).Result()
+		if dberr != nil {
+			return dberr
+		}
+		expected := map[string]bool{}
+		for _, holder := range holders {
+			expected[holder] = true
+		}
+		now := time.Now().UnixMilli()
+		_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for holder := range expected {
+				if _, ok := leases[holder]; !ok {
+					pipe.HSet(ctx, ingressLeaseDbKey, holder, now)
+					added += 1
+				}
+			}
+			for holder, acquired := range leases {
+				// Leases acquired after the holders were listed aren't known to the caller
+				if acquiredAt, _ := strconv.ParseInt(acquired, 10, 64); !expected[holder] && acquiredAt <= since {
+					pipe.HDel(ctx, ingressLeaseDbKey, holder)
+					removed += 1
+				}
+			}
+			return nil
+		})
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, ingressLeaseDbKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in ReconcileIngress transaction")
+		return 0, 0, errors.InternalServerError("")
+	}
+	return added, removed, nil
+}
+
+// Helper to get redis key holding storage usage of an user, or across Popcorn if username is blank.
+func storageUsageKey(username string) string {
+	if username == "" {
+		return storageDbKey
+	}
+	return "storage-usage:" + username
+}
+
+// Helper to get redis key holding streaming usage of an user within month.
+func streamUsageKey(username, month string) string {
+	return "stream-usage:" + month + ":" + username
+}
+
+// Helper to run txf in a transaction, retried as long as the watched keys get changed in between.
+func (r repository) watch(ctx context.Context, txf func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < r.db.GetMaxRetries(); i++ {
+		dberr := r.db.Client().Watch(ctx, txf, keys...)
+		if dberr != redis.TxFailedErr {
+			return dberr
+		}
+		// Optimistic lock lost. Retry.
+	}
+	return errors.New("increment reached maximum number of retries")
+}
+
+func (r repository) GetStorageUsage(ctx context.Context, logger log.Logger, username string) (entity.StorageUsage, error) {
+	var usage entity.StorageUsage
+	if dberr := r.db.Client().HGetAll(ctx, storageUsageKey(username)).Scan(&usage); dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGetAll() in metrics.GetStorageUsage")
+		return entity.StorageUsage{}, errors.InternalServerError("")
+	}
+	return usage, nil
+}
+
+func (r repository) ReserveStorage(ctx context.Context, logger log.Logger, username string, size, userQuota, totalQuota int64) error {
+	userKey := storageUsageKey(username)
+	var quotaerr error
+	txf := func(tx *redis.Tx) error {
+		quotaerr = nil
+		var userUsage, totalUsage entity.StorageUsage
+		if dberr := tx.HGetAll(ctx, userKey).Scan(&userUsage); dberr != nil {
+			return dberr
+		}
+		if dberr := tx.HGetAll(ctx, storageDbKey).Scan(&totalUsage); dberr != nil {
+			return dberr
+		}
+		if userQuota > 0 && userUsage.InFlight+userUsage.Stored+size > userQuota {
+			quotaerr = errors.InsufficientStorage("upload exceeds your storage quota")
+			return nil
+		} else if totalQuota > 0 && totalUsage.InFlight+totalUsage.Stored+size > totalQuota {
+			quotaerr = errors.InsufficientStorage("storage quota of Popcorn is exhausted, please try again later")
+			return nil
+		}
+		// Operation is commited only if the watched keys remain unchanged
+		_, dberr := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(ctx, userKey, "in_flight", size)
+			pipe.HIncrBy(ctx, storageDbKey, "in_flight", size)
+			return nil
+		})
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, userKey, storageDbKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in ReserveStorage transaction")
+		return errors.InternalServerError("")
+	}
+	return quotaerr
+}
+
+func (r repository) TrackUpload(ctx context.Context, logger log.Logger, username, uploadID string, size int64) error {
+	uploadKey := "storage-upload:" + uploadID
+	txf := func(tx *redis.Tx) error {
+		exists, dberr := tx.Exists(ctx, uploadKey).Result()
+		if dberr != nil || exists != 0 {
+			// Upload finished already, tracked while commiting
+			return dberr
+		}
+		_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, uploadKey, "user", username, "size", size, "stored", false, "tracked", time.Now().UnixMilli())
+			return nil
+		})
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, uploadKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in TrackUpload transaction")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+func (r repository) CommitStorage(ctx context.Context, logger log.Logger, username, uploadID string, size int64) error {
+	uploadKey := "storage-upload:" + uploadID
+	txf := func(tx *redis.Tx) error {
+		var upload entity.StorageUpload
+		if dberr := tx.HGetAll(ctx, uploadKey).Scan(&upload); dberr != nil {
+			return dberr
+		} else if upload.Stored {
+			return nil
+		}
+		reserved, tracked := size, time.Now().UnixMilli()
+		if upload.User != "" {
+			// Reservation of uploads with deferred length differs from their final size
+			reserved, tracked = upload.Size, upload.Tracked
+		}
+		_, dberr := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range []string{storageUsageKey(username), storageDbKey} {
+				pipe.HIncrBy(ctx, key, "in_flight", -reserved)
+				pipe.HIncrBy(ctx, key, "stored", size)
 			}
-			// Return any other error.
+			pipe.HSet(ctx, uploadKey, "user", username, "size", size, "stored", true, "tracked", tracked)
+			return nil
+		})
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, uploadKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in CommitStorage transaction")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+func (r repository) ReleaseStorage(ctx context.Context, logger log.Logger, uploadID string) error {
+	uploadKey := "storage-upload:" + uploadID
+	txf := func(tx *redis.Tx) error {
+		var upload entity.StorageUpload
+		if dberr := tx.HGetAll(ctx, uploadKey).Scan(&upload); dberr != nil {
 			return dberr
+		} else if upload.User == "" {
+			// Untracked or released already
+			return nil
+		}
+		field := "in_flight"
+		if upload.Stored {
+			field = "stored"
+		}
+		_, dberr := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(ctx, storageUsageKey(upload.User), field, -upload.Size)
+			pipe.HIncrBy(ctx, storageDbKey, field, -upload.Size)
+			pipe.Del(ctx, uploadKey)
+			return nil
+		})
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, uploadKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in ReleaseStorage transaction")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Helper to list keys matching pattern through SCAN, which unlike KEYS doesn't block redis.
+func scanKeys(ctx context.Context, cmd redis.Cmdable, pattern string) ([]string, error) {
+	keys := []string{}
+	cursor := uint64(0)
+	for {
+		page, newCursor, dberr := cmd.Scan(ctx, cursor, pattern, 100).Result()
+		if dberr != nil {
+			return nil, dberr
+		}
+		keys = append(keys, page...)
+		if newCursor == 0 {
+			return keys, nil
 		}
-		return errors.New("increment reached maximum number of retries")
-	}(metricsDbKey)
-	if txferr != nil {
-		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in SetOrUpdateMetrics transaction")
+		cursor = newCursor
+	}
+}
+
+func (r repository) ReconcileStorage(ctx context.Context, logger log.Logger, uploadIDs []string, since int64) (int, int, error) {
+	var dropped, fixed int
+	listed := map[string]bool{}
+	for _, uploadID := range uploadIDs {
+		listed[uploadID] = true
+	}
+	// Every reservation, commit and release changes the total usage, which aborts the transaction.
+	// Reservations of uploads never created, or not tracked yet, aren't counted in.
+	txf := func(tx *redis.Tx) error {
+		dropped, fixed = 0, 0
+		uploadKeys, dberr := scanKeys(ctx, tx, "storage-upload:*")
+		if dberr != nil {
+			return dberr
+		}
+		usageKeys, dberr := scanKeys(ctx, tx, storageUsageKey("*"))
+		if dberr != nil {
+			return dberr
+		}
+		expected := map[string]entity.StorageUsage{storageDbKey: {}}
+		for _, usageKey := range usageKeys {
+			expected[usageKey] = entity.StorageUsage{}
+		}
+		stale := []string{}
+		for _, uploadKey := range uploadKeys {
+			var upload entity.StorageUpload
+			if dberr := tx.HGetAll(ctx, uploadKey).Scan(&upload); dberr != nil {
+				return dberr
+			} else if upload.User == "" {
+				// Released in between
+				continue
+			}
+			// Uploads tracked after the store was listed aren't known to the caller
+			if !listed[strings.TrimPrefix(uploadKey, "storage-upload:")] && upload.Tracked <= since {
+				stale = append(stale, uploadKey)
+				continue
+			}
+			for _, usageKey := range []string{storageUsageKey(upload.User), storageDbKey} {
+				usage := expected[usageKey]
+				if upload.Stored {
+					usage.Stored += upload.Size
+				} else {
+					usage.InFlight += upload.Size
+				}
+				expected[usageKey] = usage
+			}
+		}
+		actual := map[string]entity.StorageUsage{}
+		for usageKey := range expected {
+			var usage entity.StorageUsage
+			if dberr := tx.HGetAll(ctx, usageKey).Scan(&usage); dberr != nil {
+				return dberr
+			}
+			actual[usageKey] = usage
+		}
+		_, dberr = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, uploadKey := range stale {
+				pipe.Del(ctx, uploadKey)
+				dropped += 1
+			}
+			for usageKey, usage := range expected {
+				if usage != actual[usageKey] {
+					pipe.HSet(ctx, usageKey, "in_flight", usage.InFlight, "stored", usage.Stored)
+					fixed += 1
+				}
+			}
+			return nil
+		})
+		return dberr
+	}
+	if txferr := r.watch(ctx, txf, storageDbKey); txferr != nil {
+		logger.WithCtx(ctx).Error().Err(txferr).Msg("Error occured in ReconcileStorage transaction")
+		return 0, 0, errors.InternalServerError("")
+	}
+	return dropped, fixed, nil
+}
+
+func (r repository) GetStreamUsage(ctx context.Context, logger log.Logger, username, month string) (entity.StreamUsage, error) {
+	usage := entity.StreamUsage{Month: month}
+	if dberr := r.db.Client().HGetAll(ctx, streamUsageKey(username, month)).Scan(&usage); dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HGetAll() in metrics.GetStreamUsage")
+		return entity.StreamUsage{Month: month}, errors.InternalServerError("")
+	}
+	return usage, nil
+}
+
+func (r repository) AddStreamUsage(ctx context.Context, logger log.Logger, username, month string, screenShare bool, seconds int64) error {
+	key := streamUsageKey(username, month)
+	field := "ingress"
+	if screenShare {
+		field = "screen_share"
+	}
+	_, dberr := r.db.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, key, field, seconds)
+		// Kept around for a while after the month ends for users to look back at
+		pipe.Expire(ctx, key, streamUsageRetention)
+		return nil
+	})
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HIncrBy() in metrics.AddStreamUsage")
 		return errors.InternalServerError("")
 	}
 	return nil
@@ -4,20 +4,62 @@ package metrics
 
 import (
 	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
 	"Popcorn/pkg/log"
 	"context"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 )
 
+var (
+	// Storage in bytes each user can hold in uploads, unlimited if not set
+	USER_STORAGE_QUOTA string = os.Getenv("USER_STORAGE_QUOTA")
+	// Storage in bytes all uploads together can hold, unlimited if not set
+	TOTAL_STORAGE_QUOTA string = os.Getenv("TOTAL_STORAGE_QUOTA")
+	// Minutes each user can stream through ingress every month, unlimited if not set
+	USER_MONTHLY_INGRESS_MINUTES string = os.Getenv("USER_MONTHLY_INGRESS_MINUTES")
+	// Minutes each user can screen share every month, unlimited if not set
+	USER_MONTHLY_SCREENSHARE_MINUTES string = os.Getenv("USER_MONTHLY_SCREENSHARE_MINUTES")
+	// Percentage of a monthly streaming budget users get warned at, 80 if not set
+	STREAM_USAGE_WARNING_PERCENT string = os.Getenv("STREAM_USAGE_WARNING_PERCENT")
+)
+
 // Service layer of internal package metrics which encapsulates metrics CRUD logic of Popcorn.
 type Service interface {
 	// get Popcorn metrics
 	GetMetrics(ctx context.Context) (entity.Metrics, error)
-	// set or update Popcorn metrics
-	SetOrUpdateMetrics(ctx context.Context, metrics *entity.Metrics) error
+	// flag monthly ingress quota as exceeded or not
+	SetIngressQuotaExceeded(ctx context.Context, exceeded bool) error
+	// lease an ingress to holder within the concurrent ingress limit, false if the limit is reached
+	AcquireIngress(ctx context.Context, holder string) (bool, error)
+	// lease an ingress to holder regardless of the limit, used for contents accepted already
+	HoldIngress(ctx context.Context, holder string) error
+	// release ingress leased to holder, if any
+	ReleaseIngress(ctx context.Context, holder string)
+	// reconcile ingress leases against their actual holders, leases acquired after since are kept as their holders may not be listed
+	ReconcileIngress(ctx context.Context, holders []string, since time.Time) error
 	// reset metrics in the beginning of every month
 	ResetMetrics(ctx context.Context)
+	// get storage usage of an user, or across Popcorn if username is blank
+	GetStorageUsage(ctx context.Context, username string) (entity.StorageUsage, error)
+	// reserve storage for a new upload, fails if user or total quota would be exceeded
+	ReserveStorage(ctx context.Context, username string, size int64) error
+	// attach upload ID to storage reserved for it
+	TrackUpload(ctx context.Context, username, uploadID string, size int64) error
+	// account storage of a finished upload as stored
+	CommitStorage(ctx context.Context, username, uploadID string, size int64) error
+	// release storage of an upload once its content is deleted
+	ReleaseStorage(ctx context.Context, uploadID string)
+	// reconcile storage usage against the uploads kept in the content store, uploads tracked after since are kept as they may not be listed
+	ReconcileStorage(ctx context.Context, uploadIDs []string, since time.Time) error
+	// get streaming usage of an user in the current month
+	GetStreamUsage(ctx context.Context, username string) (entity.StreamUsage, error)
+	// account seconds streamed by an user in the current month
+	AddStreamUsage(ctx context.Context, username string, screenShare bool, seconds int64)
+	// check if an user has monthly streaming budget left, fails if it's used up
+	CheckStreamBudget(ctx context.Context, username string, screenShare bool) error
 }
 
 // Object of this will be passed around from main to routers to API.
@@ -49,8 +91,149 @@ func (s service) GetMetrics(ctx context.Context) (entity.Metrics, error) {
 	return s.metricsRepo.GetMetrics(ctx, s.logger)
 }
 
-func (s service) SetOrUpdateMetrics(ctx context.Context, metrics *entity.Metrics) error {
-	return s.metricsRepo.SetOrUpdateMetrics(ctx, s.logger, metrics)
+func (s service) SetIngressQuotaExceeded(ctx context.Context, exceeded bool) error {
+	return s.metricsRepo.SetIngressQuotaExceeded(ctx, s.logger, exceeded)
+}
+
+func (s service) AcquireIngress(ctx context.Context, holder string) (bool, error) {
+	return s.metricsRepo.AcquireIngress(ctx, s.logger, holder, s.livekit_config.MaxConcurrentIngressLimit)
+}
+
+func (s service) HoldIngress(ctx context.Context, holder string) error {
+	_, dberr := s.metricsRepo.AcquireIngress(ctx, s.logger, holder, 0)
+	return dberr
+}
+
+func (s service) ReleaseIngress(ctx context.Context, holder string) {
+	// Errors are logged in the repository, leases left behind get released on reconciliation
+	s.metricsRepo.ReleaseIngress(ctx, s.logger, holder)
+}
+
+func (s service) ReconcileIngress(ctx context.Context, holders []string, since time.Time) error {
+	added, removed, dberr := s.metricsRepo.ReconcileIngress(ctx, s.logger, holders, since.UnixMilli())
+	if dberr == nil && added+removed != 0 {
+		s.logger.WithCtx(ctx).Info().Msgf("Fixed ingress leases, %d added and %d removed", added, removed)
+	}
+	return dberr
+}
+
+// Helper to parse storage quota, 0 if unlimited.
+func storageQuota(quota string) int64 {
+	value, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
+
+func (s service) GetStorageUsage(ctx context.Context, username string) (entity.StorageUsage, error) {
+	usage, dberr := s.metricsRepo.GetStorageUsage(ctx, s.logger, username)
+	if username == "" {
+		usage.Quota = storageQuota(TOTAL_STORAGE_QUOTA)
+	} else {
+		usage.Quota = storageQuota(USER_STORAGE_QUOTA)
+	}
+	return usage, dberr
+}
+
+func (s service) ReserveStorage(ctx context.Context, username string, size int64) error {
+	return s.metricsRepo.ReserveStorage(ctx, s.logger, username, size, storageQuota(USER_STORAGE_QUOTA), storageQuota(TOTAL_STORAGE_QUOTA))
+}
+
+func (s service) TrackUpload(ctx context.Context, username, uploadID string, size int64) error {
+	return s.metricsRepo.TrackUpload(ctx, s.logger, username, uploadID, size)
+}
+
+func (s service) CommitStorage(ctx context.Context, username, uploadID string, size int64) error {
+	return s.metricsRepo.CommitStorage(ctx, s.logger, username, uploadID, size)
+}
+
+func (s service) ReleaseStorage(ctx context.Context, uploadID string) {
+	if uploadID == "" {
+		return
+	}
+	// Errors are logged in the repository, nothing else can be done by the callers
+	s.metricsRepo.ReleaseStorage(ctx, s.logger, uploadID)
+}
+
+func (s service) ReconcileStorage(ctx context.Context, uploadIDs []string, since time.Time) error {
+	dropped, fixed, dberr := s.metricsRepo.ReconcileStorage(ctx, s.logger, uploadIDs, since.UnixMilli())
+	if dberr == nil && dropped+fixed != 0 {
+		s.logger.WithCtx(ctx).Info().Msgf("Fixed storage usage, %d uploads dropped and %d usages recomputed", dropped, fixed)
+	}
+	return dberr
+}
+
+// Helper to get the month streaming usage is accounted in as YYYY-MM.
+func usageMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+// Helper to parse monthly streaming budget in minutes as seconds, 0 if unlimited.
+func streamBudget(minutes string) int64 {
+	value, err := strconv.ParseInt(minutes, 10, 64)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value * 60
+}
+
+// Helper to parse the percentage of a budget users get warned at.
+func warningPercent() int {
+	value, err := strconv.Atoi(STREAM_USAGE_WARNING_PERCENT)
+	if err != nil || value <= 0 || value >= 100 {
+		return 80
+	}
+	return value
+}
+
+// UsageThreshold returns the highest threshold of the monthly budget crossed once elapsed seconds of
+// the ongoing stream are added to usage, 100 if the budget is used up and 0 if none is crossed or it's unlimited.
+func UsageThreshold(usage entity.StreamUsage, screenShare bool, elapsed int64) int {
+	used, budget := usage.Ingress, usage.IngressBudget
+	if screenShare {
+		used, budget = usage.ScreenShare, usage.ScreenShareBudget
+	}
+	if budget == 0 {
+		return 0
+	}
+	used += elapsed
+	if used >= budget {
+		return 100
+	}
+	if percent := warningPercent(); used*100 >= budget*int64(percent) {
+		return percent
+	}
+	return 0
+}
+
+func (s service) GetStreamUsage(ctx context.Context, username string) (entity.StreamUsage, error) {
+	usage, dberr := s.metricsRepo.GetStreamUsage(ctx, s.logger, username, usageMonth())
+	usage.IngressBudget = streamBudget(USER_MONTHLY_INGRESS_MINUTES)
+	usage.ScreenShareBudget = streamBudget(USER_MONTHLY_SCREENSHARE_MINUTES)
+	return usage, dberr
+}
+
+func (s service) AddStreamUsage(ctx context.Context, username string, screenShare bool, seconds int64) {
+	if seconds <= 0 {
+		return
+	}
+	// Errors are logged in the repository, nothing else can be done by the callers
+	s.metricsRepo.AddStreamUsage(ctx, s.logger, username, usageMonth(), screenShare, seconds)
+}
+
+func (s service) CheckStreamBudget(ctx context.Context, username string, screenShare bool) error {
+	usage, dberr := s.GetStreamUsage(ctx, username)
+	if dberr != nil {
+		return dberr
+	}
+	if UsageThreshold(usage, screenShare, 0) < 100 {
+		return nil
+	}
+	if screenShare {
+		return errors.TooManyRequests("Monthly screen share budget is used up")
+	}
+	return errors.TooManyRequests("Monthly streaming budget is used up")
 }
 
 func (s service) ResetMetrics(ctx context.Context) {
@@ -79,8 +262,7 @@ func (s service) revertingressquota(ctx context.Context) {
 	if day == 1 && metrics.IngressQuotaExceeded {
 		// Reset the metrics on the first day of every month
 		s.logger.WithCtx(ctx).Info().Msg("Setting metrics.IngressQuotaExceeded to False")
-		metrics.IngressQuotaExceeded = false
-		s.SetOrUpdateMetrics(ctx, &metrics)
+		s.SetIngressQuotaExceeded(ctx, false)
 		s.logger.WithCtx(ctx).Info().Msg("Metrics Reset Successful")
 	}
 }
@@ -5,9 +5,13 @@ package sse
 import (
 	"Popcorn/internal/entity"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/monitor"
+	"Popcorn/pkg/tracing"
 	"context"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Service interface {
@@ -15,6 +19,8 @@ type Service interface {
 	GetOrSetEvent(ctx context.Context) *entity.SSE
 	// Launch a listener for SSE, preferably in a goroutine for non-blockage
 	Listen(ctx context.Context)
+	// Force close SSE connection of a client, preferably in a goroutine for non-blockage
+	DisconnectClient(ctx context.Context, id string)
 }
 
 // Object of this will be passed around from main to routers to API.
@@ -42,10 +48,11 @@ func (s service) GetOrSetEvent(ctx context.Context) *entity.SSE {
 	once.Do(func() {
 		quit = make(chan bool)
 		event = &entity.SSE{
-			Message:       make(chan entity.SSEData),
-			NewClients:    make(chan entity.SSEClient),
-			ClosedClients: make(chan entity.SSEClient),
-			TotalClients:  make(map[string]chan entity.SSEData),
+			Message:             make(chan entity.SSEData),
+			NewClients:          make(chan entity.SSEClient),
+			ClosedClients:       make(chan entity.SSEClient),
+			DisconnectedClients: make(chan string),
+			TotalClients:        make(map[string]chan entity.SSEData),
 		}
 		s.logger.WithCtx(ctx).Info().Msg("Initialized Popcorn SSE instance.")
 	})
@@ -61,26 +68,52 @@ func (s service) Listen(ctx context.Context) {
 				s.logger.WithCtx(ctx).Error().Msgf("Error occured while setting new SSE channel for %s", client.ID)
 			} else {
 				s.GetOrSetEvent(ctx).TotalClients[client.ID] = client.Channel
+				monitor.SSEConnections.Set(float64(len(s.GetOrSetEvent(ctx).TotalClients)))
 				s.logger.WithCtx(ctx).Info().Msgf("Added client %s into Popcorn SSE event channel", client.ID)
 			}
 
 		// Remove closed client
 		case client, ok := <-s.GetOrSetEvent(ctx).ClosedClients:
-			if ok {
+			// Client channel might have been already closed by a force disconnect
+			if ok && s.GetOrSetEvent(ctx).TotalClients[client.ID] == client.Channel {
 				close(client.Channel)
 				delete(s.GetOrSetEvent(ctx).TotalClients, client.ID)
+				monitor.SSEConnections.Set(float64(len(s.GetOrSetEvent(ctx).TotalClients)))
 				s.logger.WithCtx(ctx).Info().Msgf("Removed client %s from Popcorn SSE event channel", client.ID)
 			}
 
+		// Force close client connection, stream API returns once its channel is closed
+		case id, ok := <-s.GetOrSetEvent(ctx).DisconnectedClients:
+			if ok && s.GetOrSetEvent(ctx).TotalClients[id] != nil {
+				close(s.GetOrSetEvent(ctx).TotalClients[id])
+				delete(s.GetOrSetEvent(ctx).TotalClients, id)
+				monitor.SSEConnections.Set(float64(len(s.GetOrSetEvent(ctx).TotalClients)))
+				s.logger.WithCtx(ctx).Info().Msgf("Disconnected client %s from Popcorn SSE event channel", id)
+			}
+
 		// Broadcast message to a specific client with client ID fetched from eventMsg.To
 		case eventMsg, ok := <-s.GetOrSetEvent(ctx).Message:
-			if ok && s.GetOrSetEvent(ctx).TotalClients[eventMsg.To] != nil {
+			if !ok {
+				continue
+			}
+			_, span := tracing.Start(ctx, "sse.deliver", attribute.String("sse.type", eventMsg.Type))
+			if s.GetOrSetEvent(ctx).TotalClients[eventMsg.To] != nil {
 				s.GetOrSetEvent(ctx).TotalClients[eventMsg.To] <- eventMsg
+				span.SetAttributes(attribute.Bool("sse.delivered", true))
+			} else {
+				// Recipient isn't connected
+				monitor.SSEDropped.Inc()
+				span.SetAttributes(attribute.Bool("sse.delivered", false))
 			}
+			span.End()
 		}
 	}
 }
 
+func (s service) DisconnectClient(ctx context.Context, id string) {
+	s.GetOrSetEvent(ctx).DisconnectedClients <- id
+}
+
 func Cleanup(ctx context.Context) error {
 	// This quit signal will close open stream API connections
 	close(quit)
@@ -89,6 +122,7 @@ func Cleanup(ctx context.Context) error {
 		close(event.Message)
 		close(event.ClosedClients)
 		close(event.NewClients)
+		close(event.DisconnectedClients)
 	}()
 	return nil
 }
@@ -3,19 +3,97 @@
 package storage
 
 import (
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/signedurl"
+	"io"
+	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	tusd "github.com/tus/tusd/pkg/handler"
 )
 
-func APIHandlers(router *gin.Engine, storage_handler *tusd.UnroutedHandler, authWithAcc, preUploadValidation gin.HandlerFunc, logger log.Logger) {
+func APIHandlers(
+	router *gin.Engine,
+	storage_handler *tusd.UnroutedHandler,
+	storageService Service,
+	contentStore objectstore.Store,
+	signer signedurl.Signer,
+	authWithAcc, preUploadValidation gin.HandlerFunc,
+	logger log.Logger) {
 	uploadGroup := router.Group("/api/upload_content")
 	{
 		uploadGroup.POST("", authWithAcc, preUploadValidation, gin.WrapF(storage_handler.PostFile))
-		uploadGroup.GET("/:id", gin.WrapF(storage_handler.GetFile))
+		uploadGroup.POST("/check", authWithAcc, checkContentHash(storageService, logger))
+		uploadGroup.GET("/:id", getContent(contentStore, signer, logger))
 		uploadGroup.HEAD("/:id", authWithAcc, preUploadValidation, gin.WrapF(storage_handler.HeadFile))
 		uploadGroup.PATCH("/:id", authWithAcc, preUploadValidation, gin.WrapF(storage_handler.PatchFile))
 		uploadGroup.DELETE("/:id", authWithAcc, preUploadValidation, gin.WrapF(storage_handler.DelFile))
 	}
 }
+
+// getContent returns a handler which serves uploaded content to livekit ingress through signed URLs.
+// Range requests are supported so that ingress can seek through the content.
+func getContent(contentStore objectstore.Store, signer signedurl.Signer, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		contentID := gctx.Param("id")
+		if sigerr := signer.Verify(contentID, gctx.Request.URL.Query()); sigerr != nil {
+			// Unsigned, tampered or expired URL
+			logger.WithCtx(gctx).Info().Err(sigerr).Msgf("Rejected content download - %s", contentID)
+			gctx.AbortWithStatusJSON(http.StatusForbidden, errors.Forbidden(sigerr.Error()))
+			return
+		}
+		reader, strerr := contentStore.Open(gctx, contentID)
+		if strerr != nil {
+			gctx.AbortWithStatusJSON(http.StatusNotFound, errors.NotFound(""))
+			return
+		}
+		defer reader.Close()
+		seeker, ok := reader.(io.ReadSeeker)
+		if !ok {
+			// Backends which can't seek are served as a whole
+			gctx.Status(http.StatusOK)
+			io.Copy(gctx.Writer, reader)
+			return
+		}
+		// Content type is sniffed as upload ID carries no extension
+		http.ServeContent(gctx.Writer, gctx.Request, contentID, time.Time{}, seeker)
+	}
+}
+
+// checkContentHash returns a handler which takes care of attaching content stored already to the gang,
+// so that the client can skip uploading it. Not found is returned if the client has to upload the content.
+func checkContentHash(storageService Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		// Fetch username from context which will be used as the gang admin
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in checkContentHash")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		var check entity.ContentHashCheck
+		if binderr := gctx.ShouldBindJSON(&check); binderr != nil {
+			// Error occured during serialization
+			gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, errors.UnprocessableEntity(""))
+			return
+		}
+		err := storageService.checkcontenthash(gctx, user.Username, check)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,234 @@
+// Content storage API tests in Popcorn.
+
+package storage
+
+import (
+	"Popcorn/internal/blob"
+	"Popcorn/internal/entity"
+	"Popcorn/internal/gang"
+	"Popcorn/internal/library"
+	"Popcorn/internal/metrics"
+	"Popcorn/internal/sse"
+	"Popcorn/internal/test"
+	"Popcorn/pkg/db"
+	"Popcorn/pkg/log"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/signedurl"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+// Global instance of log.Logger to be used during storage testing.
+var logger log.Logger
+
+// Global instance of Db instance to be used during storage testing.
+var client *db.RedisDB
+
+// Sets up resources before testing storage in Popcorn.
+func setup() {
+	// Load test.env
+	enverr := godotenv.Load("../../config/test.env")
+	if enverr != nil {
+		// Error during loading test.env, abort test run immediately
+		os.Exit(4)
+	}
+	// Janitor reconciles every gang in DB, a dedicated DB keeps test data of other packages out of its reach
+	os.Setenv("REDIS_DB_NUMBER", "2")
+	logger = log.New(os.Getenv("VERSION"))
+
+	// Db client instance
+	var dberr error
+	client, dberr = db.NewDbConnection(ctx, logger)
+	// Sending a PING request to DB for connection status check
+	if dberr != nil || client.CheckDbConnection(ctx, logger) != nil {
+		// connection failure
+		os.Exit(6)
+	}
+	logger.Info().Msg("Test resources setup successful.")
+}
+
+// Cleans up the resources built during execution of setup()
+func teardown() {
+	logger.Info().Msg("Cleaning up resources ...")
+	if client.CheckDbConnection(ctx, logger) == nil {
+		// client still open
+		client.Client().FlushDB(ctx)
+		client.CloseDbConnection(ctx)
+	}
+	logger.Info().Msg("Cleanup complete :)")
+}
+
+func TestMain(m *testing.M) {
+	// Setting up Resources
+	setup()
+	// Running the tests
+	testExitCode := m.Run()
+	// Cleanup Resources
+	teardown()
+	// Exit
+	os.Exit(testExitCode)
+}
+
+// Helper to upload content into store, only the first received bytes are written. Returns the upload ID.
+func upload(t *testing.T, store objectstore.Store, size, received int64) string {
+	composer := tusd.NewStoreComposer()
+	store.UseIn(composer)
+	upload, err := composer.Core.NewUpload(ctx, tusd.FileInfo{Size: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.WriteChunk(ctx, 0, bytes.NewReader(make([]byte, received))); err != nil {
+		t.Fatal(err)
+	}
+	info, err := upload.GetInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ID
+}
+
+func TestGetContent(t *testing.T) {
+	signer := signedurl.NewSigner([]byte("popcorn"), time.Hour)
+	store, strerr := objectstore.NewLocalStore(t.TempDir(), "https://popcorn.test", signer)
+	if strerr != nil {
+		t.Fatal(strerr)
+	}
+	contentID := upload(t, store, 64, 64)
+	router := test.MockRouter()
+	router.GET("/api/upload_content/:id", getContent(store, signer, logger))
+
+	// Helper to download content through rawURL, range is requested unless blank
+	download := func(rawURL, byteRange string) *httptest.ResponseRecorder {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := httptest.NewRequest(http.MethodGet, u.RequestURI(), nil)
+		if byteRange != "" {
+			request.Header.Set("Range", byteRange)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	pullURL, strerr := store.PullURL(ctx, contentID)
+	if strerr != nil {
+		t.Fatal(strerr)
+	}
+	response := download(pullURL, "")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, 64, response.Body.Len())
+	assert.Equal(t, "bytes", response.Header().Get("Accept-Ranges"))
+
+	// Ingress seeks through range requests
+	response = download(pullURL, "bytes=10-19")
+	assert.Equal(t, http.StatusPartialContent, response.Code)
+	assert.Equal(t, "bytes 10-19/64", response.Header().Get("Content-Range"))
+	body, _ := io.ReadAll(response.Body)
+	assert.Len(t, body, 10)
+
+	// Unsigned, foreign and expired URLs are rejected
+	assert.Equal(t, http.StatusForbidden, download("/api/upload_content/"+contentID, "").Code)
+	otherID := upload(t, store, 64, 64)
+	u, _ := url.Parse(pullURL)
+	assert.Equal(t, http.StatusForbidden, download("/api/upload_content/"+otherID+"?"+u.RawQuery, "").Code)
+	expired, _ := signedurl.NewSigner([]byte("popcorn"), -time.Minute).Sign("/api/upload_content/"+contentID, contentID)
+	assert.Equal(t, http.StatusForbidden, download(expired, "").Code)
+
+	// Deleted content is gone even with a valid signature
+	assert.NoError(t, store.Delete(ctx, contentID))
+	assert.Equal(t, http.StatusNotFound, download(pullURL, "").Code)
+}
+
+func TestCheckContentHash(t *testing.T) {
+	store, strerr := objectstore.NewLocalStore(t.TempDir(), "https://popcorn.test", signedurl.NewSigner([]byte("popcorn"), time.Hour))
+	if strerr != nil {
+		t.Fatal(strerr)
+	}
+	gangRepo := gang.NewRepository(client)
+	libraryRepo := library.NewRepository(client)
+	blobRepo := blob.NewRepository(client)
+	livekitMockConfig := entity.LivekitConfig{MaxConcurrentIngressLimit: 10}
+	metricsService := metrics.NewService(livekitMockConfig, metrics.NewRepository(client), logger)
+	storageService := NewService(livekitMockConfig, store, gangRepo, libraryRepo, blobRepo, metricsService, sse.NewService(logger), logger)
+	router := test.MockRouter()
+	router.POST("/api/upload_content/check", test.MockAuthMiddleware(logger), checkContentHash(storageService, logger))
+	assert.NoError(t, metricsService.ReconcileIngress(ctx, nil, time.Now()))
+
+	// Content stored already
+	contentID := upload(t, store, 64, 64)
+	contentHash, strerr := hashContent(ctx, store, contentID)
+	assert.NoError(t, strerr)
+	_, dberr := blobRepo.SetOrAcquireBlob(ctx, logger, entity.Blob{ID: contentID, Hash: contentHash, Size: 64, Meta: `{"container":"mp4"}`})
+	assert.NoError(t, dberr)
+	_, dberr = gangRepo.SetOrUpdateGang(ctx, logger, &entity.Gang{
+		Admin:          "Hash_Admin",
+		Name:           "Hash Gang",
+		PassKey:        "12345",
+		Limit:          2,
+		MembersListKey: "gang-members:Hash_Admin",
+	}, false)
+	if dberr != nil {
+		t.Fatal(dberr)
+	}
+	defer gangRepo.DelGang(ctx, logger, "Hash_Admin")
+
+	// Helper to check content hash as user
+	check := func(username string, payload entity.ContentHashCheck, want int) {
+		body, _ := json.Marshal(payload)
+		test.ExecuteAPITest(logger, t, router, &test.RequestAPITest{
+			Method:       http.MethodPost,
+			Path:         "/api/upload_content/check",
+			Body:         bytes.NewReader(body),
+			WantResponse: []int{want},
+			Header:       test.MockHeader(),
+			Parameters:   url.Values{},
+			Cookie:       []*http.Cookie{test.MockAuthAllowCookie, {Name: "user", Value: username}},
+		})
+	}
+	unknownHash := hex.EncodeToString(make([]byte, 32))
+	check("Hash_Admin", entity.ContentHashCheck{Hash: "popcorn", Filename: "movie.mp4"}, http.StatusBadRequest)
+	check("Hash_Admin", entity.ContentHashCheck{Hash: unknownHash, Filename: "movie.mp4"}, http.StatusNotFound)
+	check("Hash_Nobody", entity.ContentHashCheck{Hash: contentHash, Filename: "movie.mp4"}, http.StatusBadRequest)
+
+	// Stored content gets attached to the gang without uploading it again
+	check("Hash_Admin", entity.ContentHashCheck{Hash: strings.ToUpper(contentHash), Filename: "movie.mp4", Library: true}, http.StatusOK)
+	gangData, dberr := gangRepo.GetGang(ctx, logger, "gang:Hash_Admin", "Hash_Admin", false)
+	assert.NoError(t, dberr)
+	assert.Equal(t, contentID, gangData.ContentID)
+	assert.Equal(t, "movie.mp4", gangData.ContentName)
+	assert.Equal(t, entity.ContentMeta(`{"container":"mp4"}`), gangData.ContentMeta)
+	assert.True(t, gangData.ContentLibrary)
+	item, dberr := libraryRepo.GetLibraryItem(ctx, logger, "Hash_Admin", contentID)
+	assert.NoError(t, dberr)
+	assert.Equal(t, int64(64), item.Size)
+	metricsData, dberr := metricsService.GetMetrics(ctx)
+	assert.NoError(t, dberr)
+	assert.Equal(t, 1, metricsData.ActiveIngress)
+	check("Hash_Admin", entity.ContentHashCheck{Hash: contentHash, Filename: "movie.mp4"}, http.StatusBadRequest)
+
+	// Library item holds a reference along with the original upload
+	assert.NoError(t, libraryRepo.DelLibraryItem(ctx, logger, item))
+	assert.NoError(t, gangRepo.UpdateGangContentData(ctx, logger, "Hash_Admin", "", "", "", false, false))
+	last, dberr := blobRepo.ReleaseBlob(ctx, logger, contentID)
+	assert.NoError(t, dberr)
+	assert.False(t, last)
+	last, dberr = blobRepo.ReleaseBlob(ctx, logger, contentID)
+	assert.NoError(t, dberr)
+	assert.True(t, last)
+	check("Hash_Admin", entity.ContentHashCheck{Hash: contentHash, Filename: "movie.mp4"}, http.StatusNotFound)
+}
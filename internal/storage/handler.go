@@ -3,48 +3,51 @@
 package storage
 
 import (
+	"Popcorn/internal/blob"
 	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
 	"Popcorn/internal/gang"
+	"Popcorn/internal/library"
 	"Popcorn/internal/metrics"
 	"Popcorn/internal/sse"
 	"Popcorn/pkg/cleanup"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/mediaprobe"
+	"Popcorn/pkg/monitor"
+	"Popcorn/pkg/objectstore"
 	"context"
-	"errors"
+	"encoding/json"
+	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/h2non/filetype"
-	"github.com/tus/tusd/pkg/filestore"
 	tusd "github.com/tus/tusd/pkg/handler"
 )
 
 var (
-	store           filestore.FileStore
 	composer        *tusd.StoreComposer
 	handler         *tusd.UnroutedHandler
 	tusderr         error
 	content_types   map[string]string = map[string]string{"video/mp4": "mp4", "video/x-matroska": "mkv"}
 	ctx             context.Context   = context.Background()
-	UPLOAD_PATH     string            = os.Getenv("UPLOAD_PATH")
 	MAX_UPLOAD_SIZE string            = os.Getenv("MAX_UPLOAD_SIZE")
+	// Time uploads got created at by their ID, uploads created before a restart aren't timed
+	uploadStarts sync.Map
 )
 
 // Returns a fresh or existing Tusd Unrouted handler to help in gang content upload
 func GetTusdStorageHandler(
+	contentStore objectstore.Store,
 	gangRepo gang.Repository,
+	libraryRepo library.Repository,
+	blobRepo blob.Repository,
 	metricsService metrics.Service,
 	sseService sse.Service,
 	livekit_config entity.LivekitConfig,
 	logger log.Logger) *tusd.UnroutedHandler {
-	// Check if upload directory exists, if not make one
-	if _, err := os.Stat(UPLOAD_PATH); errors.Is(err, os.ErrNotExist) {
-		err := os.MkdirAll(UPLOAD_PATH, 0777)
-		if err != nil {
-			logger.WithCtx(ctx).Fatal().Err(err).Msg("Error during creating upload directory for tusd storage")
-		}
-	}
+	storageService := service{livekit_config, contentStore, gangRepo, libraryRepo, blobRepo, metricsService, sseService, logger}
 	// Convert MAX_UPLOAD_SIZE to int64
 	contentUploadSize, err := strconv.ParseInt(MAX_UPLOAD_SIZE, 10, 64)
 	if err != nil {
@@ -52,18 +55,17 @@ func GetTusdStorageHandler(
 		contentUploadSize = 524288000
 	}
 
-	store = filestore.FileStore{Path: UPLOAD_PATH}
-
 	composer = tusd.NewStoreComposer()
-	store.UseIn(composer)
+	contentStore.UseIn(composer)
 
 	handler, tusderr = tusd.NewUnroutedHandler(tusd.Config{
 		BasePath:                "/api/upload_content",
 		MaxSize:                 contentUploadSize,
 		StoreComposer:           composer,
 		NotifyCompleteUploads:   true,
+		NotifyCreatedUploads:    true,
 		NotifyTerminatedUploads: true,
-		DisableDownload:         false,
+		DisableDownload:         true,
 		PreUploadCreateCallback: func(hook tusd.HookEvent) error {
 			// Check livekit metrics
 			metrics, dberr := metricsService.GetMetrics(ctx)
@@ -90,85 +92,108 @@ func GetTusdStorageHandler(
 				// filename cannot be blank
 				return tusd.ErrNotFound
 			}
+			if keepInLibrary(hook) {
+				// Library has a limited number of slots
+				dberr = library.CheckCapacity(ctx, logger, libraryRepo, user)
+				if err, ok := dberr.(errors.ErrorResponse); ok {
+					return tusd.NewHTTPError(err, err.Status)
+				} else if dberr != nil {
+					return tusd.NewHTTPError(dberr, 500)
+				}
+			}
+			// Reserve storage for the upload as long as user and total quotas allow it
+			size := hook.Upload.Size
+			if hook.Upload.SizeIsDeferred {
+				size = contentUploadSize
+			}
+			dberr = metricsService.ReserveStorage(ctx, user, size)
+			if err, ok := dberr.(errors.ErrorResponse); ok {
+				return tusd.NewHTTPError(err, err.Status)
+			} else if dberr != nil {
+				return tusd.NewHTTPError(dberr, 500)
+			}
 			return nil
 		},
 		PreFinishResponseCallback: func(hook tusd.HookEvent) error {
 			user := hook.HTTPRequest.Header.Get("User")
+			// Uploads created along with their data finish before CreatedUploads gets to track them,
+			// storage reserved for them must be tracked before it can be committed or released.
+			// Deferred length can't be declared within the creation request, these are tracked already.
+			dberr := metricsService.TrackUpload(ctx, user, hook.Upload.ID, hook.Upload.Size)
+			if dberr != nil {
+				// Error occured in TrackUpload()
+				return tusd.NewHTTPError(dberr, 500)
+			}
 			gangKey := "gang:" + user
 			// Check if content URL is there already for this gang
-			gang, dberr := gangRepo.GetGang(ctx, logger, gangKey, user, false)
+			gangData, dberr := gangRepo.GetGang(ctx, logger, gangKey, user, false)
 			if dberr != nil {
 				// Error occured in GetGang()
 				return tusd.NewHTTPError(dberr, 500)
-			} else if gang.ContentURL != "" || gang.ContentScreenShare {
+			} else if gangData.ContentURL != "" || gangData.ContentScreenShare {
 				// cannot contain content file & URL or screenshare ON at the same time
 				return tusd.NewHTTPError(errors.New("cannot contain content file & URL at the same time"), 400)
 			}
 			// Validate uploaded file and add filename and ID into gang data upon success
-			filepath := UPLOAD_PATH + hook.Upload.ID
-			file, oserr := os.Open(filepath)
+			file, oserr := contentStore.Open(ctx, hook.Upload.ID)
 			if oserr != nil {
 				logger.Error().Err(oserr).Msg("Cannot open content - " + hook.Upload.ID)
 				return tusd.ErrFileLocked
 			}
-			head := make([]byte, 261)
-			file.Read(head)
-			if !filetype.IsVideo(head) {
-				// Filetype validation failed
-				return tusd.ErrInvalidContentType
+			// Parse the container to catch corrupt files and codecs livekit ingress can't stream
+			mediaInfo, prberr := mediaprobe.Probe(file, hook.Upload.Size)
+			file.Close()
+			if prberr == nil {
+				prberr = mediaprobe.Validate(mediaInfo)
+			}
+			if prberr != nil {
+				// Media validation failed, no need to keep the content around
+				logger.Info().Err(prberr).Msg("Rejected content - " + hook.Upload.ID)
+				metricsService.ReleaseStorage(ctx, hook.Upload.ID)
+				go cleanup.DeleteContentFiles(contentStore, blobRepo, hook.Upload.ID, logger)
+				return tusd.NewHTTPError(prberr, http.StatusUnsupportedMediaType)
 			}
 
-			dberr = gangRepo.UpdateGangContentData(ctx, logger, user, hook.Upload.MetaData["filename"], hook.Upload.ID, "", false, false)
-			if dberr != nil {
-				// Error occured in UpdateGangContentData()
-				return tusd.NewHTTPError(dberr, 500)
+			// Same content uploaded before is kept once, this upload gets a reference to it instead
+			contentHash, strerr := hashContent(ctx, contentStore, hook.Upload.ID)
+			if strerr != nil {
+				logger.Error().Err(strerr).Msg("Cannot hash content - " + hook.Upload.ID)
+				return tusd.ErrFileLocked
 			}
-			// Update metrics
-			metrics, dberr := metricsService.GetMetrics(ctx)
+			mediaMeta, _ := json.Marshal(mediaInfo)
+			blob, dberr := blobRepo.SetOrAcquireBlob(ctx, logger, entity.Blob{
+				ID:   hook.Upload.ID,
+				Hash: contentHash,
+				Size: hook.Upload.Size,
+				Meta: entity.ContentMeta(mediaMeta),
+			})
 			if dberr != nil {
-				// Error occured in GetMetrics()
+				// Error occured in SetOrAcquireBlob()
 				return tusd.NewHTTPError(dberr, 500)
 			}
-			metrics.ActiveIngress += 1
-			dberr = metricsService.SetOrUpdateMetrics(ctx, &metrics)
+			if blob.ID != hook.Upload.ID {
+				// Duplicate is neither kept nor accounted
+				logger.Info().Msgf("Deduplicated content - %s into %s", hook.Upload.ID, blob.ID)
+				metricsService.ReleaseStorage(ctx, hook.Upload.ID)
+				go cleanup.DeleteContentFiles(contentStore, blobRepo, hook.Upload.ID, logger)
+			} else {
+				// Upload is no more in flight
+				dberr = metricsService.CommitStorage(ctx, user, hook.Upload.ID, hook.Upload.Size)
+				if dberr != nil {
+					// Error occured in CommitStorage()
+					return tusd.NewHTTPError(dberr, 500)
+				}
+			}
+			dberr = storageService.setgangcontent(ctx, user, hook.Upload.MetaData["filename"], blob, keepInLibrary(hook))
 			if dberr != nil {
-				// Error occured in SetOrUpdateMetrics()
+				// Error occured in setgangcontent()
 				return tusd.NewHTTPError(dberr, 500)
 			}
 
-			// The uploaded file should be deleted if not streamed under 10mins as storage is limited
-			time.AfterFunc(10*time.Minute, func() {
-				gang, _ := gangRepo.GetGang(ctx, logger, gangKey, user, false)
+			// Uploaded file gets deleted by the Janitor if not streamed in time as storage is limited
 
-				if len(gang.Name) != 0 && !gang.Streaming {
-					logger.Info().Msgf("Deleting unstreamed content files for: %s", gangKey)
-					// Delete gang content files
-					cleanup.DeleteContentFiles(gang.ContentID, logger)
-					// Erase gang content data from DB
-					gangRepo.UpdateGangContentData(ctx, logger, user, "", "", "", false, false)
-					// Update metrics
-					metrics, _ = metricsService.GetMetrics(ctx)
-					if metrics.ActiveIngress >= 1 {
-						metrics.ActiveIngress -= 1
-						metricsService.SetOrUpdateMetrics(ctx, &metrics)
-					}
-					// Notify the members that stream has stopped
-					members, _ := gangRepo.GetGangMembers(ctx, logger, user)
-					for _, member := range members {
-						go func(member string) {
-							data := entity.SSEData{
-								Data: nil,
-								Type: "gangUpdate",
-								To:   member,
-							}
-							sseService.GetOrSetEvent(ctx).Message <- data
-						}(member)
-					}
-				}
-			})
-
-			diskSpaceAvail, _ := getAvailableDiskSpace(ctx, logger)
-			logger.WithCtx(ctx).Info().Msgf("Available disk space - %d", diskSpaceAvail)
+			diskSpaceAvail, _ := contentStore.AvailableSpace(ctx)
+			logger.WithCtx(ctx).Info().Msgf("Available storage space - %d", diskSpaceAvail)
 
 			return nil
 		},
@@ -177,12 +202,30 @@ func GetTusdStorageHandler(
 		logger.WithCtx(ctx).Fatal().Err(tusderr).Msg("Unable to create tusd handler")
 	}
 	// Start a goroutine for receiving events from the handler whenever
+	// an upload is created, storage reserved for it gets tracked by its ID.
+	go func() {
+		for {
+			event := <-handler.CreatedUploads
+			user := event.HTTPRequest.Header.Get("User")
+			size := event.Upload.Size
+			if event.Upload.SizeIsDeferred {
+				size = contentUploadSize
+			}
+			metricsService.TrackUpload(ctx, user, event.Upload.ID, size)
+			uploadStarts.Store(event.Upload.ID, time.Now())
+		}
+	}()
+	// Start a goroutine for receiving events from the handler whenever
 	// an upload is completed. The event will contains details about the upload
 	// itself and the relevant HTTP request.
 	go func() {
 		for {
 			event := <-handler.CompleteUploads
 			logger.Info().Msgf("Upload %s finished", event.Upload.ID)
+			monitor.UploadBytes.Add(float64(event.Upload.Size))
+			if started, ok := uploadStarts.LoadAndDelete(event.Upload.ID); ok {
+				monitor.UploadDuration.Observe(time.Since(started.(time.Time)).Seconds())
+			}
 			// Send notifications to gang Members about the updates
 			user := event.HTTPRequest.Header.Get("User")
 			members, _ := gangRepo.GetGangMembers(ctx, logger, user)
@@ -205,6 +248,8 @@ func GetTusdStorageHandler(
 		for {
 			event := <-handler.TerminatedUploads
 			logger.Info().Msgf("Upload %s terminated", event.Upload.ID)
+			uploadStarts.Delete(event.Upload.ID)
+			metricsService.ReleaseStorage(ctx, event.Upload.ID)
 			// Send notifications to gang Members about the updates
 			user := event.HTTPRequest.Header.Get("User")
 			members, _ := gangRepo.GetGangMembers(ctx, logger, user)
@@ -224,3 +269,9 @@ func GetTusdStorageHandler(
 
 	return handler
 }
+
+// Returns true if the uploader asked to keep the content in their library.
+func keepInLibrary(hook tusd.HookEvent) bool {
+	keep, _ := strconv.ParseBool(hook.Upload.MetaData["library"])
+	return keep
+}
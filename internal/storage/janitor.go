@@ -0,0 +1,285 @@
+// Janitor keeping uploaded contents in line with gang content data saved in DB.
+// Replaces per upload in-memory timers, which were lost on every restart.
+
+package storage
+
+import (
+	"Popcorn/internal/blob"
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/internal/gang"
+	"Popcorn/internal/library"
+	"Popcorn/internal/metrics"
+	"Popcorn/internal/sse"
+	"Popcorn/pkg/cleanup"
+	"Popcorn/pkg/log"
+	"Popcorn/pkg/objectstore"
+	"context"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	// Minutes between two reconciliations
+	UPLOAD_JANITOR_INTERVAL_MINS string = os.Getenv("UPLOAD_JANITOR_INTERVAL_MINS")
+	// Minutes for which finished uploads are kept around without being streamed
+	UNSTREAMED_CONTENT_TTL_MINS string = os.Getenv("UNSTREAMED_CONTENT_TTL_MINS")
+	// Minutes for which unfinished uploads are kept around without receiving any data
+	PARTIAL_UPLOAD_TTL_MINS string = os.Getenv("PARTIAL_UPLOAD_TTL_MINS")
+)
+
+// ticker used in Run to trigger reconciliation periodically
+var janitorTicker *time.Ticker
+
+// stopJanitor channel used to stop long running Run() method
+var stopJanitor chan bool
+
+// Janitor reconciles the content store against gang content IDs and library items saved in DB.
+type Janitor interface {
+	// Delete orphan, unstreamed and stale partial uploads, expire library items and reconcile storage usage and ingress leases
+	Reconcile(ctx context.Context) error
+	// Reconcile right away and then periodically till Cleanup() gets called
+	Run(ctx context.Context)
+}
+
+type janitor struct {
+	livekit_config entity.LivekitConfig
+	contentStore   objectstore.Store
+	gangRepo       gang.Repository
+	libraryRepo    library.Repository
+	blobRepo       blob.Repository
+	metricsService metrics.Service
+	sseService     sse.Service
+	logger         log.Logger
+}
+
+func NewJanitor(
+	livekit_config entity.LivekitConfig,
+	contentStore objectstore.Store,
+	gangRepo gang.Repository,
+	libraryRepo library.Repository,
+	blobRepo blob.Repository,
+	metricsService metrics.Service,
+	sseService sse.Service,
+	logger log.Logger) Janitor {
+	return janitor{livekit_config, contentStore, gangRepo, libraryRepo, blobRepo, metricsService, sseService, logger}
+}
+
+// Helper to parse duration in minutes, falls back to the default one if not set.
+func minutes(value string, fallback time.Duration) time.Duration {
+	mins, err := strconv.Atoi(value)
+	if err != nil || mins <= 0 {
+		return fallback
+	}
+	return time.Duration(mins) * time.Minute
+}
+
+func (j janitor) Run(ctx context.Context) {
+	janitorTicker = time.NewTicker(minutes(UPLOAD_JANITOR_INTERVAL_MINS, 5*time.Minute))
+	stopJanitor = make(chan bool)
+	j.logger.WithCtx(ctx).Info().Msg("Launching upload Janitor")
+	// Uploads left behind by the previous run are taken care of right away
+	j.Reconcile(ctx)
+	for {
+		select {
+		case <-janitorTicker.C:
+			j.Reconcile(ctx)
+		case <-stopJanitor:
+			janitorTicker.Stop()
+			j.logger.WithCtx(ctx).Info().Msg("Successfully stopped upload Janitor")
+			return
+		}
+	}
+}
+
+func (j janitor) Reconcile(ctx context.Context) error {
+	unstreamedTTL := minutes(UNSTREAMED_CONTENT_TTL_MINS, 10*time.Minute)
+	partialTTL := minutes(PARTIAL_UPLOAD_TTL_MINS, 24*time.Hour)
+	// Leases acquired after this are left alone, their holders may not be listed below
+	started := time.Now()
+
+	// Gangs are listed before uploads, so that contents finished in between aren't taken as orphans.
+	// Deduplicated contents can be held by several gangs at once.
+	gangs := map[string][]entity.GangResponse{}
+	leaseHolders := []string{}
+	cursor := uint64(0)
+	for {
+		gangList, newCursor, dberr := j.gangRepo.ListGangs(ctx, j.logger, cursor)
+		if dberr != nil {
+			// Error in ListGangs()
+			return dberr
+		}
+		for _, gang := range gangList {
+			if gang.ContentID != "" {
+				gangs[gang.ContentID] = append(gangs[gang.ContentID], gang)
+			} else if gang.Streaming && gang.ContentURL != "" {
+				leaseHolders = append(leaseHolders, gang.Admin)
+			}
+		}
+		if newCursor == 0 {
+			break
+		}
+		cursor = newCursor
+	}
+	items, dberr := j.libraryRepo.ListLibraryItems(ctx, j.logger)
+	if dberr != nil {
+		// Error in ListLibraryItems()
+		return dberr
+	}
+	uploads, strerr := j.contentStore.List(ctx)
+	if strerr != nil {
+		j.logger.WithCtx(ctx).Error().Err(strerr).Msg("Error occured during listing content store in storage.Reconcile")
+		return errors.InternalServerError("")
+	}
+
+	now := time.Now()
+	listed := map[string]bool{}
+	uploadIDs := []string{}
+	for _, upload := range uploads {
+		listed[upload.ID] = true
+		uploadIDs = append(uploadIDs, upload.ID)
+		modified := time.Unix(upload.Modified, 0)
+		holders, referenced := gangs[upload.ID]
+		kept, inLibrary := items[upload.ID]
+		switch {
+		case !upload.Finished():
+			// Uploads missing either of their files are never finished and go the same way
+			if now.Sub(modified) > partialTTL {
+				j.logger.WithCtx(ctx).Info().Msgf("Deleting stale partial upload - %s", upload.ID)
+				j.deleteUpload(ctx, upload.ID)
+			}
+		case !referenced && !inLibrary:
+			if now.Sub(modified) > unstreamedTTL {
+				// Nothing holds the content, references left behind go along with it
+				j.logger.WithCtx(ctx).Info().Msgf("Deleting orphan upload - %s", upload.ID)
+				j.blobRepo.DelBlob(ctx, j.logger, upload.ID)
+				j.deleteUpload(ctx, upload.ID)
+			}
+		default:
+			// Library items are idle since they were last attached, unless attached right now
+			for _, item := range kept {
+				if now.Sub(time.Unix(item.LastUsed, 0)) > library.Retention() && !attached(holders, item.Owner) {
+					j.logger.WithCtx(ctx).Info().Msgf("Deleting expired library item - %s of %s", upload.ID, item.Owner)
+					if j.libraryRepo.DelLibraryItem(ctx, j.logger, item) == nil {
+						j.deleteUpload(ctx, upload.ID)
+					}
+				}
+			}
+			remaining := []entity.GangResponse{}
+			for _, gang := range holders {
+				updated := modified
+				if gang.ContentUpdated != 0 {
+					updated = time.Unix(gang.ContentUpdated, 0)
+				}
+				if gang.Streaming || now.Sub(updated) <= unstreamedTTL || !j.eraseGangContent(ctx, gang.Admin, upload.ID) {
+					remaining = append(remaining, gang)
+				} else if !gang.ContentLibrary {
+					// Library items are only detached from the gang, their files and storage are kept
+					j.deleteUpload(ctx, upload.ID)
+				}
+			}
+			gangs[upload.ID] = remaining
+		}
+	}
+	// Library items whose content went missing can never be attached again
+	for contentID, kept := range items {
+		if listed[contentID] {
+			continue
+		}
+		for _, item := range kept {
+			if j.libraryRepo.DelLibraryItem(ctx, j.logger, item) == nil {
+				j.logger.WithCtx(ctx).Info().Msgf("Deleting library item of missing content - %s of %s", contentID, item.Owner)
+			}
+		}
+		j.blobRepo.DelBlob(ctx, j.logger, contentID)
+		j.metricsService.ReleaseStorage(ctx, contentID)
+	}
+	// Gangs referring to contents missing from the store can never stream them
+	for contentID, holders := range gangs {
+		if listed[contentID] {
+			for _, gang := range holders {
+				leaseHolders = append(leaseHolders, gang.Admin)
+			}
+			continue
+		}
+		erased := true
+		for _, gang := range holders {
+			if gang.Streaming || !j.eraseGangContent(ctx, gang.Admin, contentID) {
+				leaseHolders = append(leaseHolders, gang.Admin)
+				erased = false
+			}
+		}
+		if erased {
+			j.blobRepo.DelBlob(ctx, j.logger, contentID)
+			j.metricsService.ReleaseStorage(ctx, contentID)
+		}
+	}
+
+	// Reservations lost along the way, e.g. by uploads which failed to get created, would block users from uploading
+	storerr := j.metricsService.ReconcileStorage(ctx, uploadIDs, started)
+
+	// Every gang holding an uploaded content holds an ingress as well, streaming or not.
+	// Ingresses Popcorn lost track of still count towards the livekit limit till they end.
+	ingressHolders, ingerr := gang.ListIngressHolders(ctx, j.logger, j.livekit_config)
+	if ingerr != nil {
+		j.logger.WithCtx(ctx).Warn().Msg("Reconciling ingress leases against gangs alone")
+	}
+	if dberr := j.metricsService.ReconcileIngress(ctx, append(leaseHolders, ingressHolders...), started); dberr != nil {
+		// Error in ReconcileIngress()
+		return dberr
+	}
+	return storerr
+}
+
+// Returns true if library item of owner is attached to the gang of owner.
+func attached(holders []entity.GangResponse, owner string) bool {
+	for _, gang := range holders {
+		if gang.Admin == owner && gang.ContentLibrary {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to drop a reference to upload, which is deleted from the store along with the storage accounted for it
+// once nothing refers to it anymore.
+func (j janitor) deleteUpload(ctx context.Context, contentID string) {
+	if cleanup.DeleteContentFiles(j.contentStore, j.blobRepo, contentID, j.logger) {
+		j.metricsService.ReleaseStorage(ctx, contentID)
+	}
+}
+
+// Helper to erase unstreamed content of a gang, returns false if gang started streaming in the meantime.
+// Content files and storage are left to the caller.
+func (j janitor) eraseGangContent(ctx context.Context, admin, contentID string) bool {
+	gangData, dberr := j.gangRepo.GetGang(ctx, j.logger, "gang:"+admin, admin, false)
+	if dberr != nil || gangData.ContentID != contentID || gangData.Streaming {
+		return false
+	}
+	j.logger.WithCtx(ctx).Info().Msgf("Erasing unstreamed content for: gang:%s", admin)
+	// Erase gang content data from DB
+	j.gangRepo.UpdateGangContentData(ctx, j.logger, admin, "", "", "", false, false)
+	// Notify the members that content is gone
+	members, _ := j.gangRepo.GetGangMembers(ctx, j.logger, admin)
+	for _, member := range members {
+		go func(member string) {
+			data := entity.SSEData{
+				Data: nil,
+				Type: "gangUpdate",
+				To:   member,
+			}
+			j.sseService.GetOrSetEvent(ctx).Message <- data
+		}(member)
+	}
+	return true
+}
+
+// Stops long running Run() method, if launched.
+func Cleanup(ctx context.Context) {
+	if stopJanitor == nil {
+		return
+	}
+	stopJanitor <- true
+	close(stopJanitor)
+}
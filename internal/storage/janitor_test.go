@@ -0,0 +1,219 @@
+// Upload Janitor tests in Popcorn.
+
+package storage
+
+import (
+	"Popcorn/internal/blob"
+	"Popcorn/internal/entity"
+	"Popcorn/internal/gang"
+	"Popcorn/internal/library"
+	"Popcorn/internal/metrics"
+	"Popcorn/internal/sse"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/signedurl"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJanitorReconcile(t *testing.T) {
+	dir := t.TempDir()
+	store, strerr := objectstore.NewLocalStore(dir, "", signedurl.NewSigner([]byte("popcorn"), time.Hour))
+	if strerr != nil {
+		t.Fatal(strerr)
+	}
+	gangRepo := gang.NewRepository(client)
+	metricsService := metrics.NewService(entity.LivekitConfig{}, metrics.NewRepository(client), logger)
+	libraryRepo := library.NewRepository(client)
+	blobRepo := blob.NewRepository(client)
+	janitor := NewJanitor(entity.LivekitConfig{}, store, gangRepo, libraryRepo, blobRepo, metricsService, sse.NewService(logger), logger)
+
+	// Helper to make uploads look idle since a day
+	stale := func(id string) {
+		old := time.Now().Add(-24 * time.Hour)
+		if oserr := os.Chtimes(filepath.Join(dir, id), old, old); oserr != nil {
+			t.Fatal(oserr)
+		}
+	}
+	// Helper to make gang content look idle since a day
+	staleGang := func(admin string) {
+		if dberr := client.Client().HSet(ctx, "gang:"+admin, "gang_content_updated", time.Now().Add(-24*time.Hour).Unix()).Err(); dberr != nil {
+			t.Fatal(dberr)
+		}
+	}
+	// Helper to create a gang holding content
+	createGang := func(admin, contentID, contentURL string, streaming bool) {
+		_, dberr := gangRepo.SetOrUpdateGang(ctx, logger, &entity.Gang{
+			Admin:          admin,
+			Name:           admin + " Gang",
+			PassKey:        "12345",
+			Limit:          2,
+			MembersListKey: "gang-members:" + admin,
+		}, false)
+		if dberr == nil {
+			dberr = gangRepo.UpdateGangContentData(ctx, logger, admin, "movie.mp4", contentID, contentURL, false, streaming)
+		}
+		if dberr != nil {
+			t.Fatal(dberr)
+		}
+	}
+
+	unstreamed := upload(t, store, 64, 64)
+	stale(unstreamed)
+	createGang("Unstreamed_Admin", unstreamed, "", false)
+	staleGang("Unstreamed_Admin")
+	streaming := upload(t, store, 64, 64)
+	stale(streaming)
+	createGang("Streaming_Admin", streaming, "", true)
+	createGang("URL_Admin", "", "https://popcorn.test/movie.mp4", true)
+	createGang("Missing_Admin", "missing-content", "", false)
+
+	orphan := upload(t, store, 64, 64)
+	stale(orphan)
+	freshOrphan := upload(t, store, 64, 64)
+	partial := upload(t, store, 64, 10)
+	stale(partial)
+	freshPartial := upload(t, store, 64, 10)
+
+	// Helper to leave only one of the files an upload is made of, as a crash mid-create or mid-delete does
+	unpaired := func(removed, kept string, age time.Duration) string {
+		id := upload(t, store, 64, 64)
+		if oserr := os.Remove(filepath.Join(dir, id+removed)); oserr != nil {
+			t.Fatal(oserr)
+		}
+		old := time.Now().Add(-age)
+		if oserr := os.Chtimes(filepath.Join(dir, id+kept), old, old); oserr != nil {
+			t.Fatal(oserr)
+		}
+		return id
+	}
+	unpaired(".info", "", 24*time.Hour+time.Minute)
+	unpaired("", ".info", 24*time.Hour+time.Minute)
+	freshData := unpaired(".info", "", time.Hour)
+	freshInfo := unpaired("", ".info", time.Hour)
+
+	// Helper to keep upload in the library of user, last used the given time ago
+	keep := func(id, owner string, lastUsed time.Duration) entity.LibraryItem {
+		item := entity.LibraryItem{
+			ID:       id,
+			Owner:    owner,
+			Name:     "movie.mp4",
+			Size:     64,
+			Created:  time.Now().Add(-lastUsed).Unix(),
+			LastUsed: time.Now().Add(-lastUsed).Unix(),
+		}
+		assert.NoError(t, libraryRepo.SetLibraryItem(ctx, logger, item))
+		assert.NoError(t, metricsService.ReserveStorage(ctx, owner, 64))
+		assert.NoError(t, metricsService.TrackUpload(ctx, owner, id, 64))
+		assert.NoError(t, metricsService.CommitStorage(ctx, owner, id, 64))
+		return item
+	}
+	kept := upload(t, store, 64, 64)
+	stale(kept)
+	keep(kept, "Library_User", time.Hour)
+	expired := upload(t, store, 64, 64)
+	keep(expired, "Library_User", library.Retention()+time.Hour)
+	attached := upload(t, store, 64, 64)
+	keep(attached, "Library_Admin", 24*time.Hour)
+	createGang("Library_Admin", attached, "", false)
+	assert.NoError(t, gangRepo.SetGangContentLibrary(ctx, logger, "Library_Admin", true))
+	staleGang("Library_Admin")
+	keep("missing-item", "Library_User", time.Hour)
+
+	// Deduplicated content held by two gangs, only one of them is done with it
+	shared := upload(t, store, 64, 64)
+	stale(shared)
+	for i := 0; i < 2; i++ {
+		_, dberr := blobRepo.SetOrAcquireBlob(ctx, logger, entity.Blob{ID: shared, Hash: "shared", Size: 64})
+		assert.NoError(t, dberr)
+	}
+	createGang("Shared_Stale_Admin", shared, "", false)
+	staleGang("Shared_Stale_Admin")
+	createGang("Shared_Fresh_Admin", shared, "", false)
+	// Deduplicated orphan takes its blob along
+	blobOrphan := upload(t, store, 64, 64)
+	stale(blobOrphan)
+	_, dberr := blobRepo.SetOrAcquireBlob(ctx, logger, entity.Blob{ID: blobOrphan, Hash: "orphan", Size: 64})
+	assert.NoError(t, dberr)
+
+	// Stale partial upload holds storage in flight
+	assert.NoError(t, metricsService.ReserveStorage(ctx, "Partial_User", 64))
+	assert.NoError(t, metricsService.TrackUpload(ctx, "Partial_User", partial, 64))
+	// Reservation of an upload which never got created, and storage of an upload gone from the store
+	assert.NoError(t, metricsService.ReserveStorage(ctx, "Leaked_User", 64))
+	assert.NoError(t, metricsService.ReserveStorage(ctx, "Gone_User", 64))
+	assert.NoError(t, metricsService.TrackUpload(ctx, "Gone_User", "gone-upload", 64))
+	assert.NoError(t, metricsService.CommitStorage(ctx, "Gone_User", "gone-upload", 64))
+	// Leases left behind by gangs gone in the meantime
+	for _, holder := range []string{"Gone_Admin1", "Gone_Admin2"} {
+		assert.NoError(t, metricsService.HoldIngress(ctx, holder))
+	}
+
+	assert.NoError(t, janitor.Reconcile(ctx))
+
+	objects, strerr := store.List(ctx)
+	assert.NoError(t, strerr)
+	remaining := []string{}
+	for _, object := range objects {
+		remaining = append(remaining, object.ID)
+	}
+	assert.ElementsMatch(t, []string{streaming, freshOrphan, freshPartial, freshData, freshInfo, kept, attached, shared}, remaining)
+	// Stale uploads missing either of their files are deleted along with the one left
+	entries, oserr := os.ReadDir(dir)
+	assert.NoError(t, oserr)
+	assert.Len(t, entries, 2*6+2)
+
+	// Gangs which can't stream their content anymore are erased
+	for admin, contentID := range map[string]string{
+		"Unstreamed_Admin":   "",
+		"Missing_Admin":      "",
+		"Streaming_Admin":    streaming,
+		"Library_Admin":      "",
+		"Shared_Stale_Admin": "",
+		"Shared_Fresh_Admin": shared,
+	} {
+		gangData, dberr := gangRepo.GetGang(ctx, logger, "gang:"+admin, admin, false)
+		assert.NoError(t, dberr)
+		assert.Equal(t, contentID, gangData.ContentID, admin)
+	}
+
+	// Streaming gangs hold an ingress each
+	metricsData, dberr := metricsService.GetMetrics(ctx)
+	assert.NoError(t, dberr)
+	assert.Equal(t, 3, metricsData.ActiveIngress)
+
+	// Gang done with deduplicated content only dropped its reference
+	last, dberr := blobRepo.ReleaseBlob(ctx, logger, shared)
+	assert.NoError(t, dberr)
+	assert.True(t, last)
+	_, dberr = blobRepo.AcquireBlob(ctx, logger, "orphan")
+	assert.Error(t, dberr)
+
+	usage, dberr := metricsService.GetStorageUsage(ctx, "Partial_User")
+	assert.NoError(t, dberr)
+	assert.Zero(t, usage.InFlight)
+	usage, dberr = metricsService.GetStorageUsage(ctx, "Leaked_User")
+	assert.NoError(t, dberr)
+	assert.Zero(t, usage.InFlight)
+	usage, dberr = metricsService.GetStorageUsage(ctx, "Gone_User")
+	assert.NoError(t, dberr)
+	assert.Zero(t, usage.Stored)
+	assert.Zero(t, client.Client().Exists(ctx, "storage-upload:gone-upload").Val())
+
+	// Expired library items and the ones missing their content are gone, detached ones stay accounted
+	items, dberr := libraryRepo.ListLibraryItems(ctx, logger)
+	assert.NoError(t, dberr)
+	assert.Contains(t, items, kept)
+	assert.Contains(t, items, attached)
+	assert.NotContains(t, items, expired)
+	assert.NotContains(t, items, "missing-item")
+	usage, dberr = metricsService.GetStorageUsage(ctx, "Library_User")
+	assert.NoError(t, dberr)
+	assert.Equal(t, int64(64), usage.Stored)
+	usage, dberr = metricsService.GetStorageUsage(ctx, "Library_Admin")
+	assert.NoError(t, dberr)
+	assert.Equal(t, int64(64), usage.Stored)
+}
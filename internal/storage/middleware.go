@@ -8,17 +8,16 @@ import (
 	"Popcorn/internal/gang"
 	"Popcorn/internal/metrics"
 	"Popcorn/pkg/log"
-	"context"
+	"Popcorn/pkg/objectstore"
 	"net/http"
 	"strconv"
-	"syscall"
 
 	"github.com/gin-gonic/gin"
 )
 
 // As only gang admin can do anything regarding content (upload / update / delete),
 // This middleware is needed to validate incoming tus requests.
-func ContentStorageMiddleware(logger log.Logger, livekit_config entity.LivekitConfig, metricsService metrics.Service, gangRepo gang.Repository) gin.HandlerFunc {
+func ContentStorageMiddleware(logger log.Logger, livekit_config entity.LivekitConfig, metricsService metrics.Service, gangRepo gang.Repository, contentStore objectstore.Store) gin.HandlerFunc {
 	return func(gctx *gin.Context) {
 		// Fetch username from context which will be used as the gang admin
 		user, ok := gctx.Value("User").(entity.User)
@@ -59,18 +58,20 @@ func ContentStorageMiddleware(logger log.Logger, livekit_config entity.LivekitCo
 			gctx.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		// Check if enough disk space is available to accept another content
+		// Check if enough storage space is available to accept another content
 		// Convert MAX_UPLOAD_SIZE to int64
 		contentUploadSize, err := strconv.ParseInt(MAX_UPLOAD_SIZE, 10, 64)
 		if err != nil {
 			// Set default to 524MBs
 			contentUploadSize = 524288000
 		}
-		diskSpaceAvail, err := getAvailableDiskSpace(gctx, logger)
+		diskSpaceAvail, err := contentStore.AvailableSpace(gctx)
 		if err != nil {
+			// Error occured in AvailableSpace()
+			logger.WithCtx(gctx).Error().Err(err).Msg("Error occured while trying to fetch available storage space")
 			gctx.AbortWithStatus(http.StatusInternalServerError)
 			return
-		} else if diskSpaceAvail-52428800 < uint64(contentUploadSize) {
+		} else if diskSpaceAvail < uint64(contentUploadSize)+52428800 {
 			// Not enough space available
 			gctx.AbortWithStatus(http.StatusInsufficientStorage)
 			return
@@ -85,15 +86,3 @@ func ContentStorageMiddleware(logger log.Logger, livekit_config entity.LivekitCo
 		gctx.Next()
 	}
 }
-
-// Helper method to get available disk space
-func getAvailableDiskSpace(ctx context.Context, logger log.Logger) (uint64, error) {
-	fs := syscall.Statfs_t{}
-	err := syscall.Statfs(UPLOAD_PATH, &fs)
-	if err != nil {
-		// Error occured in Statfs()
-		logger.WithCtx(ctx).Error().Err(err).Msg("Error occured while trying to fetch available disk space")
-		return 0, err
-	}
-	return fs.Bfree * uint64(fs.Bsize), err
-}
@@ -0,0 +1,211 @@
+// Service layer of the internal package storage.
+// Uploads are deduplicated by their content hash, so content stored already is attached to the gang without being uploaded again.
+
+package storage
+
+import (
+	"Popcorn/internal/blob"
+	"Popcorn/internal/entity"
+	"Popcorn/internal/errors"
+	"Popcorn/internal/gang"
+	"Popcorn/internal/library"
+	"Popcorn/internal/metrics"
+	"Popcorn/internal/sse"
+	"Popcorn/pkg/log"
+	"Popcorn/pkg/mediaprobe"
+	"Popcorn/pkg/objectstore"
+	"Popcorn/pkg/tracing"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+)
+
+// Service layer of internal package storage which encapsulates content deduplication logic of Popcorn.
+type Service interface {
+	// Attach content stored already to the gang created by user, so that its upload can be skipped
+	checkcontenthash(ctx context.Context, username string, check entity.ContentHashCheck) error
+}
+
+// Object of this will be passed around from main to routers to API.
+// Helps to access the service layer interface and call methods.
+// Also helps to pass objects to be used from outer layer.
+type service struct {
+	livekit_config entity.LivekitConfig
+	contentStore   objectstore.Store
+	gangRepo       gang.Repository
+	libraryRepo    library.Repository
+	blobRepo       blob.Repository
+	metricsService metrics.Service
+	sseService     sse.Service
+	logger         log.Logger
+}
+
+// Helps to access the service layer interface and call methods. Service object is passed from main.
+func NewService(
+	livekit_config entity.LivekitConfig,
+	contentStore objectstore.Store,
+	gangRepo gang.Repository,
+	libraryRepo library.Repository,
+	blobRepo blob.Repository,
+	metricsService metrics.Service,
+	sseService sse.Service,
+	logger log.Logger) Service {
+	return service{livekit_config, contentStore, gangRepo, libraryRepo, blobRepo, metricsService, sseService, logger}
+}
+
+func (s service) checkcontenthash(ctx context.Context, username string, check entity.ContentHashCheck) error {
+	_, valerr := govalidator.ValidateStruct(check)
+	if valerr != nil {
+		// Error occured during validation
+		valerr := valerr.(govalidator.Errors).Errors()
+		return errors.GenerateValidationErrorResponse(valerr)
+	}
+	gangData, dberr := s.gangRepo.GetGang(ctx, s.logger, "gang:"+username, username, false)
+	if dberr != nil {
+		// Error in GetGang()
+		return dberr
+	} else if gangData.Admin == "" {
+		// Not an admin
+		return errors.BadRequest("user needs to create a gang")
+	} else if gangData.ContentID != "" || gangData.ContentURL != "" || gangData.ContentScreenShare || gangData.Streaming {
+		// Either file or link or share
+		valerr := errors.New("gang:Can only have file or link or screenshare as a content")
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+	// Skipped upload holds an ingress just like a finished one
+	metricsData, dberr := s.metricsService.GetMetrics(ctx)
+	if dberr != nil {
+		// Error in GetMetrics()
+		return dberr
+	}
+	acquired := false
+	if !metricsData.IngressQuotaExceeded {
+		acquired, dberr = s.metricsService.AcquireIngress(ctx, username)
+		if dberr != nil {
+			// Error in AcquireIngress()
+			return dberr
+		}
+	}
+	if !acquired {
+		// Livekit ingress quota or concurrent ingress limit exceeded
+		valerr := errors.New("gang:Max concurrent File livestream limit or monthly quota exceeded")
+		return errors.GenerateValidationErrorResponse([]error{valerr})
+	}
+
+	// Not found unless the content is stored already, client goes on uploading it then
+	blob, dberr := s.blobRepo.AcquireBlob(ctx, s.logger, strings.ToLower(check.Hash))
+	if dberr != nil {
+		// Error in AcquireBlob()
+		s.metricsService.ReleaseIngress(ctx, username)
+		return dberr
+	}
+	if check.Library {
+		// Library has a limited number of slots, unless the content is kept there already
+		_, dberr = s.libraryRepo.GetLibraryItem(ctx, s.logger, username, blob.ID)
+		if err, ok := dberr.(errors.ErrorResponse); ok && err.StatusCode() == http.StatusNotFound {
+			dberr = library.CheckCapacity(ctx, s.logger, s.libraryRepo, username)
+		}
+	}
+	if dberr == nil {
+		dberr = s.setgangcontent(ctx, username, check.Filename, blob, check.Library)
+	}
+	if dberr != nil {
+		// Reference and ingress taken above aren't held by anyone
+		s.blobRepo.ReleaseBlob(ctx, s.logger, blob.ID)
+		s.metricsService.ReleaseIngress(ctx, username)
+		return dberr
+	}
+	// Send notifications to gang Members about the updates
+	members, _ := s.gangRepo.GetGangMembers(ctx, s.logger, username)
+	for _, member := range members {
+		go func(member string) {
+			data := entity.SSEData{
+				Data: nil,
+				Type: "gangUpdate",
+				To:   member,
+			}
+			s.sseService.GetOrSetEvent(ctx).Message <- data
+		}(member)
+	}
+	return nil
+}
+
+// Helper to make blob the content of the gang created by user, as if user had just uploaded it.
+// Reference to blob taken by the caller is held by the gang, or by the library item if the content is kept in the library.
+func (s service) setgangcontent(ctx context.Context, username, filename string, blob entity.Blob, keep bool) error {
+	dberr := s.gangRepo.UpdateGangContentData(ctx, s.logger, username, filename, blob.ID, "", false, false)
+	if dberr != nil {
+		// Error occured in UpdateGangContentData()
+		return dberr
+	}
+	dberr = s.gangRepo.SetGangContentMeta(ctx, s.logger, username, []byte(blob.Meta))
+	if dberr != nil {
+		// Error occured in SetGangContentMeta()
+		return dberr
+	}
+	if keep {
+		// Kept after the stream ends, storage stays accounted till the item gets deleted
+		now := time.Now().Unix()
+		item, dberr := s.libraryRepo.GetLibraryItem(ctx, s.logger, username, blob.ID)
+		if dberr == nil {
+			// Library item holds a reference of its own already
+			s.blobRepo.ReleaseBlob(ctx, s.logger, blob.ID)
+		} else if err, ok := dberr.(errors.ErrorResponse); ok && err.StatusCode() == http.StatusNotFound {
+			item = entity.LibraryItem{
+				ID:      blob.ID,
+				Owner:   username,
+				Name:    filename,
+				Size:    blob.Size,
+				Meta:    blob.Meta,
+				Created: now,
+			}
+		} else {
+			// Error occured in GetLibraryItem()
+			return dberr
+		}
+		item.LastUsed = now
+		dberr = s.libraryRepo.SetLibraryItem(ctx, s.logger, item)
+		if dberr == nil {
+			dberr = s.gangRepo.SetGangContentLibrary(ctx, s.logger, username, true)
+		}
+		if dberr != nil {
+			// Error occured in SetLibraryItem() or SetGangContentLibrary()
+			return dberr
+		}
+	}
+	var mediaInfo mediaprobe.Info
+	if jsonerr := json.Unmarshal([]byte(blob.Meta), &mediaInfo); jsonerr == nil &&
+		len(mediaInfo.Subtitles) != 0 && mediaInfo.Container != mediaprobe.ContainerMP4 {
+		// Text subtitle tracks are walked through the whole content, so not making the uploader wait
+		go gang.ExtractContentSubtitles(tracing.Detach(ctx), s.logger, s.gangRepo, s.sseService, s.contentStore, username, blob.ID, blob.Size)
+	}
+	s.gangRepo.AddGangActivity(ctx, s.logger, username, entity.GangActivity{
+		Actor:   username,
+		Action:  gang.ActivityUpload,
+		Target:  filename,
+		Created: time.Now().Unix(),
+	})
+	// Gang holds an ingress from here on, leased already if the upload got skipped
+	return s.metricsService.HoldIngress(ctx, username)
+}
+
+// Helper to compute hex encoded sha256 of a finished upload.
+func hashContent(ctx context.Context, contentStore objectstore.Store, contentID string) (string, error) {
+	file, strerr := contentStore.Open(ctx, contentID)
+	if strerr != nil {
+		return "", strerr
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, ioerr := io.Copy(hash, file); ioerr != nil {
+		return "", ioerr
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -18,6 +18,7 @@ func APIHandlers(router *gin.Engine, service Service, AuthWithAcc gin.HandlerFun
 	{
 		userGroup.GET("/get", AuthWithAcc, getUser(service, logger))
 		userGroup.GET("/search", AuthWithAcc, searchUser(service, logger))
+		userGroup.GET("/usage", AuthWithAcc, getUsage(service, logger))
 	}
 }
 
@@ -51,6 +52,35 @@ func getUser(service Service, logger log.Logger) gin.HandlerFunc {
 	}
 }
 
+// getUsage returns a handler which takes care of getting monthly streaming usage of the user.
+func getUsage(service Service, logger log.Logger) gin.HandlerFunc {
+	return func(gctx *gin.Context) {
+		user, ok := gctx.Value("User").(entity.User)
+		if !ok {
+			// Type assertion error
+			logger.WithCtx(gctx).Error().Msg("Type assertion error in get_usage")
+			gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+			return
+		}
+		// Apply the service logic for Get Usage in Popcorn
+		usage, err := service.getusage(gctx, user.Username)
+		if err != nil {
+			// Error occured, might be validation or server error
+			err, ok := err.(errors.ErrorResponse)
+			if !ok {
+				// Type assertion error
+				gctx.AbortWithStatusJSON(http.StatusInternalServerError, errors.InternalServerError(""))
+				return
+			}
+			gctx.AbortWithStatusJSON(err.Status, err)
+			return
+		}
+		gctx.JSON(http.StatusOK, gin.H{
+			"usage": usage,
+		})
+	}
+}
+
 // searchUser returns a handler which takes care of user search in Popcorn.
 func searchUser(service Service, logger log.Logger) gin.HandlerFunc {
 	return func(gctx *gin.Context) {
@@ -4,6 +4,7 @@ package user
 
 import (
 	"Popcorn/internal/entity"
+	"Popcorn/internal/metrics"
 	"Popcorn/internal/test"
 	"Popcorn/pkg/db"
 	"Popcorn/pkg/log"
@@ -17,6 +18,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/gin-gonic/gin"
@@ -36,6 +38,9 @@ var client *db.RedisDB
 // Global instance of user Repository to be used during user API testing.
 var userRepo Repository
 
+// Global instance of metrics Service to be used during user API testing.
+var metricsService metrics.Service
+
 // Global context
 var ctx context.Context = context.Background()
 
@@ -68,9 +73,10 @@ func setupMockRouter(dbConnWrp *db.RedisDB, logger log.Logger) {
 
 	// Repositories needed by user APIs and services to work
 	userRepo = NewRepository(dbConnWrp)
+	metricsService = metrics.NewService(entity.LivekitConfig{}, metrics.NewRepository(dbConnWrp), logger)
 
 	// Register internal package user handler
-	userService := NewService(userRepo, logger)
+	userService := NewService(userRepo, metricsService, logger)
 	APIHandlers(mockRouter, userService, test.MockAuthMiddleware(logger), logger)
 }
 
@@ -296,3 +302,32 @@ func TestSearchUserPaginated(t *testing.T) {
 	assert.True(t, len(searchResult.Result) >= 1)
 	assert.True(t, searchResult.Page == 0)
 }
+
+func TestGetUsage(t *testing.T) {
+	defer func(ingress string) { metrics.USER_MONTHLY_INGRESS_MINUTES = ingress }(metrics.USER_MONTHLY_INGRESS_MINUTES)
+	metrics.USER_MONTHLY_INGRESS_MINUTES = "10"
+	username := "usage." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	metricsService.AddStreamUsage(ctx, username, false, 90)
+	metricsService.AddStreamUsage(ctx, username, false, 30)
+	metricsService.AddStreamUsage(ctx, username, true, 45)
+
+	// Usage of the current month is returned along with the budgets, screen shares are unlimited here
+	request := test.RequestAPITest{
+		Method:       http.MethodGet,
+		Path:         "/api/user/usage",
+		Body:         bytes.NewReader([]byte{}),
+		WantResponse: []int{http.StatusOK},
+		Header:       test.MockHeader(),
+		Cookie:       []*http.Cookie{test.MockAuthAllowCookie, {Name: "user", Value: username}},
+	}
+	response := test.ExecuteAPITest(logger, t, mockRouter, &request)
+	result := struct {
+		Usage entity.StreamUsage `json:"usage"`
+	}{}
+	assert.Nil(t, json.Unmarshal(response.Body, &result))
+	assert.Equal(t, time.Now().UTC().Format("2006-01"), result.Usage.Month)
+	assert.Equal(t, int64(120), result.Usage.Ingress)
+	assert.Equal(t, int64(45), result.Usage.ScreenShare)
+	assert.Equal(t, int64(600), result.Usage.IngressBudget)
+	assert.Equal(t, int64(0), result.Usage.ScreenShareBudget)
+}
@@ -7,6 +7,7 @@ import (
 	"Popcorn/internal/errors"
 	"Popcorn/pkg/db"
 	"Popcorn/pkg/log"
+	"Popcorn/pkg/tracing"
 	"context"
 	"time"
 
@@ -28,6 +29,10 @@ type Repository interface {
 	GetStreamingToken(ctx context.Context, logger log.Logger, username string) string
 	// DelStreamingToken deletes the user streaming token from DB.
 	DelStreamingToken(ctx context.Context, logger log.Logger, username string)
+	// SetUserOperator grants or revokes the operator role of an user.
+	SetUserOperator(ctx context.Context, logger log.Logger, username string, operator bool) error
+	// SetUserStatus updates the account status of an user, suspendedUntil is only used with suspended status.
+	SetUserStatus(ctx context.Context, logger log.Logger, username string, status string, suspendedUntil int64) error
 }
 
 // repository struct of user Repository.
@@ -44,6 +49,8 @@ func NewRepository(dbwrp *db.RedisDB) Repository {
 
 // Returns the user data object if user with the given username is found in the DB.
 func (r repository) GetUser(ctx context.Context, logger log.Logger, username string) (entity.User, error) {
+	ctx, span := tracing.Start(ctx, "user.GetUser")
+	defer span.End()
 	user := entity.User{}
 	available, dberr := r.db.Client().HExists(ctx, "user:"+username, "username").Result()
 	if dberr != nil && dberr != redis.Nil {
@@ -64,6 +71,8 @@ func (r repository) GetUser(ctx context.Context, logger log.Logger, username str
 
 // Returns true if user got successfully added or updated into the DB.
 func (r repository) SetOrUpdateUser(ctx context.Context, logger log.Logger, ue entity.User, userExistCheck bool) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.SetOrUpdateUser")
+	defer span.End()
 	if !userExistCheck {
 		// Checking if an user with username ue.username exists in the DB
 		available, dberr := r.HasUser(ctx, logger, ue.Username)
@@ -118,6 +127,8 @@ func (r repository) SetOrUpdateUser(ctx context.Context, logger log.Logger, ue e
 
 // Returns true if user with the given username exists in Popcorn.
 func (r repository) HasUser(ctx context.Context, logger log.Logger, username string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.HasUser")
+	defer span.End()
 	available, dberr := r.db.Client().Exists(ctx, "user:"+username).Result()
 	if dberr != nil && dberr != redis.Nil {
 		// Error during interacting with DB
@@ -132,6 +143,8 @@ func (r repository) HasUser(ctx context.Context, logger log.Logger, username str
 
 // Returns user data matching incoming query in DB.
 func (r repository) SearchUser(ctx context.Context, logger log.Logger, query entity.UserSearch) ([]entity.User, uint64, error) {
+	ctx, span := tracing.Start(ctx, "user.SearchUser")
+	defer span.End()
 	searchBy := query.Username + "*"
 	initialResult, newCursor, dberr := r.db.Client().SScan(ctx, "user:index", uint64(query.Cursor), searchBy, 10).Result()
 	if dberr != nil && dberr != redis.Nil {
@@ -176,6 +189,8 @@ func (r repository) SearchUser(ctx context.Context, logger log.Logger, query ent
 
 // Adds a newly created user gang content streaming token to DB.
 func (r repository) AddStreamingToken(ctx context.Context, logger log.Logger, username, token string) {
+	ctx, span := tracing.Start(ctx, "user.AddStreamingToken")
+	defer span.End()
 	dberr := r.db.Client().Set(ctx, "stream_token:"+username, token, time.Hour*3).Err()
 	if dberr != nil {
 		// Error during interacting with DB
@@ -185,6 +200,8 @@ func (r repository) AddStreamingToken(ctx context.Context, logger log.Logger, us
 
 // Get user streaming token if available.
 func (r repository) GetStreamingToken(ctx context.Context, logger log.Logger, username string) string {
+	ctx, span := tracing.Start(ctx, "user.GetStreamingToken")
+	defer span.End()
 	token, dberr := r.db.Client().Get(ctx, "stream_token:"+username).Result()
 	if dberr != nil {
 		if dberr != redis.Nil {
@@ -198,6 +215,8 @@ func (r repository) GetStreamingToken(ctx context.Context, logger log.Logger, us
 
 // Delete user streaming token.
 func (r repository) DelStreamingToken(ctx context.Context, logger log.Logger, username string) {
+	ctx, span := tracing.Start(ctx, "user.DelStreamingToken")
+	defer span.End()
 	_, dberr := r.db.Client().Del(ctx, "stream_token:"+username).Result()
 	if dberr != nil {
 		if dberr != redis.Nil {
@@ -206,3 +225,43 @@ func (r repository) DelStreamingToken(ctx context.Context, logger log.Logger, us
 		}
 	}
 }
+
+// Returns nil if operator role of the user got successfully updated.
+func (r repository) SetUserOperator(ctx context.Context, logger log.Logger, username string, operator bool) error {
+	ctx, span := tracing.Start(ctx, "user.SetUserOperator")
+	defer span.End()
+	available, dberr := r.HasUser(ctx, logger, username)
+	if dberr != nil {
+		// Issues in HasUser()
+		return dberr
+	} else if !available {
+		return errors.NotFound("User not available")
+	}
+	dberr = r.db.Client().HSet(ctx, "user:"+username, "user_operator", operator).Err()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HSet() in user.SetUserOperator")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// Returns nil if account status of the user got successfully updated.
+func (r repository) SetUserStatus(ctx context.Context, logger log.Logger, username string, status string, suspendedUntil int64) error {
+	ctx, span := tracing.Start(ctx, "user.SetUserStatus")
+	defer span.End()
+	available, dberr := r.HasUser(ctx, logger, username)
+	if dberr != nil {
+		// Issues in HasUser()
+		return dberr
+	} else if !available {
+		return errors.NotFound("User not available")
+	}
+	dberr = r.db.Client().HSet(ctx, "user:"+username, "user_status", status, "user_suspended_until", suspendedUntil).Err()
+	if dberr != nil {
+		// Error during interacting with DB
+		logger.WithCtx(ctx).Error().Err(dberr).Msg("Error occured during execution of redis.HSet() in user.SetUserStatus")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ package user
 import (
 	"Popcorn/internal/entity"
 	"Popcorn/internal/errors"
+	"Popcorn/internal/metrics"
 	"Popcorn/pkg/log"
 	"context"
 
@@ -17,18 +18,21 @@ type Service interface {
 	getuser(ctx context.Context, username string) (entity.User, error)
 	// Search for an user in Popcorn.
 	searchuser(ctx context.Context, query entity.UserSearch) ([]entity.User, uint64, error)
+	// Fetches streaming usage of an user in the current month.
+	getusage(ctx context.Context, username string) (entity.StreamUsage, error)
 }
 
 // Object of this will be passed around from main to routers to API.
 // Helps to access the service layer interface and call methods.
 // Also helps to pass objects to be used from outer layer.
 type service struct {
-	userRepo Repository
-	logger   log.Logger
+	userRepo       Repository
+	metricsService metrics.Service
+	logger         log.Logger
 }
 
-func NewService(userRepo Repository, logger log.Logger) Service {
-	return service{userRepo, logger}
+func NewService(userRepo Repository, metricsService metrics.Service, logger log.Logger) Service {
+	return service{userRepo, metricsService, logger}
 }
 
 func (s service) getuser(ctx context.Context, username string) (entity.User, error) {
@@ -52,6 +56,10 @@ func (s service) searchuser(ctx context.Context, query entity.UserSearch) ([]ent
 	return s.userRepo.SearchUser(ctx, s.logger, query)
 }
 
+func (s service) getusage(ctx context.Context, username string) (entity.StreamUsage, error) {
+	return s.metricsService.GetStreamUsage(ctx, username)
+}
+
 // Helper to validate the user data against validation-tags mentioned in its entity.
 func (s service) validateUserSearchData(ctx context.Context, ue entity.UserSearch) error {
 	_, valerr := govalidator.ValidateStruct(ue)
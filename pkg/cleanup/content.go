@@ -2,20 +2,30 @@ package cleanup
 
 import (
 	"Popcorn/pkg/log"
-	"os"
+	"Popcorn/pkg/objectstore"
+	"context"
 )
 
-var UPLOAD_PATH string = os.Getenv("UPLOAD_PATH")
+// References keeps count of holders of contents shared across deduplicated uploads.
+type References interface {
+	// ReleaseBlob drops a reference to content, returns true if nothing refers to it anymore.
+	ReleaseBlob(ctx context.Context, logger log.Logger, contentID string) (bool, error)
+}
 
-// Helper method to delete file due to any issues found during or post upload
-func DeleteContentFiles(contentID string, logger log.Logger) {
-	if len(contentID) != 0 {
-		ext := []string{"", ".info"}
-		for i := 0; i < len(ext); i++ {
-			oserr := os.Remove(UPLOAD_PATH + contentID + ext[i])
-			if oserr != nil {
-				logger.Error().Err(oserr).Msgf("Error occured during deleting content file - %s", UPLOAD_PATH+contentID+ext[i])
-			}
-		}
+// Helper method to delete file due to any issues found during or post upload.
+// Content shared by other holders is only dereferenced, returns true if files got deleted.
+func DeleteContentFiles(store objectstore.Store, refs References, contentID string, logger log.Logger) bool {
+	if len(contentID) == 0 {
+		return false
+	}
+	last, dberr := refs.ReleaseBlob(context.Background(), logger, contentID)
+	if dberr != nil || !last {
+		// Still referenced, or left for the upload Janitor to delete once nothing refers to it
+		return false
+	}
+	oserr := store.Delete(context.Background(), contentID)
+	if oserr != nil {
+		logger.Error().Err(oserr).Msgf("Error occured during deleting content files - %s", contentID)
 	}
+	return true
 }
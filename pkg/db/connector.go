@@ -69,6 +69,9 @@ func NewDbConnection(ctx context.Context, logger log.Logger) (*RedisDB, error) {
 			Password: pwd,
 			DB:       dbNumber,
 		})
+		// Latency and errors of redis calls are exposed as Prometheus metrics, and traced
+		client.AddHook(monitorHook{})
+		client.AddHook(tracingHook{})
 		// Initializing globalDbClient once
 		globalDbClient = &RedisDB{client: client, txMaxRetries: maxRetries}
 	})
@@ -0,0 +1,98 @@
+// Redis client hooks recording latency and errors of redis calls into Prometheus metrics, and tracing them.
+
+package db
+
+import (
+	"Popcorn/pkg/monitor"
+	"Popcorn/pkg/tracing"
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Context key holding the time a redis call got started at.
+type callStartKey struct{}
+
+// monitorHook implements redis.Hook, pipelines and transactions are recorded as a single pipeline call.
+type monitorHook struct{}
+
+func (monitorHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, callStartKey{}, time.Now()), nil
+}
+
+func (monitorHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeCall(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+func (monitorHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, callStartKey{}, time.Now()), nil
+}
+
+func (monitorHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmderr := cmd.Err(); failedCall(cmderr) {
+			err = cmderr
+			break
+		}
+	}
+	observeCall(ctx, "pipeline", err)
+	return nil
+}
+
+// Helper to record latency of a redis call started by BeforeProcess, failed calls are counted as errors.
+func observeCall(ctx context.Context, command string, err error) {
+	if start, ok := ctx.Value(callStartKey{}).(time.Time); ok {
+		monitor.RedisDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	}
+	if failedCall(err) {
+		monitor.RedisErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// tracingHook implements redis.Hook, every redis call gets a span of its own.
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = tracing.StartClient(ctx, "redis."+cmd.Name(), attribute.String("db.system", "redis"))
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endCall(ctx, cmd.Err())
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = tracing.StartClient(ctx, "redis.pipeline", attribute.String("db.system", "redis"), attribute.Int("db.redis.commands", len(cmds)))
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmderr := cmd.Err(); failedCall(cmderr) {
+			err = cmderr
+			break
+		}
+	}
+	endCall(ctx, err)
+	return nil
+}
+
+// Helper to end span of a redis call started by BeforeProcess.
+func endCall(ctx context.Context, err error) {
+	if !failedCall(err) {
+		err = nil
+	}
+	tracing.End(trace.SpanFromContext(ctx), err)
+}
+
+// Helper to tell failed redis calls apart, missing keys and lost optimistic locks are expected outcomes.
+func failedCall(err error) bool {
+	return err != nil && err != redis.Nil && err != redis.TxFailedErr
+}
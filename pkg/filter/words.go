@@ -0,0 +1,83 @@
+// Pluggable text filters used to moderate user generated content in Popcorn.
+
+package filter
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Filter moderates incoming text before it reaches other users.
+type Filter interface {
+	// Clean returns text with the offending parts masked and whether anything got masked.
+	Clean(text string) (string, bool)
+}
+
+// wordFilter masks every word present in its word list, case insensitive.
+type wordFilter struct {
+	pattern *regexp.Regexp
+}
+
+// Returns a Filter which masks the given words with asterisks.
+// A blank word list returns a Filter which doesn't modify anything.
+func NewWordFilter(words []string) Filter {
+	quoted := []string{}
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return wordFilter{}
+	}
+	// \b only knows ASCII word characters, words are bounded by any letter or number instead
+	return wordFilter{pattern: regexp.MustCompile(`(?i)(^|[^\p{L}\p{N}_])(` + strings.Join(quoted, "|") + `)($|[^\p{L}\p{N}_])`)}
+}
+
+func (f wordFilter) Clean(text string) (string, bool) {
+	if f.pattern == nil {
+		return text, false
+	}
+	masked := false
+	// Words sharing a boundary can't be matched at once, masked words become boundaries of the next pass
+	for {
+		var cleaned strings.Builder
+		last := 0
+		matches := f.pattern.FindAllStringSubmatchIndex(text, -1)
+		masked = masked || len(matches) != 0
+		for _, match := range matches {
+			start, end := match[4], match[5]
+			cleaned.WriteString(text[last:start])
+			cleaned.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[start:end])))
+			last = end
+		}
+		cleaned.WriteString(text[last:])
+		if cleaned.String() == text {
+			return text, masked
+		}
+		text = cleaned.String()
+	}
+}
+
+// Reads a newline separated word list from path, lines starting with # are ignored.
+func LoadWordList(path string) ([]string, error) {
+	file, oserr := os.Open(path)
+	if oserr != nil {
+		return []string{}, oserr
+	}
+	defer file.Close()
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,56 @@
+// Text filter tests in Popcorn.
+
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWordFilter(t *testing.T) {
+	filter := NewWordFilter([]string{"spoiler", " café ", "ending", "", "a.b"})
+	tests := map[string]struct {
+		text   string
+		want   string
+		masked bool
+	}{
+		"Clean":           {"what a movie", "what a movie", false},
+		"CaseInsensitive": {"SPOILER ahead", "******* ahead", true},
+		"NonASCII":        {"un café noir", "un **** noir", true},
+		"NonASCIICase":    {"CAFÉ", "****", true},
+		"WithinWord":      {"spoilers and cafés", "spoilers and cafés", false},
+		"NonASCIIBound":   {"écafé spoileré", "écafé spoileré", false},
+		"Adjacent":        {"spoiler spoiler,ending", "******* *******,******", true},
+		"Punctuation":     {"(spoiler)!", "(*******)!", true},
+		"QuotedMeta":      {"a.b axb", "*** axb", true},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			cleaned, masked := filter.Clean(test.text)
+			assert.Equal(t, test.want, cleaned)
+			assert.Equal(t, test.masked, masked)
+		})
+	}
+
+	// Blank word list doesn't modify anything
+	cleaned, masked := NewWordFilter([]string{" ", ""}).Clean("spoiler")
+	assert.Equal(t, "spoiler", cleaned)
+	assert.False(t, masked)
+}
+
+func TestLoadWordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# banned words\nspoiler\n\n  café  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	words, err := LoadWordList(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"spoiler", "café"}, words)
+
+	_, err = LoadWordList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/pkgerrors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Logger acts as a wrapper for zerolog with custom features.
@@ -61,14 +62,22 @@ func New(version string) Logger {
 	return globalLogger
 }
 
-// Returns a sub-logger by adding additional correlationID context to it.
+// Returns a sub-logger by adding additional correlationID and trace context to it.
 // Helps in debugging issues.
 func (l *logger) WithCtx(ctx context.Context) Logger {
-	correlationID := ctx.Value("correlation_id")
-	if correlationID != nil {
-		if crrID, ok := correlationID.(string); ok {
-			return &logger{l.With().Str("correlationID", crrID).Timestamp().Caller().Stack().Logger()}
-		}
+	sublogger := l.With()
+	bridged := false
+	if crrID, ok := ctx.Value("correlation_id").(string); ok {
+		sublogger = sublogger.Str("correlationID", crrID)
+		bridged = true
+	}
+	// Logs are bridged with the trace they're written during
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		sublogger = sublogger.Str("traceID", spanCtx.TraceID().String()).Str("spanID", spanCtx.SpanID().String())
+		bridged = true
+	}
+	if !bridged {
+		return l
 	}
-	return l
+	return &logger{sublogger.Timestamp().Caller().Stack().Logger()}
 }
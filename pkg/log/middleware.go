@@ -3,7 +3,9 @@
 package log
 
 import (
+	"Popcorn/pkg/monitor"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -39,6 +41,14 @@ func LoggerGinExtension(logger Logger) gin.HandlerFunc {
 		}
 		param.Path = path
 
+		// Requests are labelled by route pattern, keeping the number of series bounded
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		monitor.HTTPRequests.WithLabelValues(param.Method, route, strconv.Itoa(param.StatusCode)).Inc()
+		monitor.HTTPDuration.WithLabelValues(param.Method, route).Observe(param.TimeStamp.Sub(start).Seconds())
+
 		message := fmt.Sprintf("%s | %s | %s | %d | %s | %s",
 			param.ClientIP,
 			param.Method,
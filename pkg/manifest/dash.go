@@ -0,0 +1,116 @@
+// DASH MPD parsing, renditions are the representations of video adaptation sets.
+
+package manifest
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parts of the MPD schema needed to list renditions, namespaces are ignored.
+type mpd struct {
+	Type     string `xml:"type,attr"`
+	Duration string `xml:"mediaPresentationDuration,attr"`
+	Periods  []struct {
+		Duration       string          `xml:"duration,attr"`
+		AdaptationSets []adaptationSet `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+type adaptationSet struct {
+	MimeType        string               `xml:"mimeType,attr"`
+	ContentType     string               `xml:"contentType,attr"`
+	Protection      []struct{}           `xml:"ContentProtection"`
+	Representations []dashRepresentation `xml:"Representation"`
+}
+
+type dashRepresentation struct {
+	ID         string     `xml:"id,attr"`
+	MimeType   string     `xml:"mimeType,attr"`
+	Bandwidth  int64      `xml:"bandwidth,attr"`
+	Width      int        `xml:"width,attr"`
+	Height     int        `xml:"height,attr"`
+	Codecs     string     `xml:"codecs,attr"`
+	Protection []struct{} `xml:"ContentProtection"`
+}
+
+// ISO 8601 durations as used by MPDs, years and months aren't as they have no fixed length.
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// Helper to parse an MPD, renditions are taken from the first period with video while every period is checked for DRM.
+func parseDASH(body []byte) (Manifest, error) {
+	var doc mpd
+	if xmlerr := xml.Unmarshal(body, &doc); xmlerr != nil || len(doc.Periods) == 0 {
+		return Manifest{}, ErrInvalid
+	}
+	if doc.Type == "dynamic" {
+		return Manifest{}, ErrLive
+	}
+	manifest := Manifest{Format: FormatDASH}
+	if doc.Duration != "" {
+		duration, ok := parseISODuration(doc.Duration)
+		if !ok {
+			return Manifest{}, ErrInvalid
+		}
+		manifest.Duration = duration
+	}
+	for _, period := range doc.Periods {
+		listed := len(manifest.Renditions) != 0
+		if doc.Duration == "" {
+			// Presentation duration is optional if every period tells its own
+			duration, _ := parseISODuration(period.Duration)
+			manifest.Duration += duration
+		}
+		for _, set := range period.AdaptationSets {
+			if len(set.Protection) != 0 {
+				return Manifest{}, ErrProtected
+			}
+			for _, representation := range set.Representations {
+				if len(representation.Protection) != 0 {
+					return Manifest{}, ErrProtected
+				}
+				mimeType := representation.MimeType
+				if mimeType == "" {
+					mimeType = set.MimeType
+				}
+				if set.ContentType != "video" && !strings.HasPrefix(mimeType, "video/") {
+					continue
+				}
+				if listed {
+					// Renditions of later periods mirror those of the first one
+					continue
+				}
+				id := representation.ID
+				if id == "" {
+					id = strconv.Itoa(len(manifest.Renditions))
+				}
+				manifest.Renditions = append(manifest.Renditions, Rendition{
+					ID:        id,
+					Bandwidth: representation.Bandwidth,
+					Width:     representation.Width,
+					Height:    representation.Height,
+					Codecs:    representation.Codecs,
+				})
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// Returns seconds of an ISO 8601 duration such as PT1H30M5.5S.
+func parseISODuration(duration string) (float64, bool) {
+	parts := isoDuration.FindStringSubmatch(duration)
+	if parts == nil || duration == "P" || strings.HasSuffix(duration, "T") {
+		return 0, false
+	}
+	var seconds float64
+	for i, unit := range []float64{86400, 3600, 60, 1} {
+		if parts[i+1] != "" {
+			value, _ := strconv.ParseFloat(parts[i+1], 64)
+			seconds += value * unit
+		}
+	}
+	return seconds, true
+}
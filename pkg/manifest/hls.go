@@ -0,0 +1,175 @@
+// HLS playlist parsing, master playlists list renditions while media playlists list segments.
+
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Parsed HLS media playlist.
+type mediaPlaylist struct {
+	duration  float64
+	ended     bool
+	vod       bool
+	protected bool
+}
+
+// Helper to parse an HLS playlist, master playlists get their top rendition fetched to tell duration and liveness.
+// Renditions of a presentation share both, so the rest aren't fetched.
+func loadHLS(ctx context.Context, fetcher Fetcher, base *url.URL, body []byte) (Manifest, error) {
+	manifest := Manifest{Format: FormatHLS}
+	lines, err := playlistLines(body)
+	if err != nil {
+		return Manifest{}, err
+	}
+	master := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			master = true
+			break
+		}
+	}
+	var media []string
+	if master {
+		var protected bool
+		manifest.Renditions, protected, err = parseMaster(base, lines)
+		if err != nil {
+			return Manifest{}, err
+		} else if protected {
+			return Manifest{}, ErrProtected
+		} else if len(manifest.Renditions) == 0 {
+			return Manifest{}, ErrNoRenditions
+		}
+		top, _ := manifest.Rendition("")
+		body, err = fetcher.Fetch(ctx, top.URL, maxSize)
+		if err != nil {
+			return Manifest{}, err
+		}
+		media, err = playlistLines(body)
+		if err != nil {
+			return Manifest{}, err
+		}
+	} else {
+		media = lines
+		manifest.Renditions = []Rendition{{ID: "0", URL: base.String()}}
+	}
+	playlist := parseMedia(media)
+	if playlist.protected {
+		return Manifest{}, ErrProtected
+	} else if !playlist.ended && !playlist.vod {
+		// Live and event playlists keep growing till they end
+		return Manifest{}, ErrLive
+	}
+	manifest.Duration = playlist.duration
+	return manifest, nil
+}
+
+// Helper to split playlist into its non blank lines, returns an error unless it's an extended M3U playlist.
+func playlistLines(body []byte) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))))
+	scanner.Buffer(make([]byte, 0, 64<<10), maxSize)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if scanner.Err() != nil || len(lines) == 0 || lines[0] != "#EXTM3U" {
+		return nil, ErrInvalid
+	}
+	return lines, nil
+}
+
+// Helper to list variant streams of a master playlist, along with whether it declares session keys of DRM systems.
+func parseMaster(base *url.URL, lines []string) ([]Rendition, bool, error) {
+	var renditions []Rendition
+	protected := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "#EXT-X-SESSION-KEY:") {
+			protected = protected || drmKey(attributes(strings.TrimPrefix(line, "#EXT-X-SESSION-KEY:")))
+			continue
+		} else if !strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			continue
+		}
+		attrs := attributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+		// URI of the variant is the next line which isn't a tag
+		for i++; i < len(lines) && strings.HasPrefix(lines[i], "#"); i++ {
+		}
+		if i == len(lines) {
+			return nil, false, ErrInvalid
+		}
+		uri, err := base.Parse(lines[i])
+		if err != nil {
+			return nil, false, ErrInvalid
+		}
+		rendition := Rendition{ID: strconv.Itoa(len(renditions)), URL: uri.String(), Codecs: attrs["CODECS"]}
+		rendition.Bandwidth, _ = strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+		rendition.Width, rendition.Height = parseResolution(attrs["RESOLUTION"])
+		renditions = append(renditions, rendition)
+	}
+	return renditions, protected, nil
+}
+
+// Helper to parse the segment list of a media playlist.
+func parseMedia(lines []string) mediaPlaylist {
+	var playlist mediaPlaylist
+	for _, line := range lines {
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXTINF":
+			duration, _, _ := strings.Cut(value, ",")
+			if seconds, err := strconv.ParseFloat(duration, 64); err == nil {
+				playlist.duration += seconds
+			}
+		case "#EXT-X-ENDLIST":
+			playlist.ended = true
+		case "#EXT-X-PLAYLIST-TYPE":
+			playlist.vod = value == "VOD"
+		case "#EXT-X-KEY":
+			playlist.protected = playlist.protected || drmKey(attributes(value))
+		}
+	}
+	return playlist
+}
+
+// Returns true if key attributes belong to a DRM system. Segments encrypted with plain AES-128 keys can still be pulled.
+func drmKey(attrs map[string]string) bool {
+	method := attrs["METHOD"]
+	if method == "" || method == "NONE" {
+		return false
+	}
+	format := attrs["KEYFORMAT"]
+	return method != "AES-128" || (format != "" && format != "identity")
+}
+
+// Helper to parse an attribute list such as BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2".
+func attributes(list string) map[string]string {
+	attrs := map[string]string{}
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			// Quoted strings may contain commas
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.TrimSpace(name)] = value
+		list = rest
+	}
+	return attrs
+}
@@ -0,0 +1,135 @@
+// Adaptive streaming manifests (HLS playlists and DASH MPDs) used as gang content sources.
+// Only on demand presentations in the clear are accepted, as ingress can neither rewind live streams nor decrypt DRM.
+
+package manifest
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Errors returned while loading manifests, messages are sent to clients as is.
+var (
+	ErrInvalid          = errors.New("manifest is malformed")
+	ErrLive             = errors.New("manifest is of a live stream")
+	ErrProtected        = errors.New("manifest is protected by DRM")
+	ErrNoRenditions     = errors.New("manifest has no video renditions")
+	ErrUnknownRendition = errors.New("manifest has no such rendition")
+)
+
+// Manifest formats.
+const (
+	FormatHLS  = "hls"
+	FormatDASH = "dash"
+)
+
+// Manifests larger than this are refused, real ones are a few kilobytes at most.
+const maxSize = 4 << 20
+
+// Fetcher fetches manifests, implementations are expected to guard against requests to private addresses.
+type Fetcher interface {
+	// Fetch returns the body of rawURL, or an error if it's larger than limit bytes.
+	Fetch(ctx context.Context, rawURL string, limit int64) ([]byte, error)
+}
+
+// Presentation described by a manifest.
+type Manifest struct {
+	Format string `json:"format"`
+	// Duration in seconds
+	Duration   float64     `json:"duration"`
+	Renditions []Rendition `json:"renditions"`
+}
+
+// Video rendition of a presentation.
+type Rendition struct {
+	ID string `json:"id"`
+	// Media playlist of HLS renditions, DASH renditions can't be pulled on their own
+	URL       string `json:"url,omitempty"`
+	Bandwidth int64  `json:"bandwidth"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Codecs    string `json:"codecs,omitempty"`
+}
+
+// Returns format of the manifest at rawURL going by its content type or extension, empty if it's no manifest.
+func Detect(rawURL, contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/vnd.apple.mpegurl", "application/x-mpegurl", "audio/mpegurl":
+		return FormatHLS
+	case "application/dash+xml":
+		return FormatDASH
+	}
+	if target, err := url.Parse(rawURL); err == nil {
+		switch strings.ToLower(path.Ext(target.Path)) {
+		case ".m3u8":
+			return FormatHLS
+		case ".mpd":
+			return FormatDASH
+		}
+	}
+	return ""
+}
+
+// Fetches and parses the manifest of format at rawURL.
+// Returns an error if the presentation is live, protected or has no video to stream.
+func Load(ctx context.Context, fetcher Fetcher, rawURL, format string) (Manifest, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return Manifest{}, ErrInvalid
+	}
+	body, err := fetcher.Fetch(ctx, rawURL, maxSize)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var manifest Manifest
+	switch format {
+	case FormatHLS:
+		manifest, err = loadHLS(ctx, fetcher, base, body)
+	case FormatDASH:
+		manifest, err = parseDASH(body)
+	default:
+		return Manifest{}, ErrInvalid
+	}
+	if err != nil {
+		return Manifest{}, err
+	} else if len(manifest.Renditions) == 0 {
+		return Manifest{}, ErrNoRenditions
+	}
+	return manifest, nil
+}
+
+// Returns the rendition with the given ID, or the one of highest bandwidth if id is empty.
+func (m Manifest) Rendition(id string) (Rendition, error) {
+	var best Rendition
+	for i, rendition := range m.Renditions {
+		if id == "" && (i == 0 || rendition.Bandwidth > best.Bandwidth) {
+			best = rendition
+		} else if id != "" && rendition.ID == id {
+			return rendition, nil
+		}
+	}
+	if best.ID == "" {
+		return Rendition{}, ErrUnknownRendition
+	}
+	return best, nil
+}
+
+// Helper to parse resolutions given as <width>x<height>.
+func parseResolution(resolution string) (int, int) {
+	w, h, ok := strings.Cut(strings.ToLower(resolution), "x")
+	if !ok {
+		return 0, 0
+	}
+	width, werr := strconv.Atoi(w)
+	height, herr := strconv.Atoi(h)
+	if werr != nil || herr != nil {
+		return 0, 0
+	}
+	return width, height
+}
@@ -0,0 +1,152 @@
+// Manifest tests in Popcorn.
+
+package manifest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves manifests from memory.
+type fetcher map[string]string
+
+func (f fetcher) Fetch(_ context.Context, rawURL string, limit int64) ([]byte, error) {
+	body, ok := f[rawURL]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return []byte(body), nil
+}
+
+const master = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+360p/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2"
+https://cdn.popcorn.test/720p/index.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,URI="iframes.m3u8"
+`
+
+const media = `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXTINF:10.0,
+segment0.ts
+#EXTINF:9.5,
+segment1.ts
+#EXT-X-ENDLIST
+`
+
+const vod = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT1H2M3.5S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="v1" bandwidth="1500000" width="960" height="540" codecs="avc1.4d401f"/>
+      <Representation id="v2" bandwidth="4000000" width="1920" height="1080" codecs="avc1.640028"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <Representation id="a1" bandwidth="128000" codecs="mp4a.40.2"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, FormatHLS, Detect("https://popcorn.test/watch", "application/vnd.apple.mpegurl; charset=utf-8"))
+	assert.Equal(t, FormatDASH, Detect("https://popcorn.test/watch", "application/dash+xml"))
+	assert.Equal(t, FormatHLS, Detect("https://popcorn.test/movie/Master.M3U8?token=1", "application/octet-stream"))
+	assert.Equal(t, FormatDASH, Detect("https://popcorn.test/movie.mpd", ""))
+	assert.Equal(t, "", Detect("https://popcorn.test/movie.mp4", "video/mp4"))
+}
+
+func TestLoadHLS(t *testing.T) {
+	ctx := context.Background()
+	manifests := fetcher{
+		"https://popcorn.test/movie/master.m3u8":     master,
+		"https://cdn.popcorn.test/720p/index.m3u8":   media,
+		"https://popcorn.test/movie/360p/index.m3u8": media,
+	}
+
+	manifest, err := Load(ctx, manifests, "https://popcorn.test/movie/master.m3u8", FormatHLS)
+	assert.NoError(t, err)
+	assert.Equal(t, Manifest{
+		Format:   FormatHLS,
+		Duration: 19.5,
+		Renditions: []Rendition{
+			{ID: "0", URL: "https://popcorn.test/movie/360p/index.m3u8", Bandwidth: 800000, Width: 640, Height: 360, Codecs: "avc1.4d401e,mp4a.40.2"},
+			{ID: "1", URL: "https://cdn.popcorn.test/720p/index.m3u8", Bandwidth: 2800000, Width: 1280, Height: 720, Codecs: "avc1.4d401f,mp4a.40.2"},
+		},
+	}, manifest)
+
+	rendition, err := manifest.Rendition("")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", rendition.ID)
+	rendition, err = manifest.Rendition("0")
+	assert.NoError(t, err)
+	assert.Equal(t, 360, rendition.Height)
+	_, err = manifest.Rendition("7")
+	assert.Equal(t, ErrUnknownRendition, err)
+
+	// Media playlists are a rendition of their own
+	manifest, err = Load(ctx, manifests, "https://popcorn.test/movie/360p/index.m3u8", FormatHLS)
+	assert.NoError(t, err)
+	assert.Equal(t, []Rendition{{ID: "0", URL: "https://popcorn.test/movie/360p/index.m3u8"}}, manifest.Renditions)
+	assert.Equal(t, 19.5, manifest.Duration)
+
+	for body, want := range map[string]error{
+		// Neither ended nor VOD
+		"#EXTM3U\n#EXTINF:10.0,\nsegment0.ts\n":                                                                                                    ErrLive,
+		"#EXTM3U\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXTINF:10.0,\nsegment0.ts\n":                                                                        ErrLive,
+		"#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"skd://key\",KEYFORMAT=\"com.apple.streamingkeydelivery\"\n#EXT-X-ENDLIST\n":                   ErrProtected,
+		"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"data:text/plain\",KEYFORMAT=\"urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed\"\n#EXT-X-ENDLIST\n": ErrProtected,
+		"#EXTM3U\n#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI=\"skd://key\"\n#EXT-X-STREAM-INF:BANDWIDTH=1\n360p/index.m3u8\n":                        ErrProtected,
+		"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n":                                                                                                 ErrInvalid,
+		"<html></html>": ErrInvalid,
+	} {
+		manifests["https://popcorn.test/other.m3u8"] = body
+		_, err = Load(ctx, manifests, "https://popcorn.test/other.m3u8", FormatHLS)
+		assert.Equal(t, want, err, body)
+	}
+}
+
+func TestLoadDASH(t *testing.T) {
+	ctx := context.Background()
+	manifests := fetcher{"https://popcorn.test/movie.mpd": vod}
+
+	manifest, err := Load(ctx, manifests, "https://popcorn.test/movie.mpd", FormatDASH)
+	assert.NoError(t, err)
+	assert.Equal(t, Manifest{
+		Format:   FormatDASH,
+		Duration: 3723.5,
+		Renditions: []Rendition{
+			{ID: "v1", Bandwidth: 1500000, Width: 960, Height: 540, Codecs: "avc1.4d401f"},
+			{ID: "v2", Bandwidth: 4000000, Width: 1920, Height: 1080, Codecs: "avc1.640028"},
+		},
+	}, manifest)
+
+	for body, want := range map[string]error{
+		`<MPD type="dynamic"><Period/></MPD>`: ErrLive,
+		`<MPD type="static" mediaPresentationDuration="PT10S"><Period><AdaptationSet mimeType="video/mp4">
+			<ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc"/>
+			<Representation id="v1" bandwidth="1"/></AdaptationSet></Period></MPD>`: ErrProtected,
+		`<MPD type="static" mediaPresentationDuration="PT10S"><Period><AdaptationSet mimeType="audio/mp4">
+			<Representation id="a1" bandwidth="1"/></AdaptationSet></Period></MPD>`: ErrNoRenditions,
+		`<MPD type="static" mediaPresentationDuration="P1Y"><Period/></MPD>`: ErrInvalid,
+		`#EXTM3U`: ErrInvalid,
+	} {
+		manifests["https://popcorn.test/other.mpd"] = body
+		_, err = Load(ctx, manifests, "https://popcorn.test/other.mpd", FormatDASH)
+		assert.Equal(t, want, err, body)
+	}
+
+	// Periods without a presentation duration add up
+	manifests["https://popcorn.test/other.mpd"] = `<MPD><Period duration="PT1M"><AdaptationSet contentType="video">
+		<Representation bandwidth="1" height="720"/></AdaptationSet></Period><Period duration="PT30S"/></MPD>`
+	manifest, err = Load(ctx, manifests, "https://popcorn.test/other.mpd", FormatDASH)
+	assert.NoError(t, err)
+	assert.Equal(t, 90.0, manifest.Duration)
+	assert.Equal(t, []Rendition{{ID: "0", Bandwidth: 1, Height: 720}}, manifest.Renditions)
+}
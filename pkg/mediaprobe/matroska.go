@@ -0,0 +1,377 @@
+// Matroska (MKV) and WebM parsing, both are built on top of EBML.
+
+package mediaprobe
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+)
+
+// EBML element IDs needed while probing.
+const (
+	ebmlHeaderID      = 0x1A45DFA3
+	ebmlDocTypeID     = 0x4282
+	mkvSegmentID      = 0x18538067
+	mkvInfoID         = 0x1549A966
+	mkvTimecodeScale  = 0x2AD7B1
+	mkvDurationID     = 0x4489
+	mkvTracksID       = 0x1654AE6B
+	mkvTrackEntryID   = 0xAE
+	mkvTrackNumberID  = 0xD7
+	mkvTrackTypeID    = 0x83
+	mkvCodecID        = 0x86
+	mkvLanguageID     = 0x22B59C
+	mkvLanguageIETFID = 0x22B59D
+	mkvNameID         = 0x536E
+	mkvVideoID        = 0xE0
+	mkvPixelWidthID   = 0xB0
+	mkvPixelHeightID  = 0xBA
+	mkvAudioID        = 0xE1
+	mkvSamplingFreqID = 0xB5
+	mkvChannelsID     = 0x9F
+	mkvDefaultDurID   = 0x23E383
+	mkvClusterID      = 0x1F43B675
+	mkvClusterTimeID  = 0xE7
+	mkvSimpleBlockID  = 0xA3
+	mkvBlockGroupID   = 0xA0
+	mkvBlockID        = 0xA1
+	mkvBlockDurID     = 0x9B
+)
+
+// Matroska track types.
+const (
+	mkvTrackVideo    = 1
+	mkvTrackAudio    = 2
+	mkvTrackSubtitle = 17
+)
+
+// Matroska codec IDs mapped to codec names, audio codec IDs might carry a profile suffix.
+var mkvCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC": "h264", "V_MPEGH/ISO/HEVC": "hevc", "V_VP8": "vp8", "V_VP9": "vp9", "V_AV1": "av1",
+	"V_MPEG2": "mpeg2video", "V_MPEG4/ISO/ASP": "mpeg4", "V_THEORA": "theora",
+	"A_AAC": "aac", "A_OPUS": "opus", "A_VORBIS": "vorbis", "A_MPEG/L3": "mp3", "A_AC3": "ac3", "A_EAC3": "eac3",
+	"A_FLAC": "flac", "A_DTS": "dts", "A_TRUEHD": "truehd", "A_PCM/INT/LIT": "pcm",
+	"S_TEXT/UTF8": "subrip", "S_TEXT/ASS": "ass", "S_TEXT/SSA": "ssa", "S_ASS": "ass", "S_SSA": "ssa",
+	"S_TEXT/WEBVTT": "webvtt", "S_HDMV/PGS": "hdmv_pgs_subtitle", "S_VOBSUB": "dvd_subtitle",
+}
+
+// Helper to walk segment children till its end and parse Info and Tracks once found.
+func probeMatroska(src *source) (Info, error) {
+	info, segmentEnd, err := openSegment(src)
+	if err != nil {
+		return info, err
+	}
+	foundTracks := false
+	for src.offset < segmentEnd {
+		id, size, err := readElementHeader(src)
+		if err != nil {
+			return info, err
+		} else if size < 0 {
+			// Elements of unknown size (live muxed clusters) can't be skipped through
+			break
+		} else if src.offset+size > segmentEnd {
+			return info, ErrTruncated
+		}
+		switch id {
+		case mkvInfoID, mkvTracksID:
+			if size > maxMetadataSize {
+				return info, ErrCorrupt
+			}
+			body, err := src.read(size)
+			if err != nil {
+				return info, err
+			}
+			if id == mkvInfoID {
+				err = parseMkvInfo(body, &info)
+			} else {
+				foundTracks = true
+				err = parseMkvTracks(body, &info)
+			}
+			if err != nil {
+				return info, err
+			}
+		default:
+			if err := src.skip(size); err != nil {
+				return info, err
+			}
+		}
+	}
+	if !foundTracks {
+		return info, ErrCorrupt
+	}
+	return info, nil
+}
+
+// Helper to read EBML header and the segment header following it, returns the offset segment ends at.
+func openSegment(src *source) (Info, int64, error) {
+	info := Info{}
+	id, size, err := readElementHeader(src)
+	if err != nil {
+		return info, 0, err
+	} else if id != ebmlHeaderID || size < 0 || size > 4096 {
+		return info, 0, ErrCorrupt
+	}
+	header, err := src.read(size)
+	if err != nil {
+		return info, 0, err
+	}
+	err = eachElement(header, func(id uint64, body []byte) error {
+		if id == ebmlDocTypeID {
+			info.Container = strings.TrimRight(string(body), "\x00")
+		}
+		return nil
+	})
+	if err != nil {
+		return info, 0, err
+	} else if info.Container != ContainerMatroska && info.Container != ContainerWebM {
+		return info, 0, ErrUnknownContainer
+	}
+
+	id, size, err = readElementHeader(src)
+	if err != nil {
+		return info, 0, err
+	} else if id != mkvSegmentID {
+		return info, 0, ErrCorrupt
+	}
+	segmentEnd := src.size
+	if size >= 0 {
+		segmentEnd = src.offset + size
+		if segmentEnd > src.size {
+			return info, 0, ErrTruncated
+		}
+	}
+	return info, segmentEnd, nil
+}
+
+func parseMkvInfo(body []byte, info *Info) error {
+	scale, duration := uint64(1000000), 0.0
+	err := eachElement(body, func(id uint64, data []byte) error {
+		switch id {
+		case mkvTimecodeScale:
+			scale = ebmlUint(data)
+		case mkvDurationID:
+			duration = ebmlFloat(data)
+		}
+		return nil
+	})
+	info.Duration = duration * float64(scale) / 1e9
+	return err
+}
+
+// Track details gathered while walking a TrackEntry element.
+type mkvTrack struct {
+	number    uint64
+	trackType uint64
+	codec     string
+	language  string
+	name      string
+	width     uint64
+	height    uint64
+	channels  uint64
+	rate      uint64
+	// Default duration of frames in nanoseconds, 0 if unknown
+	defaultDuration uint64
+}
+
+func parseMkvTracks(body []byte, info *Info) error {
+	return eachElement(body, func(id uint64, entry []byte) error {
+		if id != mkvTrackEntryID {
+			return nil
+		}
+		track, err := parseMkvTrackEntry(entry)
+		if err != nil {
+			return err
+		}
+		switch track.trackType {
+		case mkvTrackVideo:
+			info.Video = append(info.Video, VideoTrack{Codec: track.codec, Width: track.width, Height: track.height})
+		case mkvTrackAudio:
+			info.Audio = append(info.Audio, AudioTrack{Codec: track.codec, Channels: track.channels, SampleRate: track.rate, Language: track.language})
+		case mkvTrackSubtitle:
+			info.Subtitles = append(info.Subtitles, SubtitleTrack{Codec: track.codec, Language: track.language, Name: track.name})
+		}
+		return nil
+	})
+}
+
+func parseMkvTrackEntry(entry []byte) (mkvTrack, error) {
+	track := mkvTrack{channels: 1, rate: 8000, language: "eng"}
+	codecID, languageIETF := "", ""
+	err := eachElement(entry, func(id uint64, data []byte) error {
+		switch id {
+		case mkvTrackNumberID:
+			track.number = ebmlUint(data)
+		case mkvTrackTypeID:
+			track.trackType = ebmlUint(data)
+		case mkvCodecID:
+			codecID = strings.TrimRight(string(data), "\x00")
+		case mkvLanguageID:
+			track.language = strings.TrimRight(string(data), "\x00")
+		case mkvLanguageIETFID:
+			languageIETF = strings.TrimRight(string(data), "\x00")
+		case mkvNameID:
+			track.name = string(data)
+		case mkvDefaultDurID:
+			track.defaultDuration = ebmlUint(data)
+		case mkvVideoID:
+			return eachElement(data, func(id uint64, data []byte) error {
+				switch id {
+				case mkvPixelWidthID:
+					track.width = ebmlUint(data)
+				case mkvPixelHeightID:
+					track.height = ebmlUint(data)
+				}
+				return nil
+			})
+		case mkvAudioID:
+			return eachElement(data, func(id uint64, data []byte) error {
+				switch id {
+				case mkvSamplingFreqID:
+					track.rate = uint64(ebmlFloat(data))
+				case mkvChannelsID:
+					track.channels = ebmlUint(data)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return track, err
+	}
+	track.codec = mkvCodec(codecID)
+	if languageIETF != "" {
+		// IETF language tag takes precedence over the legacy one
+		track.language = languageIETF
+	}
+	if track.language == "und" {
+		track.language = ""
+	}
+	return track, nil
+}
+
+// Helper to map Matroska codec ID to codec name.
+func mkvCodec(codecID string) string {
+	if codec, ok := mkvCodecs[codecID]; ok {
+		return codec
+	}
+	// Codec IDs like A_AAC/MPEG4/LC carry profile after the codec
+	if idx := strings.Index(codecID, "/"); idx != -1 {
+		if codec, ok := mkvCodecs[codecID[:idx]]; ok {
+			return codec
+		}
+	}
+	return strings.ToLower(codecID)
+}
+
+// Helper to read element ID and size from source, size is -1 when unknown.
+func readElementHeader(src *source) (uint64, int64, error) {
+	first, err := src.read(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	length := vintLength(first[0])
+	if length == 0 || length > 4 {
+		return 0, 0, ErrCorrupt
+	}
+	rest, err := src.read(int64(length - 1))
+	if err != nil {
+		return 0, 0, err
+	}
+	id := uint64(first[0])
+	for _, b := range rest {
+		id = id<<8 | uint64(b)
+	}
+
+	first, err = src.read(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	length = vintLength(first[0])
+	if length == 0 {
+		return 0, 0, ErrCorrupt
+	}
+	rest, err = src.read(int64(length - 1))
+	if err != nil {
+		return 0, 0, err
+	}
+	size, unknown := vintValue(append(first, rest...))
+	if unknown {
+		return id, -1, nil
+	} else if size > math.MaxInt64 {
+		return 0, 0, ErrCorrupt
+	}
+	return id, int64(size), nil
+}
+
+// Helper to iterate over child elements held in data.
+func eachElement(data []byte, fn func(id uint64, body []byte) error) error {
+	for len(data) > 0 {
+		length := vintLength(data[0])
+		if length == 0 || length > 4 || len(data) < length {
+			return ErrCorrupt
+		}
+		id := uint64(0)
+		for _, b := range data[:length] {
+			id = id<<8 | uint64(b)
+		}
+		data = data[length:]
+		if len(data) == 0 {
+			return ErrCorrupt
+		}
+		length = vintLength(data[0])
+		if length == 0 || len(data) < length {
+			return ErrCorrupt
+		}
+		size, unknown := vintValue(data[:length])
+		data = data[length:]
+		if unknown || size > uint64(len(data)) {
+			return ErrCorrupt
+		}
+		if err := fn(id, data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// Returns the length of a variable sized integer from its first byte, 0 if invalid.
+func vintLength(first byte) int {
+	for i := 0; i < 8; i++ {
+		if first&(0x80>>i) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Returns value of a variable sized integer without its length marker, and whether all value bits are set.
+func vintValue(data []byte) (uint64, bool) {
+	value := uint64(data[0] & (0xFF >> len(data)))
+	allOnes := value == uint64(0xFF>>len(data))
+	for _, b := range data[1:] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	return value, allOnes
+}
+
+func ebmlUint(data []byte) uint64 {
+	value := uint64(0)
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
@@ -0,0 +1,236 @@
+// ISO base media file format (MP4) parsing.
+
+package mediaprobe
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// Metadata boxes bigger than this aren't loaded in memory.
+const maxMetadataSize = 64 << 20
+
+// Sample entry formats mapped to codec names.
+var mp4Codecs = map[string]string{
+	"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc", "vp08": "vp8", "vp09": "vp9", "av01": "av1",
+	"mp4v": "mpeg4", "mp4a": "aac", "Opus": "opus", ".mp3": "mp3", "ac-3": "ac3", "ec-3": "eac3", "fLaC": "flac",
+	"tx3g": "mov_text", "wvtt": "webvtt", "stpp": "ttml", "c608": "eia_608",
+}
+
+// Helper to walk top-level boxes till the end of file and parse moov once found.
+func probeMP4(src *source) (Info, error) {
+	info := Info{Container: ContainerMP4}
+	foundMoov := false
+	for src.offset < src.size {
+		boxType, bodySize, err := readBoxHeader(src)
+		if err != nil {
+			return info, err
+		}
+		if boxType != "moov" {
+			if err := src.skip(bodySize); err != nil {
+				return info, err
+			}
+			continue
+		}
+		if bodySize > maxMetadataSize {
+			return info, ErrCorrupt
+		}
+		body, err := src.read(bodySize)
+		if err != nil {
+			return info, err
+		}
+		if err := parseMoov(body, &info); err != nil {
+			return info, err
+		}
+		foundMoov = true
+	}
+	if !foundMoov {
+		// Media without moov can't be played at all
+		return info, ErrTruncated
+	}
+	return info, nil
+}
+
+// Helper to read box header from source, returns box type and body size.
+func readBoxHeader(src *source) (string, int64, error) {
+	header, err := src.read(8)
+	if err != nil {
+		return "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	headerSize := int64(8)
+	switch size {
+	case 0:
+		// Box extends till the end of file
+		size = src.size - src.offset + headerSize
+	case 1:
+		largeSize, err := src.read(8)
+		if err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(largeSize))
+		headerSize += 8
+	}
+	if size < headerSize {
+		return "", 0, ErrCorrupt
+	}
+	return string(header[4:8]), size - headerSize, nil
+}
+
+// Helper to iterate over child boxes held in data.
+func eachBox(data []byte, fn func(boxType string, body []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return ErrCorrupt
+		}
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		headerSize := uint64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				return ErrCorrupt
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return ErrCorrupt
+		}
+		if err := fn(string(data[4:8]), data[headerSize:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+func parseMoov(moov []byte, info *Info) error {
+	return eachBox(moov, func(boxType string, body []byte) error {
+		switch boxType {
+		case "mvhd":
+			timescale, duration, err := parseTimes(body)
+			if err != nil {
+				return err
+			} else if timescale != 0 {
+				info.Duration = float64(duration) / float64(timescale)
+			}
+		case "trak":
+			return parseTrak(body, info)
+		}
+		return nil
+	})
+}
+
+// Track details gathered while walking a trak box.
+type mp4Track struct {
+	handler  string
+	language string
+	format   string
+	width    uint64
+	height   uint64
+	channels uint64
+	rate     uint64
+}
+
+func parseTrak(trak []byte, info *Info) error {
+	track := mp4Track{}
+	var walk func(data []byte) error
+	walk = func(data []byte) error {
+		return eachBox(data, func(boxType string, body []byte) error {
+			switch boxType {
+			case "mdia", "minf", "stbl":
+				return walk(body)
+			case "mdhd":
+				return parseMdhd(body, &track)
+			case "hdlr":
+				if len(body) < 12 {
+					return ErrCorrupt
+				}
+				track.handler = string(body[8:12])
+			case "stsd":
+				return parseStsd(body, &track)
+			}
+			return nil
+		})
+	}
+	if err := walk(trak); err != nil {
+		return err
+	}
+	codec, ok := mp4Codecs[track.format]
+	if !ok {
+		codec = strings.ToLower(strings.TrimSpace(track.format))
+	}
+	switch track.handler {
+	case "vide":
+		info.Video = append(info.Video, VideoTrack{Codec: codec, Width: track.width, Height: track.height})
+	case "soun":
+		info.Audio = append(info.Audio, AudioTrack{Codec: codec, Channels: track.channels, SampleRate: track.rate, Language: track.language})
+	case "sbtl", "subt", "text", "clcp":
+		info.Subtitles = append(info.Subtitles, SubtitleTrack{Codec: codec, Language: track.language})
+	}
+	return nil
+}
+
+// Helper to read timescale and duration from mvhd or mdhd body.
+func parseTimes(body []byte) (uint64, uint64, error) {
+	if len(body) < 1 {
+		return 0, 0, ErrCorrupt
+	}
+	if body[0] == 1 {
+		if len(body) < 32 {
+			return 0, 0, ErrCorrupt
+		}
+		return uint64(binary.BigEndian.Uint32(body[20:24])), binary.BigEndian.Uint64(body[24:32]), nil
+	}
+	if len(body) < 20 {
+		return 0, 0, ErrCorrupt
+	}
+	return uint64(binary.BigEndian.Uint32(body[12:16])), uint64(binary.BigEndian.Uint32(body[16:20])), nil
+}
+
+func parseMdhd(body []byte, track *mp4Track) error {
+	// Language is right after duration, packed as 3 x 5 bits
+	offset := 20
+	if len(body) > 0 && body[0] == 1 {
+		offset = 32
+	}
+	if len(body) < offset+2 {
+		return ErrCorrupt
+	}
+	packed := binary.BigEndian.Uint16(body[offset : offset+2])
+	lang := []byte{byte(packed>>10&0x1F) + 0x60, byte(packed>>5&0x1F) + 0x60, byte(packed&0x1F) + 0x60}
+	if string(lang) != "und" && lang[0] >= 'a' && lang[0] <= 'z' {
+		track.language = string(lang)
+	}
+	return nil
+}
+
+func parseStsd(body []byte, track *mp4Track) error {
+	// version & flags followed by entry count, only the first sample entry is considered
+	if len(body) < 16 {
+		return ErrCorrupt
+	}
+	entry := body[8:]
+	size := binary.BigEndian.Uint32(entry[:4])
+	if size < 8 || int(size) > len(entry) {
+		return ErrCorrupt
+	}
+	track.format = string(entry[4:8])
+	entry = entry[8:size]
+	switch track.handler {
+	case "vide":
+		// reserved, data reference index and pre defined fields come before dimensions
+		if len(entry) >= 28 {
+			track.width = uint64(binary.BigEndian.Uint16(entry[24:26]))
+			track.height = uint64(binary.BigEndian.Uint16(entry[26:28]))
+		}
+	case "soun":
+		// reserved and data reference index come before channel count, sample rate is 16.16 fixed point
+		if len(entry) >= 28 {
+			track.channels = uint64(binary.BigEndian.Uint16(entry[16:18]))
+			track.rate = uint64(binary.BigEndian.Uint32(entry[24:28]) >> 16)
+		}
+	}
+	return nil
+}
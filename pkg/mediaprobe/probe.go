@@ -0,0 +1,152 @@
+// Container level inspection of uploaded media used to reject contents livekit ingress cannot stream.
+
+package mediaprobe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned while probing or validating media.
+var (
+	ErrUnknownContainer = errors.New("unknown media container")
+	ErrTruncated        = errors.New("media file is truncated")
+	ErrCorrupt          = errors.New("media file is corrupt")
+	ErrNoVideo          = errors.New("media has no video track")
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+)
+
+// Supported containers.
+const (
+	ContainerMP4      = "mp4"
+	ContainerMatroska = "matroska"
+	ContainerWebM     = "webm"
+)
+
+// Codecs livekit ingress is able to decode, can be extended before probing.
+var (
+	SupportedVideoCodecs = map[string]bool{"h264": true, "hevc": true, "vp8": true, "vp9": true, "av1": true}
+	SupportedAudioCodecs = map[string]bool{"aac": true, "opus": true, "mp3": true, "vorbis": true, "ac3": true, "eac3": true, "flac": true}
+)
+
+// Media metadata found in the container.
+type Info struct {
+	Container string `json:"container"`
+	// Duration in seconds
+	Duration  float64         `json:"duration"`
+	Video     []VideoTrack    `json:"video"`
+	Audio     []AudioTrack    `json:"audio"`
+	Subtitles []SubtitleTrack `json:"subtitles"`
+}
+
+type VideoTrack struct {
+	Codec  string `json:"codec"`
+	Width  uint64 `json:"width"`
+	Height uint64 `json:"height"`
+}
+
+type AudioTrack struct {
+	Codec      string `json:"codec"`
+	Channels   uint64 `json:"channels"`
+	SampleRate uint64 `json:"sample_rate"`
+	Language   string `json:"language,omitempty"`
+}
+
+type SubtitleTrack struct {
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// Parses the container of media having size bytes and returns its metadata.
+// Seekable readers are skipped through, others are read till the end as the whole structure gets verified.
+func Probe(r io.Reader, size int64) (Info, error) {
+	src, head, err := newSource(r, size)
+	if err != nil {
+		return Info{}, err
+	}
+	switch {
+	case string(head[4:8]) == "ftyp":
+		return probeMP4(src)
+	case bytes.Equal(head[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return probeMatroska(src)
+	}
+	return Info{}, ErrUnknownContainer
+}
+
+// Returns an error if media can't be streamed, either due to missing video or unsupported codecs.
+func Validate(info Info) error {
+	if len(info.Video) == 0 {
+		return ErrNoVideo
+	}
+	supported := false
+	for _, track := range info.Video {
+		supported = supported || SupportedVideoCodecs[track.Codec]
+	}
+	if !supported {
+		return fmt.Errorf("%w: %s", ErrUnsupportedCodec, info.Video[0].Codec)
+	}
+	if len(info.Audio) != 0 {
+		supported = false
+		for _, track := range info.Audio {
+			supported = supported || SupportedAudioCodecs[track.Codec]
+		}
+		if !supported {
+			return fmt.Errorf("%w: %s", ErrUnsupportedCodec, info.Audio[0].Codec)
+		}
+	}
+	return nil
+}
+
+// source keeps track of the read offset, helps in skipping and bounds checking.
+type source struct {
+	r      io.Reader
+	seeker io.Seeker
+	size   int64
+	offset int64
+}
+
+// Helper to wrap r in a source, returns the first bytes of media to detect its container from.
+func newSource(r io.Reader, size int64) (*source, []byte, error) {
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, nil, ErrTruncated
+	}
+	src := &source{r: io.MultiReader(bytes.NewReader(head), r), size: size}
+	if seeker, ok := r.(io.Seeker); ok {
+		// Seek back so that skipping can make use of it
+		if _, err := seeker.Seek(0, io.SeekStart); err == nil {
+			src.r, src.seeker = r, seeker
+		}
+	}
+	return src, head, nil
+}
+
+func (s *source) read(n int64) ([]byte, error) {
+	if n < 0 || s.offset+n > s.size {
+		return nil, ErrTruncated
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return nil, ErrTruncated
+	}
+	s.offset += n
+	return buf, nil
+}
+
+func (s *source) skip(n int64) error {
+	if n < 0 || s.offset+n > s.size {
+		return ErrTruncated
+	}
+	if s.seeker != nil {
+		if _, err := s.seeker.Seek(n, io.SeekCurrent); err != nil {
+			return ErrTruncated
+		}
+	} else if _, err := io.CopyN(io.Discard, s.r, n); err != nil {
+		return ErrTruncated
+	}
+	s.offset += n
+	return nil
+}
@@ -0,0 +1,231 @@
+// Media probing tests in Popcorn.
+
+package mediaprobe
+
+import (
+	"Popcorn/pkg/subtitle"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to build a MP4 box.
+func box(boxType string, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)+8))
+	copy(header[4:], boxType)
+	return append(header, data...)
+}
+
+// Helper to build big endian integers of n bytes.
+func be(value uint64, n int) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return data[8-n:]
+}
+
+// Helper to build n zero bytes used as padding.
+func zeros(n int) []byte {
+	return make([]byte, n)
+}
+
+// Helper to build a MP4 trak box.
+func trak(handler, format string, entry []byte) []byte {
+	mdhd := bytes.Join([][]byte{be(0, 4), be(0, 8), be(1000, 4), be(60000, 4), be(0x15C7, 2), be(0, 2)}, nil) // language "eng"
+	hdlr := bytes.Join([][]byte{be(0, 8), []byte(handler), zeros(12), {0}}, nil)
+	stsd := bytes.Join([][]byte{be(0, 4), be(1, 4), box(format, entry)}, nil)
+	return box("trak", box("tkhd", zeros(84)), box("mdia", box("mdhd", mdhd), box("hdlr", hdlr), box("minf", box("stbl", box("stsd", stsd)))))
+}
+
+// Helper to build a MP4 file with a 1080p video track, stereo audio track and a subtitle track.
+func mp4File(videoFormat string) []byte {
+	video := bytes.Join([][]byte{be(1, 8), zeros(16), be(1920, 2), be(1080, 2), zeros(50)}, nil)
+	audio := bytes.Join([][]byte{be(1, 8), be(0, 8), be(2, 2), be(16, 2), be(0, 4), be(48000<<16, 4)}, nil)
+	mvhd := bytes.Join([][]byte{be(0, 4), be(0, 8), be(1000, 4), be(90500, 4), zeros(80)}, nil)
+	moov := box("moov", box("mvhd", mvhd), trak("vide", videoFormat, video), trak("soun", "mp4a", audio), trak("sbtl", "tx3g", be(0, 8)))
+	return bytes.Join([][]byte{box("ftyp", []byte("isom"), be(512, 4)), box("mdat", bytes.Repeat([]byte{0xAB}, 4096)), moov}, nil)
+}
+
+// Helper to build a EBML element.
+func element(id uint64, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	idBytes := be(id, 4)
+	for len(idBytes) > 1 && idBytes[0] == 0 {
+		idBytes = idBytes[1:]
+	}
+	// 8 byte size vint
+	size := be(uint64(len(data)), 8)
+	size[0] = 0x01
+	return bytes.Join([][]byte{idBytes, size, data}, nil)
+}
+
+// Helper to build a Matroska file with a 720p video track, audio track and a subtitle track.
+func mkvFile(videoCodec string) []byte {
+	header := element(ebmlHeaderID, element(ebmlDocTypeID, []byte("matroska")))
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(125500))
+	info := element(mkvInfoID, element(mkvTimecodeScale, be(1000000, 3)), element(mkvDurationID, duration))
+	rate := make([]byte, 8)
+	binary.BigEndian.PutUint64(rate, math.Float64bits(44100))
+	tracks := element(mkvTracksID,
+		element(mkvTrackEntryID, element(mkvTrackTypeID, be(1, 1)), element(mkvCodecID, []byte(videoCodec)),
+			element(mkvVideoID, element(mkvPixelWidthID, be(1280, 2)), element(mkvPixelHeightID, be(720, 2)))),
+		element(mkvTrackEntryID, element(mkvTrackTypeID, be(2, 1)), element(mkvCodecID, []byte("A_AAC/MPEG4/LC")),
+			element(mkvLanguageID, []byte("jpn")), element(mkvAudioID, element(mkvSamplingFreqID, rate), element(mkvChannelsID, be(6, 1)))),
+		element(mkvTrackEntryID, element(mkvTrackTypeID, be(17, 1)), element(mkvCodecID, []byte("S_TEXT/UTF8")),
+			element(mkvLanguageID, []byte("eng")), element(mkvLanguageIETFID, []byte("en-US")), element(mkvNameID, []byte("English"))),
+	)
+	cluster := element(0x1F43B675, bytes.Repeat([]byte{0xCD}, 4096))
+	return append(header, element(mkvSegmentID, info, tracks, cluster)...)
+}
+
+// Reader which isn't seekable, like object storage response bodies.
+type streamReader struct {
+	io.Reader
+}
+
+func TestProbeMP4(t *testing.T) {
+	data := mp4File("avc1")
+	for name, reader := range map[string]io.Reader{"Seekable": bytes.NewReader(data), "Stream": streamReader{bytes.NewReader(data)}} {
+		t.Run(name, func(t *testing.T) {
+			info, err := Probe(reader, int64(len(data)))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, ContainerMP4, info.Container)
+			assert.Equal(t, 90.5, info.Duration)
+			assert.Equal(t, []VideoTrack{{Codec: "h264", Width: 1920, Height: 1080}}, info.Video)
+			assert.Equal(t, []AudioTrack{{Codec: "aac", Channels: 2, SampleRate: 48000, Language: "eng"}}, info.Audio)
+			assert.Equal(t, []SubtitleTrack{{Codec: "mov_text", Language: "eng"}}, info.Subtitles)
+			assert.NoError(t, Validate(info))
+		})
+	}
+}
+
+func TestProbeMatroska(t *testing.T) {
+	data := mkvFile("V_MPEG4/ISO/AVC")
+	info, err := Probe(bytes.NewReader(data), int64(len(data)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, ContainerMatroska, info.Container)
+	assert.Equal(t, 125.5, info.Duration)
+	assert.Equal(t, []VideoTrack{{Codec: "h264", Width: 1280, Height: 720}}, info.Video)
+	assert.Equal(t, []AudioTrack{{Codec: "aac", Channels: 6, SampleRate: 44100, Language: "jpn"}}, info.Audio)
+	assert.Equal(t, []SubtitleTrack{{Codec: "subrip", Language: "en-US", Name: "English"}}, info.Subtitles)
+	assert.NoError(t, Validate(info))
+}
+
+func TestProbeInvalid(t *testing.T) {
+	mp4, mkv := mp4File("avc1"), mkvFile("V_VP9")
+	tests := map[string]struct {
+		data []byte
+		want error
+	}{
+		"TruncatedMP4":      {mp4[:len(mp4)-10], ErrTruncated},
+		"MP4WithoutMoov":    {mp4[:len(mp4)-len(box("moov"))-1000], ErrTruncated},
+		"TruncatedMatroska": {mkv[:len(mkv)-100], ErrTruncated},
+		"UnknownContainer":  {bytes.Repeat([]byte("popcorn"), 10), ErrUnknownContainer},
+		"Empty":             {[]byte{}, ErrTruncated},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			_, err := Probe(bytes.NewReader(test.data), int64(len(test.data)))
+			assert.ErrorIs(t, err, test.want)
+		})
+	}
+}
+
+func TestValidateCodecs(t *testing.T) {
+	data := mp4File("mp4v")
+	info, err := Probe(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, "mpeg4", info.Video[0].Codec)
+	assert.ErrorIs(t, Validate(info), ErrUnsupportedCodec)
+
+	data = mkvFile("V_MPEG2")
+	info, err = Probe(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	err = Validate(info)
+	assert.True(t, errors.Is(err, ErrUnsupportedCodec))
+	assert.Contains(t, err.Error(), "mpeg2video")
+
+	// Unsupported audio is rejected, media without audio is fine
+	info = Info{Video: []VideoTrack{{Codec: "vp9"}}, Audio: []AudioTrack{{Codec: "dts"}}}
+	assert.ErrorIs(t, Validate(info), ErrUnsupportedCodec)
+	info.Audio = nil
+	assert.NoError(t, Validate(info))
+	assert.ErrorIs(t, Validate(Info{Audio: []AudioTrack{{Codec: "aac"}}}), ErrNoVideo)
+}
+
+// Helper to build a Matroska block of track n starting at relTime.
+func block(n uint64, relTime int16, flags byte, data string) []byte {
+	return bytes.Join([][]byte{{0x80 | byte(n)}, be(uint64(uint16(relTime)), 2), {flags}, []byte(data)}, nil)
+}
+
+func TestExtractSubtitles(t *testing.T) {
+	header := element(ebmlHeaderID, element(ebmlDocTypeID, []byte("matroska")))
+	info := element(mkvInfoID, element(mkvTimecodeScale, be(1000000, 3)))
+	tracks := element(mkvTracksID,
+		element(mkvTrackEntryID, element(mkvTrackNumberID, be(1, 1)), element(mkvTrackTypeID, be(1, 1)), element(mkvCodecID, []byte("V_VP9"))),
+		element(mkvTrackEntryID, element(mkvTrackNumberID, be(2, 1)), element(mkvTrackTypeID, be(17, 1)), element(mkvCodecID, []byte("S_TEXT/UTF8")),
+			element(mkvLanguageID, []byte("eng")), element(mkvNameID, []byte("English"))),
+		element(mkvTrackEntryID, element(mkvTrackNumberID, be(3, 1)), element(mkvTrackTypeID, be(17, 1)), element(mkvCodecID, []byte("S_TEXT/ASS")),
+			element(mkvLanguageID, []byte("fre")), element(mkvDefaultDurID, be(2e9, 4))),
+		element(mkvTrackEntryID, element(mkvTrackNumberID, be(4, 1)), element(mkvTrackTypeID, be(17, 1)), element(mkvCodecID, []byte("S_HDMV/PGS"))),
+	)
+	clusters := [][]byte{
+		element(mkvClusterID, element(mkvClusterTimeID, be(1000, 2)),
+			element(mkvSimpleBlockID, block(1, 0, 0x80, string(bytes.Repeat([]byte{0xCD}, 512)))),
+			element(mkvBlockGroupID, element(mkvBlockID, block(2, 500, 0, "Hello <i>there</i>")), element(mkvBlockDurID, be(1500, 2))),
+			element(mkvSimpleBlockID, block(3, 250, 0x80, `0,0,Default,,0,0,0,,{\an8}Bonjour\Nà tous`)),
+			element(mkvSimpleBlockID, block(4, 0, 0x80, "\x16\x00\x00")),
+		),
+		element(mkvClusterID, element(mkvClusterTimeID, be(60000, 2)),
+			element(mkvSimpleBlockID, block(2, -100, 0x80, "No duration")),
+		),
+	}
+	data := append(header, element(mkvSegmentID, append([][]byte{info, tracks}, clusters...)...)...)
+
+	for name, reader := range map[string]io.Reader{"Seekable": bytes.NewReader(data), "Stream": streamReader{bytes.NewReader(data)}} {
+		t.Run(name, func(t *testing.T) {
+			subs, err := ExtractSubtitles(reader, int64(len(data)))
+			if !assert.NoError(t, err) || !assert.Len(t, subs, 2) {
+				return
+			}
+			assert.Equal(t, SubtitleTrack{Codec: "subrip", Language: "eng", Name: "English"}, subs[0].Track)
+			assert.Equal(t, []subtitle.Cue{
+				{Start: 1500 * time.Millisecond, End: 3 * time.Second, Text: "Hello <i>there</i>"},
+				{Start: 59900 * time.Millisecond, End: 59900*time.Millisecond + defaultCueDuration, Text: "No duration"},
+			}, subs[0].Cues)
+			assert.Equal(t, SubtitleTrack{Codec: "ass", Language: "fre"}, subs[1].Track)
+			assert.Equal(t, []subtitle.Cue{{Start: 1250 * time.Millisecond, End: 3250 * time.Millisecond, Text: "Bonjour\nà tous"}}, subs[1].Cues)
+		})
+	}
+
+	// MP4 subtitles aren't extracted
+	mp4 := mp4File("avc1")
+	subs, err := ExtractSubtitles(bytes.NewReader(mp4), int64(len(mp4)))
+	assert.NoError(t, err)
+	assert.Empty(t, subs)
+	_, err = ExtractSubtitles(bytes.NewReader(data[:len(data)-10]), int64(len(data)))
+	assert.ErrorIs(t, err, ErrTruncated)
+
+	// Integers longer than 8 bytes are never read into memory
+	for name, cluster := range map[string][]byte{
+		"ClusterTime":   element(mkvClusterID, element(mkvClusterTimeID, zeros(4096))),
+		"BlockDuration": element(mkvClusterID, element(mkvBlockGroupID, element(mkvBlockDurID, zeros(4096)))),
+	} {
+		corrupt := append(header, element(mkvSegmentID, info, tracks, cluster)...)
+		_, err = ExtractSubtitles(bytes.NewReader(corrupt), int64(len(corrupt)))
+		assert.ErrorIs(t, err, ErrCorrupt, name)
+	}
+}
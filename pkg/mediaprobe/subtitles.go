@@ -0,0 +1,263 @@
+// Extraction of text subtitle tracks muxed into Matroska media.
+
+package mediaprobe
+
+import (
+	"Popcorn/pkg/subtitle"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+// Cues shown for longer are cut short when blocks carry no duration of their own.
+const defaultCueDuration = 3 * time.Second
+
+// Subtitle codecs holding plain text, others (PGS, VobSub) are bitmaps and can't be extracted.
+var textSubtitleCodecs = map[string]bool{"subrip": true, "webvtt": true, "ass": true, "ssa": true}
+
+// Subtitle track extracted from media along with its cues.
+type Subtitles struct {
+	Track SubtitleTrack
+	Cues  []subtitle.Cue
+}
+
+// Extracts text subtitle tracks from Matroska media having size bytes, MP4 media is reported to carry none.
+// Clusters are walked through till the end of media, blocks of other tracks are skipped.
+func ExtractSubtitles(r io.Reader, size int64) ([]Subtitles, error) {
+	src, head, err := newSource(r, size)
+	if err != nil {
+		return nil, err
+	} else if string(head[4:8]) == "ftyp" {
+		return nil, nil
+	} else if !bytes.Equal(head[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		return nil, ErrUnknownContainer
+	}
+	_, segmentEnd, err := openSegment(src)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := &mkvExtractor{scale: 1000000, tracks: map[uint64]int{}}
+	for src.offset < segmentEnd {
+		id, size, err := readElementHeader(src)
+		if err != nil {
+			return nil, err
+		} else if size < 0 {
+			// Elements of unknown size (live muxed clusters) can't be skipped through
+			break
+		} else if src.offset+size > segmentEnd {
+			return nil, ErrTruncated
+		}
+		switch id {
+		case mkvInfoID, mkvTracksID:
+			if size > maxMetadataSize {
+				return nil, ErrCorrupt
+			}
+			body, err := src.read(size)
+			if err != nil {
+				return nil, err
+			}
+			if id == mkvInfoID {
+				err = ext.parseInfo(body)
+			} else {
+				err = ext.parseTracks(body)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case mkvClusterID:
+			if err := ext.walkCluster(src, src.offset+size); err != nil {
+				return nil, err
+			}
+		default:
+			if err := src.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ext.subtitles, nil
+}
+
+// mkvExtractor keeps the state of text tracks while walking through clusters.
+type mkvExtractor struct {
+	// Nanoseconds per timecode unit
+	scale     uint64
+	subtitles []Subtitles
+	// Track number to its index in subtitles
+	tracks map[uint64]int
+	// Default cue duration per track number, in nanoseconds
+	durations map[uint64]uint64
+}
+
+func (e *mkvExtractor) parseInfo(body []byte) error {
+	return eachElement(body, func(id uint64, data []byte) error {
+		if id == mkvTimecodeScale {
+			if scale := ebmlUint(data); scale != 0 {
+				e.scale = scale
+			}
+		}
+		return nil
+	})
+}
+
+func (e *mkvExtractor) parseTracks(body []byte) error {
+	e.durations = map[uint64]uint64{}
+	return eachElement(body, func(id uint64, entry []byte) error {
+		if id != mkvTrackEntryID {
+			return nil
+		}
+		track, err := parseMkvTrackEntry(entry)
+		if err != nil {
+			return err
+		} else if track.trackType != mkvTrackSubtitle || !textSubtitleCodecs[track.codec] {
+			return nil
+		}
+		e.tracks[track.number] = len(e.subtitles)
+		e.durations[track.number] = track.defaultDuration
+		e.subtitles = append(e.subtitles, Subtitles{
+			Track: SubtitleTrack{Codec: track.codec, Language: track.language, Name: track.name},
+			Cues:  []subtitle.Cue{},
+		})
+		return nil
+	})
+}
+
+// Helper to walk cluster children till end and collect cues of text tracks.
+func (e *mkvExtractor) walkCluster(src *source, end int64) error {
+	if len(e.tracks) == 0 {
+		// Nothing to extract
+		return src.skip(end - src.offset)
+	}
+	clusterTime := uint64(0)
+	for src.offset < end {
+		id, size, err := readElementHeader(src)
+		if err != nil {
+			return err
+		} else if size < 0 || src.offset+size > end {
+			return ErrCorrupt
+		}
+		switch id {
+		case mkvClusterTimeID:
+			if size > 8 {
+				// Unsigned integers are 8 bytes at most
+				return ErrCorrupt
+			}
+			body, err := src.read(size)
+			if err != nil {
+				return err
+			}
+			clusterTime = ebmlUint(body)
+		case mkvSimpleBlockID:
+			track, relTime, data, err := e.readBlock(src, size)
+			if err != nil {
+				return err
+			} else if data != nil {
+				e.addCue(track, int64(clusterTime)+relTime, 0, data)
+			}
+		case mkvBlockGroupID:
+			if err := e.walkBlockGroup(src, src.offset+size, clusterTime); err != nil {
+				return err
+			}
+		default:
+			if err := src.skip(size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *mkvExtractor) walkBlockGroup(src *source, end int64, clusterTime uint64) error {
+	var (
+		track, duration uint64
+		relTime         int64
+		data            []byte
+	)
+	for src.offset < end {
+		id, size, err := readElementHeader(src)
+		if err != nil {
+			return err
+		} else if size < 0 || src.offset+size > end {
+			return ErrCorrupt
+		}
+		switch id {
+		case mkvBlockID:
+			track, relTime, data, err = e.readBlock(src, size)
+			if err != nil {
+				return err
+			}
+		case mkvBlockDurID:
+			if size > 8 {
+				// Unsigned integers are 8 bytes at most
+				return ErrCorrupt
+			}
+			body, err := src.read(size)
+			if err != nil {
+				return err
+			}
+			duration = ebmlUint(body)
+		default:
+			if err := src.skip(size); err != nil {
+				return err
+			}
+		}
+	}
+	if data != nil {
+		e.addCue(track, int64(clusterTime)+relTime, duration, data)
+	}
+	return nil
+}
+
+// Helper to read a block having size bytes, data is nil for blocks of other tracks.
+func (e *mkvExtractor) readBlock(src *source, size int64) (uint64, int64, []byte, error) {
+	first, err := src.read(1)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length := vintLength(first[0])
+	if length == 0 || int64(length)+3 > size {
+		return 0, 0, nil, ErrCorrupt
+	}
+	rest, err := src.read(int64(length - 1))
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	track, _ := vintValue(append(first, rest...))
+	header, err := src.read(3)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	relTime := int64(int16(binary.BigEndian.Uint16(header[:2])))
+	remaining := size - int64(length) - 3
+	// Laced blocks hold several frames, text tracks are never laced
+	if _, ok := e.tracks[track]; !ok || header[2]&0x06 != 0 || remaining > maxMetadataSize {
+		return track, relTime, nil, src.skip(remaining)
+	}
+	data, err := src.read(remaining)
+	return track, relTime, data, err
+}
+
+// Helper to add a cue starting at timecode lasting for duration timecode units, 0 if unknown.
+func (e *mkvExtractor) addCue(track uint64, timecode int64, duration uint64, data []byte) {
+	if timecode < 0 {
+		timecode = 0
+	}
+	start := time.Duration(uint64(timecode) * e.scale)
+	end := start + defaultCueDuration
+	if duration != 0 {
+		end = start + time.Duration(duration*e.scale)
+	} else if e.durations[track] != 0 {
+		end = start + time.Duration(e.durations[track])
+	}
+	subs := &e.subtitles[e.tracks[track]]
+	text := strings.TrimRight(string(data), "\x00")
+	if subs.Track.Codec == "ass" || subs.Track.Codec == "ssa" {
+		text = subtitle.FromASS(text)
+	}
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	subs.Cues = append(subs.Cues, subtitle.Cue{Start: start, End: end, Text: text})
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/xid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // This middleware will be used to populate every incoming request's context with an Unique CorrelationID.
@@ -16,5 +18,7 @@ func CorrelationMiddleware(logger log.Logger) gin.HandlerFunc {
 		gctx.Set("correlation_id", correlationID)
 		// Setting the correlationID to response header
 		gctx.Writer.Header().Set("X-Correlation-ID", correlationID)
+		// Request span carries the correlationID too, to look traces up from logs and responses
+		trace.SpanFromContext(gctx.Request.Context()).SetAttributes(attribute.String("popcorn.correlation_id", correlationID))
 	}
 }
@@ -0,0 +1,131 @@
+// Prometheus metrics of Popcorn, collected all over the service and exposed through Handler.
+
+package monitor
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace every Popcorn metric is prefixed with.
+const namespace = "popcorn"
+
+// Registry holding Popcorn metrics alone, along with go runtime and process metrics.
+var registry = prometheus.NewRegistry()
+
+var (
+	// HTTP requests served, by route pattern and response status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests served, by method, route and status.",
+	}, []string{"method", "route", "status"})
+	// Latency of HTTP requests, by route pattern
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	// Clients connected over SSE
+	SSEConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sse",
+		Name:      "connections",
+		Help:      "Clients currently connected over SSE.",
+	})
+	// SSE events dropped as their recipient wasn't connected
+	SSEDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sse",
+		Name:      "dropped_events_total",
+		Help:      "SSE events dropped as their recipient wasn't connected.",
+	})
+	// Bytes of finished uploads
+	UploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "bytes_total",
+		Help:      "Bytes of finished uploads.",
+	})
+	// Time uploads took from creation till finish
+	UploadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "duration_seconds",
+		Help:      "Time uploads took from creation till finish.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	})
+	// Latency of redis calls, by command
+	RedisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of redis calls, by command. Pipelines and transactions are labelled pipeline.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+	// Failed redis calls, by command
+	RedisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "errors_total",
+		Help:      "Failed redis calls, by command. Missing keys and lost optimistic locks aren't counted.",
+	}, []string{"command"})
+	// Rejected authentication attempts, by token kind or login, and reason
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "failures_total",
+		Help:      "Rejected authentication attempts, by kind and reason.",
+	}, []string{"kind", "reason"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		SSEConnections,
+		SSEDropped,
+		UploadBytes,
+		UploadDuration,
+		RedisDuration,
+		RedisErrors,
+		AuthFailures,
+	)
+}
+
+// RegisterGauge registers a gauge whose value is taken from value on every scrape.
+// Gauges are registered once, on server start up.
+func RegisterGauge(name, help string, value func() float64) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, value))
+}
+
+// Handler returns an http.Handler serving Popcorn metrics in the Prometheus exposition format.
+// Scrapes must carry token as a bearer token unless it's empty.
+func Handler(token string) http.Handler {
+	metrics := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return metrics
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+// Prometheus metrics tests in Popcorn.
+
+package monitor
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to scrape handler with the given Authorization header and return the response.
+func scrape(t *testing.T, handler http.Handler, authorization string) (int, string) {
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	HTTPRequests.WithLabelValues(http.MethodGet, "/api/gang/get", "200").Inc()
+	AuthFailures.WithLabelValues("login", "invalid_credentials").Inc()
+	RegisterGauge("test_gauge", "Gauge of monitor tests.", func() float64 { return 7 })
+
+	// Scrapes are refused without the token
+	handler := Handler("popcorn")
+	code, _ := scrape(t, handler, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = scrape(t, handler, "Bearer butter")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, body := scrape(t, handler, "Bearer popcorn")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `popcorn_http_requests_total{method="GET",route="/api/gang/get",status="200"} 1`)
+	assert.Contains(t, body, `popcorn_auth_failures_total{kind="login",reason="invalid_credentials"} 1`)
+	assert.Contains(t, body, "popcorn_test_gauge 7")
+	assert.True(t, strings.Contains(body, "go_goroutines"))
+
+	// Metrics served on their own listener may go without a token
+	code, _ = scrape(t, Handler(""), "")
+	assert.Equal(t, http.StatusOK, code)
+}
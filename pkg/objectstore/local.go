@@ -0,0 +1,129 @@
+// Local disk storage backend, uploads are kept inside UPLOAD_PATH.
+
+package objectstore
+
+import (
+	"Popcorn/pkg/signedurl"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/tus/tusd/pkg/filestore"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+// localStore keeps uploads on local disk via tusd filestore.
+type localStore struct {
+	path      string
+	publicURL string
+	signer    signedurl.Signer
+	store     filestore.FileStore
+	composer  *tusd.StoreComposer
+}
+
+// Returns a Store keeping uploads inside path, which is created if missing.
+// Uploads are pulled by livekit ingress from publicURL through URLs signed by signer.
+func NewLocalStore(path string, publicURL string, signer signedurl.Signer) (Store, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(path, 0777); err != nil {
+			return nil, err
+		}
+	}
+	s := localStore{
+		path:      path,
+		publicURL: publicURL,
+		signer:    signer,
+		store:     filestore.FileStore{Path: path},
+		composer:  tusd.NewStoreComposer(),
+	}
+	s.store.UseIn(s.composer)
+	return s, nil
+}
+
+func (s localStore) UseIn(composer *tusd.StoreComposer) {
+	s.store.UseIn(composer)
+}
+
+func (s localStore) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	return open(ctx, s.store, id)
+}
+
+func (s localStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.store.GetUpload(ctx, id); err != nil {
+		// tusd can't open uploads missing either of their files, whichever one is left is removed here
+		for _, name := range []string{id, id + ".info"} {
+			if oserr := os.Remove(filepath.Join(s.path, name)); oserr != nil && !os.IsNotExist(oserr) {
+				return oserr
+			}
+		}
+		return nil
+	}
+	return terminate(ctx, s.store, s.composer, id)
+}
+
+func (s localStore) List(ctx context.Context) ([]Object, error) {
+	objects := []Object{}
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return objects, nil
+		}
+		return objects, err
+	}
+	// Every upload is made of a data file and a tusd .info file
+	files := map[string][]fs.DirEntry{}
+	for _, dirEntry := range entries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		id := strings.TrimSuffix(dirEntry.Name(), ".info")
+		files[id] = append(files[id], dirEntry)
+	}
+	for id, dirEntries := range files {
+		object := Object{ID: id, Size: UnknownSize}
+		for _, dirEntry := range dirEntries {
+			stat, err := dirEntry.Info()
+			if err != nil {
+				// File removed in between
+				continue
+			}
+			if modified := stat.ModTime().Unix(); dirEntry.Name() == id || object.Modified == 0 {
+				// Data file is the one written to while uploading
+				object.Modified = modified
+			}
+		}
+		if object.Modified == 0 {
+			// Upload removed in between
+			continue
+		}
+		if len(dirEntries) == 2 {
+			info, err := uploadInfo(ctx, s.store, id)
+			if err == tusd.ErrNotFound {
+				// Upload removed in between
+				continue
+			} else if err == nil {
+				object.Size, object.Offset = info.Size, info.Offset
+			}
+		}
+		// Uploads missing either of their files are listed with UnknownSize, so that they can still be deleted
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func (s localStore) AvailableSpace(ctx context.Context) (uint64, error) {
+	fs := syscall.Statfs_t{}
+	err := syscall.Statfs(s.path, &fs)
+	if err != nil {
+		return 0, err
+	}
+	return fs.Bfree * uint64(fs.Bsize), nil
+}
+
+func (s localStore) PullURL(ctx context.Context, id string) (string, error) {
+	return s.signer.Sign(s.publicURL+"/api/upload_content/"+id, id)
+}
@@ -0,0 +1,185 @@
+// S3 compatible storage backend, works with AWS S3 and self hosted stand-ins such as MinIO.
+
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	tusd "github.com/tus/tusd/pkg/handler"
+	"github.com/tus/tusd/pkg/s3store"
+)
+
+// Presigned pull URLs stay valid for 6 hours unless configured otherwise.
+const defaultPresignExpiry = 360
+
+// Configuration needed to connect with an S3 compatible bucket.
+type S3Config struct {
+	Bucket   string
+	Region   string
+	Endpoint string
+	// Static credentials, the default AWS credential chain is used when left blank
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix under which uploads are kept inside the bucket
+	ObjectPrefix string
+	// Bytes allowed to be stored in the bucket, 0 means unlimited
+	Capacity uint64
+	// Minutes for which presigned pull URLs stay valid
+	PresignExpiry int
+}
+
+// s3Store keeps uploads in an S3 compatible bucket via tusd s3store.
+type s3Store struct {
+	config   S3Config
+	client   *s3.S3
+	store    s3store.S3Store
+	composer *tusd.StoreComposer
+}
+
+// Returns a Store keeping uploads in the configured S3 compatible bucket.
+func NewS3Store(config S3Config) (Store, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PresignExpiry <= 0 {
+		config.PresignExpiry = defaultPresignExpiry
+	}
+	awsConfig := aws.NewConfig().WithRegion(config.Region)
+	if config.Endpoint != "" {
+		// Self hosted stand-ins don't support virtual hosted buckets
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint).WithS3ForcePathStyle(true)
+	}
+	if config.AccessKeyID != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, ""))
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.New(sess)
+	store := s3store.New(config.Bucket, client)
+	store.ObjectPrefix = config.ObjectPrefix
+	s := s3Store{
+		config:   config,
+		client:   client,
+		store:    store,
+		composer: tusd.NewStoreComposer(),
+	}
+	s.store.UseIn(s.composer)
+	return s, nil
+}
+
+func (s s3Store) UseIn(composer *tusd.StoreComposer) {
+	s.store.UseIn(composer)
+}
+
+func (s s3Store) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	return open(ctx, s.store, id)
+}
+
+func (s s3Store) Delete(ctx context.Context, id string) error {
+	return terminate(ctx, s.store, s.composer, id)
+}
+
+func (s s3Store) List(ctx context.Context) ([]Object, error) {
+	objects := []Object{}
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.config.Bucket),
+		Prefix: aws.String(s.prefix()),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			// tusd keeps upload ID and size inside the .info object
+			if !strings.HasSuffix(aws.StringValue(obj.Key), ".info") {
+				continue
+			}
+			info, err := s.fetchInfo(ctx, aws.StringValue(obj.Key))
+			if err == nil {
+				// Offset is calculated from the uploaded parts
+				info, err = uploadInfo(ctx, s.store, info.ID)
+			}
+			if err != nil {
+				// Upload removed in between
+				continue
+			}
+			objects = append(objects, Object{
+				ID:       info.ID,
+				Size:     info.Size,
+				Offset:   info.Offset,
+				Modified: aws.TimeValue(obj.LastModified).Unix(),
+			})
+		}
+		return true
+	})
+	return objects, err
+}
+
+func (s s3Store) AvailableSpace(ctx context.Context) (uint64, error) {
+	if s.config.Capacity == 0 {
+		// Buckets don't run out of space
+		return math.MaxUint64, nil
+	}
+	used := uint64(0)
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.config.Bucket),
+		Prefix: aws.String(s.prefix()),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			used += uint64(aws.Int64Value(obj.Size))
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	} else if used >= s.config.Capacity {
+		return 0, nil
+	}
+	return s.config.Capacity - used, nil
+}
+
+func (s s3Store) PullURL(ctx context.Context, id string) (string, error) {
+	// tusd s3store IDs are made of object ID and multipart upload ID
+	objectID, _, _ := strings.Cut(id, "+")
+	request, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.prefix() + objectID),
+	})
+	request.SetContext(ctx)
+	return request.Presign(time.Duration(s.config.PresignExpiry) * time.Minute)
+}
+
+// Helper to fetch upload metadata written by tusd s3store.
+func (s s3Store) fetchInfo(ctx context.Context, key string) (tusd.FileInfo, error) {
+	info := tusd.FileInfo{}
+	res, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return info, err
+	}
+	defer res.Body.Close()
+	err = json.NewDecoder(res.Body).Decode(&info)
+	return info, err
+}
+
+// Helper to build the key prefix the same way tusd s3store does.
+func (s s3Store) prefix() string {
+	prefix := s.config.ObjectPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
@@ -0,0 +1,125 @@
+// Pluggable storage backends used to keep uploaded gang contents in Popcorn.
+
+package objectstore
+
+import (
+	"Popcorn/pkg/signedurl"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+var (
+	STORAGE_BACKEND        string = os.Getenv("STORAGE_BACKEND")
+	UPLOAD_PATH            string = os.Getenv("UPLOAD_PATH")
+	APP_URL                string = os.Getenv("ACCESS_CTL_ALLOW_ORGIN")
+	S3_BUCKET              string = os.Getenv("S3_BUCKET")
+	S3_REGION              string = os.Getenv("S3_REGION")
+	S3_ENDPOINT            string = os.Getenv("S3_ENDPOINT")
+	S3_ACCESS_KEY_ID       string = os.Getenv("S3_ACCESS_KEY_ID")
+	S3_SECRET_ACCESS_KEY   string = os.Getenv("S3_SECRET_ACCESS_KEY")
+	S3_OBJECT_PREFIX       string = os.Getenv("S3_OBJECT_PREFIX")
+	S3_STORAGE_CAPACITY    string = os.Getenv("S3_STORAGE_CAPACITY")
+	S3_PRESIGN_EXPIRY_MINS string = os.Getenv("S3_PRESIGN_EXPIRY_MINS")
+)
+
+// Supported storage backends.
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Store keeps uploaded contents and is shared by tusd, cleanups and livestreams.
+type Store interface {
+	// UseIn registers the store as tusd data store.
+	UseIn(composer *tusd.StoreComposer)
+	// Open returns a reader for a finished upload.
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+	// Delete removes an upload along with its metadata.
+	Delete(ctx context.Context, id string) error
+	// List returns every upload kept in the store.
+	List(ctx context.Context) ([]Object, error)
+	// AvailableSpace returns the number of bytes which can still be stored.
+	AvailableSpace(ctx context.Context) (uint64, error)
+	// PullURL returns an URL from which livekit ingress can pull the upload.
+	PullURL(ctx context.Context, id string) (string, error)
+}
+
+// Size of uploads missing either their data or their .info file, left behind by a crash mid-create or mid-delete.
+const UnknownSize int64 = -1
+
+// Upload kept in a Store.
+type Object struct {
+	ID string
+	// Declared size of the upload, UnknownSize if the upload is missing either of its files
+	Size int64
+	// Bytes received so far, equals Size once the upload is finished
+	Offset int64
+	// Last time the upload was written to, S3 only keeps its creation time
+	Modified int64
+}
+
+// Returns true if every byte of the upload has been received.
+func (o Object) Finished() bool {
+	return o.Size != UnknownSize && o.Offset == o.Size
+}
+
+// Returns the Store selected by STORAGE_BACKEND, local filestore is used by default.
+// Local uploads are pulled through URLs signed by signer, S3 presigns them on its own.
+func New(signer signedurl.Signer) (Store, error) {
+	switch strings.ToLower(STORAGE_BACKEND) {
+	case BackendS3:
+		capacity, _ := strconv.ParseUint(S3_STORAGE_CAPACITY, 10, 64)
+		expiry, _ := strconv.Atoi(S3_PRESIGN_EXPIRY_MINS)
+		return NewS3Store(S3Config{
+			Bucket:          S3_BUCKET,
+			Region:          S3_REGION,
+			Endpoint:        S3_ENDPOINT,
+			AccessKeyID:     S3_ACCESS_KEY_ID,
+			SecretAccessKey: S3_SECRET_ACCESS_KEY,
+			ObjectPrefix:    S3_OBJECT_PREFIX,
+			Capacity:        capacity,
+			PresignExpiry:   expiry,
+		})
+	default:
+		return NewLocalStore(UPLOAD_PATH, APP_URL, signer)
+	}
+}
+
+// Helper to delete an upload through tusd, which knows every file or object an upload is made of.
+func terminate(ctx context.Context, dataStore tusd.DataStore, composer *tusd.StoreComposer, id string) error {
+	upload, err := dataStore.GetUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+	return composer.Terminater.AsTerminatableUpload(upload).Terminate(ctx)
+}
+
+// Helper to fetch tusd upload info, which knows how many bytes of an upload have been received.
+func uploadInfo(ctx context.Context, dataStore tusd.DataStore, id string) (tusd.FileInfo, error) {
+	upload, err := dataStore.GetUpload(ctx, id)
+	if err != nil {
+		return tusd.FileInfo{}, err
+	}
+	return upload.GetInfo(ctx)
+}
+
+// Helper to open a finished upload through tusd.
+func open(ctx context.Context, dataStore tusd.DataStore, id string) (io.ReadCloser, error) {
+	upload, err := dataStore.GetUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := upload.GetReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.ReadCloser); ok {
+		return closer, nil
+	}
+	return io.NopCloser(reader), nil
+}
@@ -0,0 +1,213 @@
+// Content storage backend tests in Popcorn.
+
+package objectstore
+
+import (
+	"Popcorn/pkg/signedurl"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+// Global context
+var ctx context.Context = context.Background()
+
+// Content used across the tests, starts with the magic bytes of a mp4 container.
+var content []byte = append([]byte{0x00, 0x00, 0x00, 0x18, 'f', 't', 'y', 'p', 'm', 'p', '4', '2'}, bytes.Repeat([]byte("popcorn"), 64)...)
+
+// Helper to upload content into store the same way tusd handler does, returns the upload ID.
+func upload(t *testing.T, store Store) string {
+	composer := tusd.NewStoreComposer()
+	store.UseIn(composer)
+	upload, err := composer.Core.NewUpload(ctx, tusd.FileInfo{
+		Size:     int64(len(content)),
+		MetaData: tusd.MetaData{"filename": "movie.mp4", "filetype": "video/mp4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.WriteChunk(ctx, 0, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = upload.FinishUpload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	info, err := upload.GetInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ID
+}
+
+// Helper to run the checks shared by every Store implementation.
+func testStore(t *testing.T, store Store) string {
+	id := upload(t, store)
+
+	// Uploaded content can be read back
+	reader, err := store.Open(ctx, id)
+	if assert.NoError(t, err) {
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+	}
+
+	// Upload is listed with its ID and size
+	objects, err := store.List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, objects, 1) {
+		assert.Equal(t, id, objects[0].ID)
+		assert.Equal(t, int64(len(content)), objects[0].Size)
+		assert.True(t, objects[0].Finished())
+	}
+
+	space, err := store.AvailableSpace(ctx)
+	assert.NoError(t, err)
+	assert.NotZero(t, space)
+
+	pullURL, err := store.PullURL(ctx, id)
+	assert.NoError(t, err)
+
+	// Deleted upload is gone along with its metadata
+	assert.NoError(t, store.Delete(ctx, id))
+	_, err = store.Open(ctx, id)
+	assert.Error(t, err)
+	objects, err = store.List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+	return pullURL
+}
+
+func TestLocalStore(t *testing.T) {
+	signer := signedurl.NewSigner([]byte("popcorn"), time.Hour)
+	store, err := NewLocalStore(t.TempDir(), "https://popcorn.test", signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullURL := testStore(t, store)
+	assert.True(t, strings.HasPrefix(pullURL, "https://popcorn.test/api/upload_content/"))
+
+	// Pull URL is signed for the upload
+	u, err := url.Parse(pullURL)
+	if assert.NoError(t, err) {
+		id := strings.TrimPrefix(u.Path, "/api/upload_content/")
+		assert.NoError(t, signer.Verify(id, u.Query()))
+	}
+
+	// Partial uploads are listed with the bytes received so far
+	composer := tusd.NewStoreComposer()
+	store.UseIn(composer)
+	partial, err := composer.Core.NewUpload(ctx, tusd.FileInfo{Size: int64(len(content))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = partial.WriteChunk(ctx, 0, bytes.NewReader(content[:100])); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := store.List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, objects, 1) {
+		assert.Equal(t, int64(100), objects[0].Offset)
+		assert.False(t, objects[0].Finished())
+	}
+}
+
+func TestLocalStoreUnpaired(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir, "https://popcorn.test", signedurl.NewSigner([]byte("popcorn"), time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataOnly := upload(t, store)
+	infoOnly := upload(t, store)
+	if err = os.Remove(filepath.Join(dir, dataOnly+".info")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, infoOnly)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Uploads missing either of their files are listed, but never finished
+	objects, err := store.List(ctx)
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, object := range objects {
+		ids = append(ids, object.ID)
+		assert.Equal(t, UnknownSize, object.Size)
+		assert.False(t, object.Finished())
+		assert.NotZero(t, object.Modified)
+	}
+	assert.ElementsMatch(t, []string{dataOnly, infoOnly}, ids)
+
+	// Whichever file is left gets deleted
+	assert.NoError(t, store.Delete(ctx, dataOnly))
+	assert.NoError(t, store.Delete(ctx, infoOnly))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestS3Store(t *testing.T) {
+	// In-memory S3 compatible stand-in
+	backend := s3mem.New()
+	faker := gofakes3.New(backend)
+	srv := httptest.NewServer(faker.Server())
+	defer srv.Close()
+	if err := backend.CreateBucket("popcorn"); err != nil {
+		t.Fatal(err)
+	}
+
+	config := S3Config{
+		Bucket:          "popcorn",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "popcorn",
+		SecretAccessKey: "popcorn",
+		ObjectPrefix:    "uploads",
+	}
+	store, err := NewS3Store(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Presigned URL serves the content while it exists
+	id := upload(t, store)
+	pullURL, err := store.PullURL(ctx, id)
+	if assert.NoError(t, err) {
+		res, err := http.Get(pullURL)
+		if assert.NoError(t, err) {
+			data, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, content, data)
+		}
+	}
+	assert.NoError(t, store.Delete(ctx, id))
+
+	pullURL = testStore(t, store)
+	assert.True(t, strings.HasPrefix(pullURL, srv.URL+"/popcorn/uploads/"))
+
+	// Bucket capacity limits available space
+	config.Capacity = uint64(len(content)) * 2
+	store, err = NewS3Store(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id = upload(t, store)
+	space, err := store.AvailableSpace(ctx)
+	assert.NoError(t, err)
+	assert.Less(t, space, uint64(len(content))+1)
+	assert.NoError(t, store.Delete(ctx, id))
+}
@@ -0,0 +1,96 @@
+// Expiring HMAC signed URLs, lets livekit ingress pull uploaded contents without exposing them to everyone.
+
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	CONTENT_URL_SECRET      string = os.Getenv("CONTENT_URL_SECRET")
+	CONTENT_URL_EXPIRY_MINS string = os.Getenv("CONTENT_URL_EXPIRY_MINS")
+)
+
+// Signed URLs stay valid for 6 hours unless configured otherwise, ingress keeps seeking through the content while streaming.
+const defaultExpiry = 360 * time.Minute
+
+// Errors returned while verifying signed URLs.
+var (
+	ErrMissingSignature = errors.New("url is not signed")
+	ErrInvalidSignature = errors.New("url signature is invalid")
+	ErrExpired          = errors.New("url signature has expired")
+)
+
+// Signer signs URLs bound to an upload ID and verifies them later on.
+type Signer struct {
+	secret []byte
+	expiry time.Duration
+}
+
+// Returns a Signer configured via CONTENT_URL_SECRET and CONTENT_URL_EXPIRY_MINS.
+// A random secret is used if none is set, URLs signed with it don't survive restarts.
+func New() (Signer, error) {
+	secret := []byte(CONTENT_URL_SECRET)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Signer{}, err
+		}
+	}
+	expiry := defaultExpiry
+	if mins, err := strconv.Atoi(CONTENT_URL_EXPIRY_MINS); err == nil && mins > 0 {
+		expiry = time.Duration(mins) * time.Minute
+	}
+	return NewSigner(secret, expiry), nil
+}
+
+// Returns a Signer using secret, signed URLs stay valid for expiry.
+func NewSigner(secret []byte, expiry time.Duration) Signer {
+	return Signer{secret: secret, expiry: expiry}
+}
+
+// Returns rawURL along with expiry and signature bound to id as query parameters.
+func (s Signer) Sign(rawURL, id string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(s.expiry).Unix(), 10)
+	query := u.Query()
+	query.Set("expires", expires)
+	query.Set("signature", s.signature(id, expires))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Returns an error unless query carries an unexpired signature bound to id.
+func (s Signer) Verify(id string, query url.Values) error {
+	expires, signature := query.Get("expires"), query.Get("signature")
+	if expires == "" || signature == "" {
+		return ErrMissingSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(id, expires))) {
+		return ErrInvalidSignature
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	} else if time.Now().Unix() > expiresAt {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (s Signer) signature(id, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(id + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,71 @@
+// Signed URL tests in Popcorn.
+
+package signedurl
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to sign rawURL and return its query parameters.
+func signedQuery(t *testing.T, signer Signer, rawURL, id string) url.Values {
+	signed, err := signer.Sign(rawURL, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer := NewSigner([]byte("popcorn"), time.Hour)
+	signed, err := signer.Sign("https://popcorn.test/api/upload_content/movie?v=1", "movie")
+	if !assert.NoError(t, err) {
+		return
+	}
+	u, _ := url.Parse(signed)
+	assert.Equal(t, "/api/upload_content/movie", u.Path)
+	assert.Equal(t, "1", u.Query().Get("v"))
+	assert.NoError(t, signer.Verify("movie", u.Query()))
+
+	// Signature is bound to the upload ID and the secret
+	assert.ErrorIs(t, signer.Verify("another-movie", u.Query()), ErrInvalidSignature)
+	assert.ErrorIs(t, NewSigner([]byte("butter"), time.Hour).Verify("movie", u.Query()), ErrInvalidSignature)
+
+	// Extending the expiry invalidates the signature
+	query := u.Query()
+	query.Set("expires", strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10))
+	assert.ErrorIs(t, signer.Verify("movie", query), ErrInvalidSignature)
+
+	query.Del("signature")
+	assert.ErrorIs(t, signer.Verify("movie", query), ErrMissingSignature)
+	assert.ErrorIs(t, signer.Verify("movie", url.Values{}), ErrMissingSignature)
+}
+
+func TestVerifyExpired(t *testing.T) {
+	signer := NewSigner([]byte("popcorn"), -time.Minute)
+	query := signedQuery(t, signer, "https://popcorn.test/api/upload_content/movie", "movie")
+	assert.ErrorIs(t, signer.Verify("movie", query), ErrExpired)
+}
+
+func TestNewWithoutSecret(t *testing.T) {
+	defer func(secret string) { CONTENT_URL_SECRET = secret }(CONTENT_URL_SECRET)
+	CONTENT_URL_SECRET = ""
+	first, err := New()
+	assert.NoError(t, err)
+	second, err := New()
+	assert.NoError(t, err)
+
+	// Random secrets differ, so signatures can't be forged without knowing them
+	query := signedQuery(t, first, "https://popcorn.test/api/upload_content/movie", "movie")
+	assert.NoError(t, first.Verify("movie", query))
+	assert.ErrorIs(t, second.Verify("movie", query), ErrInvalidSignature)
+	assert.Equal(t, defaultExpiry, first.expiry)
+}
@@ -0,0 +1,182 @@
+// Text subtitle parsing, every supported format is normalised to WebVTT before reaching the clients.
+
+package subtitle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Errors returned while parsing subtitles.
+var (
+	ErrInvalid = errors.New("invalid subtitle file")
+	ErrEmpty   = errors.New("subtitle file has no cues")
+)
+
+// Supported subtitle formats.
+const (
+	FormatSRT    = "srt"
+	FormatWebVTT = "vtt"
+)
+
+// Single subtitle cue, times are relative to the start of the content.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	// WebVTT cue settings such as position or alignment, kept as is
+	Settings string
+	Text     string
+}
+
+// Matches cue timings of both SRT (00:01:02,500) and WebVTT (01:02.500) files.
+var timingRegex = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})(.*)$`)
+
+// Matches ASS override blocks such as {\an8} or {\i1}.
+var assOverrideRegex = regexp.MustCompile(`\{[^}]*\}`)
+
+// Returns the format of data, files without WebVTT header are taken as SRT.
+func Detect(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if bytes.HasPrefix(data, []byte("WEBVTT")) {
+		return FormatWebVTT
+	}
+	return FormatSRT
+}
+
+// Parses SRT or WebVTT data into cues, the format is detected from data.
+func Parse(data []byte) ([]Cue, error) {
+	text := strings.TrimPrefix(normalise(data), "\uFEFF")
+	isVTT := Detect([]byte(text)) == FormatWebVTT
+	blocks := strings.Split(text, "\n\n")
+	if isVTT {
+		// Header block carries no cues
+		blocks = blocks[1:]
+	}
+	cues := []Cue{}
+	for _, block := range blocks {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if isVTT && (strings.HasPrefix(lines[0], "NOTE") || lines[0] == "STYLE" || lines[0] == "REGION") {
+			continue
+		}
+		// Cue identifier (SRT counter or WebVTT cue ID) comes before the timings, if any
+		timing := 0
+		for timing < len(lines) && !strings.Contains(lines[timing], "-->") {
+			timing++
+		}
+		if timing == len(lines) {
+			if strings.TrimSpace(block) == "" {
+				continue
+			}
+			return nil, fmt.Errorf("%w: cue without timings", ErrInvalid)
+		}
+		match := timingRegex.FindStringSubmatch(strings.TrimSpace(lines[timing]))
+		if match == nil {
+			return nil, fmt.Errorf("%w: malformed cue timings %q", ErrInvalid, lines[timing])
+		}
+		start, err := parseTimestamp(match[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimestamp(match[2])
+		if err != nil {
+			return nil, err
+		}
+		cue := Cue{Start: start, End: end, Text: strings.Join(lines[timing+1:], "\n")}
+		if isVTT {
+			cue.Settings = strings.TrimSpace(match[3])
+		}
+		if end < start || strings.TrimSpace(cue.Text) == "" {
+			// Nothing to show
+			continue
+		}
+		cues = append(cues, cue)
+	}
+	if len(cues) == 0 {
+		return nil, ErrEmpty
+	}
+	return cues, nil
+}
+
+// Returns dialogue text of an ASS or SSA event as plain cue text.
+// Matroska keeps events as ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV, Effect, Text.
+func FromASS(event string) string {
+	fields := strings.SplitN(event, ",", 9)
+	text := fields[len(fields)-1]
+	text = assOverrideRegex.ReplaceAllString(text, "")
+	text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+	return strings.TrimSpace(text)
+}
+
+// Returns cues serialised as a WebVTT file.
+func WebVTT(cues []Cue) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		buf.WriteString("\n" + formatTimestamp(cue.Start) + " --> " + formatTimestamp(cue.End))
+		if cue.Settings != "" {
+			buf.WriteString(" " + cue.Settings)
+		}
+		buf.WriteString("\n" + sanitise(cue.Text) + "\n")
+	}
+	return buf.Bytes()
+}
+
+// Helper to bring data to UTF-8 with unix line endings, files which aren't valid UTF-8 are taken as Latin-1.
+func normalise(data []byte) string {
+	text := string(data)
+	if !utf8.Valid(data) {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
+}
+
+// Helper to keep cue text from breaking the WebVTT structure.
+func sanitise(text string) string {
+	lines := []string{}
+	for _, line := range strings.Split(normalise([]byte(text)), "\n") {
+		// Blank lines end cues and arrows start new ones
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, strings.ReplaceAll(line, "-->", "->"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseTimestamp(value string) (time.Duration, error) {
+	value = strings.Replace(value, ",", ".", 1)
+	parts := strings.Split(value, ":")
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed timestamp %q", ErrInvalid, value)
+	}
+	total := time.Duration(seconds * float64(time.Second))
+	unit := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, fmt.Errorf("%w: malformed timestamp %q", ErrInvalid, value)
+		}
+		total += time.Duration(n) * unit
+		unit *= 60
+	}
+	return total.Round(time.Millisecond), nil
+}
+
+func formatTimestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,73 @@
+// Subtitle parsing tests in Popcorn.
+
+package subtitle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSRT(t *testing.T) {
+	srt := "\xEF\xBB\xBF1\r\n00:00:01,500 --> 00:00:04,000\r\nHello <i>there</i>\r\n\r\n" +
+		"2\r\n00:01:02,250 --> 00:01:05,000 X1:40 X2:600\r\nTwo\r\nlines\r\n\r\n\r\n"
+	assert.Equal(t, FormatSRT, Detect([]byte(srt)))
+	cues, err := Parse([]byte(srt))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []Cue{
+		{Start: 1500 * time.Millisecond, End: 4 * time.Second, Text: "Hello <i>there</i>"},
+		{Start: 62250 * time.Millisecond, End: 65 * time.Second, Text: "Two\nlines"},
+	}, cues)
+	assert.Equal(t, "WEBVTT\n\n00:00:01.500 --> 00:00:04.000\nHello <i>there</i>\n\n00:01:02.250 --> 00:01:05.000\nTwo\nlines\n", string(WebVTT(cues)))
+}
+
+func TestParseWebVTT(t *testing.T) {
+	vtt := "WEBVTT - Popcorn\nKind: captions\n\nNOTE translated by popcorn\n\nintro\n01:02.000 --> 01:03.500 align:start\nHey\n\n" +
+		"1:00:00.000 --> 1:00:01.000\nLast --> one\n"
+	assert.Equal(t, FormatWebVTT, Detect([]byte(vtt)))
+	cues, err := Parse([]byte(vtt))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []Cue{
+		{Start: 62 * time.Second, End: 63500 * time.Millisecond, Settings: "align:start", Text: "Hey"},
+		{Start: time.Hour, End: time.Hour + time.Second, Text: "Last --> one"},
+	}, cues)
+	// Arrows inside text would start a new cue
+	assert.Contains(t, string(WebVTT(cues)), "01:00:00.000 --> 01:00:01.000\nLast -> one\n")
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]struct {
+		data string
+		want error
+	}{
+		"Blank":          {"", ErrEmpty},
+		"HeaderOnly":     {"WEBVTT\n\n", ErrEmpty},
+		"NoTimings":      {"1\nHello\n", ErrInvalid},
+		"MalformedTimes": {"1\n00:00:01 --> 00:00:02\nHello\n", ErrInvalid},
+		"Binary":         {"\x00\x00\x00\x18ftypmp42", ErrInvalid},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(test.data))
+			assert.ErrorIs(t, err, test.want)
+		})
+	}
+}
+
+func TestLatin1(t *testing.T) {
+	cues, err := Parse([]byte("1\n00:00:01,000 --> 00:00:02,000\nCaf\xe9\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Café", cues[0].Text)
+	}
+}
+
+func TestFromASS(t *testing.T) {
+	assert.Equal(t, "Hello\nworld  again", FromASS(`12,0,Default,,0,0,0,,{\an8}Hello\Nworld\h {\i1}again{\i0}`))
+	assert.Equal(t, "Plain, with comma", FromASS("3,0,Default,Alice,0,0,0,,Plain, with comma"))
+}
@@ -0,0 +1,93 @@
+// OpenTelemetry tracing of Popcorn, spans are exported to stdout or an OTLP collector as configured via env.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// Exporter spans are sent through, either stdout or otlp. Spans aren't exported if not set.
+	// OTLP collector is configured through the standard OTEL_EXPORTER_OTLP_* variables, sampling through OTEL_TRACES_SAMPLER.
+	OTEL_TRACES_EXPORTER string = os.Getenv("OTEL_TRACES_EXPORTER")
+)
+
+// Name of the service spans are exported under, also used as the tracer name.
+const ServiceName = "popcorn"
+
+// Init sets up the global tracer provider with the configured exporter and the W3C trace context propagator.
+// Incoming traceparent headers are honoured even if spans aren't exported, so that logs carry the trace IDs.
+// Returns a function flushing the pending spans, to be called on shutdown.
+func Init(ctx context.Context, version string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var experr error
+	switch strings.ToLower(strings.TrimSpace(OTEL_TRACES_EXPORTER)) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, experr = stdouttrace.New()
+	case "otlp":
+		exporter, experr = otlptracehttp.New(ctx)
+	default:
+		experr = fmt.Errorf("unknown traces exporter %q", OTEL_TRACES_EXPORTER)
+	}
+	if experr != nil {
+		return nil, experr
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(ServiceName),
+			semconv.ServiceVersion(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of the span in ctx, if any.
+// The returned context carries the new span.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartClient starts a span of a call made to an external service, such as redis or livekit.
+func StartClient(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// End ends span, marking it failed if err isn't nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Detach returns a context carrying the span and correlation ID of ctx, without its cancellation and other values.
+// Used by goroutines outliving the request they got spawned by, their spans stay within the trace of the request.
+func Detach(ctx context.Context) context.Context {
+	detached := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+	if correlationID, ok := ctx.Value("correlation_id").(string); ok {
+		detached = context.WithValue(detached, "correlation_id", correlationID)
+	}
+	return detached
+}
@@ -0,0 +1,61 @@
+// Tracing tests in Popcorn.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInit(t *testing.T) {
+	defer func(exporter string) { OTEL_TRACES_EXPORTER = exporter }(OTEL_TRACES_EXPORTER)
+	ctx := context.Background()
+
+	OTEL_TRACES_EXPORTER = "jaeger"
+	_, err := Init(ctx, "test")
+	assert.Error(t, err)
+
+	OTEL_TRACES_EXPORTER = "none"
+	shutdown, err := Init(ctx, "test")
+	if assert.NoError(t, err) {
+		assert.NoError(t, shutdown(ctx))
+	}
+}
+
+func TestTraceparentPropagation(t *testing.T) {
+	defer func(exporter string) { OTEL_TRACES_EXPORTER = exporter }(OTEL_TRACES_EXPORTER)
+	OTEL_TRACES_EXPORTER = ""
+	if _, err := Init(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Handlers see the trace of the incoming traceparent header through gin context
+	var spanCtx trace.SpanContext
+	var detached context.Context
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.ContextWithFallback = true
+	router.Use(otelgin.Middleware(ServiceName))
+	router.GET("/trace", func(gctx *gin.Context) {
+		gctx.Set("correlation_id", "popcorn")
+		spanCtx = trace.SpanContextFromContext(gctx)
+		detached = Detach(gctx)
+		gctx.Status(http.StatusOK)
+	})
+	request := httptest.NewRequest(http.MethodGet, "/trace", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spanCtx.TraceID().String())
+
+	// Detached contexts keep the trace and correlation ID, but not the request cancellation
+	assert.Equal(t, spanCtx, trace.SpanContextFromContext(detached))
+	assert.Equal(t, "popcorn", detached.Value("correlation_id"))
+	assert.Nil(t, detached.Done())
+}
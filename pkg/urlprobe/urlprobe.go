@@ -0,0 +1,305 @@
+// Pre-flight probing of direct content URLs, catches dead links and non media pages before livekit ingress pulls them.
+// Probes never connect to private or loopback addresses, so users can't make Popcorn reach its own network.
+
+package urlprobe
+
+import (
+	"Popcorn/pkg/manifest"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	URL_PROBE_TIMEOUT_SECS  string = os.Getenv("URL_PROBE_TIMEOUT_SECS")
+	URL_PROBE_MAX_REDIRECTS string = os.Getenv("URL_PROBE_MAX_REDIRECTS")
+	URL_CONTENT_MAX_SIZE    string = os.Getenv("URL_CONTENT_MAX_SIZE")
+)
+
+// Probes give up after 10 seconds and 5 redirects unless configured otherwise.
+const (
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRedirects = 5
+)
+
+// Bytes read to sniff content type when the server doesn't declare one.
+const sniffSize = 512
+
+// Errors returned while probing URLs, messages are sent to clients as is.
+var (
+	ErrInvalidURL       = errors.New("url must be an absolute http or https url")
+	ErrPrivateAddress   = errors.New("url points to a private or loopback address")
+	ErrTooManyRedirects = errors.New("url redirects too many times")
+	ErrTimeout          = errors.New("url took too long to respond")
+	ErrUnreachable      = errors.New("url cannot be reached")
+	ErrUnsupportedType  = errors.New("url doesn't point to a supported media file")
+	ErrEmpty            = errors.New("url points to an empty file")
+	ErrTooLarge         = errors.New("url points to a file larger than allowed")
+)
+
+// StatusError is returned if the URL responded with a non successful status.
+type StatusError struct {
+	Code int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("url responded with status %d", e.Code)
+}
+
+// Media types livekit ingress can pull, generic binaries are let through as plenty of servers label media that way.
+var mediaTypes = map[string]bool{
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"audio/mpegurl":                 true,
+	"application/dash+xml":          true,
+	"application/octet-stream":      true,
+	"binary/octet-stream":           true,
+}
+
+// Shared address space of carrier grade NATs, not covered by net.IP.IsPrivate.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Config of a Prober.
+type Config struct {
+	// Time given to the whole probe including redirects
+	Timeout time.Duration
+	// Redirects followed before giving up
+	MaxRedirects int
+	// Content size limit in bytes, 0 if unlimited
+	MaxSize int64
+	// Lets probes reach private and loopback addresses, meant for local stand-ins only
+	AllowPrivate bool
+}
+
+// Outcome of a successful probe.
+type Result struct {
+	// URL reached after following redirects
+	URL string
+	// Declared or sniffed media type
+	ContentType string
+	// Content size in bytes, -1 if the server didn't tell
+	Size int64
+}
+
+// Prober checks content URLs before they are accepted.
+type Prober interface {
+	// Probe returns an error if rawURL can't be pulled as media content.
+	Probe(ctx context.Context, rawURL string) (Result, error)
+	// Fetch returns the body of rawURL, or ErrTooLarge if it's larger than limit bytes.
+	Fetch(ctx context.Context, rawURL string, limit int64) ([]byte, error)
+}
+
+// prober probes URLs through an HTTP client which refuses to dial blocked addresses.
+type prober struct {
+	client *http.Client
+	config Config
+}
+
+// Returns a Prober configured via URL_PROBE_TIMEOUT_SECS, URL_PROBE_MAX_REDIRECTS and URL_CONTENT_MAX_SIZE.
+func New() Prober {
+	config := Config{Timeout: defaultTimeout, MaxRedirects: defaultMaxRedirects}
+	if secs, err := strconv.Atoi(URL_PROBE_TIMEOUT_SECS); err == nil && secs > 0 {
+		config.Timeout = time.Duration(secs) * time.Second
+	}
+	if redirects, err := strconv.Atoi(URL_PROBE_MAX_REDIRECTS); err == nil && redirects >= 0 {
+		config.MaxRedirects = redirects
+	}
+	if size, err := strconv.ParseInt(URL_CONTENT_MAX_SIZE, 10, 64); err == nil && size > 0 {
+		config.MaxSize = size
+	}
+	return NewProber(config)
+}
+
+// Returns a Prober with the given config.
+func NewProber(config Config) Prober {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if !config.AllowPrivate {
+		// Checked against the resolved address, so DNS can't sneak a private address past the probe
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blocked(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		// Proxies would dial on behalf of the probe, bypassing the address check
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   config.Timeout,
+		ResponseHeaderTimeout: config.Timeout,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > config.MaxRedirects {
+				return ErrTooManyRedirects
+			} else if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidURL
+			}
+			return nil
+		},
+	}
+	return prober{client: client, config: config}
+}
+
+// Returns true if ip must not be reached by probes.
+func blocked(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || sharedAddressSpace.Contains(ip)
+}
+
+func (p prober) Probe(ctx context.Context, rawURL string) (Result, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return Result{}, ErrInvalidURL
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	// HEAD is cheap, but plenty of servers and presigned URLs only answer GET
+	result, err := p.head(ctx, target.String())
+	if err != nil || result.ContentType == "" {
+		result, err = p.get(ctx, target.String())
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(result.ContentType)
+	// Manifests are often served as plain text or generic XML, these are told apart by their extension
+	if !mediaTypes[mediaType] && !strings.HasPrefix(mediaType, "video/") && !strings.HasPrefix(mediaType, "audio/") &&
+		manifest.Detect(result.URL, mediaType) == "" {
+		return Result{}, ErrUnsupportedType
+	}
+	result.ContentType = mediaType
+	if result.Size == 0 {
+		return Result{}, ErrEmpty
+	} else if p.config.MaxSize > 0 && result.Size > p.config.MaxSize {
+		return Result{}, ErrTooLarge
+	}
+	return result, nil
+}
+
+func (p prober) Fetch(ctx context.Context, rawURL string, limit int64) ([]byte, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return nil, ErrInvalidURL
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, probeError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, StatusError{Code: resp.StatusCode}
+	} else if resp.ContentLength > limit {
+		return nil, ErrTooLarge
+	}
+	// One byte past limit tells bodies of undeclared length apart
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, probeError(err)
+	} else if int64(len(body)) > limit {
+		return nil, ErrTooLarge
+	}
+	return body, nil
+}
+
+// Helper to probe target through a HEAD request.
+func (p prober) head(ctx context.Context, target string) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return Result{}, ErrInvalidURL
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Result{}, probeError(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Result{}, StatusError{Code: resp.StatusCode}
+	}
+	return Result{
+		URL:         resp.Request.URL.String(),
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+	}, nil
+}
+
+// Helper to probe target through a GET request of its first bytes, content type gets sniffed if not declared.
+func (p prober) get(ctx context.Context, target string) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return Result{}, ErrInvalidURL
+	}
+	req.Header.Set("Range", "bytes=0-"+strconv.Itoa(sniffSize-1))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Result{}, probeError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Result{}, StatusError{Code: resp.StatusCode}
+	}
+	result := Result{
+		URL:         resp.Request.URL.String(),
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		// Content-Range carries the full size as bytes <first>-<last>/<size>
+		result.Size = -1
+		if _, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if total, err := strconv.ParseInt(size, 10, 64); err == nil {
+				result.Size = total
+			}
+		}
+	}
+	if result.ContentType == "" {
+		head, err := io.ReadAll(io.LimitReader(resp.Body, sniffSize))
+		if err != nil {
+			return Result{}, probeError(err)
+		}
+		result.ContentType = http.DetectContentType(head)
+	}
+	return result, nil
+}
+
+// Helper to map client errors onto probe errors, details of the underlying error aren't useful to clients.
+func probeError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrPrivateAddress):
+		return ErrPrivateAddress
+	case errors.Is(err, ErrTooManyRedirects):
+		return ErrTooManyRedirects
+	case errors.Is(err, ErrInvalidURL):
+		return ErrInvalidURL
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	default:
+		return ErrUnreachable
+	}
+}
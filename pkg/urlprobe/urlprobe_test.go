@@ -0,0 +1,182 @@
+// URL probe tests in Popcorn.
+
+package urlprobe
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Stand-in for content servers, serves bytes of every kind Popcorn has to tell apart.
+func standIn() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/movie.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Length", "2048")
+	})
+	mux.HandleFunc("/playlist.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl; charset=utf-8")
+		w.Write([]byte("#EXTM3U\n"))
+	})
+	mux.HandleFunc("/manifest.mpd", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<?xml version="1.0"?><MPD type="static"></MPD>`))
+	})
+	mux.HandleFunc("/playlist.txt.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("#EXTM3U\n"))
+	})
+	mux.HandleFunc("/manifest.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<?xml version="1.0"?><MPD type="static"></MPD>`))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><body>Not a movie</body></html>"))
+	})
+	mux.HandleFunc("/empty.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Length", "0")
+	})
+	mux.HandleFunc("/get-only", func(w http.ResponseWriter, r *http.Request) {
+		// Presigned URLs are bound to GET
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 0-511/4096")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(append([]byte("\x1a\x45\xdf\xa3"), make([]byte, 508)...))
+	})
+	mux.HandleFunc("/untyped", func(w http.ResponseWriter, r *http.Request) {
+		// Sniffed as HTML
+		w.Header()["Content-Type"] = nil
+		w.Write([]byte("<!DOCTYPE html><html></html>"))
+	})
+	mux.HandleFunc("/slow.mp4", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Header().Set("Content-Type", "video/mp4")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/movie.mp4", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestProbe(t *testing.T) {
+	server := standIn()
+	defer server.Close()
+	prober := NewProber(Config{Timeout: 200 * time.Millisecond, MaxRedirects: 2, MaxSize: 8192, AllowPrivate: true})
+	ctx := context.Background()
+
+	result, err := prober.Probe(ctx, server.URL+"/movie.mp4")
+	assert.NoError(t, err)
+	assert.Equal(t, Result{URL: server.URL + "/movie.mp4", ContentType: "video/mp4", Size: 2048}, result)
+
+	result, err = prober.Probe(ctx, server.URL+"/playlist.m3u8")
+	assert.NoError(t, err)
+	assert.Equal(t, "application/vnd.apple.mpegurl", result.ContentType)
+
+	// Manifests served with generic types are told apart by their extension
+	result, err = prober.Probe(ctx, server.URL+"/manifest.mpd")
+	assert.NoError(t, err)
+	assert.Equal(t, "application/xml", result.ContentType)
+	_, err = prober.Probe(ctx, server.URL+"/playlist.txt.m3u8")
+	assert.NoError(t, err)
+	_, err = prober.Probe(ctx, server.URL+"/manifest.xml")
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+
+	// HEAD refused, first bytes fetched instead
+	result, err = prober.Probe(ctx, server.URL+"/get-only")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4096), result.Size)
+	assert.Equal(t, "video/webm", result.ContentType)
+
+	result, err = prober.Probe(ctx, server.URL+"/redirect")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/movie.mp4", result.URL)
+
+	for path, want := range map[string]error{
+		"/page":      ErrUnsupportedType,
+		"/untyped":   ErrUnsupportedType,
+		"/empty.mp4": ErrEmpty,
+		"/missing":   StatusError{Code: http.StatusNotFound},
+		"/slow.mp4":  ErrTimeout,
+		"/loop":      ErrTooManyRedirects,
+	} {
+		_, err = prober.Probe(ctx, server.URL+path)
+		assert.Equal(t, want, err, path)
+	}
+	_, err = NewProber(Config{Timeout: time.Second, MaxSize: 1024, AllowPrivate: true}).Probe(ctx, server.URL+"/movie.mp4")
+	assert.Equal(t, ErrTooLarge, err)
+
+	for _, rawURL := range []string{"ftp://popcorn.test/movie.mp4", "/movie.mp4", "http://", "popcorn"} {
+		_, err = prober.Probe(ctx, rawURL)
+		assert.Equal(t, ErrInvalidURL, err, rawURL)
+	}
+	// Closed port
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	listener.Close()
+	_, err = prober.Probe(ctx, "http://"+listener.Addr().String()+"/movie.mp4")
+	assert.Equal(t, ErrUnreachable, err)
+}
+
+func TestFetch(t *testing.T) {
+	server := standIn()
+	defer server.Close()
+	prober := NewProber(Config{Timeout: 200 * time.Millisecond, MaxRedirects: 2, AllowPrivate: true})
+	ctx := context.Background()
+
+	body, err := prober.Fetch(ctx, server.URL+"/playlist.m3u8", 64)
+	assert.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n", string(body))
+
+	_, err = prober.Fetch(ctx, server.URL+"/page", 16)
+	assert.Equal(t, ErrTooLarge, err)
+	_, err = prober.Fetch(ctx, server.URL+"/missing", 64)
+	assert.Equal(t, StatusError{Code: http.StatusNotFound}, err)
+	_, err = prober.Fetch(ctx, server.URL+"/loop", 64)
+	assert.Equal(t, ErrTooManyRedirects, err)
+	_, err = NewProber(Config{Timeout: time.Second}).Fetch(ctx, server.URL+"/playlist.m3u8", 64)
+	assert.Equal(t, ErrPrivateAddress, err)
+}
+
+func TestProbePrivateAddress(t *testing.T) {
+	server := standIn()
+	defer server.Close()
+	prober := NewProber(Config{Timeout: time.Second, MaxRedirects: 2})
+	ctx := context.Background()
+
+	// Stand-in listens on loopback, which is off limits by default
+	_, err := prober.Probe(ctx, server.URL+"/movie.mp4")
+	assert.Equal(t, ErrPrivateAddress, err)
+	_, err = prober.Probe(ctx, "http://localhost:"+strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)+"/movie.mp4")
+	assert.Equal(t, ErrPrivateAddress, err)
+
+	for ip, want := range map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.64.0.1":      true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fc00::1":         true,
+		"fe80::1":         true,
+		"8.8.8.8":         false,
+		"2001:4860::1":    false,
+	} {
+		assert.Equal(t, want, blocked(net.ParseIP(ip)), ip)
+	}
+}